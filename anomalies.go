@@ -0,0 +1,146 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Anomaly kinds
+const (
+	anomalyExchangeRate = "exchange_rate"
+	anomalyPopulation   = "population"
+)
+
+// anomalyThresholdPct is the exchange rate change, in absolute percent,
+// above which a move is flagged as an anomaly
+const anomalyThresholdPct = 20.0
+
+// populationThresholdPct is the population change, in absolute percent,
+// above which a refresh is flagged; set with POPULATION_CHANGE_THRESHOLD_PCT
+var populationThresholdPct = 10.0
+
+// maxAnomalies caps how many anomalies the feed returns per request
+const maxAnomalies = 500
+
+// CountryAnomaly is a suspicious change detected during a refresh. The new
+// value is still stored; the anomaly lets operators review it.
+type CountryAnomaly struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Country    string    `gorm:"type:varchar(512);index;not null" json:"country"`
+	Kind       string    `gorm:"type:varchar(20);index;not null" json:"kind"`
+	OldValue   float64   `json:"old_value"`
+	NewValue   float64   `json:"new_value"`
+	ChangePct  float64   `json:"change_pct"`
+	DetectedAt time.Time `gorm:"index;not null" json:"detected_at"`
+}
+
+func (s *refreshSummary) addAnomaly(country, kind string, oldValue, newValue float64) {
+	s.Anomalies = append(s.Anomalies, CountryAnomaly{
+		Country:    country,
+		Kind:       kind,
+		OldValue:   oldValue,
+		NewValue:   newValue,
+		ChangePct:  (newValue - oldValue) / oldValue * 100,
+		DetectedAt: clock.Now(),
+	})
+}
+
+// checkAnomaly flags a rate change beyond anomalyThresholdPct, unless the
+// currency is pegged and the new rate simply follows its anchor
+func (s *refreshSummary) checkAnomaly(old, updated Country, rates map[string]float64) {
+	if old.ExchangeRate == nil || updated.ExchangeRate == nil || updated.CurrencyCode == nil || *old.ExchangeRate == 0 {
+		return
+	}
+	changePct := (*updated.ExchangeRate - *old.ExchangeRate) / *old.ExchangeRate * 100
+	if math.Abs(changePct) <= anomalyThresholdPct {
+		return
+	}
+	if rateHoldsPeg(*updated.CurrencyCode, *updated.ExchangeRate, rates) {
+		return
+	}
+	s.addAnomaly(updated.Name, anomalyExchangeRate, *old.ExchangeRate, *updated.ExchangeRate)
+}
+
+// checkPopulation flags a population change beyond populationThresholdPct;
+// upstream occasionally ships bogus values
+func (s *refreshSummary) checkPopulation(old, updated Country) {
+	if old.Population <= 0 || old.Population == updated.Population {
+		return
+	}
+	changePct := float64(updated.Population-old.Population) / float64(old.Population) * 100
+	if math.Abs(changePct) <= populationThresholdPct {
+		return
+	}
+	s.addAnomaly(updated.Name, anomalyPopulation, float64(old.Population), float64(updated.Population))
+}
+
+// recordAnomalies persists the anomalies found by a refresh for the feed
+func recordAnomalies(tx *gorm.DB, anomalies []CountryAnomaly) error {
+	if len(anomalies) == 0 {
+		return nil
+	}
+	return tx.Create(&anomalies).Error
+}
+
+func getAnomalies(c *fiber.Ctx) error {
+	meta := newListMeta(c, "since", "kind", "country")
+	meta.Sort = "detected_at_desc"
+	query := db.Model(&CountryAnomaly{})
+
+	if c.Query("since") != "" {
+		since, err := parseSince(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "Validation failed",
+				"details": err.Error(),
+			})
+		}
+		query = query.Where("detected_at > ?", since)
+		meta.filter("since", since.Format(time.RFC3339))
+	}
+
+	if kind := c.Query("kind"); kind != "" {
+		if kind != anomalyExchangeRate && kind != anomalyPopulation {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "Validation failed",
+				"details": "kind must be exchange_rate or population",
+			})
+		}
+		query = query.Where("kind = ?", kind)
+		meta.filter("kind", kind)
+	}
+
+	if country := c.Query("country"); country != "" {
+		query = query.Where("LOWER(country) = LOWER(?)", country)
+		meta.filter("country", country)
+	}
+
+	if err := query.Count(&meta.Total).Error; err != nil {
+		return err
+	}
+
+	anomalies := []CountryAnomaly{}
+	if err := query.Order("detected_at DESC, id DESC").Limit(maxAnomalies).Find(&anomalies).Error; err != nil {
+		return err
+	}
+
+	// The feed is capped rather than paged
+	if meta.Total > maxAnomalies {
+		meta.Page = &pageMeta{Limit: maxAnomalies}
+	}
+	return sendList(c, anomalies, meta)
+}
+
+// loadPopulationThreshold reads POPULATION_CHANGE_THRESHOLD_PCT, keeping the
+// default when it is unset or not a positive number
+func loadPopulationThreshold() {
+	if raw := getEnv("POPULATION_CHANGE_THRESHOLD_PCT", ""); raw != "" {
+		if pct, err := strconv.ParseFloat(raw, 64); err == nil && pct > 0 {
+			populationThresholdPct = pct
+		}
+	}
+}
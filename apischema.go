@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// apiError is the body of every error response
+type apiError struct {
+	Error   string      `json:"error"`
+	Details interface{} `json:"details,omitempty"`
+	// RequestID matches the X-Request-ID response header
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// apiTypes are the response types published by GET /schema and
+// `./app schema`, under their public names. The Go structs and their json
+// tags are the source of truth; add a type here when an endpoint starts
+// returning it.
+var apiTypes = []struct {
+	name string
+	typ  reflect.Type
+}{
+	{"Country", reflect.TypeOf(Country{})},
+	{"CurrencyPeg", reflect.TypeOf(CurrencyPeg{})},
+	{"CountryCurrency", reflect.TypeOf(CountryCurrency{})},
+	{"Currency", reflect.TypeOf(currencySummary{})},
+	{"Region", reflect.TypeOf(regionStats{})},
+	{"CountryStats", reflect.TypeOf(countryStats{})},
+	{"ListMeta", reflect.TypeOf(listMeta{})},
+	{"PageMeta", reflect.TypeOf(pageMeta{})},
+	{"RefreshJob", reflect.TypeOf(refreshJob{})},
+	{"CountryAnomaly", reflect.TypeOf(CountryAnomaly{})},
+	{"RateHistory", reflect.TypeOf(RateHistory{})},
+	{"PopulationHistory", reflect.TypeOf(PopulationHistory{})},
+	{"CountryHistory", reflect.TypeOf(CountryHistory{})},
+	{"ResolveResult", reflect.TypeOf(resolveResult{})},
+	{"SettingChange", reflect.TypeOf(SettingChange{})},
+	{"CountryOverride", reflect.TypeOf(CountryOverride{})},
+	{"CountryAlias", reflect.TypeOf(CountryAlias{})},
+	{"CurationDocument", reflect.TypeOf(curationDocument{})},
+	{"APIError", reflect.TypeOf(apiError{})},
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// apiField is one JSON property of a published struct
+type apiField struct {
+	name     string
+	typ      reflect.Type
+	optional bool
+}
+
+// apiFields lists the JSON properties of a struct in declaration order,
+// following encoding/json's rules for names, "-" and embedded structs
+func apiFields(t reflect.Type) []apiField {
+	var fields []apiField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			fields = append(fields, apiFields(f.Type)...)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, apiField{name: name, typ: f.Type, optional: strings.Contains(opts, "omitempty")})
+	}
+	return fields
+}
+
+// apiTypeName returns the public name of a published struct
+func apiTypeName(t reflect.Type) (string, bool) {
+	for _, published := range apiTypes {
+		if published.typ == t {
+			return published.name, true
+		}
+	}
+	return "", false
+}
+
+// jsonSchemaFor describes a Go type as a JSON Schema (draft 2020-12)
+func jsonSchemaFor(t reflect.Type) fiber.Map {
+	if t.Kind() == reflect.Pointer {
+		inner := jsonSchemaFor(t.Elem())
+		return fiber.Map{"anyOf": []fiber.Map{inner, {"type": "null"}}}
+	}
+	if name, ok := apiTypeName(t); ok {
+		return fiber.Map{"$ref": "#/$defs/" + name}
+	}
+	if t == timeType {
+		return fiber.Map{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return fiber.Map{"type": "string"}
+	case reflect.Bool:
+		return fiber.Map{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fiber.Map{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return fiber.Map{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return fiber.Map{"type": "array", "items": jsonSchemaFor(t.Elem())}
+	case reflect.Map:
+		return fiber.Map{"type": "object", "additionalProperties": jsonSchemaFor(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	}
+	// interface{} holds any JSON value
+	return fiber.Map{}
+}
+
+func structSchema(t reflect.Type) fiber.Map {
+	properties := fiber.Map{}
+	required := []string{}
+	for _, f := range apiFields(t) {
+		properties[f.name] = jsonSchemaFor(f.typ)
+		if !f.optional {
+			required = append(required, f.name)
+		}
+	}
+	return fiber.Map{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// apiJSONSchema is the document served at GET /schema
+func apiJSONSchema() fiber.Map {
+	defs := fiber.Map{}
+	for _, published := range apiTypes {
+		defs[published.name] = structSchema(published.typ)
+	}
+	return fiber.Map{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$id":     "/schema",
+		"title":   "Countries API",
+		"$defs":   defs,
+	}
+}
+
+// typeScriptFor renders a Go type as a TypeScript type expression
+func typeScriptFor(t reflect.Type) string {
+	if t.Kind() == reflect.Pointer {
+		return typeScriptFor(t.Elem()) + " | null"
+	}
+	if name, ok := apiTypeName(t); ok {
+		return name
+	}
+	if t == timeType {
+		// RFC 3339 timestamp
+		return "string"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		elem := typeScriptFor(t.Elem())
+		if strings.Contains(elem, " ") {
+			return "Array<" + elem + ">"
+		}
+		return elem + "[]"
+	case reflect.Map:
+		return "Record<string, " + typeScriptFor(t.Elem()) + ">"
+	case reflect.Struct:
+		var b strings.Builder
+		b.WriteString("{ ")
+		for _, f := range apiFields(t) {
+			fmt.Fprintf(&b, "%s%s: %s; ", f.name, optionalMark(f.optional), typeScriptFor(f.typ))
+		}
+		b.WriteString("}")
+		return b.String()
+	}
+	return "unknown"
+}
+
+func optionalMark(optional bool) string {
+	if optional {
+		return "?"
+	}
+	return ""
+}
+
+// apiTypeScript renders every published type as an exported interface,
+// plus the list envelope as a generic
+func apiTypeScript() string {
+	var b strings.Builder
+	b.WriteString("// Code generated by `./app schema typescript`. DO NOT EDIT.\n")
+	for _, published := range apiTypes {
+		fmt.Fprintf(&b, "\nexport interface %s {\n", published.name)
+		for _, f := range apiFields(published.typ) {
+			fmt.Fprintf(&b, "  %s%s: %s;\n", f.name, optionalMark(f.optional), typeScriptFor(f.typ))
+		}
+		b.WriteString("}\n")
+	}
+	b.WriteString("\nexport interface ListResponse<T> {\n  data: T[];\n  meta: ListMeta;\n}\n")
+	return b.String()
+}
+
+// getAPISchema serves the JSON Schema of the response types, or the
+// TypeScript declarations with ?format=typescript
+func getAPISchema(c *fiber.Ctx) error {
+	switch format := c.Query("format", "json"); format {
+	case "json":
+		return c.JSON(apiJSONSchema())
+	case "typescript":
+		c.Set(fiber.HeaderContentType, "application/typescript; charset=utf-8")
+		return c.SendString(apiTypeScript())
+	default:
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": "format must be json or typescript",
+		})
+	}
+}
+
+// runSchemaCommand handles `./app schema json|typescript`, printing the
+// schema to stdout, and returns the process exit code
+func runSchemaCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: schema json | schema typescript")
+		return 2
+	}
+	switch args[0] {
+	case "json":
+		out, err := json.MarshalIndent(apiJSONSchema(), "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to encode schema:", err)
+			return 1
+		}
+		fmt.Println(string(out))
+	case "typescript":
+		fmt.Print(apiTypeScript())
+	default:
+		fmt.Fprintln(os.Stderr, "usage: schema json | schema typescript")
+		return 2
+	}
+	return 0
+}
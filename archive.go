@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Archived payload names within an archive
+const (
+	archiveCountries = "countries.json.gz"
+	archiveRates     = "rates.json.gz"
+)
+
+// ErrArchiveNotFound is returned when an archive or one of its payloads is
+// missing
+var ErrArchiveNotFound = errors.New("archive not found")
+
+// archiveIDPattern matches the timestamp IDs handed out by newArchiveID and
+// keeps request-supplied IDs inside the archive directory
+var archiveIDPattern = regexp.MustCompile(`^\d{8}T\d{6}Z(-\d+)?$`)
+
+// archiveDir is where raw upstream payloads are kept; empty disables
+// archival. Set from ARCHIVE_DIR at startup.
+var archiveDir string
+
+// archiveInfo describes one archived refresh
+type archiveInfo struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Payloads  []string  `json:"payloads"`
+	Bytes     int64     `json:"bytes"`
+}
+
+// newArchiveID reserves a directory named after now, suffixed when two
+// refreshes land in the same second
+func newArchiveID(now time.Time) (string, error) {
+	base := now.UTC().Format("20060102T150405Z")
+	for i := 0; i < 100; i++ {
+		id := base
+		if i > 0 {
+			id = fmt.Sprintf("%s-%d", base, i)
+		}
+		err := os.Mkdir(filepath.Join(archiveDir, id), 0o755)
+		if err == nil {
+			return id, nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("too many archives at %s", base)
+}
+
+// archivePayloads gzips each raw payload into a new archive and returns its
+// ID. Archival is best effort: failures are logged by the caller and never
+// fail the refresh.
+func archivePayloads(payloads map[string][]byte) (string, error) {
+	if err := os.MkdirAll(archiveDir, 0o755); err != nil {
+		return "", err
+	}
+	id, err := newArchiveID(clock.Now())
+	if err != nil {
+		return "", err
+	}
+	for name, body := range payloads {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return id, err
+		}
+		if err := zw.Close(); err != nil {
+			return id, err
+		}
+		if err := os.WriteFile(filepath.Join(archiveDir, id, name), buf.Bytes(), 0o644); err != nil {
+			return id, err
+		}
+	}
+	return id, nil
+}
+
+// archivePath resolves a payload within an archive, rejecting malformed IDs
+func archivePath(id, name string) (string, error) {
+	if archiveDir == "" || !archiveIDPattern.MatchString(id) {
+		return "", ErrArchiveNotFound
+	}
+	if name != archiveCountries && name != archiveRates {
+		return "", ErrArchiveNotFound
+	}
+	return filepath.Join(archiveDir, id, name), nil
+}
+
+// checkArchive reports ErrArchiveNotFound unless id holds a full refresh
+func checkArchive(id string) error {
+	for _, name := range []string{archiveCountries, archiveRates} {
+		path, err := archivePath(id, name)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return ErrArchiveNotFound
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readArchivedPayload returns the decompressed payload stored under id
+func readArchivedPayload(id, name string) ([]byte, error) {
+	path, err := archivePath(id, name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrArchiveNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// listArchives returns archives newest first
+func listArchives() ([]archiveInfo, error) {
+	archives := []archiveInfo{}
+	if archiveDir == "" {
+		return archives, nil
+	}
+	entries, err := os.ReadDir(archiveDir)
+	if os.IsNotExist(err) {
+		return archives, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !archiveIDPattern.MatchString(entry.Name()) {
+			continue
+		}
+		created, _ := time.Parse("20060102T150405Z", strings.SplitN(entry.Name(), "-", 2)[0])
+		info := archiveInfo{ID: entry.Name(), CreatedAt: created, Payloads: []string{}}
+		files, err := os.ReadDir(filepath.Join(archiveDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			fi, err := file.Info()
+			if err != nil {
+				continue
+			}
+			info.Payloads = append(info.Payloads, file.Name())
+			info.Bytes += fi.Size()
+		}
+		archives = append(archives, info)
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].ID > archives[j].ID })
+	return archives, nil
+}
+
+func getArchives(c *fiber.Ctx) error {
+	meta := newListMeta(c)
+	meta.Sort = "id_desc"
+	archives, err := listArchives()
+	if err != nil {
+		return err
+	}
+	if archives == nil {
+		archives = []archiveInfo{}
+	}
+	meta.Total = int64(len(archives))
+	return sendList(c, archives, meta)
+}
+
+// getArchivedPayload serves one archived payload, still gzipped
+func getArchivedPayload(c *fiber.Ctx) error {
+	path, err := archivePath(c.Params("id"), c.Params("payload"))
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return ErrArchiveNotFound
+	}
+	if err != nil {
+		return err
+	}
+	// Archives never change once written, so the ID, payload and size make
+	// a strong validator for If-Range
+	etag := fmt.Sprintf(`"%s-%s-%d"`, c.Params("id"), c.Params("payload"), info.Size())
+	c.Set(fiber.HeaderETag, etag)
+	if ifRange := c.Get(fiber.HeaderIfRange); ifRange != "" && ifRange != etag {
+		c.Request().Header.Del(fiber.HeaderRange)
+	}
+	// SendFile serves Range requests with 206, so an interrupted download
+	// resumes where it stopped
+	if err := c.SendFile(path); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, "application/gzip")
+	return nil
+}
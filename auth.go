@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// APIKey is a key allowed to call the mutating endpoints. Only the SHA-256
+// of the key is stored; create and revoke keys with `./app apikey`.
+type APIKey struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	Name      string     `gorm:"type:varchar(100);uniqueIndex;not null" json:"name"`
+	KeyHash   string     `gorm:"type:varchar(64);uniqueIndex;not null" json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// envAPIKey is a key from API_KEYS
+type envAPIKey struct {
+	name string
+	key  string
+}
+
+// envAPIKeys are read from API_KEYS, a comma-separated list of name:key
+// pairs; a bare key is named after its position
+var envAPIKeys []envAPIKey
+
+// loadAPIKeys reads API_KEYS
+func loadAPIKeys() {
+	envAPIKeys = nil
+	for i, entry := range strings.Split(os.Getenv("API_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, key, named := strings.Cut(entry, ":")
+		if !named {
+			name, key = fmt.Sprintf("env-%d", i+1), entry
+		}
+		envAPIKeys = append(envAPIKeys, envAPIKey{name: name, key: key})
+	}
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// lookupAPIKey returns the name of a valid key, or "" when the key is
+// unknown or revoked
+func lookupAPIKey(key string) (string, error) {
+	for _, candidate := range envAPIKeys {
+		if subtle.ConstantTimeCompare([]byte(candidate.key), []byte(key)) == 1 {
+			return candidate.name, nil
+		}
+	}
+
+	var stored APIKey
+	err := db.Where("key_hash = ? AND revoked_at IS NULL", hashAPIKey(key)).First(&stored).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return stored.Name, nil
+}
+
+// principal is an authenticated caller
+type principal struct {
+	// Actor names the caller in audit trails
+	Actor string
+	Roles []string
+}
+
+func (p *principal) has(role string) bool {
+	for _, r := range p.Roles {
+		// admin includes every reader permission
+		if r == role || r == roleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate reads the X-API-Key header or an Authorization bearer
+// token. API keys are admin credentials.
+func authenticate(c *fiber.Ctx) (*principal, error) {
+	if key := c.Get("X-API-Key"); key != "" {
+		name, err := lookupAPIKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			return nil, errInvalidAPIKey
+		}
+		return &principal{Actor: "key:" + name, Roles: []string{roleAdmin}}, nil
+	}
+
+	auth := c.Get(fiber.HeaderAuthorization)
+	if raw, ok := strings.CutPrefix(auth, "Bearer "); ok && jwtEnabled() {
+		p, err := parseBearerToken(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, &tokenError{err: err}
+		}
+		return p, nil
+	}
+	return nil, errNoCredentials
+}
+
+// authResult is the outcome of authenticate, kept for the rest of a request
+type authResult struct {
+	p   *principal
+	err error
+}
+
+// authenticateOnce authenticates a request once, however many middlewares
+// ask: the rate limiter, role checks and redaction all need the caller
+func authenticateOnce(c *fiber.Ctx) (*principal, error) {
+	if result, ok := c.Locals("auth").(*authResult); ok {
+		return result.p, result.err
+	}
+	p, err := authenticate(c)
+	c.Locals("auth", &authResult{p: p, err: err})
+	return p, err
+}
+
+// errInvalidAPIKey is an unknown or revoked X-API-Key
+var errInvalidAPIKey = errors.New("the API key is not valid or has been revoked")
+
+// tokenError is a bearer token that failed verification
+type tokenError struct {
+	err error
+}
+
+func (e *tokenError) Error() string {
+	return "invalid bearer token: " + e.err.Error()
+}
+
+// requireRole guards an endpoint: 401 without valid credentials, 403 when
+// the caller lacks role
+func requireRole(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		p, err := authenticateOnce(c)
+		var tokenErr *tokenError
+		switch {
+		case errors.Is(err, errNoCredentials):
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer, APIKey header="X-API-Key"`)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error":   "Unauthorized",
+				"details": "this endpoint requires an X-API-Key header or a bearer token",
+			})
+		case errors.As(err, &tokenErr):
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error":   "Unauthorized",
+				"details": tokenErr.Error(),
+			})
+		case errors.Is(err, errInvalidAPIKey):
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error":   "Forbidden",
+				"details": err.Error(),
+			})
+		case err != nil:
+			return err
+		}
+
+		if !p.has(role) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error":   "Forbidden",
+				"details": fmt.Sprintf("this endpoint requires the %s role", role),
+			})
+		}
+		c.Locals("actor", p.Actor)
+		return c.Next()
+	}
+}
+
+// publicReads are GET endpoints that stay open with AUTH_READS=reader, so
+// load balancer health checks keep working
+var publicReads = map[string]bool{"/status": true}
+
+// readAccess requires the reader role for GET requests when
+// AUTH_READS=reader; writes are guarded per route
+func readAccess() fiber.Handler {
+	reader := requireRole(roleReader)
+	return func(c *fiber.Ctx) error {
+		if jwtSettings.ReadsPublic || publicReads[c.Path()] ||
+			(c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead) {
+			return c.Next()
+		}
+		return reader(c)
+	}
+}
+
+// requestActor names who made a request for audit trails: the API key or
+// token subject when authenticated, otherwise the caller's IP
+func requestActor(c *fiber.Ctx) string {
+	if actor, ok := c.Locals("actor").(string); ok && actor != "" {
+		return actor
+	}
+	return clientIP(c)
+}
+
+// runAPIKeyCommand handles `./app apikey create|revoke|list [name]` and
+// returns the process exit code
+func runAPIKeyCommand(args []string) int {
+	usage := func() int {
+		fmt.Fprintln(os.Stderr, "usage: apikey create <name> | apikey revoke <name> | apikey list")
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+
+	switch {
+	case args[0] == "create" && len(args) == 2:
+		raw := make([]byte, 24)
+		if _, err := rand.Read(raw); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to generate key:", err)
+			return 1
+		}
+		key := hex.EncodeToString(raw)
+		if err := db.Create(&APIKey{Name: args[1], KeyHash: hashAPIKey(key)}).Error; err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to store key:", err)
+			return 1
+		}
+		// The key is not stored, so this is the only time it is shown
+		fmt.Println(key)
+		return 0
+	case args[0] == "revoke" && len(args) == 2:
+		result := db.Model(&APIKey{}).Where("name = ? AND revoked_at IS NULL", args[1]).
+			Update("revoked_at", clock.Now())
+		if result.Error != nil {
+			fmt.Fprintln(os.Stderr, "Failed to revoke key:", result.Error)
+			return 1
+		}
+		if result.RowsAffected == 0 {
+			fmt.Fprintf(os.Stderr, "No active key named %q\n", args[1])
+			return 1
+		}
+		fmt.Printf("Revoked %s\n", args[1])
+		return 0
+	case args[0] == "list" && len(args) == 1:
+		var keys []APIKey
+		if err := db.Order("name").Find(&keys).Error; err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to list keys:", err)
+			return 1
+		}
+		for _, key := range keys {
+			state := "active"
+			if key.RevokedAt != nil {
+				state = "revoked " + key.RevokedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%-30s  created %s  %s\n", key.Name, key.CreatedAt.Format(time.RFC3339), state)
+		}
+		for _, key := range envAPIKeys {
+			fmt.Printf("%-30s  from API_KEYS\n", key.name)
+		}
+		return 0
+	}
+	return usage()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxBatchLookups caps the names and codes accepted by one batch lookup
+const maxBatchLookups = 100
+
+// lookup finds a country by exact key: an ISO 3166-1 alpha-2 or alpha-3
+// code, or the stored name ignoring case and accents. Unlike resolve it
+// tries no aliases and suggests nothing.
+func (r *countryResolver) lookup(key string) *Country {
+	trimmed := strings.TrimSpace(key)
+	upper := strings.ToUpper(trimmed)
+	if country, ok := r.byAlpha2[upper]; ok && len(upper) == 2 {
+		return country
+	}
+	if country, ok := r.byAlpha3[upper]; ok && len(upper) == 3 {
+		return country
+	}
+	return r.byName[foldName(trimmed)]
+}
+
+// lookupCountries returns the countries matching a list of names and ISO
+// codes, in request order without repeats, and the keys that matched none
+func lookupCountries(c *fiber.Ctx) error {
+	lang, err := parseLanguage(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+	var keys []string
+	if err := c.BodyParser(&keys); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": "body must be a JSON array of names or ISO codes",
+		})
+	}
+	if len(keys) == 0 || len(keys) > maxBatchLookups {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": fmt.Sprintf("batch must contain between 1 and %d names or codes", maxBatchLookups),
+		})
+	}
+
+	var all []Country
+	if err := db.Find(&all).Error; err != nil {
+		return err
+	}
+	resolver := newCountryResolver(all)
+
+	countries := []Country{}
+	misses := []string{}
+	seen := map[uint]bool{}
+	for _, key := range keys {
+		country := resolver.lookup(key)
+		if country == nil {
+			misses = append(misses, key)
+			continue
+		}
+		if !seen[country.ID] {
+			seen[country.ID] = true
+			countries = append(countries, *country)
+		}
+	}
+	if err := attachCurrencies(db, countries); err != nil {
+		return err
+	}
+	localizeCountries(lang, countries)
+
+	return c.JSON(fiber.Map{
+		"total":     len(keys),
+		"found":     len(keys) - len(misses),
+		"countries": countries,
+		"misses":    misses,
+	})
+}
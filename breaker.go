@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// errCircuitOpen is returned without calling an upstream whose breaker is
+// open
+var errCircuitOpen = errors.New("circuit open")
+
+// breakerSettings are shared by every upstream breaker; override with
+// UPSTREAM_BREAKER_THRESHOLD and UPSTREAM_BREAKER_COOLDOWN
+var breakerSettings = struct {
+	Threshold int
+	Cooldown  time.Duration
+}{Threshold: 5, Cooldown: time.Minute}
+
+// circuitBreaker stops calling an upstream after Threshold consecutive
+// failures. Once Cooldown has passed a single trial call is let through:
+// success closes the circuit, failure opens it for another cooldown.
+type circuitBreaker struct {
+	Name      string     `json:"name"`
+	State     string     `json:"state"`
+	Failures  int        `json:"consecutive_failures"`
+	OpenedAt  *time.Time `json:"opened_at"`
+	RetryAt   *time.Time `json:"retry_at"`
+	LastError *string    `json:"last_error"`
+
+	trial bool
+}
+
+var (
+	breakersMu sync.Mutex
+	breakers   = map[string]*circuitBreaker{}
+)
+
+// loadBreakerSettings reads the breaker environment settings
+func loadBreakerSettings() error {
+	if raw := getEnv("UPSTREAM_BREAKER_THRESHOLD", ""); raw != "" {
+		threshold := getEnvInt("UPSTREAM_BREAKER_THRESHOLD", 0)
+		if threshold < 1 {
+			return fmt.Errorf("invalid UPSTREAM_BREAKER_THRESHOLD %q", raw)
+		}
+		breakerSettings.Threshold = threshold
+	}
+	cooldown, err := parseInterval("UPSTREAM_BREAKER_COOLDOWN")
+	if err != nil {
+		return err
+	}
+	if cooldown > 0 {
+		breakerSettings.Cooldown = cooldown
+	}
+	return nil
+}
+
+// breakerKey names the upstream behind a URL by host and path, so paged or
+// parameterized calls to one endpoint share a breaker
+func breakerKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Host + u.Path
+}
+
+// allow reports whether a call may go ahead, marking it as the trial call
+// when the cooldown has passed
+func allow(key string, now time.Time) (*circuitBreaker, error) {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	b, ok := breakers[key]
+	if !ok {
+		b = &circuitBreaker{Name: key, State: breakerClosed}
+		breakers[key] = b
+	}
+	switch b.State {
+	case breakerOpen:
+		if now.Before(*b.RetryAt) {
+			return b, fmt.Errorf("%w after %d consecutive failures, retrying after %s",
+				errCircuitOpen, b.Failures, b.RetryAt.Format(time.RFC3339))
+		}
+		b.State = breakerHalfOpen
+		b.trial = true
+	case breakerHalfOpen:
+		if b.trial {
+			return b, fmt.Errorf("%w: trial call in progress", errCircuitOpen)
+		}
+		b.trial = true
+	}
+	return b, nil
+}
+
+// record updates a breaker with the outcome of a call. Only failures that
+// suggest the upstream is unhealthy count; see retryable.
+func (b *circuitBreaker) record(err error, now time.Time) {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	b.trial = false
+	if err == nil || !retryable(err) {
+		b.State = breakerClosed
+		b.Failures = 0
+		b.OpenedAt, b.RetryAt = nil, nil
+		return
+	}
+
+	msg := err.Error()
+	b.LastError = &msg
+	b.Failures++
+	if b.State == breakerHalfOpen || b.Failures >= breakerSettings.Threshold {
+		retryAt := now.Add(breakerSettings.Cooldown)
+		b.State = breakerOpen
+		b.OpenedAt, b.RetryAt = &now, &retryAt
+	}
+}
+
+// withBreaker runs fn unless the breaker for rawURL is open. Cooldowns use
+// wall time, not the pinnable clock, so a FIXED_TIME run can still recover.
+func withBreaker(rawURL string, fn func() ([]byte, error)) ([]byte, error) {
+	b, err := allow(breakerKey(rawURL), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	body, err := fn()
+	b.record(err, time.Now())
+	return body, err
+}
+
+// breakerSnapshot copies every breaker for /status, and reports whether any
+// upstream is currently short-circuited
+func breakerSnapshot() ([]circuitBreaker, bool) {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	degraded := false
+	snapshot := make([]circuitBreaker, 0, len(breakers))
+	for _, b := range breakers {
+		snapshot = append(snapshot, *b)
+		degraded = degraded || b.State != breakerClosed
+	}
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].Name < snapshot[j].Name })
+	return snapshot, degraded
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// bulkFilterColumns are the columns a bulk update may select countries by
+var bulkFilterColumns = []string{
+	"name", "alpha2_code", "alpha3_code", "region", "subregion", "currency_code",
+	"population_tier", "gdp_tier", "source",
+}
+
+// bulkCondition selects the countries whose column equals one of Values,
+// or is NULL when Null is set
+type bulkCondition struct {
+	Column string
+	Values []string
+	Null   bool
+}
+
+// bulkUpdate is a validated POST /admin/bulk-update body
+type bulkUpdate struct {
+	Where []bulkCondition
+	Set   countryPatch
+	// DryRun lists the matching countries without changing them
+	DryRun bool
+}
+
+// bulkUpdateRequest is the body of POST /admin/bulk-update. Set takes the
+// fields of PATCH /countries/:name, pin and unpin included.
+type bulkUpdateRequest struct {
+	Where  map[string]json.RawMessage `json:"where"`
+	Set    json.RawMessage            `json:"set"`
+	DryRun bool                       `json:"dry_run"`
+}
+
+// parseBulkUpdate validates a bulk update body. Every where value is a
+// string, a list of strings or null; at least one is required so a typo
+// cannot edit every country. It returns every problem found.
+func parseBulkUpdate(body []byte) (bulkUpdate, []string) {
+	var update bulkUpdate
+	var req bulkUpdateRequest
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return update, []string{"body must be a JSON object with where, set and optionally dry_run"}
+	}
+	update.DryRun = req.DryRun
+
+	var problems []string
+	known := map[string]bool{}
+	for _, column := range bulkFilterColumns {
+		known[column] = true
+	}
+	columns := make([]string, 0, len(req.Where))
+	for column := range req.Where {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	for _, column := range columns {
+		if !known[column] {
+			problems = append(problems, fmt.Sprintf("where.%s is not filterable; fields are %s", column, strings.Join(bulkFilterColumns, ", ")))
+			continue
+		}
+		condition := bulkCondition{Column: column}
+		var one *string
+		if err := json.Unmarshal(req.Where[column], &one); err == nil {
+			if one == nil {
+				condition.Null = true
+			} else {
+				condition.Values = []string{strings.TrimSpace(*one)}
+			}
+		} else if err := json.Unmarshal(req.Where[column], &condition.Values); err != nil || len(condition.Values) == 0 {
+			problems = append(problems, fmt.Sprintf("where.%s must be a string, a non-empty list of strings or null", column))
+			continue
+		}
+		for i := range condition.Values {
+			condition.Values[i] = strings.TrimSpace(condition.Values[i])
+		}
+		update.Where = append(update.Where, condition)
+	}
+	if len(req.Where) == 0 {
+		problems = append(problems, "where must select countries by at least one field")
+	}
+
+	if len(req.Set) == 0 {
+		problems = append(problems, "set is required")
+	} else {
+		patch, setProblems := parseCountryPatch(req.Set)
+		for _, problem := range setProblems {
+			problems = append(problems, "set: "+problem)
+		}
+		update.Set = patch
+	}
+	return update, problems
+}
+
+// scope applies the conditions to a countries query
+func (u bulkUpdate) scope(query *gorm.DB) *gorm.DB {
+	for _, condition := range u.Where {
+		if condition.Null {
+			query = query.Where(condition.Column + " IS NULL")
+			continue
+		}
+		query = query.Where(condition.Column+" IN ?", condition.Values)
+	}
+	return query
+}
+
+// bulkUpdateCountries applies one edit to every country the update selects,
+// in a single transaction: a failure, or a concurrent change to any of
+// them, leaves all unchanged. It returns the names matched and changed.
+func bulkUpdateCountries(update bulkUpdate, rates map[string]float64, actor string) (matched, changed []string, err error) {
+	matched, changed = []string{}, []string{}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var countries []Country
+		if err := update.scope(tx).Order("name ASC").Find(&countries).Error; err != nil {
+			return err
+		}
+		for _, country := range countries {
+			matched = append(matched, country.Name)
+		}
+		if update.DryRun || len(countries) == 0 {
+			return nil
+		}
+		if err := attachCurrencies(tx, countries); err != nil {
+			return err
+		}
+
+		now := clock.Now()
+		for _, country := range countries {
+			edited, err := applyCountryPatch(tx, country, update.Set, rates, actor, now)
+			if err != nil {
+				return err
+			}
+			if edited {
+				changed = append(changed, country.Name)
+			}
+		}
+		return nil
+	})
+	return matched, changed, err
+}
+
+// postBulkUpdate edits every country matching a filter at once, for large
+// curated corrections. Each change is recorded in the country's history
+// with the caller as actor.
+func postBulkUpdate(c *fiber.Ctx) error {
+	invalid := func(details interface{}) error {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": details,
+		})
+	}
+
+	update, problems := parseBulkUpdate(c.Body())
+	if len(problems) > 0 {
+		return invalid(problems)
+	}
+
+	var rates map[string]float64
+	if _, ok := update.Set.Values["currency_code"]; ok {
+		var err error
+		if rates, err = currencyRates(); err != nil {
+			return err
+		}
+	}
+
+	matched, changed, err := bulkUpdateCountries(update, rates, requestActor(c))
+	if errors.Is(err, ErrNothingToPin) {
+		return invalid([]string{err.Error()})
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(changed) > 0 {
+		notifyDataChanged()
+	}
+
+	return c.JSON(fiber.Map{
+		"dry_run": update.DryRun,
+		"matched": len(matched),
+		"changed": len(changed),
+		"countries": fiber.Map{
+			"matched": matched,
+			"changed": changed,
+		},
+	})
+}
+
+// deleteCountries removes a whole region (?region=), or every country,
+// once ?confirm=true. Other parameters are refused so a misspelt filter
+// cannot widen the delete to every row.
+func deleteCountries(c *fiber.Ctx) error {
+	invalid := func(details string) error {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": details,
+		})
+	}
+	queries := c.Queries()
+	for key := range queries {
+		if key != "region" && key != "confirm" {
+			return invalid(fmt.Sprintf("unknown parameter %s; only region and confirm are accepted", key))
+		}
+	}
+	region, filtered := queries["region"]
+	if region = strings.TrimSpace(region); filtered && region == "" {
+		return invalid("region must not be empty; leave it out to delete every country")
+	}
+	if c.Query("confirm") != "true" {
+		scope := "every country"
+		if region != "" {
+			scope = "every country in " + region
+		}
+		return invalid("this deletes " + scope + "; repeat with confirm=true")
+	}
+
+	names, err := deleteCountriesInRegion(region, requestActor(c))
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		notifyDataChanged()
+	}
+
+	var filter *string
+	if region != "" {
+		filter = &region
+	}
+	return c.JSON(fiber.Map{
+		"message":   "Countries deleted successfully",
+		"region":    filter,
+		"deleted":   len(names),
+		"countries": names,
+	})
+}
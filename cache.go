@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// maxMemoryCacheEntries bounds the in-process cache; it is cleared when full
+const maxMemoryCacheEntries = 10000
+
+// defaultCacheTTLs apply when a cache engine is enabled and CACHE_TTLS does
+// not override them. Keys are route patterns as registered.
+var defaultCacheTTLs = map[string]time.Duration{
+	"/countries":               60 * time.Second,
+	"/countries/:name":         60 * time.Second,
+	"/countries/:name/summary": 300 * time.Second,
+	"/countries/stats":         60 * time.Second,
+	"/countries/top":           60 * time.Second,
+	"/status":                  10 * time.Second,
+}
+
+// cachedHeaders are response headers replayed on a cache hit
+var cachedHeaders = []string{"X-Next-Cursor"}
+
+// cachedResponse is a stored successful GET response
+type cachedResponse struct {
+	ContentType string            `json:"content_type"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        []byte            `json:"body"`
+}
+
+// responseCache stores rendered responses by request key
+type responseCache interface {
+	get(key string) (*cachedResponse, bool)
+	set(key string, resp cachedResponse, ttl time.Duration)
+	// purge drops every entry, e.g. after the data changed
+	purge()
+}
+
+var (
+	responses responseCache
+	cacheTTLs = map[string]time.Duration{}
+)
+
+// cacheKeyers build the key of routes whose requests can be written in
+// more than one way for the same response. They report false for a request
+// the handler will reject, which is never cached.
+var cacheKeyers = map[string]func(c *fiber.Ctx) (string, bool){
+	"/countries": listCacheKey,
+}
+
+// cacheStats counts response cache lookups per route and result (hit,
+// miss) for /metrics
+var cacheStats = struct {
+	sync.Mutex
+	lookups map[string]map[string]int64
+}{lookups: map[string]map[string]int64{}}
+
+func countCacheLookup(route, result string) {
+	cacheStats.Lock()
+	defer cacheStats.Unlock()
+	if cacheStats.lookups[route] == nil {
+		cacheStats.lookups[route] = map[string]int64{}
+	}
+	cacheStats.lookups[route][result]++
+}
+
+// initResponseCache configures the cache from CACHE_ENGINE (memory or
+// redis) and CACHE_TTLS ("/countries=60s,/status=10s"; 0 disables a route)
+func initResponseCache() error {
+	switch engine := os.Getenv("CACHE_ENGINE"); engine {
+	case "":
+		return nil
+	case "memory":
+		responses = &memoryCache{entries: map[string]memoryEntry{}}
+	case "redis":
+		client, err := redisClient()
+		if err != nil {
+			return err
+		}
+		responses = &redisCache{client: client, prefix: getEnv("REDIS_CACHE_PREFIX", "cache")}
+	default:
+		return fmt.Errorf("unknown CACHE_ENGINE %q", engine)
+	}
+
+	ttls, err := loadCacheTTLs()
+	if err != nil {
+		return err
+	}
+	cacheTTLs = ttls
+	return nil
+}
+
+// loadCacheTTLs merges CACHE_TTLS over the default TTLs
+func loadCacheTTLs() (map[string]time.Duration, error) {
+	ttls := make(map[string]time.Duration, len(defaultCacheTTLs))
+	for route, ttl := range defaultCacheTTLs {
+		ttls[route] = ttl
+	}
+	for _, pair := range strings.Split(os.Getenv("CACHE_TTLS"), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		route, raw, ok := strings.Cut(pair, "=")
+		ttl, err := time.ParseDuration(raw)
+		if !ok || err != nil || ttl < 0 {
+			return nil, fmt.Errorf("invalid CACHE_TTLS entry %q", pair)
+		}
+		ttls[strings.TrimSpace(route)] = ttl
+	}
+	return ttls, nil
+}
+
+// cacheFor returns middleware caching successful GET responses of route
+// for its configured TTL. Requests carrying credentials bypass the cache.
+func cacheFor(route string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ttl := cacheTTLs[route]
+		if responses == nil || ttl <= 0 || c.Method() != fiber.MethodGet ||
+			c.Get(fiber.HeaderAuthorization) != "" || c.Get("X-API-Key") != "" {
+			return c.Next()
+		}
+
+		key := cacheKey(c)
+		if keyer := cacheKeyers[route]; keyer != nil {
+			var ok bool
+			if key, ok = keyer(c); !ok {
+				return c.Next()
+			}
+		}
+		// Routes serving XML as well key the negotiated format, which the
+		// Accept header may have chosen
+		if format, ok := c.Locals("format").(string); ok {
+			key += "|" + format
+		}
+		if resp, ok := responses.get(key); ok {
+			countCacheLookup(route, "hit")
+			c.Set("X-Cache", "HIT")
+			c.Set(fiber.HeaderContentType, resp.ContentType)
+			for name, value := range resp.Headers {
+				c.Set(name, value)
+			}
+			return c.Send(resp.Body)
+		}
+
+		if err := c.Next(); err != nil {
+			return err
+		}
+		countCacheLookup(route, "miss")
+		c.Set("X-Cache", "MISS")
+		if c.Response().StatusCode() == fiber.StatusOK {
+			resp := cachedResponse{
+				ContentType: string(c.Response().Header.ContentType()),
+				Body:        append([]byte(nil), c.Response().Body()...),
+			}
+			for _, name := range cachedHeaders {
+				if value := c.GetRespHeader(name); value != "" {
+					if resp.Headers == nil {
+						resp.Headers = map[string]string{}
+					}
+					resp.Headers[name] = value
+				}
+			}
+			responses.set(key, resp, ttl)
+		}
+		return nil
+	}
+}
+
+// cacheKey identifies a request by path, sorted query and the headers that
+// change the response
+func cacheKey(c *fiber.Ctx) string {
+	queries := c.Queries()
+	names := make([]string, 0, len(queries))
+	for name := range queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(strings.ToLower(c.Path()))
+	for i, name := range names {
+		if i == 0 {
+			b.WriteByte('?')
+		} else {
+			b.WriteByte('&')
+		}
+		b.WriteString(name + "=" + queries[name])
+	}
+	// Localized responses and caller-dependent ordering vary by these
+	b.WriteString("|" + c.Get(fiber.HeaderAcceptLanguage))
+	if c.QueryBool("nearby") {
+		b.WriteString("|" + clientIP(c))
+	}
+	return b.String()
+}
+
+// listCacheKey keys a GET /countries request by what the handler reads
+// from it rather than how it is written. Empty parameters are dropped,
+// limit and offset are their parsed values, nearby is a flag, the language
+// is the one resolved from ?lang or Accept-Language, and unknown
+// parameters count by name only, as only their names reach the response.
+func listCacheKey(c *fiber.Ctx) (string, bool) {
+	lang, err := parseLanguage(c)
+	if err != nil {
+		return "", false
+	}
+	page, err := parsePage(c)
+	if err != nil {
+		return "", false
+	}
+
+	params := url.Values{}
+	for _, name := range []string{"region", "currency", "population_tier", "gdp_tier", "since", "sort"} {
+		if value := c.Query(name); value != "" {
+			params.Set(name, value)
+		}
+	}
+	switch {
+	case page.Cursor:
+		params.Set("cursor", c.Query("cursor"))
+		params.Set("limit", strconv.Itoa(page.Limit))
+	case page.Limit > 0:
+		params.Set("limit", strconv.Itoa(page.Limit))
+		params.Set("offset", strconv.Itoa(page.Offset))
+	}
+	if lang != "" {
+		params.Set("lang", lang)
+	}
+	// The order depends on the caller's region, found from its address
+	if c.QueryBool("nearby") {
+		params.Set("nearby", clientIP(c))
+	}
+
+	meta := newListMeta(c, listParams...)
+	return "/countries?" + params.Encode() + "|" + strings.Join(meta.IgnoredParams, ","), true
+}
+
+// writeCacheMetrics appends the response cache counters to a /metrics
+// response
+func writeCacheMetrics(b *strings.Builder) {
+	cacheStats.Lock()
+	defer cacheStats.Unlock()
+	if len(cacheStats.lookups) == 0 {
+		return
+	}
+
+	routes := make([]string, 0, len(cacheStats.lookups))
+	for route := range cacheStats.lookups {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	b.WriteString("# TYPE response_cache_lookups counter\n# HELP response_cache_lookups Response cache lookups by route and result (hit, miss).\n")
+	for _, route := range routes {
+		for _, result := range []string{"hit", "miss"} {
+			if n, ok := cacheStats.lookups[route][result]; ok {
+				fmt.Fprintf(b, "response_cache_lookups_total{route=\"%s\",result=\"%s\"} %d\n", escapeLabel(route), result, n)
+			}
+		}
+	}
+}
+
+// purgeResponseCache empties the cache after a data change
+func purgeResponseCache() {
+	if responses != nil {
+		responses.purge()
+	}
+}
+
+type memoryEntry struct {
+	resp    cachedResponse
+	expires time.Time
+}
+
+// memoryCache keeps responses in process memory
+type memoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+func (m *memoryCache) get(key string) (*cachedResponse, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		delete(m.entries, key)
+		return nil, false
+	}
+	return &entry.resp, true
+}
+
+func (m *memoryCache) set(key string, resp cachedResponse, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.entries) >= maxMemoryCacheEntries {
+		m.entries = map[string]memoryEntry{}
+	}
+	m.entries[key] = memoryEntry{resp: resp, expires: time.Now().Add(ttl)}
+}
+
+func (m *memoryCache) purge() {
+	m.mu.Lock()
+	m.entries = map[string]memoryEntry{}
+	m.mu.Unlock()
+}
+
+// redisCache shares responses across instances. Keys embed a generation
+// number, so a purge is a single INCR and old entries simply expire.
+type redisCache struct {
+	client *redis.Client
+	prefix string
+}
+
+func (r *redisCache) generation(ctx context.Context) (int64, error) {
+	gen, err := r.client.Get(ctx, r.prefix+":gen").Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return gen, err
+}
+
+func (r *redisCache) key(ctx context.Context, key string) (string, error) {
+	gen, err := r.generation(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d:%s", r.prefix, gen, key), nil
+}
+
+func (r *redisCache) get(key string) (*cachedResponse, bool) {
+	ctx := context.Background()
+	k, err := r.key(ctx, key)
+	if err != nil {
+		return nil, false
+	}
+	raw, err := r.client.Get(ctx, k).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Response cache read failed: %v", err)
+		}
+		return nil, false
+	}
+	var resp cachedResponse
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return nil, false
+	}
+	return &resp, true
+}
+
+func (r *redisCache) set(key string, resp cachedResponse, ttl time.Duration) {
+	ctx := context.Background()
+	k, err := r.key(ctx, key)
+	if err != nil {
+		return
+	}
+	raw, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	if err := r.client.Set(ctx, k, raw, ttl).Err(); err != nil {
+		log.Printf("Response cache write failed: %v", err)
+	}
+}
+
+func (r *redisCache) purge() {
+	if err := r.client.Incr(context.Background(), r.prefix+":gen").Err(); err != nil {
+		log.Printf("Response cache purge failed: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// cacheKeyFor runs listCacheKey against a GET /countries request
+func cacheKeyFor(t *testing.T, query, acceptLanguage string) (string, bool) {
+	t.Helper()
+	var key string
+	var ok bool
+	app := fiber.New()
+	app.Get("/countries", func(c *fiber.Ctx) error {
+		key, ok = listCacheKey(c)
+		return nil
+	})
+
+	req := httptest.NewRequest("GET", "/countries"+query, nil)
+	if acceptLanguage != "" {
+		req.Header.Set(fiber.HeaderAcceptLanguage, acceptLanguage)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return key, ok
+}
+
+func TestListCacheKeyNormalises(t *testing.T) {
+	type request struct{ query, acceptLanguage string }
+	tests := []struct {
+		name string
+		a, b request
+	}{
+		{"parameter order", request{"?region=Africa&sort=gdp_desc", ""}, request{"?sort=gdp_desc&region=Africa", ""}},
+		{"empty parameters", request{"?region=Africa&currency=", ""}, request{"?region=Africa", ""}},
+		{"default offset", request{"?limit=100&offset=0", ""}, request{"?limit=100", ""}},
+		{"zero-padded numbers", request{"?limit=010", ""}, request{"?limit=10", ""}},
+		{"english by parameter", request{"?lang=en", ""}, request{"", ""}},
+		{"english by header", request{"", "en-US,en;q=0.9"}, request{"", ""}},
+		{"language by parameter or header", request{"?lang=fr", ""}, request{"", "fr"}},
+		{"unknown parameters by name", request{"?utm_source=a", ""}, request{"?utm_source=b", ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyA, okA := cacheKeyFor(t, tt.a.query, tt.a.acceptLanguage)
+			keyB, okB := cacheKeyFor(t, tt.b.query, tt.b.acceptLanguage)
+			if !okA || !okB {
+				t.Fatalf("listCacheKey refused %+v (%v) or %+v (%v)", tt.a, okA, tt.b, okB)
+			}
+			if keyA != keyB {
+				t.Errorf("keys differ: %q for %+v, %q for %+v", keyA, tt.a, keyB, tt.b)
+			}
+		})
+	}
+}
+
+func TestListCacheKeyDistinguishes(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  string
+		langA string
+		langB string
+	}{
+		{name: "filter value", a: "?region=Africa", b: "?region=Europe"},
+		{name: "page", a: "?limit=10", b: "?limit=20"},
+		{name: "offset", a: "?limit=10&offset=10", b: "?limit=10&offset=20"},
+		{name: "sort", a: "?sort=gdp_desc", b: "?sort=gdp_asc"},
+		{name: "language", a: "", b: "", langB: "de"},
+		{name: "unknown parameter", a: "?utm_source=a", b: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyA, _ := cacheKeyFor(t, tt.a, tt.langA)
+			keyB, _ := cacheKeyFor(t, tt.b, tt.langB)
+			if keyA == keyB {
+				t.Errorf("%q and %q share the key %q", tt.a, tt.b, keyA)
+			}
+		})
+	}
+}
+
+func TestListCacheKeySkipsInvalidRequests(t *testing.T) {
+	for _, query := range []string{"?lang=xx", "?limit=abc", "?offset=-1"} {
+		if key, ok := cacheKeyFor(t, query, ""); ok {
+			t.Errorf("listCacheKey(%q) = %q, want no key", query, key)
+		}
+	}
+}
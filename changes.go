@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// CountryTombstone records a deleted country so incremental syncs can
+// remove it downstream
+type CountryTombstone struct {
+	ID        uint      `gorm:"primaryKey" json:"-" xml:"-"`
+	Name      string    `gorm:"type:varchar(512);index;not null" json:"name" xml:"name"`
+	DeletedAt time.Time `gorm:"index;not null" json:"deleted_at" xml:"deleted_at"`
+}
+
+// recordTombstone stores a deletion marker for the given country
+func recordTombstone(tx *gorm.DB, name string) error {
+	return tx.Create(&CountryTombstone{Name: name, DeletedAt: clock.Now()}).Error
+}
+
+// deltaListResponse is a list envelope answering ?since=: the rows
+// refreshed in (since, until] plus the countries deleted in that span.
+// Pass until as the next since.
+type deltaListResponse struct {
+	listResponse
+	Until   time.Time          `json:"until"`
+	Deleted []CountryTombstone `json:"deleted"`
+}
+
+// sendDelta sends a delta list, adding the tombstones of the span
+func sendDelta(c *fiber.Ctx, countries []Country, meta listMeta, since, until time.Time) error {
+	deleted := []CountryTombstone{}
+	if err := db.Where("deleted_at > ? AND deleted_at <= ?", since, until).
+		Order("deleted_at ASC").Find(&deleted).Error; err != nil {
+		return err
+	}
+	if wantsXML(c) {
+		return sendXML(c, "countries", countryListXML{Data: countries, Meta: meta, Until: &until,
+			Deleted: &tombstoneXML{deleted}})
+	}
+	return c.JSON(deltaListResponse{
+		listResponse: listResponse{Data: countries, Meta: meta},
+		Until:        until,
+		Deleted:      deleted,
+	})
+}
+
+// parseSince reads the required ?since= RFC3339 timestamp
+func parseSince(c *fiber.Ctx) (time.Time, error) {
+	raw := c.Query("since")
+	if raw == "" {
+		return time.Time{}, errors.New("since is required")
+	}
+	since, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, errors.New("since must be an RFC3339 timestamp")
+	}
+	return since.UTC(), nil
+}
+
+func getCountryChanges(c *fiber.Ctx) error {
+	since, err := parseSince(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	// Capture the upper bound first so nothing falls between two syncs
+	until := clock.Now()
+
+	var created, updated []Country
+	var deleted []CountryTombstone
+
+	// Creates and updates come from the history rather than created_at and
+	// updated_at: every full refresh bumps updated_at whether or not a
+	// value moved, and both are write times, while history is stamped with
+	// the time the data took effect, so a backfill lands in the window it
+	// describes
+	if err := historyCountries(db, historyCreate, since, until).
+		Order("created_at ASC").Find(&created).Error; err != nil {
+		return err
+	}
+	if err := historyCountries(db, historyUpdate, since, until).
+		Where(countryKey+" NOT IN (?)", historyKeys(db, historyCreate, since, until)).
+		Order("updated_at ASC").Find(&updated).Error; err != nil {
+		return err
+	}
+
+	if err := attachCurrencies(db, created); err != nil {
+		return err
+	}
+	if err := attachCurrencies(db, updated); err != nil {
+		return err
+	}
+
+	if err := db.Where("deleted_at > ? AND deleted_at <= ?", since, until).
+		Order("deleted_at ASC").Find(&deleted).Error; err != nil {
+		return err
+	}
+
+	if wantsXML(c) {
+		return sendXML(c, "changes", struct {
+			Since   time.Time          `xml:"since"`
+			Until   time.Time          `xml:"until"`
+			Created []Country          `xml:"created>country"`
+			Updated []Country          `xml:"updated>country"`
+			Deleted []CountryTombstone `xml:"deleted>country"`
+		}{since, until, created, updated, deleted})
+	}
+	return c.JSON(fiber.Map{
+		"since":   since,
+		"until":   until,
+		"created": created,
+		"updated": updated,
+		"deleted": deleted,
+	})
+}
+
+// countryKey and historyKey identify a country in countries and in its
+// history: by alpha-3 code, which survives renames, or by name for
+// countries without one
+const (
+	countryKey = "COALESCE(alpha3_code, name)"
+	historyKey = "COALESCE(country_code, country)"
+)
+
+// historyKeys selects the keys of the countries with history of the given
+// action in (since, until]
+func historyKeys(conn *gorm.DB, action string, since, until time.Time) *gorm.DB {
+	return conn.Model(&CountryHistory{}).Select(historyKey).
+		Where("action = ? AND changed_at > ? AND changed_at <= ?", action, since, until)
+}
+
+// historyCountries selects the live countries with history of the given
+// action in (since, until]
+func historyCountries(conn *gorm.DB, action string, since, until time.Time) *gorm.DB {
+	return conn.Model(&Country{}).Where(countryKey+" IN (?)", historyKeys(conn, action, since, until))
+}
+
+// refreshChanges selects the countries a refresh changed: those with
+// refresh or rates refresh history between from and to. Countries deleted
+// since are left out.
+func refreshChanges(conn *gorm.DB, from, to time.Time) *gorm.DB {
+	keys := conn.Model(&CountryHistory{}).Select(historyKey).
+		Where("source IN ? AND changed_at >= ? AND changed_at <= ?",
+			[]string{historySourceRefresh, historySourceRates}, from, to)
+	return conn.Model(&Country{}).Where(countryKey+" IN (?)", keys)
+}
+
+// getRefreshChanges pages through the countries a refresh changed, by the
+// ?from and ?to bounds of its history, as full records in name order.
+// Delta webhooks link here for the changes past their cap.
+func getRefreshChanges(c *fiber.Ctx) error {
+	invalid := func(details string) error {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": details,
+		})
+	}
+
+	meta := newListMeta(c, "from", "to", "limit", "offset", "format")
+	meta.Sort = "name_asc"
+
+	page, err := parsePage(c)
+	if err == nil && page.Cursor {
+		err = errors.New("cursor paging is not supported; use limit and offset")
+	}
+	if err != nil {
+		return invalid(err.Error())
+	}
+	if page.Limit == 0 {
+		page.Limit = defaultPageLimit
+	}
+
+	var bounds [2]time.Time
+	for i, param := range []string{"from", "to"} {
+		at, err := time.Parse(time.RFC3339, c.Query(param))
+		if err != nil {
+			return invalid(param + " is required as an RFC3339 timestamp")
+		}
+		bounds[i] = at.UTC()
+		meta.filter(param, bounds[i].Format(time.RFC3339))
+	}
+
+	query := refreshChanges(db.WithContext(c.UserContext()), bounds[0], bounds[1])
+	if err := query.Count(&meta.Total).Error; err != nil {
+		return err
+	}
+	countries := []Country{}
+	if err := query.Order("name ASC").Limit(page.Limit).Offset(page.Offset).Find(&countries).Error; err != nil {
+		return err
+	}
+	if err := attachCurrencies(db, countries); err != nil {
+		return err
+	}
+	meta.Page = pageMetaFor(page, "")
+	return sendCountryList(c, countries, meta)
+}
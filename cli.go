@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// Exit codes of the CLI subcommands, so cron jobs and CI can branch on the
+// outcome. Usage errors exit 2, as they do for Go's flag package.
+const (
+	exitOK     = 0
+	exitFailed = 1
+	exitUsage  = 2
+	// exitUpstream means an upstream was unreachable or sent data that was
+	// rejected; retrying later may succeed
+	exitUpstream = 3
+	// exitPartial means the command finished but skipped some records
+	exitPartial = 4
+)
+
+// jsonOutput is set by --json: subcommands print one JSON document on
+// stdout, and logs go to stderr
+var jsonOutput bool
+
+// cliResult is what a subcommand prints with --json
+type cliResult struct {
+	Command  string      `json:"command"`
+	OK       bool        `json:"ok"`
+	ExitCode int         `json:"exit_code"`
+	Error    string      `json:"error,omitempty"`
+	Result   interface{} `json:"result,omitempty"`
+}
+
+// commandArgs returns the subcommand and its arguments, accepting --json
+// after the subcommand as well as before it
+func commandArgs(args []string) []string {
+	rest := []string{}
+	for _, arg := range args {
+		if arg == "--json" || arg == "-json" {
+			jsonOutput = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return rest
+}
+
+// printResult prints the JSON result of a command and returns its exit
+// code; err, when set, is reported as the error
+func printResult(command string, code int, result interface{}, err error) int {
+	out := cliResult{Command: command, OK: code == exitOK, ExitCode: code, Result: result}
+	if err != nil {
+		out.Error = err.Error()
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	enc.Encode(out)
+	return code
+}
+
+// usageError reports a malformed command line as text on stderr, or as
+// JSON with --json
+func usageError(command, usage string) int {
+	if jsonOutput {
+		return printResult(command, exitUsage, nil, errors.New(usage))
+	}
+	fmt.Fprintln(os.Stderr, usage)
+	return exitUsage
+}
+
+// refreshExitCode classifies the outcome of a refresh
+func refreshExitCode(summary *refreshSummary, err error) int {
+	var upstreams upstreamErrors
+	var upstream *upstreamError
+	var schema *schemaError
+	switch {
+	case err == nil && len(summary.Errors) > 0:
+		return exitPartial
+	case err == nil:
+		return exitOK
+	case errors.As(err, &upstreams), errors.As(err, &upstream),
+		errors.As(err, &schema), errors.Is(err, errStagingInvalid):
+		return exitUpstream
+	}
+	return exitFailed
+}
+
+// runRefreshCommand handles `./app refresh [countries|rates]`: it runs one
+// refresh, prints its summary and returns the process exit code. With
+// --mock-upstreams it seeds a database from the fixtures.
+func runRefreshCommand(args []string) int {
+	const usage = "usage: refresh [countries|rates]"
+	kind := "countries"
+	if len(args) == 1 {
+		kind = args[0]
+	}
+	if len(args) > 1 || (kind != "countries" && kind != "rates") {
+		return usageError("refresh", usage)
+	}
+
+	run := runFullRefresh
+	if kind == "rates" {
+		run = runRatesRefresh
+	}
+	summary, err := run(clock.Now())
+	// The background publisher does not run in a one-shot command
+	if err == nil && standbyURL != "" {
+		if err := publishStandbySnapshot(); err != nil {
+			log.Printf("Failed to publish standby snapshot: %v", err)
+		}
+	}
+	return reportRefresh("refresh", summary, err)
+}
+
+// reportRefresh prints the outcome of a refresh or replay run from the
+// command line and returns the process exit code
+func reportRefresh(command string, summary *refreshSummary, err error) int {
+	code := refreshExitCode(summary, err)
+	if jsonOutput {
+		return printResult(command, code, summary, err)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %v\n", command, err)
+		return code
+	}
+	out, _ := json.MarshalIndent(summary, "", "  ")
+	fmt.Println(string(out))
+	if code == exitPartial {
+		fmt.Fprintf(os.Stderr, "%d of %d records were skipped\n", len(summary.Errors), summary.Processed)
+	}
+	return code
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	// Embedded zone database so ?tz= works in minimal containers
+	_ "time/tzdata"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Clock is the time source used for refresh timestamps and seeding. It is a
+// package variable so tests and backfills can substitute a fixed time.
+// Timestamps are always stored in UTC.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now().UTC()
+}
+
+// fixedClock always reports the same instant
+type fixedClock struct {
+	t time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.t
+}
+
+var clock Clock = systemClock{}
+
+// parseTimezone reads the optional ?tz= IANA zone used when rendering
+// human-facing times; it defaults to UTC
+func parseTimezone(c *fiber.Ctx) (*time.Location, error) {
+	name := c.Query("tz")
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+	return loc, nil
+}
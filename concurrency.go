@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// defaultQueueTimeout is how long a request may wait for a slot unless
+// CONCURRENCY_QUEUE_TIMEOUT says otherwise
+const defaultQueueTimeout = 10 * time.Second
+
+// concurrencyLimit is how many requests of a pool run at once and how many
+// more may wait for a slot
+type concurrencyLimit struct {
+	running int
+	queued  int
+}
+
+// defaultConcurrencyLimits bound the pools of expensive routes; override
+// with CONCURRENCY_LIMITS
+var defaultConcurrencyLimits = map[string]concurrencyLimit{
+	"refresh": {running: 1, queued: 4},
+	"images":  {running: 2, queued: 8},
+	"exports": {running: 2, queued: 4},
+}
+
+// concurrencyPool is the semaphore of one pool of routes
+type concurrencyPool struct {
+	name  string
+	limit concurrencyLimit
+	slots chan struct{}
+
+	mu       sync.Mutex
+	waiting  int
+	rejected int64
+}
+
+// concurrencyPoolState is a pool as reported by GET /status
+type concurrencyPoolState struct {
+	Limit    int   `json:"limit"`
+	Queue    int   `json:"queue"`
+	Running  int   `json:"running"`
+	Waiting  int   `json:"waiting"`
+	Rejected int64 `json:"rejected"`
+}
+
+var (
+	concurrencyPools = map[string]*concurrencyPool{}
+	queueTimeout     = defaultQueueTimeout
+)
+
+// loadConcurrencyLimits reads CONCURRENCY_LIMITS, comma-separated
+// pool=running[/queued] pairs such as "images=4/16,exports=1" (0 running
+// disables a pool), over the defaults, and CONCURRENCY_QUEUE_TIMEOUT
+func loadConcurrencyLimits() error {
+	limits := make(map[string]concurrencyLimit, len(defaultConcurrencyLimits))
+	for pool, limit := range defaultConcurrencyLimits {
+		limits[pool] = limit
+	}
+	for _, pair := range strings.Split(os.Getenv("CONCURRENCY_LIMITS"), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		invalid := fmt.Errorf("invalid CONCURRENCY_LIMITS entry %q (expected pool=running[/queued])", pair)
+		pool, raw, ok := strings.Cut(pair, "=")
+		if !ok {
+			return invalid
+		}
+		pool = strings.TrimSpace(pool)
+		if _, known := defaultConcurrencyLimits[pool]; !known {
+			return fmt.Errorf("invalid CONCURRENCY_LIMITS entry %q: pool must be one of refresh, images, exports", pair)
+		}
+		running, queued, hasQueue := strings.Cut(strings.TrimSpace(raw), "/")
+		var limit concurrencyLimit
+		var err error
+		if limit.running, err = strconv.Atoi(running); err != nil || limit.running < 0 {
+			return invalid
+		}
+		if hasQueue {
+			if limit.queued, err = strconv.Atoi(queued); err != nil || limit.queued < 0 {
+				return invalid
+			}
+		}
+		limits[pool] = limit
+	}
+
+	timeout := defaultQueueTimeout
+	if raw := os.Getenv("CONCURRENCY_QUEUE_TIMEOUT"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid CONCURRENCY_QUEUE_TIMEOUT %q", raw)
+		}
+		timeout = d
+	}
+
+	pools := make(map[string]*concurrencyPool, len(limits))
+	for name, limit := range limits {
+		if limit.running == 0 {
+			continue
+		}
+		pools[name] = &concurrencyPool{name: name, limit: limit, slots: make(chan struct{}, limit.running)}
+	}
+	concurrencyPools, queueTimeout = pools, timeout
+	return nil
+}
+
+// acquire takes a slot, waiting up to timeout when the pool is busy and its
+// queue has room. It reports false when the request should be turned away.
+func (p *concurrencyPool) acquire(timeout time.Duration) bool {
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	p.mu.Lock()
+	if p.waiting >= p.limit.queued {
+		p.rejected++
+		p.mu.Unlock()
+		return false
+	}
+	p.waiting++
+	p.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var acquired bool
+	select {
+	case p.slots <- struct{}{}:
+		acquired = true
+	case <-timer.C:
+	}
+
+	p.mu.Lock()
+	p.waiting--
+	if !acquired {
+		p.rejected++
+	}
+	p.mu.Unlock()
+	return acquired
+}
+
+func (p *concurrencyPool) release() {
+	<-p.slots
+}
+
+func (p *concurrencyPool) snapshot() concurrencyPoolState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return concurrencyPoolState{
+		Limit:    p.limit.running,
+		Queue:    p.limit.queued,
+		Running:  len(p.slots),
+		Waiting:  p.waiting,
+		Rejected: p.rejected,
+	}
+}
+
+// limitConcurrency returns middleware running at most the pool's limit of
+// its routes at once. Requests beyond it wait in a bounded queue; a full
+// queue or a wait past CONCURRENCY_QUEUE_TIMEOUT is answered with 429.
+func limitConcurrency(pool string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		p := concurrencyPools[pool]
+		if p == nil {
+			return c.Next()
+		}
+		if !p.acquire(queueTimeout) {
+			retry := max(int(queueTimeout.Seconds()), 1)
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retry))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error":   "Too many concurrent requests",
+				"details": fmt.Sprintf("%s runs %d requests at once with %d queued; retry in %ds", p.name, p.limit.running, p.limit.queued, retry),
+			})
+		}
+		slot := &concurrencySlot{pool: p}
+		c.Locals("concurrency_slot", slot)
+		defer func() {
+			if !slot.kept {
+				p.release()
+			}
+		}()
+		return c.Next()
+	}
+}
+
+// concurrencySlot is the slot limitConcurrency took for a request
+type concurrencySlot struct {
+	pool *concurrencyPool
+	kept bool
+}
+
+// keepConcurrencySlot hands the request's slot to work that outlives the
+// handler, such as a response body stream writer, which must call the
+// returned func once done. Without a slot it returns a no-op.
+func keepConcurrencySlot(c *fiber.Ctx) func() {
+	slot, ok := c.Locals("concurrency_slot").(*concurrencySlot)
+	if !ok || slot.kept {
+		return func() {}
+	}
+	slot.kept = true
+	return slot.pool.release
+}
+
+// concurrencySnapshot reports every enabled pool for GET /status
+func concurrencySnapshot() map[string]concurrencyPoolState {
+	pools := make(map[string]concurrencyPoolState, len(concurrencyPools))
+	for name, p := range concurrencyPools {
+		pools[name] = p.snapshot()
+	}
+	return pools
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxBatchConversions caps the line items accepted by one batch request
+const maxBatchConversions = 1000
+
+// conversionRequest is one {from,to,amount} tuple
+type conversionRequest struct {
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Amount float64 `json:"amount"`
+}
+
+// conversionResult echoes the request with its converted amount, or the
+// reason it could not be converted. Result is rounded to the minor unit of
+// the target currency; Formatted shows it with exactly that many decimals.
+type conversionResult struct {
+	From       string   `json:"from"`
+	To         string   `json:"to"`
+	Amount     float64  `json:"amount"`
+	Result     *float64 `json:"result"`
+	Formatted  *string  `json:"formatted"`
+	MinorUnits *int     `json:"minor_units"`
+	Rate       *float64 `json:"rate"`
+	Error      *string  `json:"error,omitempty"`
+}
+
+// storedRates returns the USD-based rate for every currency held in the
+// countries table. USD itself is always present.
+func storedRates() (map[string]float64, error) {
+	var rows []struct {
+		CurrencyCode string
+		ExchangeRate float64
+	}
+	if err := db.Model(&Country{}).
+		Select("DISTINCT currency_code, exchange_rate").
+		Where("currency_code IS NOT NULL AND exchange_rate IS NOT NULL").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	rates := map[string]float64{"USD": 1}
+	for _, row := range rows {
+		rates[row.CurrencyCode] = row.ExchangeRate
+	}
+	return rates, nil
+}
+
+// convert applies the USD-based rates to one request
+func convert(req conversionRequest, rates map[string]float64) conversionResult {
+	res := conversionResult{
+		From:   strings.ToUpper(req.From),
+		To:     strings.ToUpper(req.To),
+		Amount: req.Amount,
+	}
+
+	fail := func(format string, args ...interface{}) conversionResult {
+		msg := fmt.Sprintf(format, args...)
+		res.Error = &msg
+		return res
+	}
+
+	if res.From == "" || res.To == "" {
+		return fail("from and to are required")
+	}
+	if math.IsNaN(req.Amount) || math.IsInf(req.Amount, 0) {
+		return fail("amount must be a finite number")
+	}
+	fromRate, ok := rates[res.From]
+	if !ok || fromRate <= 0 {
+		return fail("no exchange rate for %s", res.From)
+	}
+	toRate, ok := rates[res.To]
+	if !ok || toRate <= 0 {
+		return fail("no exchange rate for %s", res.To)
+	}
+
+	rate := toRate / fromRate
+	result := roundMinor(req.Amount*rate, res.To)
+	formatted := formatAmount(result, res.To)
+	units := minorUnits(res.To)
+	res.Rate = &rate
+	res.Result = &result
+	res.Formatted = &formatted
+	res.MinorUnits = &units
+	return res
+}
+
+func convertBatch(c *fiber.Ctx) error {
+	var reqs []conversionRequest
+	if err := c.BodyParser(&reqs); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": "body must be a JSON array of {from, to, amount}",
+		})
+	}
+	if len(reqs) == 0 || len(reqs) > maxBatchConversions {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": fmt.Sprintf("batch must contain between 1 and %d conversions", maxBatchConversions),
+		})
+	}
+
+	rates, err := storedRates()
+	if err != nil {
+		return err
+	}
+	asOf, err := latestRateAsOf(db)
+	if err != nil {
+		return err
+	}
+
+	results := make([]conversionResult, len(reqs))
+	failed := 0
+	for i, req := range reqs {
+		results[i] = convert(req, rates)
+		if results[i].Error != nil {
+			failed++
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"total":       len(results),
+		"failed":      failed,
+		"rates_as_of": asOf,
+		"conversions": results,
+	})
+}
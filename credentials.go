@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// rotatableCredentials are the upstream credentials that can be read from a
+// file named by <NAME>_FILE, such as a Docker or Kubernetes secret mount,
+// instead of the variable itself. File-backed values are re-read when the
+// file changes or the process receives SIGHUP, so a rotated key takes
+// effect without a restart.
+var rotatableCredentials = []string{
+	"EXCHANGERATE_HOST_ACCESS_KEY",
+	"MAXMIND_ACCOUNT_ID",
+	"MAXMIND_LICENSE_KEY",
+}
+
+// credentialFile is the state of one file-backed credential
+type credentialFile struct {
+	path     string
+	value    string
+	modTime  time.Time
+	size     int64
+	loadedAt time.Time
+	// reloadErr is the latest failed reload, logged once
+	reloadErr string
+}
+
+// credentialStatus is reported per credential by /status; values are never
+// included
+type credentialStatus struct {
+	// Source is "file" or "env"
+	Source   string     `json:"source"`
+	Path     string     `json:"path,omitempty"`
+	LoadedAt *time.Time `json:"loaded_at,omitempty"`
+}
+
+var (
+	credentialsMu   sync.RWMutex
+	credentialFiles = map[string]*credentialFile{}
+	// credentialsPollInterval is how often files are checked for changes;
+	// zero leaves rotation to SIGHUP
+	credentialsPollInterval = 30 * time.Second
+)
+
+// credential returns the current value of an upstream credential: the
+// contents of its file when <NAME>_FILE is set, otherwise the variable
+func credential(name string) string {
+	credentialsMu.RLock()
+	defer credentialsMu.RUnlock()
+	if file, ok := credentialFiles[name]; ok {
+		return file.value
+	}
+	return os.Getenv(name)
+}
+
+// readCredentialFile reads a credential file, trimming the trailing newline
+// most secret tooling writes
+func readCredentialFile(name, path string) (*credentialFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s_FILE: %w", name, err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s_FILE: %w", name, err)
+	}
+	value := strings.TrimSpace(string(raw))
+	if value == "" {
+		return nil, fmt.Errorf("%s_FILE %s is empty", name, path)
+	}
+	return &credentialFile{path: path, value: value, modTime: info.ModTime(), size: info.Size(), loadedAt: clock.Now()}, nil
+}
+
+// loadCredentials reads every credential that has a <NAME>_FILE, and
+// CREDENTIALS_POLL_INTERVAL (Go duration, default 30s; 0 disables polling).
+// Setting both the file and the variable is an error, as it is unclear which
+// wins.
+func loadCredentials() error {
+	if os.Getenv("CREDENTIALS_POLL_INTERVAL") != "" {
+		d, err := parseInterval("CREDENTIALS_POLL_INTERVAL")
+		if err != nil {
+			return err
+		}
+		credentialsPollInterval = d
+	}
+
+	files := map[string]*credentialFile{}
+	for _, name := range rotatableCredentials {
+		path := os.Getenv(name + "_FILE")
+		if path == "" {
+			continue
+		}
+		if os.Getenv(name) != "" {
+			return fmt.Errorf("both %s and %s_FILE are set", name, name)
+		}
+		file, err := readCredentialFile(name, path)
+		if err != nil {
+			return err
+		}
+		files[name] = file
+	}
+
+	credentialsMu.Lock()
+	credentialFiles = files
+	credentialsMu.Unlock()
+	return nil
+}
+
+// reloadCredentials re-reads file-backed credentials. Unless force is set,
+// only files whose size or modification time changed are read. A file that
+// cannot be read, say mid-rotation, keeps its previous value.
+func reloadCredentials(force bool) {
+	credentialsMu.RLock()
+	current := make(map[string]*credentialFile, len(credentialFiles))
+	for name, file := range credentialFiles {
+		current[name] = file
+	}
+	credentialsMu.RUnlock()
+
+	for name, file := range current {
+		if !force {
+			info, err := os.Stat(file.path)
+			if err == nil && info.ModTime().Equal(file.modTime) && info.Size() == file.size {
+				continue
+			}
+		}
+		next, err := readCredentialFile(name, file.path)
+		if err != nil {
+			if err.Error() != file.reloadErr {
+				log.Printf("Keeping the previous %s: %v", name, err)
+				failed := *file
+				failed.reloadErr = err.Error()
+				credentialsMu.Lock()
+				credentialFiles[name] = &failed
+				credentialsMu.Unlock()
+			}
+			continue
+		}
+		credentialsMu.Lock()
+		credentialFiles[name] = next
+		credentialsMu.Unlock()
+		if next.value != file.value {
+			log.Printf("Reloaded %s from %s", name, file.path)
+		}
+	}
+}
+
+// watchCredentials re-reads changed credential files every poll interval
+// and all of them on SIGHUP. Polling also sees Kubernetes secret updates,
+// which swap a symlink rather than write the file. Without credential files
+// SIGHUP keeps its default behaviour.
+func watchCredentials() {
+	credentialsMu.RLock()
+	watched := len(credentialFiles)
+	credentialsMu.RUnlock()
+	if watched == 0 {
+		return
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if credentialsPollInterval > 0 {
+		tick = time.NewTicker(credentialsPollInterval).C
+	}
+	go func() {
+		for {
+			select {
+			case <-hup:
+				log.Println("SIGHUP: reloading credential files")
+				reloadCredentials(true)
+			case <-tick:
+				reloadCredentials(false)
+			}
+		}
+	}()
+}
+
+// credentialsSnapshot reports where each configured credential comes from
+func credentialsSnapshot() map[string]credentialStatus {
+	credentialsMu.RLock()
+	defer credentialsMu.RUnlock()
+	snapshot := map[string]credentialStatus{}
+	for _, name := range rotatableCredentials {
+		if file, ok := credentialFiles[name]; ok {
+			loadedAt := file.loadedAt
+			snapshot[name] = credentialStatus{Source: "file", Path: file.path, LoadedAt: &loadedAt}
+		} else if os.Getenv(name) != "" {
+			snapshot[name] = credentialStatus{Source: "env"}
+		}
+	}
+	return snapshot
+}
@@ -0,0 +1,564 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// overridableFields are the country fields an override can set, with the
+// column size of each text field. Population takes a positive integer and
+// currency_code an ISO 4217 code, which becomes the primary currency.
+var overridableFields = map[string]int{
+	"capital":       255,
+	"region":        100,
+	"subregion":     100,
+	"flag_url":      2048,
+	"population":    0,
+	"currency_code": 10,
+}
+
+// overridableFieldList names overridableFields in messages
+const overridableFieldList = "capital, region, subregion, flag_url, population, currency_code"
+
+// CountryOverride pins one field of one country to a curated value. Every
+// full refresh applies it over the upstream value.
+type CountryOverride struct {
+	// Country is an ISO 3166-1 alpha-3 code or the country's name; when
+	// both match a country, the code's override wins
+	Country string `gorm:"type:varchar(512);primaryKey" json:"country" yaml:"country"`
+	Field   string `gorm:"type:varchar(32);primaryKey" json:"field" yaml:"field"`
+	// Value is empty to clear a text field
+	Value     string    `gorm:"type:text;not null" json:"value" yaml:"value"`
+	Note      string    `gorm:"type:varchar(255)" json:"note,omitempty" yaml:"note,omitempty"`
+	UpdatedBy string    `gorm:"type:varchar(512);serializer:encrypted" json:"-" yaml:"-"`
+	UpdatedAt time.Time `json:"-" yaml:"-"`
+}
+
+// CountryAlias maps an informal name to a country for POST /resolve, in
+// addition to the built-in aliases, which it takes precedence over
+type CountryAlias struct {
+	// Alias is stored folded with foldName
+	Alias     string    `gorm:"type:varchar(255);primaryKey" json:"alias" yaml:"alias"`
+	Code      string    `gorm:"type:varchar(3);not null" json:"code" yaml:"code"`
+	Note      string    `gorm:"type:varchar(255)" json:"note,omitempty" yaml:"note,omitempty"`
+	UpdatedBy string    `gorm:"type:varchar(512);serializer:encrypted" json:"-" yaml:"-"`
+	UpdatedAt time.Time `json:"-" yaml:"-"`
+}
+
+// curationDocument is the export and import format of the curated tables.
+// Rows are sorted and carry no timestamps, so an export diffs cleanly in
+// Git and exporting twice gives the same file.
+type curationDocument struct {
+	Overrides []CountryOverride `json:"overrides" yaml:"overrides"`
+	Aliases   []CountryAlias    `json:"aliases" yaml:"aliases"`
+}
+
+// curationCounts is what an import did, or would do, to one table
+type curationCounts struct {
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Deleted   int `json:"deleted"`
+	Unchanged int `json:"unchanged"`
+}
+
+var (
+	curatedAliasesMu sync.RWMutex
+	// curatedAliases maps folded aliases to alpha-3 codes
+	curatedAliases = map[string]string{}
+)
+
+// loadCuratedAliases reads the stored aliases used by the resolver
+func loadCuratedAliases() error {
+	var rows []CountryAlias
+	if err := db.Find(&rows).Error; err != nil {
+		return err
+	}
+	aliases := make(map[string]string, len(rows))
+	for _, row := range rows {
+		aliases[row.Alias] = row.Code
+	}
+	curatedAliasesMu.Lock()
+	curatedAliases = aliases
+	curatedAliasesMu.Unlock()
+	return nil
+}
+
+// aliasCode returns the alpha-3 code of a folded alias, curated first
+func aliasCode(alias string) (string, bool) {
+	curatedAliasesMu.RLock()
+	code, ok := curatedAliases[alias]
+	curatedAliasesMu.RUnlock()
+	if ok {
+		return code, true
+	}
+	code, ok = countryAliases[alias]
+	return code, ok
+}
+
+// loadOverrides returns the stored overrides keyed by their upper-cased
+// country
+func loadOverrides(tx *gorm.DB) (map[string][]CountryOverride, error) {
+	var rows []CountryOverride
+	if err := tx.Order("country, field").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	overrides := map[string][]CountryOverride{}
+	for _, row := range rows {
+		key := strings.ToUpper(row.Country)
+		overrides[key] = append(overrides[key], row)
+	}
+	return overrides, nil
+}
+
+// applyOverrides sets the overridden fields of a built row and records
+// them in FieldSources. A population override also re-derives the tier and
+// an estimated GDP; a currency_code override takes its rate from rates. A
+// gdp_multiplier override re-draws an estimated GDP from its range.
+func applyOverrides(row *Country, overrides map[string][]CountryOverride, rates map[string]float64, rng *rand.Rand) {
+	matched := overrides[strings.ToUpper(row.Name)]
+	if row.Alpha3Code != nil {
+		matched = append(matched, overrides[strings.ToUpper(*row.Alpha3Code)]...)
+	}
+	// The multiplier goes first so population and currency overrides
+	// re-estimate with it
+	if m := gdpMultiplierOf(matched); m != nil {
+		row.GDPMultiplier = m
+		if row.FieldSources["estimated_gdp"] == sourceEstimate && row.ExchangeRate != nil {
+			gdp := estimateGDP(row.Population, *row.ExchangeRate, m, rng)
+			row.EstimatedGDP = &gdp
+			row.GDPTier = gdpTierFor(row.EstimatedGDP, row.Population)
+		}
+	}
+	for _, override := range matched {
+		value := override.Value
+		switch override.Field {
+		case "capital":
+			row.Capital = nilIfEmpty(&value)
+		case "region":
+			row.Region = nilIfEmpty(&value)
+		case "subregion":
+			row.Subregion = nilIfEmpty(&value)
+		case "flag_url":
+			row.FlagURL = nilIfEmpty(&value)
+		case "population":
+			population, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || population <= 0 {
+				continue
+			}
+			setPopulation(row, population, rng)
+		case "currency_code":
+			if !currencyCodePattern.MatchString(value) {
+				continue
+			}
+			setPrimaryCurrency(row, value, rates, rng)
+		default:
+			continue
+		}
+		if row.FieldSources == nil {
+			row.FieldSources = map[string]string{}
+		}
+		row.FieldSources[override.Field] = sourceOverride
+	}
+}
+
+// setPopulation changes the population of a row, re-deriving its tier and
+// an estimated GDP drawn with rng
+func setPopulation(row *Country, population int64, rng *rand.Rand) {
+	row.Population = population
+	row.PopulationTier = populationTierFor(population)
+	if row.FieldSources["estimated_gdp"] == sourceEstimate && row.ExchangeRate != nil {
+		gdp := estimateGDP(population, *row.ExchangeRate, row.GDPMultiplier, rng)
+		row.EstimatedGDP = &gdp
+		row.GDPTier = gdpTierFor(row.EstimatedGDP, population)
+	}
+}
+
+// setPrimaryCurrency makes code the primary currency of a row, with its
+// rate from rates, moving it to the front of Currencies or adding it there.
+// An estimated GDP is re-derived, and unset when the rate is unknown. The
+// rate keeps the row's rate_as_of, rates being fetched together; callers
+// fill it for a row that had no rate.
+func setPrimaryCurrency(row *Country, code string, rates map[string]float64, rng *rand.Rand) {
+	currency := CountryCurrency{Code: code}
+	others := []CountryCurrency{}
+	for _, existing := range row.Currencies {
+		if existing.Code == code {
+			currency = existing
+			continue
+		}
+		others = append(others, existing)
+	}
+	asOf := row.RateAsOf
+	if asOf == nil {
+		asOf = currency.RateAsOf
+	}
+	currency.ExchangeRate, currency.RateAsOf = nil, nil
+	if rate, ok := rates[code]; ok {
+		currency.ExchangeRate, currency.RateAsOf = &rate, asOf
+	}
+	row.Currencies = append([]CountryCurrency{currency}, others...)
+	for i := range row.Currencies {
+		row.Currencies[i].Position, row.Currencies[i].IsPrimary = i, i == 0
+	}
+
+	row.CurrencyCode = &code
+	row.CurrencyName, row.CurrencySymbol = currency.Name, currency.Symbol
+	row.ExchangeRate, row.RateAsOf = currency.ExchangeRate, currency.RateAsOf
+	if row.FieldSources["estimated_gdp"] == sourceEstimate {
+		row.EstimatedGDP = nil
+		if row.ExchangeRate != nil {
+			gdp := estimateGDP(row.Population, *row.ExchangeRate, row.GDPMultiplier, rng)
+			row.EstimatedGDP = &gdp
+		}
+		row.GDPTier = gdpTierFor(row.EstimatedGDP, row.Population)
+	}
+}
+
+// overrideValueProblem checks a trimmed value for an overridable field and
+// describes what is wrong with it, or returns ""
+func overrideValueProblem(field, value string) string {
+	switch size := overridableFields[field]; {
+	case field == "population":
+		if n, err := strconv.ParseInt(value, 10, 64); err != nil || n <= 0 {
+			return "population must be a positive integer"
+		}
+	case field == "currency_code":
+		if !currencyCodePattern.MatchString(value) {
+			return "currency_code must be a three-letter currency code"
+		}
+	case len(value) > size:
+		return fmt.Sprintf("%s is %d characters (limit %d)", field, len(value), size)
+	case field == "flag_url" && value != "":
+		if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "flag_url must be an http or https URL"
+		}
+	}
+	return ""
+}
+
+// normalize trims and folds the document in place, sorts it and returns
+// every problem found
+func (doc *curationDocument) normalize() []string {
+	var problems []string
+	seen := map[string]bool{}
+	for i := range doc.Overrides {
+		o := &doc.Overrides[i]
+		o.Country, o.Field = strings.TrimSpace(o.Country), strings.TrimSpace(o.Field)
+		o.Value, o.Note = strings.TrimSpace(o.Value), strings.TrimSpace(o.Note)
+		if isAlpha3(strings.ToUpper(o.Country)) {
+			o.Country = strings.ToUpper(o.Country)
+		}
+		where := fmt.Sprintf("overrides[%d]", i)
+		if o.Field == "currency_code" {
+			o.Value = strings.ToUpper(o.Value)
+		}
+		_, known := overridableFields[o.Field]
+		switch {
+		case o.Country == "":
+			problems = append(problems, where+": country is required")
+		case len(o.Country) > 512:
+			problems = append(problems, where+": country is longer than 512 characters")
+		case o.Field == gdpMultiplierField:
+			if _, err := parseGDPMultiplier(o.Value); err != nil {
+				problems = append(problems, where+": "+err.Error())
+			}
+		case !known:
+			problems = append(problems, fmt.Sprintf("%s: field must be one of %s, %s", where, overridableFieldList, gdpMultiplierField))
+		default:
+			if problem := overrideValueProblem(o.Field, o.Value); problem != "" {
+				problems = append(problems, where+": "+problem)
+			}
+		}
+		if len(o.Note) > 255 {
+			problems = append(problems, where+": note is longer than 255 characters")
+		}
+		key := strings.ToUpper(o.Country) + "\x00" + o.Field
+		if seen[key] {
+			problems = append(problems, fmt.Sprintf("%s: duplicate override of %s for %s", where, o.Field, o.Country))
+		}
+		seen[key] = true
+	}
+
+	seen = map[string]bool{}
+	for i := range doc.Aliases {
+		a := &doc.Aliases[i]
+		a.Alias, a.Code, a.Note = foldName(a.Alias), strings.ToUpper(strings.TrimSpace(a.Code)), strings.TrimSpace(a.Note)
+		where := fmt.Sprintf("aliases[%d]", i)
+		switch {
+		case a.Alias == "":
+			problems = append(problems, where+": alias is required")
+		case len(a.Alias) > 255:
+			problems = append(problems, where+": alias is longer than 255 characters")
+		case !isAlpha3(a.Code):
+			problems = append(problems, where+": code must be an ISO 3166-1 alpha-3 code")
+		}
+		if len(a.Note) > 255 {
+			problems = append(problems, where+": note is longer than 255 characters")
+		}
+		if seen[a.Alias] {
+			problems = append(problems, fmt.Sprintf("%s: duplicate alias %q", where, a.Alias))
+		}
+		seen[a.Alias] = true
+	}
+
+	doc.sort()
+	return problems
+}
+
+// sort orders the rows as they are exported
+func (doc *curationDocument) sort() {
+	sort.Slice(doc.Overrides, func(i, j int) bool {
+		a, b := doc.Overrides[i], doc.Overrides[j]
+		if a.Country != b.Country {
+			return a.Country < b.Country
+		}
+		return a.Field < b.Field
+	})
+	sort.Slice(doc.Aliases, func(i, j int) bool {
+		return doc.Aliases[i].Alias < doc.Aliases[j].Alias
+	})
+}
+
+// isAlpha2 reports whether s is two upper-case ASCII letters
+func isAlpha2(s string) bool {
+	return len(s) == 2 && isUpperLetters(s)
+}
+
+// isAlpha3 reports whether s is three upper-case ASCII letters
+func isAlpha3(s string) bool {
+	return len(s) == 3 && isUpperLetters(s)
+}
+
+func isUpperLetters(s string) bool {
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// importCuration writes a document in one transaction. Rows missing from
+// the document are kept, unless replace is set. With dryRun nothing is
+// written and the counts report what would change.
+func importCuration(doc curationDocument, replace, dryRun bool, actor string) (overrides, aliases curationCounts, err error) {
+	now := clock.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var existingOverrides []CountryOverride
+		if err := tx.Find(&existingOverrides).Error; err != nil {
+			return err
+		}
+		current := map[string]CountryOverride{}
+		for _, row := range existingOverrides {
+			current[strings.ToUpper(row.Country)+"\x00"+row.Field] = row
+		}
+		for _, row := range doc.Overrides {
+			key := strings.ToUpper(row.Country) + "\x00" + row.Field
+			old, exists := current[key]
+			delete(current, key)
+			switch {
+			case !exists:
+				overrides.Created++
+			case old.Country == row.Country && old.Value == row.Value && old.Note == row.Note:
+				overrides.Unchanged++
+				continue
+			default:
+				overrides.Updated++
+				// Keys compare case-insensitively; drop a differently cased one
+				if !dryRun && old.Country != row.Country {
+					if err := tx.Delete(&old).Error; err != nil {
+						return err
+					}
+				}
+			}
+			if dryRun {
+				continue
+			}
+			row.UpdatedBy, row.UpdatedAt = actor, now
+			if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&row).Error; err != nil {
+				return err
+			}
+		}
+		if replace {
+			for _, old := range current {
+				overrides.Deleted++
+				if !dryRun {
+					if err := tx.Delete(&old).Error; err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		var existingAliases []CountryAlias
+		if err := tx.Find(&existingAliases).Error; err != nil {
+			return err
+		}
+		known := map[string]CountryAlias{}
+		for _, row := range existingAliases {
+			known[row.Alias] = row
+		}
+		for _, row := range doc.Aliases {
+			old, exists := known[row.Alias]
+			delete(known, row.Alias)
+			switch {
+			case !exists:
+				aliases.Created++
+			case old.Code == row.Code && old.Note == row.Note:
+				aliases.Unchanged++
+				continue
+			default:
+				aliases.Updated++
+			}
+			if dryRun {
+				continue
+			}
+			row.UpdatedBy, row.UpdatedAt = actor, now
+			if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&row).Error; err != nil {
+				return err
+			}
+		}
+		if replace {
+			for _, old := range known {
+				aliases.Deleted++
+				if !dryRun {
+					if err := tx.Delete(&old).Error; err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+	return overrides, aliases, err
+}
+
+// curationFormat picks json or yaml from ?format=, then the Content-Type
+// of a request body
+func curationFormat(c *fiber.Ctx, fromBody bool) (string, error) {
+	if format := c.Query("format"); format != "" {
+		if format != "json" && format != "yaml" {
+			return "", errors.New("format must be json or yaml")
+		}
+		return format, nil
+	}
+	if fromBody {
+		contentType := strings.ToLower(c.Get(fiber.HeaderContentType))
+		if strings.Contains(contentType, "yaml") {
+			return "yaml", nil
+		}
+	}
+	return "json", nil
+}
+
+// getCuration exports the overrides and aliases as JSON or, with
+// ?format=yaml, YAML
+func getCuration(c *fiber.Ctx) error {
+	format, err := curationFormat(c, false)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	doc := curationDocument{Overrides: []CountryOverride{}, Aliases: []CountryAlias{}}
+	if err := db.Find(&doc.Overrides).Error; err != nil {
+		return err
+	}
+	if err := db.Find(&doc.Aliases).Error; err != nil {
+		return err
+	}
+	doc.sort()
+
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="curation.`+format+`"`)
+	if format == "yaml" {
+		out, err := yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		c.Set(fiber.HeaderContentType, "application/yaml; charset=utf-8")
+		return c.Send(out)
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	return c.Send(append(out, '\n'))
+}
+
+// putCuration imports an exported document. ?mode=replace also deletes the
+// rows it does not list, so the target ends up identical to the file;
+// ?dry_run=true only reports the changes.
+func putCuration(c *fiber.Ctx) error {
+	invalid := func(details interface{}) error {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": details,
+		})
+	}
+
+	format, err := curationFormat(c, true)
+	if err != nil {
+		return invalid(err.Error())
+	}
+	mode := c.Query("mode", "merge")
+	if mode != "merge" && mode != "replace" {
+		return invalid("mode must be merge or replace")
+	}
+
+	var doc curationDocument
+	if format == "yaml" {
+		decoder := yaml.NewDecoder(bytes.NewReader(c.Body()))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&doc)
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(c.Body()))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&doc)
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return invalid(fmt.Sprintf("body is not a valid %s curation document: %v", format, err))
+	}
+	if err != nil && mode == "replace" {
+		// An empty body would silently clear both tables
+		return invalid("body is empty; send an explicit document to replace with")
+	}
+	if problems := doc.normalize(); len(problems) > 0 {
+		return invalid(problems)
+	}
+
+	dryRun := c.QueryBool("dry_run")
+	overrides, aliases, err := importCuration(doc, mode == "replace", dryRun, requestActor(c))
+	if err != nil {
+		return err
+	}
+	if !dryRun {
+		if err := loadCuratedAliases(); err != nil {
+			return err
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"mode":      mode,
+		"dry_run":   dryRun,
+		"overrides": overrides,
+		"aliases":   aliases,
+	})
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// CountryCurrency is one of the currencies a country uses, as listed
+// upstream, with its USD rate. The first listed is the primary currency,
+// which the countries table keeps for the GDP estimate.
+type CountryCurrency struct {
+	ID           uint     `gorm:"primaryKey" json:"-" xml:"-"`
+	CountryID    uint     `gorm:"uniqueIndex:idx_country_currency;not null" json:"-" xml:"-"`
+	Code         string   `gorm:"type:varchar(10);uniqueIndex:idx_country_currency;index;not null" json:"code" xml:"code"`
+	Name         *string  `gorm:"type:varchar(100)" json:"name" xml:"name"`
+	Symbol       *string  `gorm:"type:varchar(20)" json:"symbol" xml:"symbol"`
+	ExchangeRate *float64 `json:"exchange_rate" xml:"exchange_rate"`
+	// RateAsOf is when the provider last updated ExchangeRate
+	RateAsOf *time.Time `json:"rate_as_of" xml:"rate_as_of"`
+	// Position keeps the upstream order; 0 is the primary currency
+	Position  int  `gorm:"not null" json:"-" xml:"-"`
+	IsPrimary bool `gorm:"not null" json:"primary" xml:"primary"`
+}
+
+// buildCurrencies converts the upstream currency list of a country, with
+// the USD rate of each when known. Entries without a code and repeated
+// codes are skipped.
+func buildCurrencies(country RestCountry, rates map[string]float64) []CountryCurrency {
+	currencies := []CountryCurrency{}
+	seen := map[string]bool{}
+	for _, currency := range country.Currencies {
+		code := currency["code"]
+		if code == "" || seen[code] {
+			continue
+		}
+		seen[code] = true
+		name, symbol := currency["name"], currency["symbol"]
+		entry := CountryCurrency{
+			Code:      code,
+			Name:      nilIfEmpty(&name),
+			Symbol:    nilIfEmpty(&symbol),
+			Position:  len(currencies),
+			IsPrimary: len(currencies) == 0,
+		}
+		if rate, ok := rates[code]; ok {
+			entry.ExchangeRate = &rate
+		}
+		currencies = append(currencies, entry)
+	}
+	return currencies
+}
+
+// checkCurrencySizes reports currencies of a built row that would not fit
+// their columns, which match the primary currency columns of countries
+func checkCurrencySizes(row Country) []string {
+	var problems []string
+	for _, currency := range row.Currencies {
+		// The primary currency is checked as the countries columns
+		if currency.IsPrimary {
+			continue
+		}
+		for _, field := range []struct {
+			name  string
+			value *string
+		}{
+			{"CurrencyCode", &currency.Code},
+			{"CurrencyName", currency.Name},
+			{"CurrencySymbol", currency.Symbol},
+		} {
+			limit := countryColumnSizes[field.name]
+			if field.value == nil {
+				continue
+			}
+			if n := utf8.RuneCountInString(*field.value); n > limit.size {
+				problems = append(problems, fmt.Sprintf("%s of currency %s is %d characters (limit %d)",
+					limit.column, currency.Code, n, limit.size))
+			}
+		}
+	}
+	return problems
+}
+
+// replaceCurrencies swaps in the currencies of the countries of a
+// published snapshot. Countries missing upstream keep theirs, as they keep
+// their row.
+func replaceCurrencies(tx *gorm.DB, rows []Country) error {
+	byName := make(map[string][]CountryCurrency, len(rows))
+	names := make([]string, 0, len(rows))
+	for _, row := range rows {
+		key := strings.ToLower(row.Name)
+		if _, seen := byName[key]; !seen {
+			names = append(names, row.Name)
+		}
+		// Duplicate names keep the last record, as staging does
+		byName[key] = row.Currencies
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	var live []Country
+	if err := tx.Select("id", "name").Where("name IN ?", names).Find(&live).Error; err != nil {
+		return err
+	}
+	ids := make([]uint, 0, len(live))
+	var currencies []CountryCurrency
+	for _, country := range live {
+		ids = append(ids, country.ID)
+		for _, currency := range byName[strings.ToLower(country.Name)] {
+			currency.CountryID = country.ID
+			currencies = append(currencies, currency)
+		}
+	}
+
+	if err := tx.Where("country_id IN ?", ids).Delete(&CountryCurrency{}).Error; err != nil {
+		return err
+	}
+	if len(currencies) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(currencies, refreshBatchSize).Error
+}
+
+// repriceCurrencies sets the rate of every stored currency found in rates,
+// as updated by the provider at asOf
+func repriceCurrencies(tx *gorm.DB, rates map[string]float64, asOf time.Time) error {
+	var stored []string
+	if err := tx.Model(&CountryCurrency{}).Distinct().Pluck("code", &stored).Error; err != nil {
+		return err
+	}
+	var cases strings.Builder
+	var args []interface{}
+	var codes []string
+	for _, code := range stored {
+		rate, ok := rates[code]
+		if !ok {
+			continue
+		}
+		cases.WriteString(" WHEN ? THEN ?")
+		args = append(args, code, rate)
+		codes = append(codes, code)
+	}
+	if len(codes) == 0 {
+		return nil
+	}
+	args = append(args, asOf, codes)
+	return tx.Exec(`UPDATE country_currencies SET
+	exchange_rate = CASE code`+cases.String()+` END,
+	rate_as_of = ?
+WHERE code IN ?`, args...).Error
+}
+
+// attachCurrencies loads the currencies of each country, primary first.
+// Countries with none get an empty list.
+func attachCurrencies(conn *gorm.DB, countries []Country) error {
+	if len(countries) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(countries))
+	for i := range countries {
+		ids[i] = countries[i].ID
+		countries[i].Currencies = []CountryCurrency{}
+	}
+
+	var currencies []CountryCurrency
+	// Large lists are read whole rather than through a long IN clause
+	query := conn.Order("country_id ASC, position ASC")
+	if len(ids) <= refreshBatchSize {
+		query = query.Where("country_id IN ?", ids)
+	}
+	if err := query.Find(&currencies).Error; err != nil {
+		return err
+	}
+
+	index := make(map[uint]int, len(countries))
+	for i := range countries {
+		index[countries[i].ID] = i
+	}
+	for _, currency := range currencies {
+		if i, ok := index[currency.CountryID]; ok {
+			countries[i].Currencies = append(countries[i].Currencies, currency)
+		}
+	}
+	return nil
+}
+
+// currencySummary is a currency with every country that uses it
+type currencySummary struct {
+	Code         string       `json:"code"`
+	Name         *string      `json:"name"`
+	Symbol       *string      `json:"symbol"`
+	ExchangeRate *float64     `json:"exchange_rate"`
+	RateAsOf     *time.Time   `json:"rate_as_of"`
+	Peg          *CurrencyPeg `json:"peg,omitempty"`
+	// MinorUnits is the ISO 4217 number of decimals of the currency
+	MinorUnits int `json:"minor_units"`
+	// Countries are sorted by name; Primary lists those where it is the
+	// primary currency
+	Countries []string `json:"countries"`
+	Primary   []string `json:"primary_for"`
+}
+
+// loadCurrencySummaries groups the stored currencies by code, sorted by
+// code; code limits the result to one currency when set
+func loadCurrencySummaries(code string) ([]currencySummary, error) {
+	var rows []struct {
+		CountryCurrency
+		Country string
+	}
+	query := db.Table("country_currencies").
+		Select("country_currencies.*, countries.name AS country").
+		Joins("JOIN countries ON countries.id = country_currencies.country_id").
+		Order("country_currencies.code ASC, countries.name ASC")
+	if code != "" {
+		query = query.Where("country_currencies.code = ?", code)
+	}
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	summaries := []currencySummary{}
+	for _, row := range rows {
+		if len(summaries) == 0 || summaries[len(summaries)-1].Code != row.Code {
+			summaries = append(summaries, currencySummary{
+				Code:       row.Code,
+				Peg:        pegFor(row.Code),
+				MinorUnits: minorUnits(row.Code),
+				Countries:  []string{},
+				Primary:    []string{},
+			})
+		}
+		summary := &summaries[len(summaries)-1]
+		// Upstream spells names and symbols the same way for every country;
+		// take the first one given
+		if summary.Name == nil {
+			summary.Name = row.Name
+		}
+		if summary.Symbol == nil {
+			summary.Symbol = row.Symbol
+		}
+		if summary.ExchangeRate == nil {
+			summary.ExchangeRate, summary.RateAsOf = row.ExchangeRate, row.RateAsOf
+		}
+		summary.Countries = append(summary.Countries, row.Country)
+		if row.IsPrimary {
+			summary.Primary = append(summary.Primary, row.Country)
+		}
+	}
+	return summaries, nil
+}
+
+// getCurrencies lists every currency used by a stored country
+func getCurrencies(c *fiber.Ctx) error {
+	meta := newListMeta(c)
+	meta.Sort = "code_asc"
+	summaries, err := loadCurrencySummaries("")
+	if err != nil {
+		return err
+	}
+	meta.Total = int64(len(summaries))
+	return sendList(c, summaries, meta)
+}
+
+// getCurrency returns one currency and the countries using it
+func getCurrency(c *fiber.Ctx) error {
+	code := strings.ToUpper(c.Params("code"))
+	if !currencyCodePattern.MatchString(code) {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": "code must be a three-letter currency code",
+		})
+	}
+	summaries, err := loadCurrencySummaries(code)
+	if err != nil {
+		return err
+	}
+	if len(summaries) == 0 {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Currency not found",
+		})
+	}
+	return c.JSON(summaries[0])
+}
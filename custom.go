@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// newCountryFields are the fields POST /countries accepts. Everything else
+// is derived: the rate from the last fetched rates, then tiers and GDP.
+var newCountryFields = []string{
+	"name", "alpha2_code", "alpha3_code", "capital", "region", "subregion", "population",
+	"currency_code", "currency_name", "currency_symbol", "flag_url",
+}
+
+// parseNewCountry reads a POST /countries body into its trimmed text
+// values, upper-casing codes. It returns every problem found.
+func parseNewCountry(body []byte) (map[string]string, []string) {
+	values := map[string]string{}
+	var raw map[string]json.RawMessage
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil || raw == nil {
+		return values, []string{"body must be a JSON object"}
+	}
+
+	known := map[string]bool{}
+	for _, field := range newCountryFields {
+		known[field] = true
+	}
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var problems []string
+	for _, key := range keys {
+		if !known[key] {
+			problems = append(problems, fmt.Sprintf("%s is not accepted; fields are %s", key, strings.Join(newCountryFields, ", ")))
+			continue
+		}
+		if key == "population" {
+			var n json.Number
+			if err := json.Unmarshal(raw[key], &n); err != nil {
+				problems = append(problems, "population must be a positive integer")
+				continue
+			}
+			values[key] = n.String()
+			continue
+		}
+		var text *string
+		if err := json.Unmarshal(raw[key], &text); err != nil {
+			problems = append(problems, key+" must be a string or null")
+			continue
+		}
+		if text == nil || strings.TrimSpace(*text) == "" {
+			continue
+		}
+		values[key] = strings.TrimSpace(*text)
+		if key == "alpha2_code" || key == "alpha3_code" || key == "currency_code" {
+			values[key] = strings.ToUpper(values[key])
+		}
+	}
+
+	if values["name"] == "" {
+		problems = append(problems, "name is required")
+	}
+	if code, ok := values["alpha2_code"]; ok && !isAlpha2(code) {
+		problems = append(problems, "alpha2_code must be two letters")
+	}
+	if code, ok := values["alpha3_code"]; ok && !isAlpha3(code) {
+		problems = append(problems, "alpha3_code must be three letters")
+	}
+	for _, field := range []string{"capital", "region", "subregion", "flag_url", "population", "currency_code"} {
+		if value, ok := values[field]; ok {
+			if problem := overrideValueProblem(field, value); problem != "" {
+				problems = append(problems, problem)
+			}
+		}
+	}
+	for _, field := range []string{"currency_name", "currency_symbol"} {
+		if _, ok := values[field]; ok && values["currency_code"] == "" {
+			problems = append(problems, field+" requires currency_code")
+		}
+	}
+	return values, problems
+}
+
+// newManualCountry builds the row of a country created through the API.
+// Given fields show "manual" in field_sources; a currency's name and symbol
+// default to those of another country using it.
+func newManualCountry(values map[string]string, rates map[string]float64) (Country, error) {
+	text := func(field string) *string {
+		value, ok := values[field]
+		if !ok {
+			return nil
+		}
+		return &value
+	}
+	row := Country{
+		Name:            values["name"],
+		Alpha2Code:      text("alpha2_code"),
+		Alpha3Code:      text("alpha3_code"),
+		Capital:         text("capital"),
+		Region:          text("region"),
+		Subregion:       text("subregion"),
+		FlagURL:         text("flag_url"),
+		Source:          sourceManual,
+		FieldSources:    map[string]string{"estimated_gdp": sourceEstimate},
+		LastRefreshedAt: clock.Now(),
+	}
+	for field := range values {
+		if field != "name" {
+			row.FieldSources[field] = sourceManual
+		}
+	}
+
+	// An override set up before the country existed tunes its estimate
+	m, err := loadGDPMultiplier(db, row)
+	if err != nil {
+		return row, err
+	}
+	row.GDPMultiplier = m
+	population, _ := strconv.ParseInt(values["population"], 10, 64)
+	setPopulation(&row, population, nil)
+	code, ok := values["currency_code"]
+	if !ok {
+		// No currency means no rate to estimate with, as in a refresh
+		gdp := 0.0
+		row.EstimatedGDP, row.GDPTier = &gdp, nil
+		return row, nil
+	}
+
+	var known CountryCurrency
+	if err := db.Where("code = ?", code).Limit(1).Find(&known).Error; err != nil {
+		return row, err
+	}
+	setPrimaryCurrency(&row, code, rates, nil)
+	primary := &row.Currencies[0]
+	primary.Name, primary.Symbol = known.Name, known.Symbol
+	if name, ok := values["currency_name"]; ok {
+		primary.Name = &name
+	}
+	if symbol, ok := values["currency_symbol"]; ok {
+		primary.Symbol = &symbol
+	}
+	row.CurrencyName, row.CurrencySymbol = primary.Name, primary.Symbol
+	if row.ExchangeRate != nil {
+		row.FieldSources["exchange_rate"] = sourceExchangeRates
+		asOf, err := latestRateAsOf(db)
+		if err != nil {
+			return row, err
+		}
+		row.RateAsOf, primary.RateAsOf = asOf, asOf
+	}
+	return row, nil
+}
+
+// postCountry adds a country restcountries does not list, such as a
+// disputed territory or a historical state. Refreshes never overwrite it.
+func postCountry(c *fiber.Ctx) error {
+	invalid := func(details interface{}) error {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": details,
+		})
+	}
+
+	values, problems := parseNewCountry(c.Body())
+	if len(problems) > 0 {
+		return invalid(problems)
+	}
+
+	var rates map[string]float64
+	if _, ok := values["currency_code"]; ok {
+		var err error
+		if rates, err = currencyRates(); err != nil {
+			return err
+		}
+	}
+	row, err := newManualCountry(values, rates)
+	if err != nil {
+		return err
+	}
+	if err := checkColumnSizes(row); err != nil {
+		return invalid([]string{err.Error()})
+	}
+
+	country, err := createCountry(row, requestActor(c))
+	if err != nil {
+		return err
+	}
+
+	notifyDataChanged()
+
+	return c.Status(fiber.StatusCreated).JSON(country)
+}
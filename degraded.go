@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gofiber/fiber/v2"
+)
+
+// degradedSnapshot is the standby snapshot a running server falls back to
+// while MySQL is unreachable. It is fetched on the first failed read and
+// again at most once per STANDBY_RETRY_INTERVAL, so a long outage picks up
+// a snapshot another instance published meanwhile.
+var degradedSnapshot = struct {
+	sync.Mutex
+	reads     *standbyReads
+	fetchedAt time.Time
+}{}
+
+// degradedStats counts reads served from the snapshot per route, for
+// /metrics
+var degradedStats = struct {
+	sync.Mutex
+	served map[string]int64
+}{served: map[string]int64{}}
+
+// isDatabaseDown reports whether err means MySQL could not be reached, as
+// opposed to a query it answered with an error
+func isDatabaseDown(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr)
+}
+
+// openDegradedSnapshot returns the snapshot to serve, fetching the
+// published one when the local copy is missing or due for a check
+func openDegradedSnapshot() (*standbyReads, error) {
+	degradedSnapshot.Lock()
+	defer degradedSnapshot.Unlock()
+
+	reads := degradedSnapshot.reads
+	if reads != nil && clock.Now().Sub(degradedSnapshot.fetchedAt) < standbyRetry {
+		return reads, nil
+	}
+	degradedSnapshot.fetchedAt = clock.Now()
+	if err := fetchStandbySnapshot(); err != nil {
+		if reads != nil {
+			log.Printf("Failed to fetch standby snapshot, serving the open copy: %v", err)
+			return reads, nil
+		}
+		return nil, err
+	}
+
+	if reads == nil {
+		opened, err := openStandbySnapshot()
+		if err != nil {
+			return nil, err
+		}
+		degradedSnapshot.reads = opened
+		log.Printf("Serving reads from the standby snapshot of %s while the database is unreachable",
+			opened.snapshotMeta().CreatedAt.Format(time.RFC3339))
+		return opened, nil
+	}
+	if err := reads.reload(); err != nil {
+		log.Printf("Failed to reload standby snapshot, serving the open copy: %v", err)
+	}
+	return reads, nil
+}
+
+// degradeReads answers a read from the standby snapshot, marked with
+// X-Degraded: true, when its handler fails because MySQL is unreachable.
+// serve is the snapshot's handler for the route. Without a snapshot
+// configured, or when none can be opened, the error stands.
+func degradeReads(route string, serve func(reads *standbyReads, c *fiber.Ctx) error) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+		if err == nil || standbyURL == "" || !isDatabaseDown(err) {
+			return err
+		}
+		reads, snapshotErr := openDegradedSnapshot()
+		if snapshotErr != nil {
+			log.Printf("Database unreachable and no standby snapshot to serve: %v", snapshotErr)
+			return err
+		}
+
+		degradedStats.Lock()
+		degradedStats.served[route]++
+		degradedStats.Unlock()
+
+		c.Response().ResetBody()
+		c.Set("X-Degraded", "true")
+		return serve(reads, c)
+	}
+}
+
+// writeDegradedMetrics appends the reads served from the snapshot to a
+// /metrics response
+func writeDegradedMetrics(b *strings.Builder) {
+	degradedStats.Lock()
+	defer degradedStats.Unlock()
+	if len(degradedStats.served) == 0 {
+		return
+	}
+
+	routes := make([]string, 0, len(degradedStats.served))
+	for route := range degradedStats.served {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	b.WriteString("# TYPE degraded_responses counter\n# HELP degraded_responses Reads served from the standby snapshot while the database was unreachable.\n")
+	for _, route := range routes {
+		fmt.Fprintf(b, "degraded_responses_total{route=\"%s\"} %d\n", escapeLabel(route), degradedStats.served[route])
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// smtpConfig holds the settings for outgoing digest emails
+type smtpConfig struct {
+	Host       string
+	Port       string
+	Username   string
+	Password   string
+	From       string
+	Recipients []string
+}
+
+// loadSMTPConfig reads the email settings; ok is false when email is not
+// configured
+func loadSMTPConfig() (smtpConfig, bool) {
+	cfg := smtpConfig{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     getEnv("SMTP_PORT", "587"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     getEnv("SMTP_FROM", "country-api@localhost"),
+	}
+	for _, r := range strings.Split(os.Getenv("DIGEST_RECIPIENTS"), ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			cfg.Recipients = append(cfg.Recipients, r)
+		}
+	}
+
+	return cfg, cfg.Host != "" && len(cfg.Recipients) > 0
+}
+
+// emailNotifier sends each event as a plain-text email to the digest
+// recipients
+type emailNotifier struct {
+	cfg smtpConfig
+}
+
+func (emailNotifier) Name() string { return "email" }
+
+func (e emailNotifier) Notify(ev notificationEvent) error {
+	cfg := e.cfg
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+
+	msg := "From: " + cfg.From + "\r\n" +
+		"To: " + strings.Join(cfg.Recipients, ", ") + "\r\n" +
+		"Subject: " + ev.Subject + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + ev.Text
+
+	addr := cfg.Host + ":" + cfg.Port
+	return smtp.SendMail(addr, auth, cfg.From, cfg.Recipients, []byte(msg))
+}
+
+func formatDigest(summary *refreshSummary) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Refresh finished at %s (took %s)\n\n",
+		summary.FinishedAt.Format(time.RFC3339), summary.FinishedAt.Sub(summary.StartedAt).Round(time.Millisecond))
+	fmt.Fprintf(&b, "Processed: %d\nInserted:  %d\nUpdated:   %d\nErrors:    %d\nAnomalies: %d\n",
+		summary.Processed, summary.Inserted, summary.Updated, len(summary.Errors), len(summary.Anomalies))
+
+	if len(summary.Movers) > 0 {
+		b.WriteString("\nBiggest exchange rate movers:\n")
+		for _, m := range summary.Movers {
+			fmt.Fprintf(&b, "  %s (%s): %.4f -> %.4f (%+.2f%%)\n", m.Country, m.CurrencyCode, m.OldRate, m.NewRate, m.ChangePct)
+		}
+	}
+
+	if len(summary.Anomalies) > 0 {
+		b.WriteString("\nAnomalies:\n")
+		b.WriteString(formatAnomalies(summary.Anomalies))
+	}
+
+	if len(summary.Errors) > 0 {
+		b.WriteString("\nErrors:\n")
+		for _, e := range summary.Errors {
+			fmt.Fprintf(&b, "  %s\n", e)
+		}
+	}
+
+	return b.String()
+}
+
+func formatAnomalies(anomalies []CountryAnomaly) string {
+	var b strings.Builder
+	for _, a := range anomalies {
+		fmt.Fprintf(&b, "  %s %s: %g -> %g (%+.2f%%)\n", a.Country, a.Kind, a.OldValue, a.NewValue, a.ChangePct)
+	}
+	return b.String()
+}
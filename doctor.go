@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// doctorTimeout bounds each network check of the doctor command
+const doctorTimeout = 10 * time.Second
+
+// doctorCheck is one line of the doctor report
+type doctorCheck struct {
+	name string
+	run  func() (string, error)
+}
+
+// upstreamChecks are the doctor checks that only fail when an upstream
+// does; when nothing else fails the doctor exits with exitUpstream
+var upstreamChecks = map[string]bool{"restcountries": true, "exchange rates": true, "world bank": true}
+
+// doctorResult is one check in the --json report
+type doctorResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// doctorReport is the --json report of the doctor
+type doctorReport struct {
+	Checks []doctorResult `json:"checks"`
+	Failed int            `json:"failed"`
+}
+
+// runDoctor checks configuration, the database and its schema, the
+// upstreams and local storage, prints a pass/fail report and returns the
+// process exit code
+func runDoctor() int {
+	var conn *gorm.DB
+	checks := []doctorCheck{
+		{"config", doctorConfig},
+		{"database", func() (string, error) {
+			dsn := databaseDSN()
+			// The report carries the errors, so keep GORM's logger quiet
+			opened, err := gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: logger.Discard})
+			if err != nil {
+				return "", err
+			}
+			sqlDB, err := opened.DB()
+			if err != nil {
+				return "", err
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+			defer cancel()
+			if err := sqlDB.PingContext(ctx); err != nil {
+				return "", err
+			}
+			conn = opened
+			return "connected to " + hideSensitiveInfo(dsn), nil
+		}},
+		{"schema", func() (string, error) {
+			if conn == nil {
+				return "", errors.New("skipped: no database connection")
+			}
+			return doctorSchema(conn)
+		}},
+		{"migrations", func() (string, error) {
+			if conn == nil {
+				return "", errors.New("skipped: no database connection")
+			}
+			return doctorMigrations()
+		}},
+		{"encryption", func() (string, error) {
+			if conn == nil {
+				return "", errors.New("skipped: no database connection")
+			}
+			return doctorEncryption(conn)
+		}},
+		{"api keys", func() (string, error) {
+			loadAPIKeys()
+			var stored int64
+			if conn != nil && conn.Migrator().HasTable(&APIKey{}) {
+				if err := conn.Model(&APIKey{}).Where("revoked_at IS NULL").Count(&stored).Error; err != nil {
+					return "", err
+				}
+			}
+			if stored == 0 && len(envAPIKeys) == 0 && !jwtEnabled() {
+				return "", errors.New("no API keys or JWT settings: set API_KEYS or run ./app apikey create <name>; writes will be refused")
+			}
+			return fmt.Sprintf("%d from API_KEYS, %d active in the database", len(envAPIKeys), stored), nil
+		}},
+		{"jwt", func() (string, error) {
+			if !jwtEnabled() {
+				return "disabled (set JWT_SECRET or JWT_JWKS_URL)", nil
+			}
+			if jwtSettings.JWKSURL == "" {
+				return "HS256 with JWT_SECRET", nil
+			}
+			keys, err := fetchJWKS()
+			if err != nil {
+				return "", fmt.Errorf("JWKS: %w", err)
+			}
+			if len(keys) == 0 {
+				return "", errors.New("JWKS has no RSA or P-256 keys")
+			}
+			return fmt.Sprintf("%d signing keys from %s", len(keys), jwtSettings.JWKSURL), nil
+		}},
+		{"restcountries", func() (string, error) {
+			return doctorUpstream(func(ctx context.Context) ([]byte, error) {
+				return fetchOnce(ctx, countriesAPIURL)
+			}, func(body []byte) (int, error) {
+				countries, err := parseCountries(body)
+				return len(countries), err
+			})
+		}},
+		{"exchange rates", func() (string, error) {
+			provider, err := newRateProvider()
+			if err != nil {
+				return "", err
+			}
+			detail, err := doctorUpstream(provider.Fetch, func(body []byte) (int, error) {
+				rates, err := parseExchangeRates(body)
+				return len(rates), err
+			})
+			if err != nil {
+				return "", err
+			}
+			return provider.Name() + ": " + detail, nil
+		}},
+		{"world bank", func() (string, error) {
+			if !usesWorldBank() {
+				return "not used by FIELD_SOURCES", nil
+			}
+			return doctorUpstream(func(ctx context.Context) ([]byte, error) {
+				return fetchOnce(ctx, worldBankAPIURL+"/country/all/indicator/NY.GDP.MKTP.CD?format=json&per_page=20000&mrnev=1")
+			}, func(body []byte) (int, error) {
+				var parts []json.RawMessage
+				if err := json.Unmarshal(body, &parts); err != nil || len(parts) < 2 {
+					return 0, fmt.Errorf("unexpected World Bank response: %.200s", body)
+				}
+				var observations []worldBankObservation
+				err := json.Unmarshal(parts[1], &observations)
+				return len(observations), err
+			})
+		}},
+		{"cache dir", func() (string, error) { return doctorWritable("cache") }},
+	}
+	if archiveDir != "" {
+		checks = append(checks, doctorCheck{"archive dir", func() (string, error) { return doctorWritable(archiveDir) }})
+	}
+	if os.Getenv("READ_MODEL") == "redis" || os.Getenv("CACHE_ENGINE") == "redis" || os.Getenv("RATE_LIMIT_STORE") == "redis" {
+		checks = append(checks, doctorCheck{"redis", func() (string, error) {
+			if _, err := redisClient(); err != nil {
+				return "", err
+			}
+			return "reachable", nil
+		}})
+	}
+
+	report := doctorReport{Checks: []doctorResult{}}
+	upstreamFailed := 0
+	for _, check := range checks {
+		detail, err := check.run()
+		status := "PASS"
+		if err != nil {
+			status, detail = "FAIL", err.Error()
+			report.Failed++
+			if upstreamChecks[check.name] {
+				upstreamFailed++
+			}
+		}
+		report.Checks = append(report.Checks, doctorResult{Name: check.name, Status: status, Detail: detail})
+		if !jsonOutput {
+			fmt.Printf("%-4s  %-14s  %s\n", status, check.name, detail)
+		}
+	}
+
+	code := exitOK
+	switch {
+	case report.Failed > 0 && report.Failed == upstreamFailed:
+		code = exitUpstream
+	case report.Failed > 0:
+		code = exitFailed
+	}
+	if jsonOutput {
+		return printResult("doctor", code, report, nil)
+	}
+	if report.Failed > 0 {
+		fmt.Printf("\n%d of %d checks failed\n", report.Failed, len(checks))
+		return code
+	}
+	fmt.Printf("\nAll %d checks passed\n", len(checks))
+	return code
+}
+
+// doctorConfig validates every setting that would otherwise only fail at
+// startup or on first use
+func doctorConfig() (string, error) {
+	var problems []string
+	note := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if raw := os.Getenv("FIXED_TIME"); raw != "" {
+		if _, err := time.Parse(time.RFC3339, raw); err != nil {
+			note(fmt.Errorf("invalid FIXED_TIME %q", raw))
+		}
+	}
+	_, err := loadRefreshSchedule()
+	note(err)
+	note(loadRetryPolicy())
+	note(loadBreakerSettings())
+	note(loadFieldSources())
+	note(checkCountriesAPIVersion())
+	note(loadJWTSettings())
+	note(loadSummaryImageDefaults())
+	note(loadCredentials())
+	note(loadColumnKeys())
+	_, err = newRateProvider()
+	note(err)
+	_, err = loadRateLimiter()
+	note(err)
+	_, err = loadTrustedProxies()
+	note(err)
+	_, err = loadCacheTTLs()
+	note(err)
+	note(loadConcurrencyLimits())
+	note(loadRetentionPolicies())
+	note(loadStandbySettings())
+	_, err = logHandler()
+	note(err)
+	for key, engines := range map[string][]string{
+		"CACHE_ENGINE":           {"", "memory", "redis"},
+		"READ_MODEL":             {"", "memory", "redis"},
+		"MODE":                   {"", modeReadonlyEdge},
+		"NOTIFY_WEBHOOK_PAYLOAD": {"", payloadSummary, payloadDelta},
+	} {
+		value, known := os.Getenv(key), false
+		for _, engine := range engines {
+			known = known || value == engine
+		}
+		if !known {
+			note(fmt.Errorf("unknown %s %q", key, value))
+		}
+	}
+	if os.Getenv("MODE") == modeReadonlyEdge {
+		_, err = loadEdgeSyncInterval()
+		note(err)
+		if os.Getenv("STANDBY_SNAPSHOT_URL") == "" {
+			note(errors.New("MODE=readonly-edge requires STANDBY_SNAPSHOT_URL"))
+		}
+	}
+	if os.Getenv("SMTP_HOST") != "" && os.Getenv("DIGEST_RECIPIENTS") == "" {
+		note(errors.New("SMTP_HOST is set but DIGEST_RECIPIENTS is empty"))
+	}
+
+	if len(problems) > 0 {
+		return "", errors.New(strings.Join(problems, "; "))
+	}
+	return "ok", nil
+}
+
+// doctorSchema reports tables and columns the models expect but the
+// database lacks
+func doctorSchema(conn *gorm.DB) (string, error) {
+	var missing, narrow []string
+	for _, model := range schemaModels {
+		stmt := &gorm.Statement{DB: conn}
+		if err := stmt.Parse(model); err != nil {
+			return "", err
+		}
+		table := stmt.Schema.Table
+		if !conn.Migrator().HasTable(model) {
+			missing = append(missing, table)
+			continue
+		}
+		lengths := map[string]int64{}
+		if columns, err := conn.Migrator().ColumnTypes(model); err == nil {
+			for _, column := range columns {
+				if length, ok := column.Length(); ok {
+					lengths[column.Name()] = length
+				}
+			}
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !conn.Migrator().HasColumn(model, field.DBName) {
+				missing = append(missing, table+"."+field.DBName)
+				continue
+			}
+			if size := varcharSize(field); size > 0 && lengths[field.DBName] > 0 && lengths[field.DBName] < int64(size) {
+				narrow = append(narrow, fmt.Sprintf("%s.%s (%d of %d)", table, field.DBName, lengths[field.DBName], size))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing %s (run ./app migrate up)", strings.Join(missing, ", "))
+	}
+	if len(narrow) > 0 {
+		return "", fmt.Errorf("columns narrower than the models: %s (run ./app migrate up)", strings.Join(narrow, ", "))
+	}
+	return fmt.Sprintf("%d tables up to date", len(schemaModels)), nil
+}
+
+// doctorMigrations compares the applied migration with this build's latest
+func doctorMigrations() (string, error) {
+	m, err := newMigrator(databaseDSN())
+	if err != nil {
+		return "", err
+	}
+	defer m.Close()
+	version, dirty, err := migrationVersion(m)
+	if err != nil {
+		return "", err
+	}
+	latest := latestMigration()
+	switch {
+	case dirty:
+		return "", fmt.Errorf("migration %d is dirty: repair the schema, then run ./app migrate force <version>", version)
+	case version < latest:
+		return "", fmt.Errorf("at migration %d of %d (run ./app migrate up)", version, latest)
+	}
+	return fmt.Sprintf("at migration %d of %d", version, latest), nil
+}
+
+// doctorUpstream fetches a payload and checks it. Callers fetch once,
+// without retries, except through a rate provider.
+func doctorUpstream(fetch func(context.Context) ([]byte, error), parse func([]byte) (int, error)) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+
+	started := time.Now()
+	body, err := fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	n, err := parse(body)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d records in %s", n, time.Since(started).Round(time.Millisecond)), nil
+}
+
+// doctorWritable creates dir if needed and writes and removes a probe file
+func doctorWritable(dir string) (string, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	probe, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		return "", err
+	}
+	probe.Close()
+	if err := os.Remove(probe.Name()); err != nil {
+		return "", err
+	}
+	abs, _ := filepath.Abs(dir)
+	return abs + " is writable", nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// modeReadonlyEdge is the MODE of a read replica serving only the published
+// snapshot
+const modeReadonlyEdge = "readonly-edge"
+
+// defaultEdgeSyncInterval is how often an edge fetches the published
+// snapshot unless EDGE_SYNC_INTERVAL says otherwise
+const defaultEdgeSyncInterval = time.Minute
+
+// loadEdgeSyncInterval reads EDGE_SYNC_INTERVAL
+func loadEdgeSyncInterval() (time.Duration, error) {
+	if os.Getenv("EDGE_SYNC_INTERVAL") == "" {
+		return defaultEdgeSyncInterval, nil
+	}
+	d, err := parseInterval("EDGE_SYNC_INTERVAL")
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid EDGE_SYNC_INTERVAL %q", os.Getenv("EDGE_SYNC_INTERVAL"))
+	}
+	return d, nil
+}
+
+// serveEdge serves GET /status, /countries and /countries/:name from the
+// snapshot at STANDBY_SNAPSHOT_URL without connecting to MySQL, fetching a
+// newer snapshot every EDGE_SYNC_INTERVAL. Mutating routes are refused.
+func serveEdge() error {
+	if standbyURL == "" {
+		return errors.New("MODE=readonly-edge requires STANDBY_SNAPSHOT_URL")
+	}
+	interval, err := loadEdgeSyncInterval()
+	if err != nil {
+		return err
+	}
+	if err := fetchStandbySnapshot(); err != nil {
+		return fmt.Errorf("snapshot unavailable: %w", err)
+	}
+	reads, err := openStandbySnapshot()
+	if err != nil {
+		return fmt.Errorf("snapshot unavailable: %w", err)
+	}
+	defer reads.close()
+	log.Printf("Serving read-only edge from snapshot %s", reads.snapshotMeta().CreatedAt.Format(time.RFC3339))
+
+	go syncEdgeEvery(reads, interval)
+
+	app := snapshotApp(reads, modeReadonlyEdge, func(c *fiber.Ctx) error {
+		if c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead {
+			c.Set(fiber.HeaderAllow, "GET, HEAD")
+			return c.Status(fiber.StatusMethodNotAllowed).JSON(fiber.Map{
+				"error":   "Method not allowed",
+				"details": "this is a read-only edge deployment",
+			})
+		}
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Not found",
+			"details": "a read-only edge serves only GET /status, /countries and /countries/:name",
+		})
+	})
+	return app.Listen(":" + getEnv("PORT", "3000"))
+}
+
+// syncEdgeEvery fetches the published snapshot once per interval and
+// switches reads to it. A failed fetch keeps serving the current one.
+func syncEdgeEvery(reads *standbyReads, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		current := reads.snapshotMeta().CreatedAt
+		if err := fetchStandbySnapshot(); err != nil {
+			log.Printf("Edge sync failed: %v", err)
+			continue
+		}
+		if err := reads.reload(); err != nil {
+			log.Printf("Edge sync failed: %v", err)
+			continue
+		}
+		if created := reads.snapshotMeta().CreatedAt; !created.Equal(current) {
+			log.Printf("Edge switched to snapshot %s", created.Format(time.RFC3339))
+		}
+	}
+}
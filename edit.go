@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// countryPatch is a validated PATCH /countries/:name body
+type countryPatch struct {
+	// Values holds the edited fields as override values: trimmed, "" to
+	// clear a text field
+	Values map[string]string
+	// Pin and Unpin list fields to pin to their new (or current) value, so
+	// refreshes keep them, and fields to release to the next refresh
+	Pin   []string
+	Unpin []string
+}
+
+// fields lists the edited fields in a stable order
+func (p countryPatch) fields() []string {
+	fields := make([]string, 0, len(p.Values))
+	for field := range p.Values {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// parseCountryPatch reads a PATCH body: any overridable field, plus "pin"
+// and "unpin" lists of fields. It returns every problem found.
+func parseCountryPatch(body []byte) (countryPatch, []string) {
+	patch := countryPatch{Values: map[string]string{}}
+	var raw map[string]json.RawMessage
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil || raw == nil {
+		return patch, []string{"body must be a JSON object"}
+	}
+
+	var problems []string
+	fieldList := func(key string) []string {
+		var fields []string
+		if err := json.Unmarshal(raw[key], &fields); err != nil {
+			problems = append(problems, key+" must be a list of field names")
+			return nil
+		}
+		seen := map[string]bool{}
+		var valid []string
+		for _, field := range fields {
+			if _, ok := overridableFields[field]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: %q is not one of %s", key, field, overridableFieldList))
+				continue
+			}
+			if !seen[field] {
+				seen[field] = true
+				valid = append(valid, field)
+			}
+		}
+		sort.Strings(valid)
+		return valid
+	}
+
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch key {
+		case "pin":
+			patch.Pin = fieldList(key)
+			continue
+		case "unpin":
+			patch.Unpin = fieldList(key)
+			continue
+		}
+		if _, ok := overridableFields[key]; !ok {
+			problems = append(problems, fmt.Sprintf("%s cannot be edited; editable fields are %s", key, overridableFieldList))
+			continue
+		}
+
+		var value string
+		switch key {
+		case "population":
+			var n json.Number
+			if err := json.Unmarshal(raw[key], &n); err != nil {
+				problems = append(problems, "population must be a positive integer")
+				continue
+			}
+			value = n.String()
+		default:
+			var text *string
+			if err := json.Unmarshal(raw[key], &text); err != nil {
+				problems = append(problems, key+" must be a string or null")
+				continue
+			}
+			if text != nil {
+				value = strings.TrimSpace(*text)
+			}
+			if key == "currency_code" {
+				value = strings.ToUpper(value)
+			}
+		}
+		if problem := overrideValueProblem(key, value); problem != "" {
+			problems = append(problems, problem)
+			continue
+		}
+		patch.Values[key] = value
+	}
+
+	for _, field := range patch.Pin {
+		for _, other := range patch.Unpin {
+			if field == other {
+				problems = append(problems, field+" cannot be both pinned and unpinned")
+			}
+		}
+	}
+	if len(problems) == 0 && len(patch.Values) == 0 && len(patch.Pin) == 0 && len(patch.Unpin) == 0 {
+		problems = append(problems, "body must edit, pin or unpin at least one field")
+	}
+	return patch, problems
+}
+
+// overrideValue is the current value of an overridable field as stored in
+// country_overrides; it reports false when there is none to pin
+func overrideValue(country Country, field string) (string, bool) {
+	text := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	switch field {
+	case "capital":
+		return text(country.Capital), true
+	case "region":
+		return text(country.Region), true
+	case "subregion":
+		return text(country.Subregion), true
+	case "flag_url":
+		return text(country.FlagURL), true
+	case "population":
+		return strconv.FormatInt(country.Population, 10), country.Population > 0
+	case "currency_code":
+		return text(country.CurrencyCode), country.CurrencyCode != nil
+	}
+	return "", false
+}
+
+// currencyRates returns the stored USD rate of every currency, secondary
+// currencies included
+func currencyRates() (map[string]float64, error) {
+	rates, err := storedRates()
+	if err != nil {
+		return nil, err
+	}
+	var rows []CountryCurrency
+	if err := db.Select("code", "exchange_rate").
+		Where("exchange_rate IS NOT NULL").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if _, ok := rates[row.Code]; !ok {
+			rates[row.Code] = *row.ExchangeRate
+		}
+	}
+	return rates, nil
+}
+
+// latestRateAsOf is when the provider last updated the stored rates, or nil
+// before the first refresh. It dates a rate from currencyRates given to a
+// country that had none.
+func latestRateAsOf(conn *gorm.DB) (*time.Time, error) {
+	var asOf *time.Time
+	if err := conn.Model(&Country{}).Select("MAX(rate_as_of)").Scan(&asOf).Error; err != nil {
+		return nil, err
+	}
+	return asOf, nil
+}
+
+// patchCountry corrects fields of one country and optionally pins them so
+// full refreshes keep the correction
+func patchCountry(c *fiber.Ctx) error {
+	invalid := func(details interface{}) error {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": details,
+		})
+	}
+
+	patch, problems := parseCountryPatch(c.Body())
+	if len(problems) > 0 {
+		return invalid(problems)
+	}
+
+	var rates map[string]float64
+	if _, ok := patch.Values["currency_code"]; ok {
+		var err error
+		if rates, err = currencyRates(); err != nil {
+			return err
+		}
+	}
+
+	country, err := updateCountryByName(c.Params("name"), patch, rates, requestActor(c))
+	if errors.Is(err, ErrNothingToPin) {
+		return invalid([]string{err.Error()})
+	}
+	if err != nil {
+		return err
+	}
+
+	notifyDataChanged()
+
+	return c.JSON(country)
+}
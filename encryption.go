@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// encryptedPrefix starts every encrypted column value, followed by the key
+// ID, a colon and the base64 nonce and ciphertext. Values without it are
+// plaintext, written before encryption was enabled.
+const encryptedPrefix = "enc:v1:"
+
+// validKeyID limits key IDs to characters that cannot clash with the format
+var validKeyID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// columnKeys holds the AES-256 keys of encrypted columns. The first key
+// encrypts new values; the others only decrypt, so keys can be rotated.
+type columnKeys struct {
+	active string
+	aeads  map[string]cipher.AEAD
+}
+
+var (
+	columnKeysMu sync.RWMutex
+	// encryptionKeys is nil while column encryption is off
+	encryptionKeys *columnKeys
+)
+
+func init() {
+	schema.RegisterSerializer("encrypted", encryptedSerializer{})
+}
+
+// loadColumnKeys reads COLUMN_ENCRYPTION_KEYS, or the file named by
+// COLUMN_ENCRYPTION_KEYS_FILE: comma-separated id:key pairs, each key 32
+// bytes in standard base64 (`openssl rand -base64 32`). Unset leaves
+// encryption off.
+func loadColumnKeys() error {
+	raw := os.Getenv("COLUMN_ENCRYPTION_KEYS")
+	if path := os.Getenv("COLUMN_ENCRYPTION_KEYS_FILE"); path != "" {
+		if raw != "" {
+			return errors.New("both COLUMN_ENCRYPTION_KEYS and COLUMN_ENCRYPTION_KEYS_FILE are set")
+		}
+		file, err := readCredentialFile("COLUMN_ENCRYPTION_KEYS", path)
+		if err != nil {
+			return err
+		}
+		raw = file.value
+	}
+	if raw == "" {
+		columnKeysMu.Lock()
+		encryptionKeys = nil
+		columnKeysMu.Unlock()
+		return nil
+	}
+
+	keys := &columnKeys{aeads: map[string]cipher.AEAD{}}
+	for _, pair := range strings.Split(raw, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || !validKeyID.MatchString(id) {
+			return fmt.Errorf("invalid COLUMN_ENCRYPTION_KEYS entry: want id:base64key with a short alphanumeric id")
+		}
+		if _, dup := keys.aeads[id]; dup {
+			return fmt.Errorf("duplicate COLUMN_ENCRYPTION_KEYS id %q", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("COLUMN_ENCRYPTION_KEYS key %q must be 32 bytes in base64", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+		if keys.active == "" {
+			keys.active = id
+		}
+		keys.aeads[id] = aead
+	}
+
+	columnKeysMu.Lock()
+	encryptionKeys = keys
+	columnKeysMu.Unlock()
+	return nil
+}
+
+// currentColumnKeys returns the loaded keys, or nil when encryption is off
+func currentColumnKeys() *columnKeys {
+	columnKeysMu.RLock()
+	defer columnKeysMu.RUnlock()
+	return encryptionKeys
+}
+
+// encryptColumn encrypts a value with the active key. The column's name is
+// authenticated with it, so a value copied into another column fails to
+// decrypt. Empty values and values written with encryption off stay as they
+// are.
+func encryptColumn(column, plain string) (string, error) {
+	keys := currentColumnKeys()
+	if keys == nil || plain == "" {
+		return plain, nil
+	}
+	aead := keys.aeads[keys.active]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plain), []byte(column))
+	return encryptedPrefix + keys.active + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptColumn returns the plaintext of a stored value, passing plaintext
+// through
+func decryptColumn(column, stored string) (string, error) {
+	rest, ok := strings.CutPrefix(stored, encryptedPrefix)
+	if !ok {
+		return stored, nil
+	}
+	id, encoded, _ := strings.Cut(rest, ":")
+	keys := currentColumnKeys()
+	if keys == nil {
+		return "", fmt.Errorf("%s is encrypted but COLUMN_ENCRYPTION_KEYS is not set", column)
+	}
+	aead, ok := keys.aeads[id]
+	if !ok {
+		return "", fmt.Errorf("%s is encrypted with key %q, which COLUMN_ENCRYPTION_KEYS lacks", column, id)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("%s holds a malformed encrypted value", column)
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(column))
+	if err != nil {
+		return "", fmt.Errorf("%s failed to decrypt with key %q: %w", column, id, err)
+	}
+	return string(plain), nil
+}
+
+// encryptedSerializer is the GORM serializer of string fields tagged
+// `serializer:encrypted`
+type encryptedSerializer struct{}
+
+// columnName identifies a field as table.column, the authenticated data
+func columnName(field *schema.Field) string {
+	return field.Schema.Table + "." + field.DBName
+}
+
+func (encryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var stored string
+	switch v := dbValue.(type) {
+	case nil:
+	case []byte:
+		stored = string(v)
+	case string:
+		stored = v
+	default:
+		return fmt.Errorf("unexpected %T in encrypted column %s", dbValue, columnName(field))
+	}
+	plain, err := decryptColumn(columnName(field), stored)
+	if err != nil {
+		return err
+	}
+	field.ReflectValueOf(ctx, dst).SetString(plain)
+	return nil
+}
+
+func (encryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	plain, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("encrypted column %s must be a string field", columnName(field))
+	}
+	return encryptColumn(columnName(field), plain)
+}
+
+// encryptedColumn is a column of schemaModels stored encrypted
+type encryptedColumn struct {
+	table   string
+	column  string
+	primary []string
+}
+
+// encryptedColumns lists the columns tagged `serializer:encrypted`
+func encryptedColumns(conn *gorm.DB) ([]encryptedColumn, error) {
+	var columns []encryptedColumn
+	for _, model := range schemaModels {
+		stmt := &gorm.Statement{DB: conn}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		var primary []string
+		for _, field := range stmt.Schema.PrimaryFields {
+			primary = append(primary, field.DBName)
+		}
+		for _, field := range stmt.Schema.Fields {
+			if strings.EqualFold(field.TagSettings["SERIALIZER"], "encrypted") {
+				columns = append(columns, encryptedColumn{table: stmt.Schema.Table, column: field.DBName, primary: primary})
+			}
+		}
+	}
+	return columns, nil
+}
+
+// plaintextCount counts the non-empty values of a column not yet encrypted
+func (col encryptedColumn) plaintextCount(conn *gorm.DB) (int64, error) {
+	var n int64
+	err := conn.Table(col.table).
+		Where(fmt.Sprintf("`%s` <> '' AND `%s` NOT LIKE ?", col.column, col.column), encryptedPrefix+"%").
+		Count(&n).Error
+	return n, err
+}
+
+// reencrypt rewrites every value of the column with the active key,
+// encrypting plaintext and moving values off retired keys, and returns how
+// many it rewrote. With encryption off it decrypts the column instead.
+func (col encryptedColumn) reencrypt(conn *gorm.DB) (int, error) {
+	name := col.table + "." + col.column
+	rows, err := conn.Table(col.table).Select(append(append([]string{}, col.primary...), col.column)).Rows()
+	if err != nil {
+		return 0, err
+	}
+	type pending struct {
+		keys  []interface{}
+		value string
+	}
+	var updates []pending
+	active := ""
+	if keys := currentColumnKeys(); keys != nil {
+		active = encryptedPrefix + keys.active + ":"
+	}
+	for rows.Next() {
+		values := make([]interface{}, len(col.primary)+1)
+		pointers := make([]interface{}, len(values))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		var stored string
+		switch v := values[len(values)-1].(type) {
+		case []byte:
+			stored = string(v)
+		case string:
+			stored = v
+		}
+		// Already under the active key, or plaintext that should stay so
+		if stored == "" || (active != "" && strings.HasPrefix(stored, active)) ||
+			(active == "" && !strings.HasPrefix(stored, encryptedPrefix)) {
+			continue
+		}
+		plain, err := decryptColumn(name, stored)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		value, err := encryptColumn(name, plain)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		updates = append(updates, pending{keys: values[:len(col.primary)], value: value})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	err = conn.Transaction(func(tx *gorm.DB) error {
+		for _, update := range updates {
+			query := tx.Table(col.table)
+			for i, key := range col.primary {
+				query = query.Where(fmt.Sprintf("`%s` = ?", key), update.keys[i])
+			}
+			if err := query.UpdateColumn(col.column, update.value).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return len(updates), err
+}
+
+// runEncryptCommand handles `./app encrypt-columns`: it re-encrypts every
+// encrypted column with the active key and returns the process exit code.
+// Run it after enabling encryption, to encrypt existing rows, and after
+// rotating keys, before removing the old one.
+func runEncryptCommand(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: encrypt-columns")
+		return 2
+	}
+	columns, err := encryptedColumns(db)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to list encrypted columns:", err)
+		return 1
+	}
+	keys := currentColumnKeys()
+	for _, col := range columns {
+		n, err := col.reencrypt(db)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s.%s: %v\n", col.table, col.column, err)
+			return 1
+		}
+		verb := "encrypted"
+		if keys == nil {
+			verb = "decrypted"
+		}
+		fmt.Printf("%s.%s: %s %d values\n", col.table, col.column, verb, n)
+	}
+	return 0
+}
+
+// doctorEncryption reports the column encryption keys and any plaintext
+// left in encrypted columns
+func doctorEncryption(conn *gorm.DB) (string, error) {
+	keys := currentColumnKeys()
+	if keys == nil {
+		return "disabled (set COLUMN_ENCRYPTION_KEYS)", nil
+	}
+	columns, err := encryptedColumns(conn)
+	if err != nil {
+		return "", err
+	}
+	var plaintext []string
+	for _, col := range columns {
+		n, err := col.plaintextCount(conn)
+		if err != nil {
+			return "", err
+		}
+		if n > 0 {
+			plaintext = append(plaintext, fmt.Sprintf("%s.%s (%d)", col.table, col.column, n))
+		}
+	}
+	if len(plaintext) > 0 {
+		return "", fmt.Errorf("plaintext values in %s (run ./app encrypt-columns)", strings.Join(plaintext, ", "))
+	}
+	return fmt.Sprintf("%d columns encrypted with key %q", len(columns), keys.active), nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// listResponse is the envelope every list endpoint returns
+type listResponse struct {
+	Data interface{} `json:"data"`
+	Meta listMeta    `json:"meta"`
+}
+
+// listMeta echoes how the server interpreted a list request, so a typoed
+// filter shows up as ignored instead of silently widening the result
+type listMeta struct {
+	// Total counts every match, before paging
+	Total int64 `json:"total" xml:"total"`
+	// Page is null when the whole list was returned
+	Page           *pageMeta `json:"page" xml:"page"`
+	FiltersApplied xmlFields `json:"filters_applied" xml:"filters_applied"`
+	Sort           string    `json:"sort" xml:"sort"`
+	// IgnoredParams are query parameters the endpoint does not recognise or
+	// whose value it did not use
+	IgnoredParams []string `json:"ignored_params,omitempty" xml:"ignored_params>param,omitempty"`
+}
+
+// pageMeta describes the page returned; offset for offset paging, cursors
+// for cursor paging
+type pageMeta struct {
+	Limit      int     `json:"limit" xml:"limit"`
+	Offset     *int    `json:"offset,omitempty" xml:"offset,omitempty"`
+	Cursor     *string `json:"cursor,omitempty" xml:"cursor,omitempty"`
+	NextCursor *string `json:"next_cursor,omitempty" xml:"next_cursor,omitempty"`
+}
+
+// newListMeta starts the meta of a list request, recording every query
+// parameter outside known as ignored
+func newListMeta(c *fiber.Ctx, known ...string) listMeta {
+	meta := listMeta{FiltersApplied: map[string]string{}}
+	c.Context().QueryArgs().VisitAll(func(key, _ []byte) {
+		for _, name := range known {
+			if string(key) == name {
+				return
+			}
+		}
+		meta.ignore(string(key))
+	})
+	return meta
+}
+
+// ignore records a parameter as ignored, once
+func (m *listMeta) ignore(param string) {
+	for _, existing := range m.IgnoredParams {
+		if existing == param {
+			return
+		}
+	}
+	m.IgnoredParams = append(m.IgnoredParams, param)
+	sort.Strings(m.IgnoredParams)
+}
+
+// filter records a filter the query applied
+func (m *listMeta) filter(name, value string) {
+	m.FiltersApplied[name] = value
+}
+
+// pageMetaFor describes an offset or cursor page, or nil when unpaged
+func pageMetaFor(page pageRequest, cursor string) *pageMeta {
+	switch {
+	case page.Cursor:
+		return &pageMeta{Limit: page.Limit, Cursor: &cursor}
+	case page.Limit > 0:
+		offset := page.Offset
+		return &pageMeta{Limit: page.Limit, Offset: &offset}
+	}
+	return nil
+}
+
+func sendList(c *fiber.Ctx, data interface{}, meta listMeta) error {
+	return c.JSON(listResponse{Data: data, Meta: meta})
+}
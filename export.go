@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// exportBatchSize is the rows a JSON Lines export holds at once, to look
+// up their currencies together
+const exportBatchSize = 100
+
+// exportColumns are the CSV columns, one per stored country field. Only
+// the primary currency is exported.
+var exportColumns = []string{
+	"id", "name", "slug", "alpha2_code", "alpha3_code", "capital", "region",
+	"subregion", "population", "currency_code", "currency_name",
+	"currency_symbol", "exchange_rate", "rate_as_of", "estimated_gdp",
+	"flag_url", "population_tier", "gdp_tier", "source", "last_refreshed_at",
+	"created_at", "updated_at",
+}
+
+// csvText guards a text cell against formula injection: spreadsheets run
+// cells starting with =, +, - or @, so those get a leading apostrophe
+func csvText(s *string) string {
+	if s == nil {
+		return ""
+	}
+	if *s != "" && strings.ContainsRune("=+-@", rune((*s)[0])) {
+		return "'" + *s
+	}
+	return *s
+}
+
+// exportValue is one exported field: a number, text, or null when both
+// are nil
+type exportValue struct {
+	number *float64
+	text   *string
+}
+
+func exportNumber(f float64) exportValue {
+	return exportValue{number: &f}
+}
+
+func exportText(s string) exportValue {
+	return exportValue{text: &s}
+}
+
+// exportStamp is a time as RFC 3339 text in UTC
+func exportStamp(t *time.Time) exportValue {
+	if t == nil {
+		return exportValue{}
+	}
+	return exportText(t.UTC().Format(time.RFC3339))
+}
+
+// exportValues are one country's fields in exportColumns order, for every
+// format
+func exportValues(country Country) []exportValue {
+	number, text, stamp := exportNumber, exportText, exportStamp
+	return []exportValue{
+		number(float64(country.ID)),
+		text(country.Name),
+		{text: country.Slug},
+		{text: country.Alpha2Code},
+		{text: country.Alpha3Code},
+		{text: country.Capital},
+		{text: country.Region},
+		{text: country.Subregion},
+		number(float64(country.Population)),
+		{text: country.CurrencyCode},
+		{text: country.CurrencyName},
+		{text: country.CurrencySymbol},
+		{number: country.ExchangeRate},
+		stamp(country.RateAsOf),
+		{number: country.EstimatedGDP},
+		{text: country.FlagURL},
+		text(country.PopulationTier),
+		{text: country.GDPTier},
+		text(country.Source),
+		stamp(&country.LastRefreshedAt),
+		stamp(&country.CreatedAt),
+		stamp(&country.UpdatedAt),
+	}
+}
+
+// exportRecord is one country's CSV row, in exportColumns order. Null
+// values are empty cells and numbers are written in full.
+func exportRecord(country Country) []string {
+	values := exportValues(country)
+	record := make([]string, len(values))
+	for i, value := range values {
+		if value.number != nil {
+			record[i] = strconv.FormatFloat(*value.number, 'f', -1, 64)
+		} else {
+			record[i] = csvText(value.text)
+		}
+	}
+	return record
+}
+
+// exportWorkbook lays the exported countries out as the sheets of an XLSX
+// report: every country, the GDP ranking of the summary image, and totals
+// per region. The rankings and totals cover the exported countries, so
+// filters narrow the whole report.
+func exportWorkbook(countries []Country) []xlsxSheet {
+	all := xlsxSheet{name: "Countries", rows: [][]exportValue{xlsxHeader(exportColumns...)}}
+	for _, country := range countries {
+		all.rows = append(all.rows, exportValues(country))
+	}
+
+	// Ranked as GET /countries/top ranks by GDP
+	ranked := []Country{}
+	for _, country := range countries {
+		if country.EstimatedGDP != nil && *country.EstimatedGDP > 0 {
+			ranked = append(ranked, country)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if *ranked[i].EstimatedGDP != *ranked[j].EstimatedGDP {
+			return *ranked[i].EstimatedGDP > *ranked[j].EstimatedGDP
+		}
+		return ranked[i].Name < ranked[j].Name
+	})
+	top := xlsxSheet{name: "Top GDP", rows: [][]exportValue{xlsxHeader("rank", "name", "region", "currency_code", "estimated_gdp")}}
+	for i, country := range ranked[:min(len(ranked), defaultTopLimit)] {
+		top.rows = append(top.rows, []exportValue{
+			exportNumber(float64(i + 1)), exportText(country.Name), {text: country.Region},
+			{text: country.CurrencyCode}, {number: country.EstimatedGDP},
+		})
+	}
+
+	// Regions as GET /regions aggregates them, plus "none" for countries
+	// without one and a total over every country
+	type regionTotals struct {
+		countries, rated int
+		population       int64
+		gdp, rates       float64
+		refreshed        time.Time
+	}
+	regions := map[string]*regionTotals{}
+	var total regionTotals
+	for _, country := range countries {
+		name := "none"
+		if country.Region != nil {
+			name = *country.Region
+		}
+		if regions[name] == nil {
+			regions[name] = &regionTotals{}
+		}
+		for _, t := range []*regionTotals{regions[name], &total} {
+			t.countries++
+			t.population += country.Population
+			if country.EstimatedGDP != nil {
+				t.gdp += *country.EstimatedGDP
+			}
+			if country.ExchangeRate != nil {
+				t.rated++
+				t.rates += *country.ExchangeRate
+			}
+			if country.LastRefreshedAt.After(t.refreshed) {
+				t.refreshed = country.LastRefreshedAt
+			}
+		}
+	}
+	names := make([]string, 0, len(regions))
+	for name := range regions {
+		if name != "none" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if regions["none"] != nil {
+		names = append(names, "none")
+	}
+
+	summary := xlsxSheet{name: "Regions", rows: [][]exportValue{xlsxHeader("region", "countries", "population",
+		"estimated_gdp", "average_exchange_rate", "last_refreshed_at")}}
+	row := func(name string, t *regionTotals) []exportValue {
+		average := exportValue{}
+		if t.rated > 0 {
+			average = exportNumber(t.rates / float64(t.rated))
+		}
+		refreshed := exportValue{}
+		if t.countries > 0 {
+			refreshed = exportStamp(&t.refreshed)
+		}
+		return []exportValue{exportText(name), exportNumber(float64(t.countries)), exportNumber(float64(t.population)),
+			exportNumber(t.gdp), average, refreshed}
+	}
+	for _, name := range names {
+		summary.rows = append(summary.rows, row(name, regions[name]))
+	}
+	summary.rows = append(summary.rows, row("Total", &total))
+
+	return []xlsxSheet{all, top, summary}
+}
+
+// writeCountryLines writes each row of a countries query as one JSON line,
+// in the shape GET /countries/:name serves, flushing every batch so memory
+// stays bounded however many rows there are
+func writeCountryLines(w *bufio.Writer, query *gorm.DB, rows *sql.Rows) error {
+	batch := make([]Country, 0, exportBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := attachCurrencies(db, batch); err != nil {
+			return err
+		}
+		for _, country := range batch {
+			line, err := json.Marshal(country)
+			if err != nil {
+				return err
+			}
+			w.Write(line)
+			w.WriteByte('\n')
+		}
+		batch = batch[:0]
+		return w.Flush()
+	}
+
+	for rows.Next() {
+		var country Country
+		if err := query.ScanRows(rows, &country); err != nil {
+			return err
+		}
+		// ScanRows skips the hooks Find runs
+		if err := country.AfterFind(query); err != nil {
+			return err
+		}
+		batch = append(batch, country)
+		if len(batch) == exportBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// exportCountries streams every country matching the list filters
+// (?region, ?currency, ?population_tier, ?gdp_tier) in ?sort order as a
+// download: ?format=csv (the default), jsonl or xlsx.
+func exportCountries(c *fiber.Ctx) error {
+	invalid := func(details string) error {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": details,
+		})
+	}
+
+	format := c.Query("format", "csv")
+	if format != "csv" && format != "jsonl" && format != "xlsx" {
+		return invalid(fmt.Sprintf("format must be csv, jsonl or xlsx, not %q", format))
+	}
+
+	meta := newListMeta(c)
+	query, err := filterCountries(c, db.Model(&Country{}), &meta)
+	if err != nil {
+		return invalid(err.Error())
+	}
+	query = sortCountries(query, c.Query("sort"), &meta)
+
+	// JSON Lines is for pipelines, so rows are read one at a time as they
+	// are written. The query runs first: a failure to start it is still a
+	// 500, while one midway can only end the stream early.
+	if format == "jsonl" {
+		rows, err := query.Rows()
+		if err != nil {
+			return err
+		}
+		c.Set(fiber.HeaderContentType, "application/x-ndjson; charset=utf-8")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="countries.jsonl"`)
+		// The stream runs after the handler returns, and holds a database
+		// connection throughout, so it keeps the export slot until done
+		release := keepConcurrencySlot(c)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer release()
+			defer rows.Close()
+			if err := writeCountryLines(w, query, rows); err != nil {
+				log.Printf("Country export stopped: %v", err)
+			}
+		})
+		return nil
+	}
+
+	// Rows are read before the response starts, so a database failure is
+	// still a 500 rather than a truncated file
+	countries := []Country{}
+	if err := query.Find(&countries).Error; err != nil {
+		return err
+	}
+
+	if format == "xlsx" {
+		c.Set(fiber.HeaderContentType, xlsxContentType)
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="countries.xlsx"`)
+		release := keepConcurrencySlot(c)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer release()
+			if err := writeXLSX(w, exportWorkbook(countries)); err != nil {
+				log.Printf("Country export stopped: %v", err)
+			}
+			w.Flush()
+		})
+		return nil
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="countries.csv"`)
+	release := keepConcurrencySlot(c)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer release()
+		out := csv.NewWriter(w)
+		out.Write(exportColumns)
+		for _, country := range countries {
+			out.Write(exportRecord(country))
+		}
+		out.Flush()
+	})
+	return nil
+}
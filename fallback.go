@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Where a refresh's country data came from
+const (
+	countriesFromPrimary = "primary"
+	countriesFromMirror  = "mirror"
+	countriesFromBundled = "bundled"
+	countriesFromArchive = "archive"
+)
+
+// bundledCountries is the restcountries snapshot shipped with the binary,
+// the last resort when every live source is down. Regenerate it with
+// `make snapshot` before a release.
+//
+//go:embed snapshot/countries.json
+var bundledCountries []byte
+
+// Fallbacks for restcountries: COUNTRIES_MIRROR_URL serves the same
+// payload elsewhere; COUNTRIES_BUNDLED_FALLBACK=false disables the snapshot
+var (
+	countriesMirrorURL      string
+	countriesBundledEnabled = true
+)
+
+// lastCountriesSource remembers which source served the last full refresh
+// of this process, for /status
+var lastCountriesSource struct {
+	sync.Mutex
+	name string
+}
+
+func recordCountriesSource(name string) {
+	lastCountriesSource.Lock()
+	lastCountriesSource.name = name
+	lastCountriesSource.Unlock()
+}
+
+// countriesSourceSnapshot returns the last source, or nil before the first
+// full refresh
+func countriesSourceSnapshot() *string {
+	lastCountriesSource.Lock()
+	defer lastCountriesSource.Unlock()
+	return nilIfEmpty(&lastCountriesSource.name)
+}
+
+// countrySource is one step of the fallback chain
+type countrySource struct {
+	name  string
+	fetch func(ctx context.Context) ([]byte, error)
+}
+
+// countrySources is the fallback chain in order: restcountries, the mirror
+// when configured, then the bundled snapshot when enabled. Each live
+// source gets its own deadline so a hung primary leaves the mirror time.
+func countrySources() []countrySource {
+	live := func(url string) func(context.Context) ([]byte, error) {
+		return func(ctx context.Context) ([]byte, error) {
+			ctx, cancel := context.WithTimeout(ctx, upstreamTimeout)
+			defer cancel()
+			return fetchPayload(ctx, url)
+		}
+	}
+
+	sources := []countrySource{{countriesFromPrimary, live(countriesAPIURL)}}
+	if countriesMirrorURL != "" {
+		sources = append(sources, countrySource{countriesFromMirror, live(countriesMirrorURL)})
+	}
+	if countriesBundledEnabled {
+		sources = append(sources, countrySource{countriesFromBundled, func(context.Context) ([]byte, error) {
+			return bundledCountries, nil
+		}})
+	}
+	return sources
+}
+
+// fetchCountries walks the fallback chain and returns the first payload
+// that downloads and parses, with the name of the source that served it.
+// When every source fails the errors of all of them are returned.
+func fetchCountries(ctx context.Context) ([]byte, []RestCountry, string, error) {
+	var errs []error
+	for _, source := range countrySources() {
+		body, err := source.fetch(ctx)
+		if err == nil {
+			var countries []RestCountry
+			if countries, err = parseCountries(body); err == nil {
+				if source.name != countriesFromPrimary {
+					log.Printf("restcountries unavailable, refreshing from the %s source", source.name)
+				}
+				return body, countries, source.name, nil
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", source.name, err))
+	}
+	return nil, nil, "", errors.Join(errs...)
+}
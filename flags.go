@@ -0,0 +1,363 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// sourceFlagCheck marks a flag_url the flag check swapped in for a broken
+// upstream one
+const sourceFlagCheck = "flag-check"
+
+// flagCheckWorkers bounds the flag URLs fetched at once
+const flagCheckWorkers = 8
+
+// Outcomes of checking one country's flag
+const (
+	flagOK = "ok"
+	// flagBroken is a flag that failed with no working alternate, or one an
+	// operator set, which is reported but never replaced
+	flagBroken   = "broken"
+	flagRepaired = "repaired"
+)
+
+var flagCheckClient = &http.Client{Timeout: 10 * time.Second}
+
+// FlagCheck is the latest check of one country's flag URL
+type FlagCheck struct {
+	ID      uint   `gorm:"primaryKey" json:"-"`
+	Country string `gorm:"type:varchar(512);uniqueIndex;not null" json:"country"`
+	// FlagURL is the URL checked: the upstream one for a repaired flag
+	FlagURL     string  `gorm:"type:varchar(2048);not null" json:"flag_url"`
+	Status      string  `gorm:"type:varchar(10);index;not null" json:"status"`
+	HTTPStatus  *int    `json:"http_status"`
+	ContentType *string `gorm:"type:varchar(255)" json:"content_type"`
+	Error       *string `gorm:"type:varchar(512)" json:"error"`
+	// RepairedURL is the working alternate now served as flag_url
+	RepairedURL *string   `gorm:"type:varchar(2048)" json:"repaired_url"`
+	CheckedAt   time.Time `gorm:"index" json:"checked_at"`
+}
+
+// flagCheckSummary is the outcome of one run over every country
+type flagCheckSummary struct {
+	CheckedAt time.Time `json:"checked_at"`
+	Checked   int       `json:"checked"`
+	OK        int       `json:"ok"`
+	Broken    int       `json:"broken"`
+	Repaired  int       `json:"repaired"`
+}
+
+// flagCheckMu keeps scheduled and on-demand runs from overlapping
+var flagCheckMu sync.Mutex
+
+// errFlagCheckRunning is returned when a run is already in progress
+var errFlagCheckRunning = errors.New("a flag check is already running")
+
+// probeFlag fetches a flag URL. It fails unless the response is a 200 with
+// an image content type.
+func probeFlag(url string) (status int, contentType string, err error) {
+	resp, err := flagCheckClient.Get(url)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	contentType = resp.Header.Get(fiber.HeaderContentType)
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, contentType, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if media, _, _ := mime.ParseMediaType(contentType); !strings.HasPrefix(media, "image/") {
+		return resp.StatusCode, contentType, fmt.Errorf("content type %q is not an image", contentType)
+	}
+	return resp.StatusCode, contentType, nil
+}
+
+// alternateFlagURLs are other sources of a country's flag, keyed by its
+// alpha-2 code, tried in order when the stored one is broken
+func alternateFlagURLs(country Country) []string {
+	code := strings.ToLower(flagCode(country.FlagURL))
+	if country.Alpha2Code != nil {
+		code = strings.ToLower(*country.Alpha2Code)
+	}
+	if !isAlpha2(strings.ToUpper(code)) {
+		return nil
+	}
+	urls := []string{}
+	for _, url := range []string{
+		fmt.Sprintf("https://flagcdn.com/%s.svg", code),
+		fmt.Sprintf("https://flagcdn.com/w320/%s.png", code),
+		fmt.Sprintf("https://flagsapi.com/%s/flat/64.png", strings.ToUpper(code)),
+	} {
+		if country.FlagURL == nil || url != *country.FlagURL {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// checkFlag checks one country's stored flag and looks for a working
+// alternate if it is broken. Flags set by an operator, through a pin or an
+// edit, are never replaced.
+func checkFlag(country Country, now time.Time) FlagCheck {
+	check := FlagCheck{Country: country.Name, FlagURL: *country.FlagURL, Status: flagOK, CheckedAt: now}
+	status, contentType, err := probeFlag(*country.FlagURL)
+	if status != 0 {
+		check.HTTPStatus = &status
+	}
+	check.ContentType = nilIfEmpty(&contentType)
+	if err == nil {
+		return check
+	}
+	msg := err.Error()
+	check.Error, check.Status = &msg, flagBroken
+
+	if source := country.FieldSources["flag_url"]; source == sourceOverride || source == sourceManual {
+		return check
+	}
+	for _, url := range alternateFlagURLs(country) {
+		if _, _, err := probeFlag(url); err == nil {
+			repaired := url
+			check.Status, check.RepairedURL = flagRepaired, &repaired
+			break
+		}
+	}
+	return check
+}
+
+// repairFlag replaces a country's broken flag_url with the working
+// alternate, conditional on the row being unchanged since it was read
+func repairFlag(tx *gorm.DB, old Country, url string, now time.Time) error {
+	updated := old
+	updated.FlagURL = &url
+	updated.FieldSources = map[string]string{}
+	for field, source := range old.FieldSources {
+		updated.FieldSources[field] = source
+	}
+	updated.FieldSources["flag_url"] = sourceFlagCheck
+	updated.UpdatedAt = now
+
+	result := tx.Model(&Country{ID: old.ID}).Where("updated_at = ?", old.UpdatedAt).
+		Select("flag_url", "field_sources", "updated_at").Updates(&updated)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrStaleVersion
+	}
+	entry := historyEntry(&old, &updated, historySourceFlagCheck)
+	if entry == nil {
+		return nil
+	}
+	return recordHistory(tx, []CountryHistory{*entry}, now)
+}
+
+// checkFlags checks every stored flag URL, repairs broken ones from
+// alternate sources and replaces the stored results. A working repair stays
+// reported as repaired, so refreshes keep applying it. A country changed
+// while its flag was being checked keeps its new flag and is reported
+// broken until the next run.
+func checkFlags() (flagCheckSummary, error) {
+	if !flagCheckMu.TryLock() {
+		return flagCheckSummary{}, errFlagCheckRunning
+	}
+	defer flagCheckMu.Unlock()
+
+	now := clock.Now()
+	summary := flagCheckSummary{CheckedAt: now}
+	var countries []Country
+	if err := db.Where("flag_url IS NOT NULL AND flag_url <> ''").Order("name ASC").Find(&countries).Error; err != nil {
+		return summary, err
+	}
+
+	previous, err := flagRepairs(db)
+	if err != nil {
+		return summary, err
+	}
+
+	checks := make([]FlagCheck, len(countries))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < flagCheckWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				checks[i] = checkFlag(countries[i], now)
+			}
+		}()
+	}
+	for i := range countries {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for i, country := range countries {
+		prev, ok := previous[strings.ToLower(country.Name)]
+		if ok && checks[i].Status == flagOK && country.FieldSources["flag_url"] == sourceFlagCheck {
+			checks[i].FlagURL, checks[i].Status, checks[i].RepairedURL = prev.FlagURL, flagRepaired, country.FlagURL
+		}
+	}
+
+	changed := 0
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for i, check := range checks {
+			if check.Status != flagRepaired || *check.RepairedURL == *countries[i].FlagURL {
+				continue
+			}
+			err := repairFlag(tx, countries[i], *check.RepairedURL, now)
+			if errors.Is(err, ErrStaleVersion) {
+				checks[i].Status, checks[i].RepairedURL = flagBroken, nil
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			changed++
+		}
+		if err := tx.Where("1 = 1").Delete(&FlagCheck{}).Error; err != nil {
+			return err
+		}
+		if len(checks) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(checks, refreshBatchSize).Error
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	summary.Checked = len(checks)
+	for _, check := range checks {
+		switch check.Status {
+		case flagOK:
+			summary.OK++
+		case flagBroken:
+			summary.Broken++
+		case flagRepaired:
+			summary.Repaired++
+		}
+	}
+	if changed > 0 {
+		notifyDataChanged()
+	}
+	return summary, nil
+}
+
+// flagRepairs returns the stored repairs keyed by lower-cased country name
+func flagRepairs(conn *gorm.DB) (map[string]FlagCheck, error) {
+	var checks []FlagCheck
+	if err := conn.Where("status = ?", flagRepaired).Find(&checks).Error; err != nil {
+		return nil, err
+	}
+	repairs := make(map[string]FlagCheck, len(checks))
+	for _, check := range checks {
+		repairs[strings.ToLower(check.Country)] = check
+	}
+	return repairs, nil
+}
+
+// applyFlagRepair keeps a repaired flag across full refreshes for as long
+// as upstream still serves the URL found broken
+func applyFlagRepair(row *Country, repairs map[string]FlagCheck) {
+	repair, ok := repairs[strings.ToLower(row.Name)]
+	if !ok || row.FlagURL == nil || *row.FlagURL != repair.FlagURL {
+		return
+	}
+	row.FlagURL = repair.RepairedURL
+	if row.FieldSources == nil {
+		row.FieldSources = map[string]string{}
+	}
+	row.FieldSources["flag_url"] = sourceFlagCheck
+}
+
+// runFlagCheckEvery checks the flags once per interval, reporting each run
+// in /status
+func runFlagCheckEvery(interval time.Duration) {
+	log.Printf("Scheduled flag check every %s", interval)
+	setScheduledRun("flag-check", scheduledRun{Interval: interval.String(), NextRunAt: clock.Now().Add(interval)})
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		started := clock.Now()
+		run := scheduledRun{Interval: interval.String(), StartedAt: &started, NextRunAt: started.Add(interval)}
+		summary, err := checkFlags()
+		finished := clock.Now()
+		run.FinishedAt = &finished
+		switch {
+		case errors.Is(err, errFlagCheckRunning):
+			run.Status = scheduledSkipped
+		case err != nil:
+			log.Printf("Flag check failed: %v", err)
+			msg := err.Error()
+			run.Status, run.Error = scheduledFailed, &msg
+		default:
+			log.Printf("Flag check found %d broken and repaired %d of %d flags", summary.Broken, summary.Repaired, summary.Checked)
+			run.Status, run.Processed, run.Updated = scheduledOK, summary.Checked, summary.Repaired
+		}
+		setScheduledRun("flag-check", run)
+	}
+}
+
+// getBrokenFlags lists the flags the latest check found broken or
+// repaired; ?status= narrows it to one of them
+func getBrokenFlags(c *fiber.Ctx) error {
+	status := c.Query("status")
+	if status != "" && status != flagBroken && status != flagRepaired {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": "status must be broken or repaired",
+		})
+	}
+
+	var checks []FlagCheck
+	if err := db.Order("country ASC").Find(&checks).Error; err != nil {
+		return err
+	}
+	var checkedAt *time.Time
+	counts := map[string]int{flagOK: 0, flagBroken: 0, flagRepaired: 0}
+	flags := []FlagCheck{}
+	for _, check := range checks {
+		if checkedAt == nil {
+			at := check.CheckedAt
+			checkedAt = &at
+		}
+		counts[check.Status]++
+		if check.Status != flagOK && (status == "" || check.Status == status) {
+			flags = append(flags, check)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"checked_at": checkedAt,
+		"checked":    len(checks),
+		"by_status":  counts,
+		"flags":      flags,
+	})
+}
+
+// postFlagCheck runs a flag check now
+func postFlagCheck(c *fiber.Ctx) error {
+	summary, err := checkFlags()
+	if errors.Is(err, errFlagCheckRunning) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Flag check already running",
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(summary)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// errGeoIPDisabled is returned when no MaxMind credentials are configured
+var errGeoIPDisabled = errors.New("geoip lookup not configured")
+
+// geoIPLocation is the subset of the MaxMind GeoIP2 country response we use
+type geoIPLocation struct {
+	Country struct {
+		ISOCode string            `json:"iso_code"`
+		Names   map[string]string `json:"names"`
+	} `json:"country"`
+}
+
+// lookupGeoIP resolves an IP address using the MaxMind GeoIP2 / GeoLite2
+// web service. Credentials come from MAXMIND_ACCOUNT_ID and
+// MAXMIND_LICENSE_KEY, or their _FILE variants.
+func lookupGeoIP(ip string) (*geoIPLocation, error) {
+	accountID := credential("MAXMIND_ACCOUNT_ID")
+	licenseKey := credential("MAXMIND_LICENSE_KEY")
+	if accountID == "" || licenseKey == "" {
+		return nil, errGeoIPDisabled
+	}
+
+	// GeoLite accounts use geolite.info, paid accounts geoip.maxmind.com
+	host := getEnv("MAXMIND_HOST", "geolite.info")
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s/geoip/v2.1/country/%s", host, ip), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(accountID, licenseKey)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("GeoIP service returned status %d", resp.StatusCode)
+	}
+
+	var location geoIPLocation
+	if err := json.NewDecoder(resp.Body).Decode(&location); err != nil {
+		return nil, err
+	}
+
+	return &location, nil
+}
+
+// loadTrustedProxies reads TRUSTED_PROXIES, a comma-separated list of
+// proxy addresses or CIDR ranges
+func loadTrustedProxies() ([]string, error) {
+	var proxies []string
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q (expected an address or CIDR range)", entry)
+		}
+		proxies = append(proxies, entry)
+	}
+	return proxies, nil
+}
+
+// trustedProxyNets are the TRUSTED_PROXIES ranges, set by proxyConfig
+var trustedProxyNets []*net.IPNet
+
+// proxyConfig trusts the proxies in TRUSTED_PROXIES to report the client
+// through X-Forwarded-For (see clientIP) and X-Forwarded-Proto and -Host.
+// Requests from any other address are attributed to the connection,
+// whatever the headers say.
+func proxyConfig(config *fiber.Config) error {
+	trustedProxyNets = nil
+	proxies, err := loadTrustedProxies()
+	if err != nil || len(proxies) == 0 {
+		return err
+	}
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			bits := 128
+			if net.ParseIP(proxy).To4() != nil {
+				bits = 32
+			}
+			proxy = fmt.Sprintf("%s/%d", proxy, bits)
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return err
+		}
+		trustedProxyNets = append(trustedProxyNets, network)
+	}
+	config.EnableTrustedProxyCheck = true
+	config.TrustedProxies = proxies
+	return nil
+}
+
+// isTrustedProxy reports whether ip is in TRUSTED_PROXIES
+func isTrustedProxy(ip net.IP) bool {
+	for _, network := range trustedProxyNets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the caller's address. For a request from a trusted proxy it
+// is the rightmost X-Forwarded-For hop that is not a trusted proxy: each
+// proxy appends the address it was called from, so hops further left come
+// from the client and can be forged.
+func clientIP(c *fiber.Ctx) string {
+	remote := c.Context().RemoteIP()
+	if !isTrustedProxy(remote) {
+		return remote.String()
+	}
+	var hops []string
+	for _, header := range c.Request().Header.PeekAll(fiber.HeaderXForwardedFor) {
+		hops = append(hops, strings.Split(string(header), ",")...)
+	}
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// callerCountry resolves the caller's country record via GeoIP
+func callerCountry(c *fiber.Ctx) (*Country, error) {
+	ip := clientIP(c)
+	if parsed := net.ParseIP(ip); parsed == nil || parsed.IsLoopback() || parsed.IsPrivate() {
+		return nil, ErrCountryNotFound
+	}
+
+	location, err := lookupGeoIP(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	return findCountryByName(location.Country.Names["en"])
+}
+
+func getCallerCountry(c *fiber.Ctx) error {
+	lang, err := parseLanguage(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	country, err := callerCountry(c)
+	if err != nil {
+		if err == errGeoIPDisabled {
+			return c.Status(501).JSON(fiber.Map{
+				"error": "GeoIP lookup is not enabled",
+			})
+		}
+		if err == ErrCountryNotFound {
+			return err
+		}
+		return c.Status(503).JSON(fiber.Map{
+			"error":   "External data source unavailable",
+			"details": fmt.Sprintf("Could not resolve caller location: %v", err),
+		})
+	}
+
+	localizeCountry(lang, country)
+	return c.JSON(country)
+}
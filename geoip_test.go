@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLoadTrustedProxies(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    []string
+		wantErr bool
+	}{
+		{raw: "", want: nil},
+		{raw: "10.0.0.1", want: []string{"10.0.0.1"}},
+		{raw: " 10.0.0.0/8 , ::1,", want: []string{"10.0.0.0/8", "::1"}},
+		{raw: "proxy.internal", wantErr: true},
+		{raw: "10.0.0.0/33", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", tt.raw)
+			got, err := loadTrustedProxies()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadTrustedProxies() = %v, want error: %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadTrustedProxies() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	// app.Test connections come from 0.0.0.0
+	tests := []struct {
+		proxies, forwarded string
+		want               string
+	}{
+		{"", "203.0.113.7, 10.0.0.1", "0.0.0.0"},
+		{"10.0.0.1", "203.0.113.7, 10.0.0.1", "0.0.0.0"},
+		{"0.0.0.0/8", "203.0.113.7, 10.0.0.1", "10.0.0.1"},
+		{"0.0.0.0/8,10.0.0.0/8", "203.0.113.7, 10.0.0.1", "203.0.113.7"},
+		{"0.0.0.0/8,10.0.0.0/8", "198.51.100.9, 203.0.113.7, 10.0.0.1", "203.0.113.7"},
+		{"0.0.0.0/8,10.0.0.0/8", "10.0.0.2, 10.0.0.1", "10.0.0.2"},
+		{"0.0.0.0/8,10.0.0.0/8", "203.0.113.7, garbage, 10.0.0.1", "10.0.0.1"},
+		{"0.0.0.0/8", "", "0.0.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.proxies+" "+tt.forwarded, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", tt.proxies)
+			config := fiber.Config{}
+			if err := proxyConfig(&config); err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			app := fiber.New(config)
+			app.Get("/", func(c *fiber.Ctx) error {
+				got = clientIP(c)
+				return nil
+			})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.forwarded != "" {
+				req.Header.Set(fiber.HeaderXForwardedFor, tt.forwarded)
+			}
+			if _, err := app.Test(req); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("clientIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Kinds of country change
+const (
+	historyCreate = "create"
+	historyUpdate = "update"
+	historyDelete = "delete"
+)
+
+// Where a country change came from
+const (
+	historySourceRefresh = "refresh"
+	historySourceRates   = "rates-refresh"
+	historySourceAPI     = "api"
+	// historySourceFlagCheck is a broken flag_url replaced by the flag check
+	historySourceFlagCheck = "flag-check"
+)
+
+// historyFields are the country columns the history tracks
+var historyFields = []string{
+	"name", "alpha2_code", "alpha3_code", "capital", "region", "subregion", "population",
+	"currency_code", "currency_name", "currency_symbol", "exchange_rate",
+	"estimated_gdp", "flag_url", "population_tier", "gdp_tier",
+}
+
+// CountryHistory is one create, update or delete of a country
+type CountryHistory struct {
+	ID      uint   `gorm:"primaryKey" json:"id"`
+	Country string `gorm:"type:varchar(512);index;not null" json:"country"`
+	// CountryCode is the country's alpha-3 code, which identifies it
+	// across renames
+	CountryCode *string `gorm:"type:varchar(3);index" json:"country_code"`
+	Action      string  `gorm:"type:varchar(10);not null" json:"action"`
+	Source      string  `gorm:"type:varchar(20);not null" json:"source"`
+	// Actor is the API caller; refreshes have none
+	Actor string `gorm:"type:varchar(512);serializer:encrypted" json:"actor,omitempty"`
+	// OldValues and NewValues hold only the fields that changed. OldValues
+	// is null for a create and NewValues for a delete.
+	OldValues map[string]interface{} `gorm:"type:text;serializer:json" json:"old_values"`
+	NewValues map[string]interface{} `gorm:"type:text;serializer:json" json:"new_values"`
+	ChangedAt time.Time              `gorm:"index" json:"changed_at"`
+}
+
+func (CountryHistory) TableName() string {
+	return "country_history"
+}
+
+// historyValues maps the tracked fields of a country to their values, with
+// nil for NULL
+func historyValues(c Country) map[string]interface{} {
+	text := func(s *string) interface{} {
+		if s == nil {
+			return nil
+		}
+		return *s
+	}
+	number := func(f *float64) interface{} {
+		if f == nil {
+			return nil
+		}
+		return *f
+	}
+	return map[string]interface{}{
+		"name":            c.Name,
+		"alpha2_code":     text(c.Alpha2Code),
+		"alpha3_code":     text(c.Alpha3Code),
+		"capital":         text(c.Capital),
+		"region":          text(c.Region),
+		"subregion":       text(c.Subregion),
+		"population":      c.Population,
+		"currency_code":   text(c.CurrencyCode),
+		"currency_name":   text(c.CurrencyName),
+		"currency_symbol": text(c.CurrencySymbol),
+		"exchange_rate":   number(c.ExchangeRate),
+		"estimated_gdp":   number(c.EstimatedGDP),
+		"flag_url":        text(c.FlagURL),
+		"population_tier": c.PopulationTier,
+		"gdp_tier":        text(c.GDPTier),
+	}
+}
+
+// historyEntry describes the change from old to updated; old is nil for a
+// create and updated for a delete. It returns nil when no tracked field
+// changed.
+func historyEntry(old, updated *Country, source string) *CountryHistory {
+	entry := &CountryHistory{Source: source}
+	switch {
+	case old == nil:
+		entry.Action, entry.Country, entry.NewValues = historyCreate, updated.Name, historyValues(*updated)
+		entry.CountryCode = updated.Alpha3Code
+	case updated == nil:
+		entry.Action, entry.Country, entry.OldValues = historyDelete, old.Name, historyValues(*old)
+		entry.CountryCode = old.Alpha3Code
+	default:
+		entry.Action, entry.Country, entry.CountryCode = historyUpdate, updated.Name, updated.Alpha3Code
+		before, after := historyValues(*old), historyValues(*updated)
+		entry.OldValues, entry.NewValues = map[string]interface{}{}, map[string]interface{}{}
+		for _, field := range historyFields {
+			if before[field] != after[field] {
+				entry.OldValues[field], entry.NewValues[field] = before[field], after[field]
+			}
+		}
+		if len(entry.NewValues) == 0 {
+			return nil
+		}
+	}
+	return entry
+}
+
+// recordHistory stores the entries of one change set at the given time
+func recordHistory(tx *gorm.DB, entries []CountryHistory, at time.Time) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	for i := range entries {
+		entries[i].ChangedAt = at
+	}
+	return tx.CreateInBatches(&entries, refreshBatchSize).Error
+}
+
+// getCountryHistory lists the changes of one country, newest first. The
+// history outlives the country, so a deleted country's history is still
+// served.
+func getCountryHistory(c *fiber.Ctx) error {
+	meta := newListMeta(c, "field", "from", "to", "limit", "offset")
+	meta.Sort = "changed_at_desc"
+
+	page, err := parsePage(c)
+	if err == nil && page.Cursor {
+		err = errors.New("cursor paging is not supported; use limit and offset")
+	}
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+	if page.Limit == 0 {
+		page.Limit = defaultPageLimit
+	}
+
+	// The column's collation already compares case-insensitively, and
+	// a plain comparison keeps the index usable. A live country's code
+	// also finds the entries recorded under its former names.
+	name := c.Params("name")
+	query := db.Model(&CountryHistory{}).Where("country = ?", name)
+	country, err := findCountryByName(name)
+	if err != nil && !errors.Is(err, ErrCountryNotFound) {
+		return err
+	}
+	if country != nil && country.Alpha3Code != nil {
+		query = db.Model(&CountryHistory{}).Where("country = ? OR country_code = ?", name, *country.Alpha3Code)
+	}
+
+	if field := c.Query("field"); field != "" {
+		known := false
+		for _, tracked := range historyFields {
+			known = known || field == tracked
+		}
+		if !known {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "Validation failed",
+				"details": "field must be one of " + strings.Join(historyFields, ", "),
+			})
+		}
+		// Creates and deletes list every field; updates only changed ones
+		pattern := `%"` + field + `":%`
+		query = query.Where("old_values LIKE ? OR new_values LIKE ?", pattern, pattern)
+		meta.filter("field", field)
+	}
+
+	for _, bound := range []struct{ param, clause string }{
+		{"from", "changed_at >= ?"},
+		{"to", "changed_at <= ?"},
+	} {
+		raw := c.Query(bound.param)
+		if raw == "" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "Validation failed",
+				"details": bound.param + " must be an RFC3339 timestamp",
+			})
+		}
+		query = query.Where(bound.clause, at.UTC())
+		meta.filter(bound.param, at.UTC().Format(time.RFC3339))
+	}
+
+	if err := query.Count(&meta.Total).Error; err != nil {
+		return err
+	}
+	if meta.Total == 0 && country == nil {
+		// Unknown countries are a 404; known ones may just have no changes yet
+		return ErrCountryNotFound
+	}
+
+	history := []CountryHistory{}
+	if err := query.Order("changed_at DESC, id DESC").
+		Limit(page.Limit).Offset(page.Offset).Find(&history).Error; err != nil {
+		return err
+	}
+	meta.Page = pageMetaFor(page, "")
+	return sendList(c, history, meta)
+}
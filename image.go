@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const summaryImagePath = "cache/summary.png"
+
+// Layout constants, in logical (unscaled) pixels
+const (
+	imageMargin     = 20
+	imageLineHeight = 25
+	imageMinWidth   = 200
+	imageMaxWidth   = 4000
+	imageMaxHeight  = 4000
+	imageMaxScale   = 4
+	// imageMaxPixels bounds width*height*scale² so one request cannot
+	// allocate an outsized canvas; 8M pixels is 32MB of RGBA
+	imageMaxPixels = 8_000_000
+	// imageFitHeight stands in for a height of 0 (fit the content) when
+	// checking the pixel budget
+	imageFitHeight = 400
+)
+
+// summaryImageOptions controls the size of the rendered summary image.
+// Width and Height are logical pixels; Scale multiplies both for high-DPI
+// output (2 = retina). A Height of 0 sizes the canvas to fit the content.
+// Location is the zone used for rendered timestamps.
+type summaryImageOptions struct {
+	Width    int
+	Height   int
+	Scale    int
+	Location *time.Location
+}
+
+// summaryLine is a single row of text in the summary image
+type summaryLine struct {
+	text string
+	gap  int // extra space above the line
+}
+
+func defaultSummaryImageOptions() summaryImageOptions {
+	return summaryImageOptions{
+		Width:    getEnvInt("SUMMARY_IMAGE_WIDTH", 600),
+		Height:   getEnvInt("SUMMARY_IMAGE_HEIGHT", 400),
+		Scale:    getEnvInt("SUMMARY_IMAGE_SCALE", 1),
+		Location: time.UTC,
+	}
+}
+
+func (o summaryImageOptions) validate() error {
+	if o.Width < imageMinWidth || o.Width > imageMaxWidth {
+		return fmt.Errorf("width must be between %d and %d", imageMinWidth, imageMaxWidth)
+	}
+	if o.Height < 0 || o.Height > imageMaxHeight {
+		return fmt.Errorf("height must be between 0 and %d", imageMaxHeight)
+	}
+	if o.Scale < 1 || o.Scale > imageMaxScale {
+		return fmt.Errorf("scale must be between 1 and %d", imageMaxScale)
+	}
+	if o.Width*max(o.Height, imageFitHeight)*o.Scale*o.Scale > imageMaxPixels {
+		return fmt.Errorf("width * height * scale^2 must be at most %d pixels", imageMaxPixels)
+	}
+	return nil
+}
+
+// loadSummaryImageDefaults checks the SUMMARY_IMAGE_* defaults, which are
+// rendered without the checks query parameters get
+func loadSummaryImageDefaults() error {
+	if err := defaultSummaryImageOptions().validate(); err != nil {
+		return fmt.Errorf("invalid SUMMARY_IMAGE_WIDTH, SUMMARY_IMAGE_HEIGHT or SUMMARY_IMAGE_SCALE: %w", err)
+	}
+	return nil
+}
+
+// parseSummaryImageOptions reads width/height/scale/tz query parameters on
+// top of the defaults. The second return value reports whether any were given.
+func parseSummaryImageOptions(c *fiber.Ctx) (summaryImageOptions, bool, error) {
+	return parseImageOptions(c, defaultSummaryImageOptions())
+}
+
+// parseImageOptions reads width/height/scale/tz query parameters on top of
+// opts, for any rendered image
+func parseImageOptions(c *fiber.Ctx, opts summaryImageOptions) (summaryImageOptions, bool, error) {
+	custom := false
+
+	params := map[string]*int{
+		"width":  &opts.Width,
+		"height": &opts.Height,
+		"scale":  &opts.Scale,
+	}
+	for key, target := range params {
+		raw := c.Query(key)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			return opts, true, fmt.Errorf("%s must be an integer", key)
+		}
+		*target = value
+		custom = true
+	}
+
+	if c.Query("tz") != "" {
+		loc, err := parseTimezone(c)
+		if err != nil {
+			return opts, true, err
+		}
+		opts.Location = loc
+		custom = true
+	}
+
+	return opts, custom, opts.validate()
+}
+
+func getCountriesImage(c *fiber.Ctx) error {
+	opts, custom, err := parseSummaryImageOptions(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	// Custom sizes are rendered on demand; the cached file uses the defaults
+	if custom {
+		img, err := renderSummaryImage(opts)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return err
+		}
+		c.Set(fiber.HeaderContentType, "image/png")
+		return c.Send(buf.Bytes())
+	}
+
+	if _, err := os.Stat(summaryImagePath); os.IsNotExist(err) {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Summary image not found",
+		})
+	}
+
+	return c.SendFile(summaryImagePath)
+}
+
+// generateSummaryImage renders the cached summary image, stamped with the
+// fingerprint of the data it shows so checkSummaryImage can tell when it
+// is stale
+func generateSummaryImage() error {
+	opts := defaultSummaryImageOptions()
+	lines, err := summaryLines(opts.Location)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, renderLinesImage(lines, opts)); err != nil {
+		return err
+	}
+	stamped, err := pngWithText(buf.Bytes(), summaryFingerprintKey, summaryFingerprint(lines, opts))
+	if err != nil {
+		return err
+	}
+
+	// Replace the file atomically so readers never see half an image
+	tmp, err := os.CreateTemp(filepath.Dir(summaryImagePath), "summary-*.png")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(stamped); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), summaryImagePath)
+}
+
+// summaryLines collects the text content of the summary image
+func summaryLines(loc *time.Location) ([]summaryLine, error) {
+	// Get total countries
+	var totalCount int64
+	if err := db.Model(&Country{}).Count(&totalCount).Error; err != nil {
+		return nil, err
+	}
+
+	// Get top 5 by GDP, as GET /countries/top does
+	topCountries, _, err := loadTopCountries("gdp", true, defaultTopLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get last refresh time
+	// Nil while the table is empty
+	var lastRefresh *time.Time
+	if err := db.Model(&Country{}).Select("MAX(last_refreshed_at)").Scan(&lastRefresh).Error; err != nil {
+		return nil, err
+	}
+	if lastRefresh == nil {
+		lastRefresh = &time.Time{}
+	}
+
+	lines := []summaryLine{
+		{text: "Country Currency & Exchange Summary"},
+		{text: fmt.Sprintf("Total Countries: %d", totalCount), gap: 15},
+		{text: "Top 5 Countries by Estimated GDP:", gap: 5},
+	}
+
+	for i, country := range topCountries {
+		gdpStr := "N/A"
+		if country.EstimatedGDP != nil {
+			gdpStr = "$" + formatAmount(*country.EstimatedGDP, "USD")
+		}
+		lines = append(lines, summaryLine{text: fmt.Sprintf("%d. %s - %s", i+1, country.Name, gdpStr)})
+	}
+
+	lines = append(lines, summaryLine{
+		text: fmt.Sprintf("Last Refreshed: %s", lastRefresh.In(loc).Format(time.RFC3339)),
+		gap:  20,
+	})
+
+	return lines, nil
+}
+
+// renderSummaryImage renders the global summary
+func renderSummaryImage(opts summaryImageOptions) (image.Image, error) {
+	lines, err := summaryLines(opts.Location)
+	if err != nil {
+		return nil, err
+	}
+	return renderLinesImage(lines, opts), nil
+}
+
+// renderLinesImage lays lines out on a logical canvas, wrapping lines that
+// don't fit the width, then upscales it by opts.Scale.
+func renderLinesImage(lines []summaryLine, opts summaryImageOptions) image.Image {
+	face := basicfont.Face7x13
+	maxTextWidth := fixed.I(opts.Width - 2*imageMargin)
+
+	// Wrap every line to the available width
+	type placedLine struct {
+		text string
+		y    int
+	}
+	var placed []placedLine
+	y := imageMargin + face.Ascent
+	for i, line := range lines {
+		if i > 0 {
+			y += imageLineHeight + line.gap
+		}
+		for j, part := range wrapText(face, line.text, maxTextWidth) {
+			if j > 0 {
+				y += imageLineHeight
+			}
+			placed = append(placed, placedLine{text: part, y: y})
+		}
+	}
+
+	// Grow the canvas rather than clip when the content is taller
+	height := opts.Height
+	if contentHeight := y + face.Descent + imageMargin; height < contentHeight {
+		height = contentHeight
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, opts.Width, height))
+	theme := currentTheme()
+	draw.Draw(img, img.Bounds(), &image.Uniform{theme.Background}, image.Point{}, draw.Src)
+
+	col := theme.Text
+	for _, line := range placed {
+		addLabel(img, fixed.P(imageMargin, line.y), line.text, col)
+	}
+
+	return upscaleImage(img, opts.Scale)
+}
+
+// upscaleImage multiplies both dimensions by scale. Nearest neighbour keeps
+// the bitmap font crisp.
+func upscaleImage(img *image.RGBA, scale int) image.Image {
+	if scale <= 1 {
+		return img
+	}
+	b := img.Bounds()
+	scaled := image.NewRGBA(image.Rect(0, 0, b.Dx()*scale, b.Dy()*scale))
+	xdraw.NearestNeighbor.Scale(scaled, scaled.Bounds(), img, b, xdraw.Src, nil)
+	return scaled
+}
+
+// wrapText splits text into lines no wider than maxWidth, breaking on
+// spaces where possible and mid-word otherwise. Mid-word breaks fall on
+// rune boundaries, and every line holds at least one rune, however narrow
+// maxWidth is.
+func wrapText(face font.Face, text string, maxWidth fixed.Int26_6) []string {
+	if font.MeasureString(face, text) <= maxWidth {
+		return []string{text}
+	}
+
+	var lines []string
+	current := ""
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if current != "" {
+			candidate = current + " " + word
+		}
+		if font.MeasureString(face, candidate) <= maxWidth {
+			current = candidate
+			continue
+		}
+		if current != "" {
+			lines = append(lines, current)
+		}
+		// Hard-break words that are wider than a whole line
+		runes := []rune(word)
+		for len(runes) > 1 && font.MeasureString(face, string(runes)) > maxWidth {
+			cut := 1
+			for cut < len(runes) && font.MeasureString(face, string(runes[:cut+1])) <= maxWidth {
+				cut++
+			}
+			lines = append(lines, string(runes[:cut]))
+			runes = runes[cut:]
+		}
+		current = string(runes)
+	}
+	if current != "" {
+		lines = append(lines, current)
+	}
+
+	return lines
+}
+
+func addLabel(img *image.RGBA, point fixed.Point26_6, label string, col color.Color) {
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(col),
+		Face: basicfont.Face7x13,
+		Dot:  point,
+	}
+	d.DrawString(label)
+}
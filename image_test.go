@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"unicode/utf8"
+
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+func TestWrapText(t *testing.T) {
+	face := basicfont.Face7x13 // every glyph is 7px wide
+
+	tests := []struct {
+		name     string
+		text     string
+		maxWidth int
+		want     []string
+	}{
+		{"fits", "Total Countries: 250", 200, []string{"Total Countries: 250"}},
+		{"breaks on spaces", "one two three", 60, []string{"one two", "three"}},
+		{"hard-breaks a long word", "abcdefghij", 28, []string{"abcd", "efgh", "ij"}},
+		{"hard-break then words", "abcdefghij kl", 28, []string{"abcd", "efgh", "ij", "kl"}},
+		{"multi-byte runes", "ääääää", 28, []string{"ääää", "ää"}},
+		{"narrower than a rune", "ab cd", 3, []string{"a", "b", "c", "d"}},
+		{"negative width", "héllo", -40, []string{"h", "é", "l", "l", "o"}},
+		{"empty", "", 100, []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := wrapText(face, tt.text, fixed.I(tt.maxWidth))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("wrapText(%q, %d) = %q, want %q", tt.text, tt.maxWidth, got, tt.want)
+			}
+			for _, line := range got {
+				if !utf8.ValidString(line) {
+					t.Errorf("wrapText(%q, %d) split a rune: %q", tt.text, tt.maxWidth, line)
+				}
+			}
+		})
+	}
+}
+
+func TestSummaryImageOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    summaryImageOptions
+		wantErr bool
+	}{
+		{"defaults", summaryImageOptions{Width: 600, Height: 400, Scale: 1}, false},
+		{"fit content", summaryImageOptions{Width: 600, Height: 0, Scale: 4}, false},
+		{"largest single scale", summaryImageOptions{Width: 4000, Height: 2000, Scale: 1}, false},
+		{"too narrow", summaryImageOptions{Width: 100, Height: 400, Scale: 1}, true},
+		{"too tall", summaryImageOptions{Width: 600, Height: 5000, Scale: 1}, true},
+		{"negative height", summaryImageOptions{Width: 600, Height: -1, Scale: 1}, true},
+		{"zero scale", summaryImageOptions{Width: 600, Height: 400, Scale: 0}, true},
+		{"scale too large", summaryImageOptions{Width: 600, Height: 400, Scale: 5}, true},
+		{"over the pixel budget", summaryImageOptions{Width: 4000, Height: 4000, Scale: 1}, true},
+		{"scaled over the pixel budget", summaryImageOptions{Width: 1000, Height: 1000, Scale: 3}, true},
+		{"fit content over the pixel budget", summaryImageOptions{Width: 4000, Height: 0, Scale: 4}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate(%+v) = %v, want error: %v", tt.opts, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadSummaryImageDefaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{"unset", nil, false},
+		{"valid", map[string]string{"SUMMARY_IMAGE_WIDTH": "800", "SUMMARY_IMAGE_SCALE": "2"}, false},
+		{"too narrow", map[string]string{"SUMMARY_IMAGE_WIDTH": "10"}, true},
+		{"over the pixel budget", map[string]string{"SUMMARY_IMAGE_WIDTH": "4000", "SUMMARY_IMAGE_HEIGHT": "4000"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"SUMMARY_IMAGE_WIDTH", "SUMMARY_IMAGE_HEIGHT", "SUMMARY_IMAGE_SCALE"} {
+				t.Setenv(key, tt.env[key])
+			}
+			err := loadSummaryImageDefaults()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("loadSummaryImageDefaults() = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// summaryFingerprintKey is the PNG tEXt keyword that carries the
+// fingerprint of the data a summary image was rendered from
+const summaryFingerprintKey = "summary-fingerprint"
+
+// defaultSummaryImageCheckInterval is how often the cached summary image is
+// compared with the data unless SUMMARY_IMAGE_CHECK_INTERVAL says otherwise
+const defaultSummaryImageCheckInterval = 5 * time.Minute
+
+// Outcomes of a summary image check
+const (
+	summaryImageFresh   = "fresh"
+	summaryImageStale   = "stale"
+	summaryImageMissing = "missing"
+	// summaryImageUnverified is a file without a fingerprint, such as one
+	// written by an older build
+	summaryImageUnverified = "unverified"
+)
+
+// pngSignature starts every PNG file
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// summaryImageCheck compares the cached summary image with the data it
+// should show
+type summaryImageCheck struct {
+	State     string    `json:"state"`
+	CheckedAt time.Time `json:"checked_at"`
+	// ImageFingerprint is read from the file; nil when missing or unverified
+	ImageFingerprint *string `json:"image_fingerprint"`
+	DataFingerprint  string  `json:"data_fingerprint"`
+	// Regenerating reports that a regeneration is queued, by this check or
+	// earlier
+	Regenerating bool `json:"regenerating"`
+}
+
+// summaryFingerprint hashes everything the summary image shows: its text,
+// the theme and the size
+func summaryFingerprint(lines []summaryLine, opts summaryImageOptions) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%d|%d|%d\n", currentSettings().ImageTheme, opts.Width, opts.Height, opts.Scale)
+	for _, line := range lines {
+		fmt.Fprintf(h, "%d|%s\n", line.gap, line.text)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// pngWithText inserts a tEXt chunk right after the IHDR chunk of an
+// encoded PNG
+func pngWithText(data []byte, key, value string) ([]byte, error) {
+	// Signature, then IHDR: length, type, 13 bytes of data and a CRC
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	if len(data) < ihdrEnd || !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("not a PNG")
+	}
+	body := append([]byte(key+"\x00"), value...)
+	chunk := make([]byte, 0, len(body)+12)
+	chunk = binary.BigEndian.AppendUint32(chunk, uint32(len(body)))
+	chunk = append(chunk, "tEXt"...)
+	chunk = append(chunk, body...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	out := make([]byte, 0, len(data)+len(chunk))
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...), nil
+}
+
+// pngText returns the value of the first tEXt chunk with the keyword
+func pngText(data []byte, key string) (string, bool) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return "", false
+	}
+	for pos := len(pngSignature); pos+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			break
+		}
+		switch string(data[pos+4 : pos+8]) {
+		case "tEXt":
+			if k, v, ok := bytes.Cut(data[pos+8:pos+8+length], []byte{0}); ok && string(k) == key {
+				return string(v), true
+			}
+		case "IEND":
+			return "", false
+		}
+		pos = end
+	}
+	return "", false
+}
+
+// checkSummaryImage compares the fingerprint stamped in the cached summary
+// image with the current data and queues a regeneration when they differ.
+// A failed database read is an error rather than a reason to redraw.
+func checkSummaryImage() (summaryImageCheck, error) {
+	check := summaryImageCheck{CheckedAt: clock.Now()}
+	opts := defaultSummaryImageOptions()
+	lines, err := summaryLines(opts.Location)
+	if err != nil {
+		return check, err
+	}
+	check.DataFingerprint = summaryFingerprint(lines, opts)
+
+	data, err := os.ReadFile(summaryImagePath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		check.State = summaryImageMissing
+	case err != nil:
+		return check, err
+	default:
+		if stamped, ok := pngText(data, summaryFingerprintKey); !ok {
+			check.State = summaryImageUnverified
+		} else {
+			check.ImageFingerprint = &stamped
+			check.State = summaryImageFresh
+			if stamped != check.DataFingerprint {
+				check.State = summaryImageStale
+			}
+		}
+	}
+
+	if check.State != summaryImageFresh {
+		check.Regenerating = images.enqueue() || images.snapshot().Pending
+	}
+	images.recordCheck(check)
+	return check, nil
+}
+
+// runSummaryImageCheckEvery checks the summary image at startup and then
+// once per interval, reporting each run in /status
+func runSummaryImageCheckEvery(interval time.Duration) {
+	log.Printf("Scheduled summary image check every %s", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		started := clock.Now()
+		run := scheduledRun{Interval: interval.String(), StartedAt: &started, NextRunAt: started.Add(interval)}
+		check, err := checkSummaryImage()
+		finished := clock.Now()
+		run.FinishedAt = &finished
+		run.Processed, run.Status = 1, scheduledOK
+		if err != nil {
+			log.Printf("Summary image check failed: %v", err)
+			msg := err.Error()
+			run.Status, run.Error = scheduledFailed, &msg
+		} else if check.State != summaryImageFresh {
+			log.Printf("Summary image is %s; regenerating", check.State)
+			run.Updated = 1
+		}
+		setScheduledRun("summary-image-check", run)
+		<-ticker.C
+	}
+}
+
+// getSummaryImageCheck runs a check on demand
+func getSummaryImageCheck(c *fiber.Ctx) error {
+	check, err := checkSummaryImage()
+	if err != nil {
+		return err
+	}
+	return c.JSON(check)
+}
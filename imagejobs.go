@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// imageStatus reports the state of summary image generation
+type imageStatus struct {
+	Pending         bool       `json:"pending"`
+	LastGeneratedAt *time.Time `json:"last_generated_at"`
+	LastError       *string    `json:"last_error"`
+	// LastCheck is the latest comparison of the cached image with the data
+	LastCheck *summaryImageCheck `json:"last_check"`
+}
+
+// imageWorker regenerates the summary image in the background. Triggers
+// coalesce: any number of changes while a run is queued cause one run.
+type imageWorker struct {
+	trigger chan struct{}
+
+	mu     sync.Mutex
+	status imageStatus
+}
+
+var images = &imageWorker{trigger: make(chan struct{}, 1)}
+
+// enqueue schedules a regeneration; it reports false if one was already
+// queued and this request was folded into it
+func (w *imageWorker) enqueue() bool {
+	select {
+	case w.trigger <- struct{}{}:
+		w.mu.Lock()
+		w.status.Pending = true
+		w.mu.Unlock()
+		return true
+	default:
+		return false
+	}
+}
+
+// run processes regeneration requests until the process exits
+func (w *imageWorker) run() {
+	for range w.trigger {
+		w.mu.Lock()
+		w.status.Pending = false
+		w.mu.Unlock()
+
+		_, span := tracer.Start(context.Background(), "image.generate")
+		err := generateSummaryImage()
+		recordSpanError(span, err)
+		span.End()
+
+		w.mu.Lock()
+		if err != nil {
+			msg := err.Error()
+			w.status.LastError = &msg
+			log.Printf("Failed to generate summary image: %v", err)
+			go notifyAlert("Summary image generation failed", err)
+		} else {
+			now := clock.Now()
+			w.status.LastGeneratedAt = &now
+			w.status.LastError = nil
+		}
+		w.mu.Unlock()
+	}
+}
+
+// recordCheck keeps the latest consistency check for /status
+func (w *imageWorker) recordCheck(check summaryImageCheck) {
+	w.mu.Lock()
+	w.status.LastCheck = &check
+	w.mu.Unlock()
+}
+
+func (w *imageWorker) snapshot() imageStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+// notifyDataChanged is called whenever the countries table changes and
+// fans out to everything derived from it. The read model is rebuilt and
+// cached responses purged before returning so reads observe the change; it
+// returns whether the summary image regeneration was enqueued.
+func notifyDataChanged() bool {
+	refreshReadModel()
+	purgeResponseCache()
+	standby.enqueue()
+	return images.enqueue()
+}
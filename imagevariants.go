@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"gorm.io/gorm"
+)
+
+const imageVariantsDir = "cache/images"
+
+// Currency distribution layout, in logical pixels
+const (
+	currencyChartTop   = 12
+	currencyBarHeight  = 16
+	currencyBarGap     = 6
+	currencyLabelWidth = 6
+	// currencyChartTopN bars are drawn before the rest become "Other"
+	currencyChartTopN = 12
+)
+
+// imageVariant describes one generated dashboard image
+type imageVariant struct {
+	Name string `json:"name"`
+	// Kind is summary, region or currencies
+	Kind       string            `json:"kind"`
+	Parameters map[string]string `json:"parameters"`
+	URL        string            `json:"url"`
+	Width      int               `json:"width"`
+	Height     int               `json:"height"`
+	Bytes      int64             `json:"bytes"`
+	// GeneratedAt is null until the variant has rendered once
+	GeneratedAt *time.Time `json:"generated_at"`
+	// Error is the message of the latest failed render; the previous image
+	// keeps being served
+	Error *string `json:"error,omitempty"`
+}
+
+// variantSpec is a variant to render on the next run
+type variantSpec struct {
+	name   string
+	kind   string
+	params map[string]string
+	render func() (image.Image, error)
+}
+
+var (
+	imageVariantsMu sync.Mutex
+	imageVariants   = map[string]imageVariant{}
+)
+
+// imageVariantSpecs lists the variants for the current data: the global
+// summary, one summary per region and the currency distribution. They all
+// use the summary image defaults and the configured theme.
+func imageVariantSpecs(ctx context.Context) ([]variantSpec, error) {
+	opts := defaultSummaryImageOptions()
+	base := map[string]string{
+		"width":  strconv.Itoa(opts.Width),
+		"height": strconv.Itoa(opts.Height),
+		"scale":  strconv.Itoa(opts.Scale),
+		"theme":  currentSettings().ImageTheme,
+	}
+	with := func(extra ...string) map[string]string {
+		params := make(map[string]string, len(base)+len(extra)/2)
+		for k, v := range base {
+			params[k] = v
+		}
+		for i := 0; i+1 < len(extra); i += 2 {
+			params[extra[i]] = extra[i+1]
+		}
+		return params
+	}
+
+	specs := []variantSpec{{
+		name: "global", kind: "summary", params: with(),
+		render: func() (image.Image, error) { return renderSummaryImage(opts) },
+	}}
+
+	var regions []string
+	if err := db.WithContext(ctx).Model(&Country{}).Where("region IS NOT NULL AND region <> ''").
+		Distinct().Order("region").Pluck("region", &regions).Error; err != nil {
+		return nil, err
+	}
+	for _, region := range regions {
+		region := region
+		specs = append(specs, variantSpec{
+			name: "region-" + slugify(region), kind: "region", params: with("region", region),
+			render: func() (image.Image, error) {
+				return renderLinesImage(regionSummaryLines(region, opts.Location), opts), nil
+			},
+		})
+	}
+
+	specs = append(specs, variantSpec{
+		name: "currencies", kind: "currencies", params: with("top", strconv.Itoa(currencyChartTopN)),
+		render: func() (image.Image, error) {
+			counts, err := currencyCounts(ctx, currencyChartTopN)
+			if err != nil {
+				return nil, err
+			}
+			return renderCurrencyDistribution(counts, opts), nil
+		},
+	})
+	return specs, nil
+}
+
+// regionSummaryLines is summaryLines scoped to one region, with its total
+// population
+func regionSummaryLines(region string, loc *time.Location) []summaryLine {
+	scoped := func() *gorm.DB { return db.Model(&Country{}).Where("region = ?", region) }
+
+	var totalCount int64
+	scoped().Count(&totalCount)
+	var population int64
+	scoped().Select("COALESCE(SUM(population), 0)").Scan(&population)
+	var topCountries []Country
+	scoped().Order("estimated_gdp DESC").Limit(5).Find(&topCountries)
+	var lastRefresh time.Time
+	scoped().Select("MAX(last_refreshed_at)").Scan(&lastRefresh)
+
+	lines := []summaryLine{
+		{text: region + " Summary"},
+		{text: fmt.Sprintf("Countries: %d", totalCount), gap: 15},
+		{text: "Population: " + humanizeCount(float64(population))},
+		{text: "Top 5 Countries by Estimated GDP:", gap: 5},
+	}
+	for i, country := range topCountries {
+		gdpStr := "N/A"
+		if country.EstimatedGDP != nil {
+			gdpStr = "$" + formatAmount(*country.EstimatedGDP, "USD")
+		}
+		lines = append(lines, summaryLine{text: fmt.Sprintf("%d. %s - %s", i+1, country.Name, gdpStr)})
+	}
+	return append(lines, summaryLine{
+		text: fmt.Sprintf("Last Refreshed: %s", lastRefresh.In(loc).Format(time.RFC3339)),
+		gap:  20,
+	})
+}
+
+// currencyCount is the number of countries using a currency
+type currencyCount struct {
+	CurrencyCode string
+	Countries    int
+}
+
+// currencyCounts counts countries per currency, largest first. Currencies
+// past top are summed into one "Other" entry.
+func currencyCounts(ctx context.Context, top int) ([]currencyCount, error) {
+	var counts []currencyCount
+	if err := db.WithContext(ctx).Model(&Country{}).
+		Select("currency_code, COUNT(*) AS countries").
+		Where("currency_code IS NOT NULL AND currency_code <> ''").
+		Group("currency_code").Order("countries DESC, currency_code").
+		Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	if top > 0 && len(counts) > top {
+		other := 0
+		for _, rest := range counts[top:] {
+			other += rest.Countries
+		}
+		counts = append(counts[:top], currencyCount{"Other", other})
+	}
+	return counts, nil
+}
+
+// renderCurrencyDistribution draws a horizontal bar per currency
+func renderCurrencyDistribution(counts []currencyCount, opts summaryImageOptions) image.Image {
+	face := basicfont.Face7x13
+	theme := currentTheme()
+	barsTop := imageMargin + face.Height + currencyChartTop
+	height := max(opts.Height, barsTop+len(counts)*(currencyBarHeight+currencyBarGap)+imageMargin)
+	img := image.NewRGBA(image.Rect(0, 0, opts.Width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{theme.Background}, image.Point{}, draw.Src)
+	addLabel(img, fixed.P(imageMargin, imageMargin+face.Ascent), "Currencies by Number of Countries", theme.Text)
+
+	most := 1
+	for _, count := range counts {
+		most = max(most, count.Countries)
+	}
+	barLeft := imageMargin + currencyLabelWidth*face.Advance
+	// Room for the count after the longest bar
+	barSpace := opts.Width - barLeft - imageMargin - (len(strconv.Itoa(most))+1)*face.Advance
+	for i, count := range counts {
+		y := barsTop + i*(currencyBarHeight+currencyBarGap)
+		textY := y + (currencyBarHeight+face.Ascent)/2
+		addLabel(img, fixed.P(imageMargin, textY), count.CurrencyCode, theme.Text)
+
+		width := max(1, barSpace*count.Countries/most)
+		bar := image.Rect(barLeft, y, barLeft+width, y+currencyBarHeight)
+		fill := theme.Accent
+		if count.CurrencyCode == "Other" {
+			fill = theme.Muted
+		}
+		draw.Draw(img, bar, &image.Uniform{fill}, image.Point{}, draw.Src)
+		addLabel(img, fixed.P(bar.Max.X+face.Advance/2, textY), strconv.Itoa(count.Countries), theme.Muted)
+	}
+	return upscaleImage(img, opts.Scale)
+}
+
+// generateImageVariants renders every variant, replacing each file
+// atomically so a dashboard never reads a partial image. Variants of
+// regions that no longer exist are removed. It returns how many variants
+// there are and how many rendered.
+func generateImageVariants(ctx context.Context) (total, rendered int, err error) {
+	ctx, span := tracer.Start(ctx, "image.variants")
+	defer span.End()
+
+	specs, err := imageVariantSpecs(ctx)
+	if err != nil {
+		recordSpanError(span, err)
+		return 0, 0, err
+	}
+	if err := os.MkdirAll(imageVariantsDir, 0o755); err != nil {
+		recordSpanError(span, err)
+		return 0, 0, err
+	}
+
+	var failures []error
+	current := map[string]bool{}
+	for _, spec := range specs {
+		current[spec.name] = true
+		imageVariantsMu.Lock()
+		variant, ok := imageVariants[spec.name]
+		imageVariantsMu.Unlock()
+		if !ok {
+			variant = imageVariant{Name: spec.name, Kind: spec.kind, URL: "/images/" + spec.name + ".png"}
+		}
+		variant.Parameters = spec.params
+
+		if err := writeImageVariant(spec, &variant); err != nil {
+			msg := err.Error()
+			variant.Error = &msg
+			failures = append(failures, fmt.Errorf("%s: %w", spec.name, err))
+		} else {
+			now := clock.Now()
+			variant.GeneratedAt = &now
+			variant.Error = nil
+		}
+
+		imageVariantsMu.Lock()
+		imageVariants[spec.name] = variant
+		imageVariantsMu.Unlock()
+	}
+
+	imageVariantsMu.Lock()
+	for name := range imageVariants {
+		if !current[name] {
+			delete(imageVariants, name)
+			os.Remove(imageVariantPath(name))
+		}
+	}
+	imageVariantsMu.Unlock()
+
+	err = errors.Join(failures...)
+	recordSpanError(span, err)
+	return len(specs), len(specs) - len(failures), err
+}
+
+func imageVariantPath(name string) string {
+	return filepath.Join(imageVariantsDir, name+".png")
+}
+
+// writeImageVariant renders one variant to a temporary file and renames it
+// into place, filling in its size
+func writeImageVariant(spec variantSpec, variant *imageVariant) error {
+	img, err := spec.render()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(imageVariantsDir, spec.name+"-*.png")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := png.Encode(tmp, img); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), imageVariantPath(spec.name)); err != nil {
+		return err
+	}
+	variant.Width, variant.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	variant.Bytes = info.Size()
+	return nil
+}
+
+// runImageVariantsEvery renders the variants at startup and then once per
+// interval, reporting each run in /status like the scheduled refreshes
+func runImageVariantsEvery(interval time.Duration) {
+	log.Printf("Scheduled image variants every %s", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		started := clock.Now()
+		run := scheduledRun{Interval: interval.String(), StartedAt: &started, NextRunAt: started.Add(interval)}
+		total, rendered, err := generateImageVariants(context.Background())
+		finished := clock.Now()
+		run.FinishedAt = &finished
+		run.Processed, run.Updated = total, rendered
+		run.Status = scheduledOK
+		if err != nil {
+			log.Printf("Image variants failed: %v", err)
+			msg := err.Error()
+			run.Status, run.Error = scheduledFailed, &msg
+		}
+		setScheduledRun("image-variants", run)
+		<-ticker.C
+	}
+}
+
+// getImageVariants lists the generated variants, by name
+func getImageVariants(c *fiber.Ctx) error {
+	meta := newListMeta(c)
+	meta.Sort = "name"
+
+	imageVariantsMu.Lock()
+	variants := make([]imageVariant, 0, len(imageVariants))
+	for _, variant := range imageVariants {
+		variants = append(variants, variant)
+	}
+	imageVariantsMu.Unlock()
+	sort.Slice(variants, func(i, j int) bool { return variants[i].Name < variants[j].Name })
+
+	meta.Total = int64(len(variants))
+	return sendList(c, variants, meta)
+}
+
+// getImageVariant serves one generated variant
+func getImageVariant(c *fiber.Ctx) error {
+	imageVariantsMu.Lock()
+	variant, ok := imageVariants[c.Params("name")]
+	imageVariantsMu.Unlock()
+	if !ok || variant.GeneratedAt == nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Image not found",
+		})
+	}
+	c.Set(fiber.HeaderCacheControl, "public, max-age=60")
+	return c.SendFile(imageVariantPath(variant.Name))
+}
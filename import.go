@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// maxImportRows bounds the rows one import may hold
+const maxImportRows = 5000
+
+// Outcomes of importing one row
+const (
+	importCreated   = "created"
+	importUpdated   = "updated"
+	importUnchanged = "unchanged"
+	importInvalid   = "invalid"
+)
+
+// importResult is the outcome of one row. Row counts from 1, the first
+// after the CSV header or the first element of the JSON array.
+type importResult struct {
+	Row    int      `json:"row"`
+	Name   string   `json:"name"`
+	Status string   `json:"status"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// importFile reads the upload of an import: a multipart "file" field or
+// the raw body. The format is ?format=, else the file extension or the
+// Content-Type.
+func importFile(c *fiber.Ctx) (string, []byte, error) {
+	format := strings.ToLower(c.Query("format"))
+	if header, err := c.FormFile("file"); err == nil {
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(filepath.Ext(header.Filename)), ".")
+		}
+		file, err := header.Open()
+		if err != nil {
+			return "", nil, err
+		}
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		return format, data, err
+	}
+
+	if format == "" {
+		switch media := strings.ToLower(strings.TrimSpace(strings.Split(c.Get(fiber.HeaderContentType), ";")[0])); media {
+		case "text/csv":
+			format = "csv"
+		case fiber.MIMEApplicationJSON:
+			format = "json"
+		}
+	}
+	return format, c.Body(), nil
+}
+
+// importFieldSet holds the POST /countries fields, which are the columns an
+// import reads
+func importFieldSet() map[string]bool {
+	known := map[string]bool{}
+	for _, field := range newCountryFields {
+		known[field] = true
+	}
+	return known
+}
+
+// parseImportCSV reads a CSV with a header row into one object per row,
+// keeping the POST /countries columns and reporting the others as ignored.
+// Empty cells are left out, and the apostrophe an export puts before text
+// starting with =, +, - or @ is removed.
+func parseImportCSV(data []byte) ([]map[string]json.RawMessage, []string, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("file is not valid CSV: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, nil, errors.New("file is empty; the first row must name the columns")
+	}
+
+	known := importFieldSet()
+	header := make([]string, len(records[0]))
+	var ignored []string
+	for i, column := range records[0] {
+		header[i] = strings.ToLower(strings.TrimSpace(column))
+		if !known[header[i]] {
+			header[i] = ""
+			ignored = append(ignored, strings.TrimSpace(column))
+		}
+	}
+
+	rows := make([]map[string]json.RawMessage, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := map[string]json.RawMessage{}
+		for i, cell := range record {
+			if header[i] == "" || strings.TrimSpace(cell) == "" {
+				continue
+			}
+			if len(cell) > 1 && cell[0] == '\'' && strings.ContainsRune("=+-@", rune(cell[1])) {
+				cell = cell[1:]
+			}
+			row[header[i]], _ = json.Marshal(cell)
+		}
+		rows = append(rows, row)
+	}
+	return rows, ignored, nil
+}
+
+// parseImportJSON reads a JSON array of objects, keeping the POST
+// /countries fields and reporting the others as ignored. An element that
+// is not an object is a nil row.
+func parseImportJSON(data []byte) ([]map[string]json.RawMessage, []string, error) {
+	var elements []json.RawMessage
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return nil, nil, errors.New("file must be a JSON array of country objects")
+	}
+
+	known := importFieldSet()
+	seen := map[string]bool{}
+	var ignored []string
+	rows := make([]map[string]json.RawMessage, len(elements))
+	for i, element := range elements {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(element, &object); err != nil || object == nil {
+			continue
+		}
+		rows[i] = map[string]json.RawMessage{}
+		for key, value := range object {
+			if known[key] {
+				rows[i][key] = value
+			} else if !seen[key] {
+				seen[key] = true
+				ignored = append(ignored, key)
+			}
+		}
+	}
+	sort.Strings(ignored)
+	return rows, ignored, nil
+}
+
+// importProblem describes an error that rejects one row, or returns "" for
+// one that fails the whole import
+func importProblem(err error) string {
+	switch {
+	case errors.Is(err, ErrDuplicateName):
+		return "a country with this name was created during the import"
+	case errors.Is(err, ErrDuplicateAlpha2):
+		return "alpha2_code is already in use"
+	case errors.Is(err, ErrDuplicateAlpha3):
+		return "alpha3_code is already in use"
+	case errors.Is(err, ErrDuplicateSlug):
+		return "a country with this slug was created during the import"
+	case errors.Is(err, ErrStaleVersion):
+		return "the country was modified during the import; retry"
+	case errors.Is(err, ErrNothingToPin):
+		return err.Error()
+	}
+	return ""
+}
+
+// importPatch is the edit a row makes to an existing country: its editable
+// fields that differ from the stored values, pinned when pin is set. Codes
+// and currency names are not editable, so they are kept.
+func importPatch(country Country, values map[string]string, pin bool) countryPatch {
+	patch := countryPatch{Values: map[string]string{}}
+	for field := range overridableFields {
+		value, ok := values[field]
+		if !ok {
+			continue
+		}
+		current, _ := overrideValue(country, field)
+		if value != current {
+			patch.Values[field] = value
+		}
+		if pin && (value != current || country.FieldSources[field] != sourceOverride) {
+			patch.Pin = append(patch.Pin, field)
+		}
+	}
+	sort.Strings(patch.Pin)
+	return patch
+}
+
+// importCountry creates or updates the country of one validated row. It
+// returns the row's status, or the problem that rejects the row; an error
+// fails the whole import.
+func importCountry(values map[string]string, rates map[string]float64, pin, dryRun bool, actor string) (string, string, error) {
+	existing, err := findCountryByName(values["name"])
+	if errors.Is(err, ErrCountryNotFound) {
+		row, err := newManualCountry(values, rates)
+		if err != nil {
+			return "", "", err
+		}
+		if err := checkColumnSizes(row); err != nil {
+			return "", err.Error(), nil
+		}
+		if dryRun {
+			return importCreated, "", nil
+		}
+		if _, err := createCountry(row, actor); err != nil {
+			return "", importProblem(err), err
+		}
+		return importCreated, "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	patch := importPatch(*existing, values, pin)
+	if len(patch.Values) == 0 && len(patch.Pin) == 0 {
+		return importUnchanged, "", nil
+	}
+	if dryRun {
+		return importUpdated, "", nil
+	}
+	loaded := []Country{*existing}
+	if err := attachCurrencies(db, loaded); err != nil {
+		return "", "", err
+	}
+	changed := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		changed, err = applyCountryPatch(tx, loaded[0], patch, rates, actor, clock.Now())
+		return err
+	})
+	if err != nil {
+		return "", importProblem(err), err
+	}
+	if !changed && len(patch.Pin) == 0 {
+		return importUnchanged, "", nil
+	}
+	return importUpdated, "", nil
+}
+
+// importCountries seeds or corrects countries from an uploaded CSV or JSON
+// file. Each row is a POST /countries body: a new name creates a manual
+// country, and a known one is edited as PATCH /countries/:name would. Rows
+// are validated and applied one by one, so an invalid row is reported
+// without stopping the others.
+func importCountries(c *fiber.Ctx) error {
+	invalid := func(details string) error {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": details,
+		})
+	}
+
+	format, data, err := importFile(c)
+	if err != nil {
+		return err
+	}
+	var rows []map[string]json.RawMessage
+	var ignored []string
+	switch format {
+	case "csv":
+		rows, ignored, err = parseImportCSV(data)
+	case "json":
+		rows, ignored, err = parseImportJSON(data)
+	default:
+		return invalid("upload a .csv or .json file, or set format to csv or json")
+	}
+	if err != nil {
+		return invalid(err.Error())
+	}
+	if len(rows) == 0 {
+		return invalid("file has no rows")
+	}
+	if len(rows) > maxImportRows {
+		return invalid(fmt.Sprintf("file has %d rows; at most %d are accepted", len(rows), maxImportRows))
+	}
+
+	pin, dryRun := c.QueryBool("pin"), c.QueryBool("dry_run")
+	actor := requestActor(c)
+
+	// Validate every row first, so rates are loaded once when needed
+	results := make([]importResult, len(rows))
+	parsed := make([]map[string]string, len(rows))
+	firstRow := map[string]int{}
+	needRates := false
+	for i, row := range rows {
+		results[i] = importResult{Row: i + 1, Status: importInvalid}
+		if row == nil {
+			results[i].Errors = []string{"row must be a JSON object"}
+			continue
+		}
+		body, _ := json.Marshal(row)
+		values, problems := parseNewCountry(body)
+		results[i].Name = values["name"]
+		if first, ok := firstRow[strings.ToLower(values["name"])]; ok && values["name"] != "" {
+			problems = append(problems, fmt.Sprintf("name repeats row %d", first))
+		} else {
+			firstRow[strings.ToLower(values["name"])] = i + 1
+		}
+		if len(problems) > 0 {
+			results[i].Errors = problems
+			continue
+		}
+		parsed[i] = values
+		if _, ok := values["currency_code"]; ok {
+			needRates = true
+		}
+	}
+
+	var rates map[string]float64
+	if needRates {
+		if rates, err = currencyRates(); err != nil {
+			return err
+		}
+	}
+
+	counts := map[string]int{importCreated: 0, importUpdated: 0, importUnchanged: 0, importInvalid: 0}
+	for i, values := range parsed {
+		if values != nil {
+			status, problem, err := importCountry(values, rates, pin, dryRun, actor)
+			switch {
+			case problem != "":
+				results[i].Errors = []string{problem}
+			case err != nil:
+				return err
+			default:
+				results[i].Status = status
+			}
+		}
+		counts[results[i].Status]++
+	}
+
+	if !dryRun && counts[importCreated]+counts[importUpdated] > 0 {
+		notifyDataChanged()
+	}
+
+	if ignored == nil {
+		ignored = []string{}
+	}
+	return c.JSON(fiber.Map{
+		"dry_run":         dryRun,
+		"rows":            len(rows),
+		"created":         counts[importCreated],
+		"updated":         counts[importUpdated],
+		"unchanged":       counts[importUnchanged],
+		"invalid":         counts[importInvalid],
+		"ignored_columns": ignored,
+		"results":         results,
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// rowStrings flattens parsed rows into their JSON-encoded values, with nil
+// for rows that were not objects
+func rowStrings(rows []map[string]json.RawMessage) []map[string]string {
+	out := make([]map[string]string, len(rows))
+	for i, row := range rows {
+		if row == nil {
+			continue
+		}
+		out[i] = map[string]string{}
+		for key, value := range row {
+			out[i][key] = string(value)
+		}
+	}
+	return out
+}
+
+func TestParseImportCSV(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		wantRows    []map[string]string
+		wantIgnored []string
+		wantErr     bool
+	}{
+		{
+			name:     "known columns",
+			data:     "name,capital,population\nAtlantis,Poseidonia,1000\n",
+			wantRows: []map[string]string{{"name": `"Atlantis"`, "capital": `"Poseidonia"`, "population": `"1000"`}},
+		},
+		{
+			name:     "header case, spaces and byte order mark",
+			data:     "\xef\xbb\xbf Name ,REGION\nAtlantis,Ocean\n",
+			wantRows: []map[string]string{{"name": `"Atlantis"`, "region": `"Ocean"`}},
+		},
+		{
+			name:        "unknown columns are ignored",
+			data:        "name,id,estimated_gdp\nAtlantis,7,100\n",
+			wantRows:    []map[string]string{{"name": `"Atlantis"`}},
+			wantIgnored: []string{"id", "estimated_gdp"},
+		},
+		{
+			name:     "empty cells are left out",
+			data:     "name,capital,subregion\nAtlantis,, \n",
+			wantRows: []map[string]string{{"name": `"Atlantis"`}},
+		},
+		{
+			name:     "export formula guard is removed",
+			data:     "name,capital\nAtlantis,'=Poseidonia\nLemuria,'plain\n",
+			wantRows: []map[string]string{{"name": `"Atlantis"`, "capital": `"=Poseidonia"`}, {"name": `"Lemuria"`, "capital": `"'plain"`}},
+		},
+		{
+			name:     "header only",
+			data:     "name,capital\n",
+			wantRows: []map[string]string{},
+		},
+		{name: "empty file", data: "", wantErr: true},
+		{name: "ragged rows", data: "name,capital\nAtlantis\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, ignored, err := parseImportCSV([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseImportCSV = %v, want error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := rowStrings(rows); !reflect.DeepEqual(got, tt.wantRows) {
+				t.Errorf("rows = %v, want %v", got, tt.wantRows)
+			}
+			if !reflect.DeepEqual(ignored, tt.wantIgnored) {
+				t.Errorf("ignored = %q, want %q", ignored, tt.wantIgnored)
+			}
+		})
+	}
+}
+
+func TestParseImportJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		wantRows    []map[string]string
+		wantIgnored []string
+		wantErr     bool
+	}{
+		{
+			name:     "known fields keep their JSON",
+			data:     `[{"name":"Atlantis","population":1000}]`,
+			wantRows: []map[string]string{{"name": `"Atlantis"`, "population": `1000`}},
+		},
+		{
+			name:        "unknown fields are ignored once, sorted",
+			data:        `[{"name":"Atlantis","id":1,"gdp":2},{"name":"Lemuria","id":3}]`,
+			wantRows:    []map[string]string{{"name": `"Atlantis"`}, {"name": `"Lemuria"`}},
+			wantIgnored: []string{"gdp", "id"},
+		},
+		{
+			name:     "non-objects are nil rows",
+			data:     `[{"name":"Atlantis"},"Lemuria",null,7]`,
+			wantRows: []map[string]string{{"name": `"Atlantis"`}, nil, nil, nil},
+		},
+		{name: "empty array", data: `[]`, wantRows: []map[string]string{}},
+		{name: "object", data: `{"name":"Atlantis"}`, wantErr: true},
+		{name: "invalid", data: `[{"name":`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, ignored, err := parseImportJSON([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseImportJSON = %v, want error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := rowStrings(rows); !reflect.DeepEqual(got, tt.wantRows) {
+				t.Errorf("rows = %v, want %v", got, tt.wantRows)
+			}
+			if !reflect.DeepEqual(ignored, tt.wantIgnored) {
+				t.Errorf("ignored = %q, want %q", ignored, tt.wantIgnored)
+			}
+		})
+	}
+}
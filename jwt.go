@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Roles carried in tokens. admin includes everything reader may do.
+const (
+	roleReader = "reader"
+	roleAdmin  = "admin"
+)
+
+// jwtSettings configure bearer tokens. JWT_SECRET enables HS256 tokens,
+// including issuing them at POST /auth/token; JWT_JWKS_URL accepts RS256
+// and ES256 tokens from an identity provider.
+var jwtSettings = struct {
+	Secret      []byte
+	JWKSURL     string
+	Issuer      string
+	Audience    string
+	RolesClaim  string
+	TTL         time.Duration
+	ReadsPublic bool
+}{RolesClaim: "roles", TTL: time.Hour, ReadsPublic: true}
+
+// loadJWTSettings reads the JWT_* settings and AUTH_READS
+func loadJWTSettings() error {
+	jwtSettings.Secret = []byte(os.Getenv("JWT_SECRET"))
+	jwtSettings.JWKSURL = os.Getenv("JWT_JWKS_URL")
+	jwtSettings.Issuer = os.Getenv("JWT_ISSUER")
+	jwtSettings.Audience = os.Getenv("JWT_AUDIENCE")
+	jwtSettings.RolesClaim = getEnv("JWT_ROLES_CLAIM", "roles")
+
+	ttl, err := parseInterval("JWT_TTL")
+	if err != nil {
+		return err
+	}
+	if ttl > 0 {
+		jwtSettings.TTL = ttl
+	}
+
+	switch reads := getEnv("AUTH_READS", "public"); reads {
+	case "public":
+		jwtSettings.ReadsPublic = true
+	case roleReader:
+		jwtSettings.ReadsPublic = false
+	default:
+		return fmt.Errorf("unknown AUTH_READS %q (expected public or reader)", reads)
+	}
+	return nil
+}
+
+// jwtEnabled reports whether any way of verifying tokens is configured
+func jwtEnabled() bool {
+	return len(jwtSettings.Secret) > 0 || jwtSettings.JWKSURL != ""
+}
+
+// jwksCacheTTL is how long fetched signing keys are trusted before the
+// JWKS is downloaded again; an unknown kid refetches at most once a minute
+const jwksCacheTTL = time.Hour
+
+var jwks = struct {
+	sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}{}
+
+// jwksKey returns the public key with the given kid, refreshing the cache
+// when it is stale or the kid is new
+func jwksKey(kid string) (interface{}, error) {
+	jwks.Lock()
+	defer jwks.Unlock()
+
+	age := time.Since(jwks.fetchedAt)
+	if key, ok := jwks.keys[kid]; ok && age < jwksCacheTTL {
+		return key, nil
+	}
+	if jwks.keys == nil || age > time.Minute {
+		keys, err := fetchJWKS()
+		if err != nil {
+			return nil, fmt.Errorf("fetching JWKS: %w", err)
+		}
+		jwks.keys, jwks.fetchedAt = keys, time.Now()
+	}
+	if key, ok := jwks.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// fetchJWKS downloads the identity provider's RSA and P-256 signing keys
+func fetchJWKS() (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	body, err := fetchOnce(ctx, jwtSettings.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, err
+	}
+
+	decode := func(s string) *big.Int {
+		b, _ := base64.RawURLEncoding.DecodeString(s)
+		return new(big.Int).SetBytes(b)
+	}
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		switch {
+		case k.Kty == "RSA" && k.N != "" && k.E != "":
+			keys[k.Kid] = &rsa.PublicKey{N: decode(k.N), E: int(decode(k.E).Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256" && k.X != "" && k.Y != "":
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: decode(k.X), Y: decode(k.Y)}
+		}
+	}
+	return keys, nil
+}
+
+// parseBearerToken verifies a token's signature, expiry and, when
+// configured, issuer and audience, and returns its subject and roles
+func parseBearerToken(raw string) (*principal, error) {
+	var methods []string
+	if len(jwtSettings.Secret) > 0 {
+		methods = append(methods, "HS256")
+	}
+	if jwtSettings.JWKSURL != "" {
+		methods = append(methods, "RS256", "ES256")
+	}
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if jwtSettings.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(jwtSettings.Issuer))
+	}
+	if jwtSettings.Audience != "" {
+		opts = append(opts, jwt.WithAudience(jwtSettings.Audience))
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+			return jwtSettings.Secret, nil
+		}
+		kid, _ := token.Header["kid"].(string)
+		return jwksKey(kid)
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims.GetSubject()
+	p := &principal{Actor: "jwt:" + subject}
+	switch roles := claims[jwtSettings.RolesClaim].(type) {
+	case string:
+		p.Roles = strings.Fields(roles)
+	case []interface{}:
+		for _, role := range roles {
+			if s, ok := role.(string); ok {
+				p.Roles = append(p.Roles, s)
+			}
+		}
+	}
+	return p, nil
+}
+
+// issueToken signs an HS256 token for subject with one role
+func issueToken(subject, role string, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(ttl)
+	claims := jwt.MapClaims{
+		"sub":                  subject,
+		"iat":                  now.Unix(),
+		"exp":                  expires.Unix(),
+		jwtSettings.RolesClaim: []string{role},
+	}
+	if jwtSettings.Issuer != "" {
+		claims["iss"] = jwtSettings.Issuer
+	}
+	if jwtSettings.Audience != "" {
+		claims["aud"] = jwtSettings.Audience
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSettings.Secret)
+	return signed, expires, err
+}
+
+// issueTokenRequest is the body of POST /auth/token
+type issueTokenRequest struct {
+	Subject string `json:"subject"`
+	Role    string `json:"role"`
+	TTL     string `json:"ttl"`
+}
+
+// postAuthToken exchanges an admin credential for a bearer token, so
+// dashboards and scripts can hold a short-lived reader token instead of an
+// API key
+func postAuthToken(c *fiber.Ctx) error {
+	if len(jwtSettings.Secret) == 0 {
+		return c.Status(fiber.StatusNotImplemented).JSON(fiber.Map{
+			"error":   "Token issuance disabled",
+			"details": "set JWT_SECRET to issue tokens; with JWT_JWKS_URL alone, get tokens from your identity provider",
+		})
+	}
+
+	var req issueTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": "body must be a JSON object with subject and role",
+		})
+	}
+	if req.Role != roleReader && req.Role != roleAdmin {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": "role must be reader or admin",
+		})
+	}
+	if strings.TrimSpace(req.Subject) == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": "subject is required",
+		})
+	}
+	ttl := jwtSettings.TTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 || d > 30*24*time.Hour {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "Validation failed",
+				"details": "ttl must be a positive duration of at most 720h",
+			})
+		}
+		ttl = d
+	}
+
+	token, expires, err := issueToken(req.Subject, req.Role, ttl)
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"role":         req.Role,
+		"expires_at":   expires.UTC(),
+		"expires_in":   int(ttl.Seconds()),
+	})
+}
+
+// errNoCredentials means the request carried neither an API key nor a
+// bearer token
+var errNoCredentials = errors.New("no credentials")
@@ -0,0 +1,86 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// regionLabelsJSON maps language -> English region/subregion -> label,
+// derived from the CLDR territory names for the UN M49 groupings
+//
+//go:embed locales/regions.json
+var regionLabelsJSON []byte
+
+var regionLabels map[string]map[string]string
+
+func init() {
+	if err := json.Unmarshal(regionLabelsJSON, &regionLabels); err != nil {
+		log.Fatal("Invalid embedded region labels:", err)
+	}
+}
+
+// supportedLanguages lists the languages with region labels, sorted
+func supportedLanguages() []string {
+	langs := make([]string, 0, len(regionLabels))
+	for lang := range regionLabels {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// parseLanguage picks the label language from ?lang=, falling back to the
+// best supported Accept-Language. It returns "" when no localization is
+// wanted; an explicit unsupported ?lang= is an error.
+func parseLanguage(c *fiber.Ctx) (string, error) {
+	if lang := strings.ToLower(c.Query("lang")); lang != "" {
+		if lang == "en" {
+			return "", nil
+		}
+		if _, ok := regionLabels[lang]; !ok {
+			return "", fmt.Errorf("lang must be en or one of %v", supportedLanguages())
+		}
+		return lang, nil
+	}
+
+	// English comes first so a missing header or "*" keeps the source names
+	lang := c.AcceptsLanguages(append([]string{"en"}, supportedLanguages()...)...)
+	if lang == "en" {
+		return "", nil
+	}
+	return lang, nil
+}
+
+// localizedLabel translates an English region or subregion name, keeping
+// the original when there is no translation
+func localizedLabel(lang string, name *string) *string {
+	if name == nil {
+		return nil
+	}
+	label, ok := regionLabels[lang][*name]
+	if !ok {
+		return name
+	}
+	return &label
+}
+
+// localizeCountry fills the label fields for the given language
+func localizeCountry(lang string, country *Country) {
+	if lang == "" {
+		return
+	}
+	country.RegionLabel = localizedLabel(lang, country.Region)
+	country.SubregionLabel = localizedLabel(lang, country.Subregion)
+}
+
+func localizeCountries(lang string, countries []Country) {
+	for i := range countries {
+		localizeCountry(lang, &countries[i])
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDHeader carries the request ID in both directions
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID limits IDs accepted from callers, so a proxy's ID is kept
+// but arbitrary header content never reaches the logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// logHandler builds the slog handler: JSON lines on stdout, or logfmt-style
+// text with LOG_FORMAT=text. LOG_LEVEL is debug, info (default), warn or
+// error.
+func logHandler() (slog.Handler, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(getEnv("LOG_LEVEL", "info"))); err != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVEL %q", os.Getenv("LOG_LEVEL"))
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch format := getEnv("LOG_FORMAT", "json"); format {
+	case "json":
+		return slog.NewJSONHandler(logWriter(), opts), nil
+	case "text":
+		return slog.NewTextHandler(logWriter(), opts), nil
+	default:
+		return nil, fmt.Errorf("unknown LOG_FORMAT %q (expected json or text)", format)
+	}
+}
+
+// logWriter is where logs go: stdout, or stderr when --json keeps stdout
+// for a command's result
+func logWriter() io.Writer {
+	if jsonOutput {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// initLogging installs the structured logger. The standard log package is
+// routed through the same handler, so existing log.Printf calls become
+// structured records.
+func initLogging() error {
+	handler, err := logHandler()
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(handler))
+	// slog.SetDefault points log at the handler; its own prefix would
+	// duplicate the record's time
+	log.SetFlags(0)
+	return nil
+}
+
+// newRequestID returns 16 random bytes, hex encoded
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", clock.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestIDFor returns the ID of the current request
+func requestIDFor(c *fiber.Ctx) string {
+	id, _ := c.Locals("request_id").(string)
+	return id
+}
+
+// requestLogging assigns every request an ID, keeping a well-formed
+// X-Request-ID from the caller, echoes it in the X-Request-ID response
+// header, adds it to JSON error bodies and logs one record per request.
+func requestLogging() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		id := c.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Locals("request_id", id)
+		c.Set(requestIDHeader, id)
+
+		// Errors are turned into responses here rather than after the
+		// middleware returns, so the record and body carry the final status
+		if err := c.Next(); err != nil {
+			if err := c.App().ErrorHandler(c, err); err != nil {
+				_ = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+		status := c.Response().StatusCode()
+		if status >= 400 {
+			tagErrorBody(c, id)
+		}
+
+		attrs := []slog.Attr{
+			slog.String("request_id", id),
+			slog.String("method", c.Method()),
+			slog.String("path", c.Path()),
+			slog.String("route", c.Route().Path),
+			slog.Int("status", status),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("ip", clientIP(c)),
+		}
+		if actor, ok := c.Locals("actor").(string); ok {
+			attrs = append(attrs, slog.String("actor", actor))
+		}
+		if span := trace.SpanContextFromContext(c.UserContext()); span.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", span.TraceID().String()))
+		}
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		slog.LogAttrs(c.UserContext(), level, "request", attrs...)
+		return nil
+	}
+}
+
+// tagErrorBody adds request_id to a JSON error body
+func tagErrorBody(c *fiber.Ctx, id string) {
+	if !strings.HasPrefix(string(c.Response().Header.ContentType()), fiber.MIMEApplicationJSON) {
+		return
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(c.Response().Body(), &body); err != nil {
+		return
+	}
+	if _, isError := body["error"]; !isError {
+		return
+	}
+	body["request_id"] = id
+	if tagged, err := json.Marshal(body); err == nil {
+		c.Response().SetBodyRaw(tagged)
+	}
+}
@@ -1,50 +1,84 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"image"
-	"image/color"
-	"image/draw"
-	"image/png"
 	"io"
 	"log"
-	"math/rand"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/joho/godotenv"
-	"golang.org/x/image/font"
-	"golang.org/x/image/font/basicfont"
-	"golang.org/x/image/math/fixed"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 // Country model
 type Country struct {
-	ID              uint      `gorm:"primaryKey" json:"id"`
-	Name            string    `gorm:"type:varchar(255);uniqueIndex;not null" json:"name"`
-	Capital         *string   `gorm:"type:varchar(255)" json:"capital"`
-	Region          *string   `gorm:"type:varchar(100)" json:"region"`
-	Population      int64     `gorm:"not null" json:"population"`
-	CurrencyCode    *string   `gorm:"type:varchar(10)" json:"currency_code"`
-	ExchangeRate    *float64  `json:"exchange_rate"`
-	EstimatedGDP    *float64  `json:"estimated_gdp"`
-	FlagURL         *string   `gorm:"type:varchar(500)" json:"flag_url"`
-	LastRefreshedAt time.Time `json:"last_refreshed_at"`
+	ID   uint   `gorm:"primaryKey" json:"id" xml:"id"`
+	Name string `gorm:"type:varchar(512);uniqueIndex;not null" json:"name" xml:"name"`
+	// Slug is the URL-safe form of Name, accepted wherever a name is
+	Slug           *string      `gorm:"type:varchar(512);uniqueIndex" json:"slug" xml:"slug"`
+	Alpha2Code     *string      `gorm:"type:varchar(2);uniqueIndex" json:"alpha2_code" xml:"alpha2_code"`
+	Alpha3Code     *string      `gorm:"type:varchar(3);uniqueIndex" json:"alpha3_code" xml:"alpha3_code"`
+	Capital        *string      `gorm:"type:varchar(255)" json:"capital" xml:"capital"`
+	Region         *string      `gorm:"type:varchar(100)" json:"region" xml:"region"`
+	RegionLabel    *string      `gorm:"-" json:"region_label,omitempty" xml:"region_label,omitempty"`
+	Subregion      *string      `gorm:"type:varchar(100)" json:"subregion" xml:"subregion"`
+	SubregionLabel *string      `gorm:"-" json:"subregion_label,omitempty" xml:"subregion_label,omitempty"`
+	Population     int64        `gorm:"not null" json:"population" xml:"population"`
+	CurrencyCode   *string      `gorm:"type:varchar(10)" json:"currency_code" xml:"currency_code"`
+	CurrencyName   *string      `gorm:"type:varchar(100)" json:"currency_name" xml:"currency_name"`
+	CurrencySymbol *string      `gorm:"type:varchar(20)" json:"currency_symbol" xml:"currency_symbol"`
+	CurrencyPeg    *CurrencyPeg `gorm:"-" json:"currency_peg,omitempty" xml:"currency_peg,omitempty"`
+	// Currencies lists every currency, primary first, from country_currencies
+	Currencies   []CountryCurrency `gorm:"-" json:"currencies" xml:"currencies>currency"`
+	ExchangeRate *float64          `json:"exchange_rate" xml:"exchange_rate"`
+	// RateAsOf is when the provider last updated ExchangeRate
+	RateAsOf     *time.Time `json:"rate_as_of" xml:"rate_as_of"`
+	EstimatedGDP *float64   `json:"estimated_gdp" xml:"estimated_gdp"`
+	// PreviousExchangeRate and PreviousEstimatedGDP are the values the
+	// latest refresh replaced
+	PreviousExchangeRate *float64 `json:"-" xml:"-"`
+	PreviousEstimatedGDP *float64 `json:"-" xml:"-"`
+	// ExchangeRateChangePct and EstimatedGDPChangePct are the changes since
+	// the previous refresh in percent; null until a second refresh
+	ExchangeRateChangePct *float64 `gorm:"-" json:"exchange_rate_change_pct" xml:"exchange_rate_change_pct"`
+	EstimatedGDPChangePct *float64 `gorm:"-" json:"estimated_gdp_change_pct" xml:"estimated_gdp_change_pct"`
+	// GDPMultiplier is the country's gdp_multiplier override, if loaded
+	GDPMultiplier  *gdpMultiplier `gorm:"-" json:"-" xml:"-"`
+	FlagURL        *string        `gorm:"type:varchar(2048)" json:"flag_url" xml:"flag_url"`
+	PopulationTier string         `gorm:"type:varchar(20);index" json:"population_tier" xml:"population_tier"`
+	GDPTier        *string        `gorm:"type:varchar(20);index" json:"gdp_tier" xml:"gdp_tier"`
+	// FieldSources records which provider supplied each merged field
+	FieldSources xmlFields `gorm:"type:text;serializer:json" json:"field_sources,omitempty" xml:"field_sources,omitempty"`
+	// Source is "restcountries" for countries refreshes maintain, or
+	// "manual" for ones created through POST /countries, which they skip
+	Source          string    `gorm:"type:varchar(20);not null;default:restcountries;index" json:"source" xml:"source"`
+	LastRefreshedAt time.Time `json:"last_refreshed_at" xml:"last_refreshed_at"`
+	CreatedAt       time.Time `gorm:"index" json:"created_at" xml:"created_at"`
+	UpdatedAt       time.Time `gorm:"index" json:"updated_at" xml:"updated_at"`
 }
 
 // External API response structures
 type RestCountry struct {
 	Name       string              `json:"name"`
+	Alpha2Code string              `json:"alpha2Code"`
+	Alpha3Code string              `json:"alpha3Code"`
+	Borders    []string            `json:"borders"`
 	Capital    string              `json:"capital"`
 	Region     string              `json:"region"`
+	Subregion  string              `json:"subregion"`
 	Population int64               `json:"population"`
 	Flag       string              `json:"flag"`
 	Currencies []map[string]string `json:"currencies"`
@@ -52,38 +86,295 @@ type RestCountry struct {
 
 type ExchangeRateResponse struct {
 	Rates map[string]float64 `json:"rates"`
+	// When the provider last updated the rates; either may be missing
+	TimeLastUpdateUnix int64  `json:"time_last_update_unix,omitempty"`
+	TimeLastUpdateUTC  string `json:"time_last_update_utc,omitempty"`
 }
 
 var db *gorm.DB
 
+// Upstream data sources, overridable via env or --mock-upstreams
+var (
+	countriesAPIURL     = countriesAPIURLs[countriesAPIv2]
+	exchangeRatesAPIURL = "https://open.er-api.com/v6/latest/USD"
+)
+
 func main() {
+	mockUpstreams := flag.Bool("mock-upstreams", false, "serve restcountries and exchange rate fixtures from an in-process server")
+	replay := flag.String("replay", "", "replay the archived refresh with this ID, print its summary and exit")
+	jsonFlag := flag.Bool("json", false, "print the result of refresh, migrate, doctor or --replay as JSON")
+	flag.Parse()
+	jsonOutput = *jsonFlag
+	args := commandArgs(flag.Args())
+	command := ""
+	if len(args) > 0 {
+		command = args[0]
+	}
+
 	// Load environment variables
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found")
 	}
 
-	// Connect to database
-	initDB()
+	countriesAPIVersion = getEnv("COUNTRIES_API_VERSION", countriesAPIVersion)
+	if url, ok := countriesAPIURLs[countriesAPIVersion]; ok {
+		countriesAPIURL = url
+	}
+	countriesAPIURL = getEnv("COUNTRIES_API_URL", countriesAPIURL)
+	countriesMirrorURL = os.Getenv("COUNTRIES_MIRROR_URL")
+	countriesBundledEnabled = getEnv("COUNTRIES_BUNDLED_FALLBACK", "true") != "false"
+	exchangeRatesAPIURL = getEnv("EXCHANGE_RATES_API_URL", exchangeRatesAPIURL)
+	exchangerateHostURL = getEnv("EXCHANGERATE_HOST_URL", exchangerateHostURL)
+	archiveDir = os.Getenv("ARCHIVE_DIR")
+	worldBankAPIURL = getEnv("WORLD_BANK_API_URL", worldBankAPIURL)
+
+	// "schema" prints the API types and exits; it needs no configuration
+	if command == "schema" {
+		os.Exit(runSchemaCommand(args[1:]))
+	}
+
+	// "doctor" checks the deployment and exits instead of serving
+	if command == "doctor" {
+		if *mockUpstreams {
+			if err := startMockUpstreams(); err != nil {
+				log.Fatal("Failed to start mock upstreams:", err)
+			}
+		}
+		os.Exit(runDoctor())
+	}
+
+	// "migrate" applies or rolls back schema migrations and exits
+	if command == "migrate" {
+		os.Exit(runMigrateCommand(args[1:]))
+	}
+
+	if err := initLogging(); err != nil {
+		log.Fatal("Failed to configure logging:", err)
+	}
+
+	loadPopulationThreshold()
+	if err := loadRetryPolicy(); err != nil {
+		log.Fatal("Failed to load retry policy:", err)
+	}
+	if err := loadBreakerSettings(); err != nil {
+		log.Fatal("Failed to load circuit breaker settings:", err)
+	}
+	if err := loadFieldSources(); err != nil {
+		log.Fatal("Failed to load field sources:", err)
+	}
+	if err := checkCountriesAPIVersion(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadCredentials(); err != nil {
+		log.Fatal("Failed to load credentials:", err)
+	}
+	if err := loadColumnKeys(); err != nil {
+		log.Fatal("Failed to load column encryption keys:", err)
+	}
+	if err := loadRateProvider(); err != nil {
+		log.Fatal("Failed to load rate provider:", err)
+	}
+	if err := loadJWTSettings(); err != nil {
+		log.Fatal("Failed to load JWT settings:", err)
+	}
+	if err := loadSummaryImageDefaults(); err != nil {
+		log.Fatal(err)
+	}
+	flushTraces, err := initTracing()
+	if err != nil {
+		log.Fatal("Failed to initialize tracing:", err)
+	}
+
+	// Pin the clock for deterministic runs (e.g. with --mock-upstreams)
+	if fixed := os.Getenv("FIXED_TIME"); fixed != "" {
+		t, err := time.Parse(time.RFC3339, fixed)
+		if err != nil {
+			log.Fatal("Invalid FIXED_TIME:", err)
+		}
+		clock = fixedClock{t: t.UTC()}
+	}
+
+	if *mockUpstreams || os.Getenv("MOCK_UPSTREAMS") == "true" {
+		if err := startMockUpstreams(); err != nil {
+			log.Fatal("Failed to start mock upstreams:", err)
+		}
+	}
+
+	// Snapshot published after each change, served while MySQL is down
+	if err := loadStandbySettings(); err != nil {
+		log.Fatal("Failed to load standby settings:", err)
+	}
+
+	// A read-only edge serves the snapshot alone and never touches MySQL
+	if os.Getenv("MODE") == modeReadonlyEdge && command == "" {
+		if err := serveEdge(); err != nil {
+			log.Fatal("Failed to serve read-only edge:", err)
+		}
+		return
+	}
+
+	// Connect to database; the server waits for it on the standby snapshot
+	initDB(command == "")
+
+	// "apikey" manages the keys of the mutating endpoints and exits
+	loadAPIKeys()
+	if command == "apikey" {
+		os.Exit(runAPIKeyCommand(args[1:]))
+	}
+
+	// "encrypt-columns" re-encrypts the encrypted columns and exits
+	if command == "encrypt-columns" {
+		os.Exit(runEncryptCommand(args[1:]))
+	}
+
+	// Optional denormalized copy for single-country reads
+	if err := initReadModel(); err != nil {
+		log.Fatal("Failed to initialize read model:", err)
+	}
+
+	// Optional response cache for read endpoints
+	if err := initResponseCache(); err != nil {
+		log.Fatal("Failed to initialize response cache:", err)
+	}
+
+	// Cache for upstream payloads re-served under /proxy
+	if err := initProxy(); err != nil {
+		log.Fatal("Failed to initialize upstream proxy:", err)
+	}
 
 	// Create cache directory
 	os.MkdirAll("cache", os.ModePerm)
 
+	// One-shot replay of an archived refresh, e.g. to debug a transform
+	if *replay != "" {
+		summary, err := replayFullRefresh(*replay, clock.Now())
+		code := reportRefresh("replay", summary, err)
+		flushTraces()
+		os.Exit(code)
+	}
+
+	// "refresh" runs one refresh and exits, for cron jobs and seeding
+	if command == "refresh" {
+		code := runRefreshCommand(args[1:])
+		flushTraces()
+		os.Exit(code)
+	}
+
+	// Notification channels for refresh, anomaly and alert events
+	initNotifiers()
+
+	// Summary image regeneration runs off the request path
+	go images.run()
+	go standby.run()
+
+	// Rotated upstream keys are picked up from their files
+	watchCredentials()
+
+	// How long history tables and archives are kept, enforced by the
+	// retention schedule
+	if err := loadRetentionPolicies(); err != nil {
+		log.Fatal("Failed to load retention policies:", err)
+	}
+
+	// Background refreshes, with separate cadences for facts and rates
+	schedule, err := loadRefreshSchedule()
+	if err != nil {
+		log.Fatal("Failed to load refresh schedule:", err)
+	}
+	schedule.start()
+	go flushUsageEvery(usageFlushInterval)
+
 	// Initialize Fiber app
-	app := fiber.New(fiber.Config{
+	config := fiber.Config{
 		ErrorHandler: customErrorHandler,
-	})
+	}
+	if err := proxyConfig(&config); err != nil {
+		log.Fatal("Failed to load trusted proxies:", err)
+	}
+	app := fiber.New(config)
 
 	// Middleware
-	app.Use(logger.New())
+	app.Use(traceRequests())
+	app.Use(requestLogging())
 	app.Use(cors.New())
 
+	// Optional per-client rate limit headers
+	limiter, err := loadRateLimiter()
+	if err != nil {
+		log.Fatal("Failed to load rate limit:", err)
+	}
+	if limiter != nil {
+		app.Use(limiter.middleware())
+	}
+
+	// Expensive routes run a bounded number of requests at once
+	if err := loadConcurrencyLimits(); err != nil {
+		log.Fatal("Failed to load concurrency limits:", err)
+	}
+
+	// Authenticated requests are counted per caller for /admin/usage.png
+	app.Use(countUsage())
+
+	// GET endpoints require the reader role when AUTH_READS=reader
+	app.Use(readAccess())
+
 	// Routes
-	app.Post("/countries/refresh", refreshCountries)
-	app.Get("/countries", getCountries)
-	app.Get("/countries/image", getCountriesImage)
-	app.Get("/countries/:name", getCountryByName)
-	app.Delete("/countries/:name", deleteCountry)
-	app.Get("/status", getStatus)
+	app.Post("/countries/refresh", requireRole(roleAdmin), limitConcurrency("refresh"), refreshCountries)
+	app.Get("/countries/refresh/jobs/:id", requireRole(roleAdmin), getRefreshJob)
+	app.Get("/countries/refresh/wait", requireRole(roleAdmin), waitRefreshJob)
+	app.Get("/countries/refresh/changes", negotiateFormat(), getRefreshChanges)
+	app.Post("/rates/refresh", requireRole(roleAdmin), limitConcurrency("refresh"), refreshRates)
+	app.Get("/rates/:code/chart.png", limitConcurrency("images"), getRateChart)
+	app.Get("/regions", getRegions)
+	app.Get("/regions/:name/countries", negotiateFormat(), getRegionCountries)
+	app.Get("/currencies", getCurrencies)
+	app.Get("/currencies/:code", getCurrency)
+	app.Get("/currencies/:code/rates", getCurrencyRates)
+	app.Get("/countries", negotiateFormat(), degradeReads("/countries", (*standbyReads).listCountries), cacheFor("/countries"), getCountries)
+	app.Get("/countries/export", limitConcurrency("exports"), exportCountries)
+	app.Get("/countries/image", limitConcurrency("images"), getCountriesImage)
+	app.Get("/countries/image/check", getSummaryImageCheck)
+	app.Get("/images", getImageVariants)
+	app.Get("/images/:name.png", getImageVariant)
+	app.Get("/countries/search", searchCountries)
+	app.Get("/countries/me", getCallerCountry)
+	app.Get("/countries/changes", negotiateFormat(), getCountryChanges)
+	app.Get("/countries/stats", cacheFor("/countries/stats"), getCountryStats)
+	app.Get("/countries/top", negotiateFormat(), cacheFor("/countries/top"), getTopCountries)
+	app.Get("/countries/:name", negotiateFormat(), degradeReads("/countries/:name", (*standbyReads).getCountry), cacheFor("/countries/:name"), getCountryByName)
+	app.Get("/countries/:name/summary", cacheFor("/countries/:name/summary"), getCountrySummary)
+	app.Get("/countries/:name/og.png", limitConcurrency("images"), getCountryOGImage)
+	app.Get("/countries/:name/population-history", getPopulationHistory)
+	app.Get("/countries/:name/related", getRelatedCountries)
+	app.Get("/countries/:name/history", getCountryHistory)
+	app.Post("/countries", requireRole(roleAdmin), postCountry)
+	app.Post("/countries/batch", lookupCountries)
+	app.Post("/countries/import", requireRole(roleAdmin), importCountries)
+	app.Patch("/countries/:name", requireRole(roleAdmin), patchCountry)
+	app.Delete("/countries", requireRole(roleAdmin), deleteCountries)
+	app.Delete("/countries/:name", requireRole(roleAdmin), deleteCountry)
+	app.Get("/status", cacheFor("/status"), getStatus)
+	app.Post("/convert/batch", convertBatch)
+	app.Post("/resolve", resolveCountries)
+	app.Post("/auth/token", requireRole(roleAdmin), postAuthToken)
+	app.Get("/metrics", getMetrics)
+	app.Get("/schema", getAPISchema)
+	// Public so other instances can refresh through it without credentials;
+	// it only fetches the two configured upstreams, through the proxy cache
+	app.Get("/proxy/:upstream", getProxied)
+	app.Get("/admin/settings", requireRole(roleAdmin), getSettings)
+	app.Put("/admin/settings", requireRole(roleAdmin), putSettings)
+	app.Get("/admin/unrated", requireRole(roleAdmin), getUnratedCountries)
+	app.Get("/admin/usage.png", requireRole(roleAdmin), limitConcurrency("images"), getUsageChart)
+	app.Get("/admin/broken-flags", requireRole(roleAdmin), getBrokenFlags)
+	app.Post("/admin/broken-flags/check", requireRole(roleAdmin), postFlagCheck)
+	app.Get("/admin/curation", requireRole(roleAdmin), limitConcurrency("exports"), getCuration)
+	app.Put("/admin/curation", requireRole(roleAdmin), putCuration)
+	app.Post("/admin/bulk-update", requireRole(roleAdmin), postBulkUpdate)
+	app.Post("/admin/population-history/import", requireRole(roleAdmin), importPopulationHistoryHandler)
+	app.Get("/anomalies", getAnomalies)
+	app.Get("/archives", requireRole(roleAdmin), getArchives)
+	app.Get("/archives/:id/:payload", requireRole(roleAdmin), limitConcurrency("exports"), getArchivedPayload)
 
 	// Start server
 	port := os.Getenv("PORT")
@@ -95,39 +386,87 @@ func main() {
 	log.Fatal(app.Listen(":" + port))
 }
 
-func initDB() {
-	var dsn string
+// schemaModels are the tables the service owns. The migrations create
+// them; doctor checks the database against these models.
+var schemaModels = []interface{}{
+	&Country{}, &CountryTombstone{}, &RateHistory{}, &CountryAnomaly{},
+	&StagedCountry{}, &PopulationHistory{}, &CountryBorder{},
+	&Setting{}, &SettingChange{}, &APIKey{},
+	&CountryOverride{}, &CountryAlias{}, &CountryHistory{}, &CountryCurrency{},
+	&FlagCheck{}, &APIUsage{},
+}
 
+// databaseDSN builds the MySQL DSN from DATABASE_URL or the DB_* variables
+func databaseDSN() string {
 	// Check if DATABASE_URL exists (Railway, Heroku, etc.)
 	if databaseURL := os.Getenv("DATABASE_URL"); databaseURL != "" {
 		// Railway and other platforms provide DATABASE_URL
 		// Convert postgres:// to mysql:// if needed, or use as-is for MySQL
-		dsn = convertDatabaseURL(databaseURL)
+		return convertDatabaseURL(databaseURL)
+	}
+
+	// Local development - use individual env variables
+	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=UTC",
+		getEnv("DB_USER", "root"),
+		getEnv("DB_PASSWORD", ""),
+		getEnv("DB_HOST", "localhost"),
+		getEnv("DB_PORT", "3306"),
+		getEnv("DB_NAME", "countries_db"),
+	)
+}
+
+func openDB(dsn string) (*gorm.DB, error) {
+	config := &gorm.Config{
+		NowFunc: func() time.Time { return clock.Now() },
+	}
+	if jsonOutput {
+		// GORM's default logger writes to stdout, which --json keeps for
+		// the command's result
+		config.Logger = logger.New(log.New(os.Stderr, "\r\n", log.LstdFlags), logger.Config{
+			SlowThreshold: 200 * time.Millisecond,
+			LogLevel:      logger.Warn,
+		})
+	}
+	conn, err := gorm.Open(mysql.Open(dsn), config)
+	if err != nil {
+		return nil, err
+	}
+	return conn, registerGormTracing(conn)
+}
+
+// initDB connects to MySQL. A server (serving) with a standby snapshot
+// configured serves it until the database answers instead of exiting.
+func initDB(serving bool) {
+	if os.Getenv("DATABASE_URL") != "" {
 		log.Println("Using DATABASE_URL from environment")
 	} else {
-		// Local development - use individual env variables
-		dsn = fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-			getEnv("DB_USER", "root"),
-			getEnv("DB_PASSWORD", ""),
-			getEnv("DB_HOST", "localhost"),
-			getEnv("DB_PORT", "3306"),
-			getEnv("DB_NAME", "countries_db"),
-		)
 		log.Println("Using individual database env variables")
 	}
+	dsn := databaseDSN()
 
 	var err error
-	db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	db, err = openDB(dsn)
+	if err != nil && serving && standbyURL != "" {
+		db, err = serveStandby(dsn, err)
+	}
 	if err != nil {
 		log.Printf("Failed to connect to database: %v", err)
 		log.Printf("DSN used (password hidden): %s", hideSensitiveInfo(dsn))
 		log.Fatal("Database connection failed")
 	}
 
-	// Auto migrate
-	if err := db.AutoMigrate(&Country{}); err != nil {
+	if err := migrateOnStart(dsn); err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
+	if err := backfillSlugs(); err != nil {
+		log.Printf("Failed to backfill country slugs: %v", err)
+	}
+	if err := loadSettings(); err != nil {
+		log.Fatal("Failed to load settings:", err)
+	}
+	if err := loadCuratedAliases(); err != nil {
+		log.Fatal("Failed to load country aliases:", err)
+	}
 
 	log.Println("Database connected successfully")
 }
@@ -160,128 +499,201 @@ func convertDatabaseURL(databaseURL string) string {
 		dbName := dbParts[1]
 
 		// Construct GORM-compatible DSN
-		return fmt.Sprintf("%s@tcp(%s)/%s?charset=utf8mb4&parseTime=True&loc=Local", userPass, hostPort, dbName)
+		return fmt.Sprintf("%s@tcp(%s)/%s?charset=utf8mb4&parseTime=True&loc=UTC", userPass, hostPort, dbName)
 	}
 
 	return databaseURL
 }
 
+// hideSensitiveInfo masks the password of a DSN for logs
 func hideSensitiveInfo(dsn string) string {
-	// Hide password in logs
-	parts := strings.Split(dsn, "@")
-	if len(parts) > 1 {
-		userPass := strings.Split(parts[0], ":")
-		if len(userPass) > 1 {
-			return userPass[0] + ":****@" + parts[1]
-		}
-	}
-	return dsn
+	return maskDSN(dsn)
 }
 
 func refreshCountries(c *fiber.Ctx) error {
-	// Fetch countries
-	countries, err := fetchCountries()
-	if err != nil {
-		return c.Status(503).JSON(fiber.Map{
-			"error":   "External data source unavailable",
-			"details": fmt.Sprintf("Could not fetch data from restcountries API: %v", err),
-		})
+	// Backfills can record the refresh at an explicit effective time
+	now := clock.Now()
+	if asOf := c.Query("as_of"); asOf != "" {
+		parsed, err := time.Parse(time.RFC3339, asOf)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "Validation failed",
+				"details": "as_of must be an RFC3339 timestamp",
+			})
+		}
+		if parsed.After(now) {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "Validation failed",
+				"details": "as_of cannot be in the future",
+			})
+		}
+		now = parsed.UTC()
 	}
 
-	// Fetch exchange rates
-	rates, err := fetchExchangeRates()
-	if err != nil {
-		return c.Status(503).JSON(fiber.Map{
-			"error":   "External data source unavailable",
-			"details": fmt.Sprintf("Could not fetch data from exchange rates API: %v", err),
-		})
+	// Replays publish an archived payload pair instead of fetching
+	archiveID := c.Query("from_archive")
+	if archiveID != "" {
+		if err := checkArchive(archiveID); err != nil {
+			return err
+		}
 	}
 
-	now := time.Now()
-	rand.Seed(time.Now().UnixNano())
-
-	// Process and save countries
-	for _, country := range countries {
-		var currencyCode *string
-		var exchangeRate *float64
-		var estimatedGDP *float64
-
-		// Handle currency
-		if len(country.Currencies) > 0 && country.Currencies[0]["code"] != "" {
-			code := country.Currencies[0]["code"]
-			currencyCode = &code
-
-			// Get exchange rate
-			if rate, exists := rates[code]; exists {
-				exchangeRate = &rate
-
-				// Calculate estimated GDP
-				randomMultiplier := rand.Float64()*(2000-1000) + 1000
-				gdp := float64(country.Population) * randomMultiplier / rate
-				estimatedGDP = &gdp
-			}
-		} else {
-			// Empty currencies array
-			gdp := 0.0
-			estimatedGDP = &gdp
+	// Replays do not call the upstreams, so only live refreshes cool down
+	if archiveID == "" {
+		if wait := claimManualRefresh("countries"); wait > 0 {
+			return refreshCooldownError(c, wait)
 		}
+	}
 
-		capital := country.Capital
-		region := country.Region
-		flagURL := country.Flag
-
-		dbCountry := Country{
-			Name:            country.Name,
-			Capital:         nilIfEmpty(&capital),
-			Region:          nilIfEmpty(&region),
-			Population:      country.Population,
-			CurrencyCode:    currencyCode,
-			ExchangeRate:    exchangeRate,
-			EstimatedGDP:    estimatedGDP,
-			FlagURL:         nilIfEmpty(&flagURL),
-			LastRefreshedAt: now,
-		}
+	// ?wait=true keeps the original blocking behaviour
+	if !c.QueryBool("wait") {
+		// The job keeps the refresh slot until it finishes, not just until
+		// the 202 is sent
+		job := refreshJobs.create(refreshFull, now, archiveID)
+		go runRefreshJob(job, keepConcurrencySlot(c))
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"message":    "Refresh started",
+			"job_id":     job.ID,
+			"state":      job.State,
+			"status_url": "/countries/refresh/jobs/" + job.ID,
+		})
+	}
 
-		// Upsert (update or insert)
-		var existing Country
-		result := db.Where("LOWER(name) = LOWER(?)", country.Name).First(&existing)
+	var summary *refreshSummary
+	var err error
+	if archiveID != "" {
+		summary, err = replayFullRefresh(archiveID, now)
+	} else {
+		summary, err = runFullRefresh(now)
+	}
+	if err != nil {
+		return refreshError(c, err)
+	}
 
-		if result.Error == gorm.ErrRecordNotFound {
-			// Insert new
-			db.Create(&dbCountry)
-		} else {
-			// Update existing
-			db.Model(&existing).Updates(dbCountry)
-		}
+	return c.JSON(fiber.Map{
+		"message":           "Countries refreshed successfully",
+		"total_processed":   summary.Processed,
+		"last_refreshed_at": now,
+		"archive_id":        nilIfEmpty(&summary.ArchiveID),
+		"countries_source":  summary.CountriesSource,
+		"image_regeneration": fiber.Map{
+			"enqueued": summary.imageEnqueued,
+			"pending":  images.snapshot().Pending,
+		},
+	})
+}
+
+func refreshRates(c *fiber.Ctx) error {
+	now := clock.Now()
+	if wait := claimManualRefresh("rates"); wait > 0 {
+		return refreshCooldownError(c, wait)
 	}
 
-	// Generate summary image
-	if err := generateSummaryImage(); err != nil {
-		log.Printf("Failed to generate summary image: %v", err)
+	summary, err := runRatesRefresh(now)
+	if err != nil {
+		return refreshError(c, err)
 	}
 
 	return c.JSON(fiber.Map{
-		"message":           "Countries refreshed successfully",
-		"total_processed":   len(countries),
+		"message":           "Exchange rates refreshed successfully",
+		"total_processed":   summary.Processed,
+		"total_updated":     summary.Updated,
 		"last_refreshed_at": now,
+		"archive_id":        nilIfEmpty(&summary.ArchiveID),
+		"image_regeneration": fiber.Map{
+			"enqueued": summary.imageEnqueued,
+			"pending":  images.snapshot().Pending,
+		},
 	})
 }
 
-func getCountries(c *fiber.Ctx) error {
-	var countries []Country
-	query := db.Model(&Country{})
+// refreshError maps a failed refresh to its response: 502 when an upstream
+// payload changed shape or the staged snapshot was rejected, 503 when an
+// upstream is unreachable and 500 when writing failed and was rolled back
+func refreshError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errStagingInvalid) {
+		return c.Status(502).JSON(fiber.Map{
+			"error":   "Refresh rejected",
+			"details": err.Error(),
+		})
+	}
+	var schema *schemaError
+	if errors.As(err, &schema) {
+		return c.Status(502).JSON(fiber.Map{
+			"error":   "Upstream schema changed",
+			"details": err.Error(),
+			"sample":  schema.Sample(),
+		})
+	}
+	var upstreams upstreamErrors
+	if errors.As(err, &upstreams) {
+		sources := fiber.Map{}
+		for _, upstream := range upstreams {
+			sources[upstream.source] = upstream.err.Error()
+		}
+		return c.Status(503).JSON(fiber.Map{
+			"error":   "External data source unavailable",
+			"details": upstreams.Error(),
+			"sources": sources,
+		})
+	}
+	var upstream *upstreamError
+	if errors.As(err, &upstream) {
+		return c.Status(503).JSON(fiber.Map{
+			"error":   "External data source unavailable",
+			"details": upstream.Error(),
+		})
+	}
+	if errors.Is(err, ErrArchiveNotFound) {
+		return err
+	}
+
+	// Anything else failed before or inside the publish transaction, which
+	// was rolled back, so the live table still holds the previous snapshot
+	log.Printf("Refresh failed on %s %s: %v", c.Method(), c.Path(), err)
+	return c.Status(500).JSON(fiber.Map{
+		"error":   "Refresh failed",
+		"details": "the refresh was rolled back; no country data was changed",
+	})
+}
 
-	// Filters
+// filterCountries applies the region, currency and tier filters shared by
+// the country list and its exports, recording each in meta. A bad tier is
+// returned as a validation error.
+func filterCountries(c *fiber.Ctx, query *gorm.DB, meta *listMeta) (*gorm.DB, error) {
 	if region := c.Query("region"); region != "" {
 		query = query.Where("region = ?", region)
+		meta.filter("region", region)
 	}
 
+	// Any of a country's currencies matches, not only the primary one
 	if currency := c.Query("currency"); currency != "" {
-		query = query.Where("currency_code = ?", currency)
+		query = query.Where("currency_code = ? OR id IN (?)", currency,
+			db.Model(&CountryCurrency{}).Select("country_id").Where("code = ?", currency))
+		meta.filter("currency", currency)
 	}
 
-	// Sorting
-	sortBy := c.Query("sort")
+	if tier := c.Query("population_tier"); tier != "" {
+		if err := validateTier("population_tier", tier, populationTiers); err != nil {
+			return nil, err
+		}
+		query = query.Where("population_tier = ?", tier)
+		meta.filter("population_tier", tier)
+	}
+
+	if tier := c.Query("gdp_tier"); tier != "" {
+		if err := validateTier("gdp_tier", tier, gdpTiers); err != nil {
+			return nil, err
+		}
+		query = query.Where("gdp_tier = ?", tier)
+		meta.filter("gdp_tier", tier)
+	}
+	return query, nil
+}
+
+// sortCountries orders a countries query by ?sort and records the order in
+// meta. Unknown values sort by name and are reported as ignored.
+func sortCountries(query *gorm.DB, sortBy string, meta *listMeta) *gorm.DB {
 	switch sortBy {
 	case "gdp_desc":
 		query = query.Order("estimated_gdp DESC")
@@ -292,100 +704,267 @@ func getCountries(c *fiber.Ctx) error {
 	case "population_asc":
 		query = query.Order("population ASC")
 	default:
-		query = query.Order("name ASC")
+		meta.Sort = "name_asc"
+		if sortBy != "" {
+			meta.ignore("sort")
+		}
+		return query.Order("name ASC")
 	}
+	meta.Sort = sortBy
+	return query
+}
 
-	if err := query.Find(&countries).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": "Internal server error",
+// listParams are the query parameters GET /countries understands
+var listParams = []string{"region", "currency", "population_tier", "gdp_tier",
+	"since", "nearby", "sort", "limit", "offset", "cursor", "lang", "format"}
+
+func getCountries(c *fiber.Ctx) error {
+	lang, err := parseLanguage(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
 		})
 	}
 
-	return c.JSON(countries)
-}
+	page, err := parsePage(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+	if page.Cursor && (c.Query("sort") != "" || c.QueryBool("nearby")) {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": "cursor pagination always orders by id; sort and nearby are not supported",
+		})
+	}
 
-func getCountryByName(c *fiber.Ctx) error {
-	name := c.Params("name")
-	var country Country
+	meta := newListMeta(c, listParams...)
+	countries := []Country{}
+	query := db.Model(&Country{})
 
-	if err := db.Where("LOWER(name) = LOWER(?)", name).First(&country).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return c.Status(404).JSON(fiber.Map{
-				"error": "Country not found",
+	// ?since= turns the list into a delta of the rows refreshed since then.
+	// The upper bound is captured first so nothing falls between two syncs.
+	var since, until time.Time
+	if raw := c.Query("since"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "Validation failed",
+				"details": "since must be an RFC3339 timestamp",
 			})
 		}
-		return c.Status(500).JSON(fiber.Map{
-			"error": "Internal server error",
+		since, until = parsed.UTC(), clock.Now()
+		query = query.Where("last_refreshed_at > ? AND last_refreshed_at <= ?", since, until)
+		meta.filter("since", since.Format(time.RFC3339))
+	}
+
+	query, err = filterCountries(c, query, &meta)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
 		})
 	}
 
-	return c.JSON(country)
+	if err := query.Count(&meta.Total).Error; err != nil {
+		return err
+	}
+
+	// Put the caller's own region first; lookup failures just skip the bias
+	if c.QueryBool("nearby") {
+		if country, err := callerCountry(c); err == nil && country.Region != nil {
+			query = query.Select("*, region = ? AS in_caller_region", *country.Region).
+				Order("in_caller_region DESC")
+			meta.filter("nearby", *country.Region)
+		} else {
+			meta.ignore("nearby")
+		}
+	}
+
+	// Sorting
+	if page.Cursor {
+		query = query.Order("id ASC")
+		meta.Sort = "id_asc"
+	} else {
+		query = sortCountries(query, c.Query("sort"), &meta)
+	}
+
+	// Paging; cursor mode fetches one extra row to know if there is more
+	switch {
+	case page.Cursor:
+		query = query.Where("id > ?", page.AfterID).Limit(page.Limit + 1)
+	case page.Limit > 0:
+		query = query.Limit(page.Limit).Offset(page.Offset)
+	}
+
+	if err := query.Find(&countries).Error; err != nil {
+		return err
+	}
+
+	meta.Page = pageMetaFor(page, c.Query("cursor"))
+	if page.Cursor && len(countries) > page.Limit {
+		countries = countries[:page.Limit]
+		next := encodeCursor(countries[len(countries)-1].ID)
+		c.Set("X-Next-Cursor", next)
+		meta.Page.NextCursor = &next
+	}
+	if err := attachCurrencies(db, countries); err != nil {
+		return err
+	}
+
+	localizeCountries(lang, countries)
+	if !until.IsZero() {
+		return sendDelta(c, countries, meta, since, until)
+	}
+	return sendCountryList(c, countries, meta)
 }
 
-func deleteCountry(c *fiber.Ctx) error {
+func getCountryByName(c *fiber.Ctx) error {
 	name := c.Params("name")
-	var country Country
 
-	result := db.Where("LOWER(name) = LOWER(?)", name).First(&country)
-	if result.Error == gorm.ErrRecordNotFound {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "Country not found",
+	lang, err := parseLanguage(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
 		})
 	}
 
-	if err := db.Delete(&country).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": "Internal server error",
-		})
+	// The read model is keyed by lower-cased name only, so a miss falls
+	// back to MySQL, which also matches ISO codes, slugs and, through the
+	// accent-insensitive collation, names typed without their accents. Its
+	// blobs hold the source region names.
+	if countryReads != nil && lang == "" {
+		blob, ok, err := countryReads.get(name)
+		if err != nil {
+			log.Printf("Read model lookup failed, falling back to database: %v", err)
+		} else if ok {
+			return sendCountryBlob(c, blob)
+		}
+	}
+
+	country, err := findCountry(name)
+	if err != nil {
+		return err
+	}
+	countries := []Country{*country}
+	if err := attachCurrencies(db, countries); err != nil {
+		return err
+	}
+	country = &countries[0]
+
+	localizeCountry(lang, country)
+	return sendCountry(c, country)
+}
+
+func deleteCountry(c *fiber.Ctx) error {
+	if _, err := deleteCountryByName(c.Params("name"), requestActor(c)); err != nil {
+		return err
 	}
 
+	notifyDataChanged()
+
 	return c.JSON(fiber.Map{
 		"message": "Country deleted successfully",
 	})
 }
 
 func getStatus(c *fiber.Ctx) error {
+	loc, err := parseTimezone(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
 	var count int64
 	db.Model(&Country{}).Count(&count)
 
 	var lastRefresh time.Time
 	db.Model(&Country{}).Select("MAX(last_refreshed_at)").Scan(&lastRefresh)
 
+	ratesAsOf, _ := latestRateAsOf(db)
+	if ratesAsOf != nil {
+		local := ratesAsOf.In(loc)
+		ratesAsOf = &local
+	}
+
+	upstreams, degraded := breakerSnapshot()
+	countriesSource := countriesSourceSnapshot()
+	// Serving the bundled snapshot means country facts may be stale
+	degraded = degraded || (countriesSource != nil && *countriesSource == countriesFromBundled)
+
 	return c.JSON(fiber.Map{
+		"degraded":          degraded,
+		"upstreams":         upstreams,
+		"countries_source":  countriesSource,
 		"total_countries":   count,
-		"last_refreshed_at": lastRefresh,
+		"last_refreshed_at": lastRefresh.In(loc),
+		"rates_as_of":       ratesAsOf,
+		"summary_image":     images.snapshot(),
+		"scheduled_refresh": scheduledRunsSnapshot(),
+		"config":            configSnapshot(statusConfigKeys, redactionFor(c)),
+		"credentials":       credentialsSnapshot(),
+		"concurrency":       concurrencySnapshot(),
+		"standby_snapshot":  standby.snapshot(),
 	})
 }
 
-func getCountriesImage(c *fiber.Ctx) error {
-	imagePath := "cache/summary.png"
-	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "Summary image not found",
+// Helper functions
+// fetchPayload downloads a raw upstream response body, retrying transient
+// failures per upstreamRetry and failing fast while the upstream's circuit
+// breaker is open
+func fetchPayload(ctx context.Context, url string) ([]byte, error) {
+	return upstreamRetry.do(ctx, url, func() ([]byte, error) {
+		return withBreaker(url, func() ([]byte, error) {
+			return fetchOnce(ctx, url)
 		})
-	}
-
-	return c.SendFile(imagePath)
+	})
 }
 
-// Helper functions
-func fetchCountries() ([]RestCountry, error) {
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Get("https://restcountries.com/v2/all?fields=name,capital,region,population,flag,currencies")
+func fetchOnce(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := upstreamClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+		return nil, &statusError{code: resp.StatusCode}
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
+	return io.ReadAll(resp.Body)
+}
+
+func parseCountries(body []byte) ([]RestCountry, error) {
+	if err := validateCountriesPayload(body); err != nil {
 		return nil, err
 	}
 
+	var records []json.RawMessage
+	if err := json.Unmarshal(body, &records); err != nil {
+		return nil, err
+	}
+	if isCountriesV3(records) {
+		var v3 []restCountryV3
+		if err := json.Unmarshal(body, &v3); err != nil {
+			return nil, err
+		}
+		countries := make([]RestCountry, len(v3))
+		for i, record := range v3 {
+			countries[i] = record.toRestCountry()
+		}
+		return countries, nil
+	}
+
 	var countries []RestCountry
 	if err := json.Unmarshal(body, &countries); err != nil {
 		return nil, err
@@ -394,20 +973,8 @@ func fetchCountries() ([]RestCountry, error) {
 	return countries, nil
 }
 
-func fetchExchangeRates() (map[string]float64, error) {
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Get("https://open.er-api.com/v6/latest/USD")
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
+func parseExchangeRates(body []byte) (map[string]float64, error) {
+	if err := validateRatesPayload(body); err != nil {
 		return nil, err
 	}
 
@@ -419,73 +986,6 @@ func fetchExchangeRates() (map[string]float64, error) {
 	return ratesResp.Rates, nil
 }
 
-func generateSummaryImage() error {
-	// Get total countries
-	var totalCount int64
-	db.Model(&Country{}).Count(&totalCount)
-
-	// Get top 5 by GDP
-	var topCountries []Country
-	db.Order("estimated_gdp DESC").Limit(5).Find(&topCountries)
-
-	// Get last refresh time
-	var lastRefresh time.Time
-	db.Model(&Country{}).Select("MAX(last_refreshed_at)").Scan(&lastRefresh)
-
-	// Create image
-	img := image.NewRGBA(image.Rect(0, 0, 600, 400))
-	bgColor := color.RGBA{240, 240, 250, 255}
-	draw.Draw(img, img.Bounds(), &image.Uniform{bgColor}, image.Point{}, draw.Src)
-
-	// Draw text
-	col := color.RGBA{0, 0, 0, 255}
-	point := fixed.Point26_6{X: fixed.I(20), Y: fixed.I(30)}
-
-	// Title
-	addLabel(img, point, "Country Currency & Exchange Summary", col)
-	point.Y += fixed.I(40)
-
-	// Total countries
-	addLabel(img, point, fmt.Sprintf("Total Countries: %d", totalCount), col)
-	point.Y += fixed.I(30)
-
-	// Top 5
-	addLabel(img, point, "Top 5 Countries by Estimated GDP:", col)
-	point.Y += fixed.I(25)
-
-	for i, country := range topCountries {
-		gdpStr := "N/A"
-		if country.EstimatedGDP != nil {
-			gdpStr = fmt.Sprintf("$%.2f", *country.EstimatedGDP)
-		}
-		text := fmt.Sprintf("%d. %s - %s", i+1, country.Name, gdpStr)
-		addLabel(img, point, text, col)
-		point.Y += fixed.I(25)
-	}
-
-	point.Y += fixed.I(20)
-	addLabel(img, point, fmt.Sprintf("Last Refreshed: %s", lastRefresh.Format(time.RFC3339)), col)
-
-	// Save image
-	file, err := os.Create("cache/summary.png")
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	return png.Encode(file, img)
-}
-
-func addLabel(img *image.RGBA, point fixed.Point26_6, label string, col color.Color) {
-	d := &font.Drawer{
-		Dst:  img,
-		Src:  image.NewUniform(col),
-		Face: basicfont.Face7x13,
-		Dot:  point,
-	}
-	d.DrawString(label)
-}
-
 func nilIfEmpty(s *string) *string {
 	if s == nil || *s == "" {
 		return nil
@@ -500,16 +1000,54 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
 func customErrorHandler(c *fiber.Ctx, err error) error {
 	code := fiber.StatusInternalServerError
 	message := "Internal server error"
 
-	if e, ok := err.(*fiber.Error); ok {
-		code = e.Code
-		message = e.Message
+	var fiberErr *fiber.Error
+	switch {
+	case errors.Is(err, ErrCountryNotFound):
+		code = fiber.StatusNotFound
+		message = "Country not found"
+	case errors.Is(err, ErrDuplicateName):
+		code = fiber.StatusConflict
+		message = "Country already exists"
+	case errors.Is(err, ErrDuplicateAlpha2):
+		code = fiber.StatusConflict
+		message = "Alpha-2 code already in use"
+	case errors.Is(err, ErrDuplicateAlpha3):
+		code = fiber.StatusConflict
+		message = "Alpha-3 code already in use"
+	case errors.Is(err, ErrDuplicateSlug):
+		code = fiber.StatusConflict
+		message = "Slug already in use"
+	case errors.Is(err, ErrStaleVersion):
+		code = fiber.StatusConflict
+		message = "Country was modified concurrently"
+	case errors.Is(err, ErrJobNotFound):
+		code = fiber.StatusNotFound
+		message = "Job not found"
+	case errors.Is(err, ErrArchiveNotFound):
+		code = fiber.StatusNotFound
+		message = "Archive not found"
+	case errors.As(err, &fiberErr):
+		code = fiberErr.Code
+		message = fiberErr.Message
+	default:
+		slog.Error("Unhandled error", "request_id", requestIDFor(c), "method", c.Method(), "path", c.Path(), "error", err)
 	}
 
-	return c.Status(code).JSON(fiber.Map{
-		"error": message,
+	return c.Status(code).JSON(apiError{
+		Error:     message,
+		RequestID: requestIDFor(c),
 	})
 }
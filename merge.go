@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+)
+
+// Data providers a country field can come from
+const (
+	sourceRestCountries = "restcountries"
+	sourceWorldBank     = "worldbank"
+	sourceExchangeRates = "er-api"
+	// sourceEstimate is the population-times-multiplier GDP estimate
+	sourceEstimate = "estimate"
+	// sourceOverride is an operator-curated value from country_overrides
+	sourceOverride = "override"
+	// sourceManual is an unpinned PATCH /countries/:name edit, replaced by
+	// the next full refresh, or a value given to POST /countries. As a
+	// country's source it marks one created through POST /countries.
+	sourceManual = "manual"
+)
+
+// mergedFieldProviders lists the providers each merged field accepts. Fields
+// not listed always come from restcountries, and exchange_rate from er-api.
+var mergedFieldProviders = map[string][]string{
+	"population":    {sourceRestCountries, sourceWorldBank},
+	"estimated_gdp": {sourceEstimate, sourceWorldBank},
+}
+
+// fieldSources is the precedence for each merged field: the first provider
+// with a value wins. Override with FIELD_SOURCES.
+var fieldSources = map[string][]string{
+	"population":    {sourceRestCountries},
+	"estimated_gdp": {sourceEstimate},
+}
+
+// worldBankLatest holds the most recent World Bank value per ISO 3166-1
+// alpha-2 code (upper case)
+type worldBankLatest struct {
+	Population map[string]int64
+	GDP        map[string]float64
+}
+
+// loadFieldSources reads FIELD_SOURCES, e.g.
+// "population=worldbank,restcountries;estimated_gdp=worldbank,estimate".
+// Fields left out keep their default precedence.
+func loadFieldSources() error {
+	raw := os.Getenv("FIELD_SOURCES")
+	if raw == "" {
+		return nil
+	}
+
+	rules := map[string][]string{}
+	for field, providers := range fieldSources {
+		rules[field] = providers
+	}
+	for _, rule := range strings.Split(raw, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		field, list, ok := strings.Cut(rule, "=")
+		field = strings.TrimSpace(field)
+		accepted, known := mergedFieldProviders[field]
+		if !ok || !known {
+			return fmt.Errorf("invalid FIELD_SOURCES rule %q: field must be one of population, estimated_gdp", rule)
+		}
+
+		var providers []string
+		for _, provider := range strings.Split(list, ",") {
+			provider = strings.TrimSpace(provider)
+			valid := false
+			for _, candidate := range accepted {
+				valid = valid || provider == candidate
+			}
+			if !valid {
+				return fmt.Errorf("invalid FIELD_SOURCES rule %q: %s accepts %s", rule, field, strings.Join(accepted, ", "))
+			}
+			providers = append(providers, provider)
+		}
+		rules[field] = providers
+	}
+	fieldSources = rules
+	return nil
+}
+
+// usesWorldBank reports whether any precedence rule names the World Bank,
+// so refreshes only call it when needed
+func usesWorldBank() bool {
+	for _, providers := range fieldSources {
+		for _, provider := range providers {
+			if provider == sourceWorldBank {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fetchWorldBankLatest downloads the latest population and GDP (current
+// US$) of every country
+func fetchWorldBankLatest() (*worldBankLatest, error) {
+	latest := &worldBankLatest{Population: map[string]int64{}, GDP: map[string]float64{}}
+
+	population, err := fetchWorldBankIndicator("SP.POP.TOTL", "&mrnev=1")
+	if err != nil {
+		return nil, err
+	}
+	for _, obs := range population {
+		if obs.Value != nil && *obs.Value > 0 {
+			latest.Population[obs.Country.ID] = int64(*obs.Value)
+		}
+	}
+
+	gdp, err := fetchWorldBankIndicator("NY.GDP.MKTP.CD", "&mrnev=1")
+	if err != nil {
+		return nil, err
+	}
+	for _, obs := range gdp {
+		if obs.Value != nil && *obs.Value > 0 {
+			latest.GDP[obs.Country.ID] = *obs.Value
+		}
+	}
+	return latest, nil
+}
+
+// fetchWorldBankForRefresh fetches World Bank values when a rule needs them.
+// The World Bank only enriches, so a failure is logged and the rules fall
+// through to the next provider.
+func fetchWorldBankForRefresh() *worldBankLatest {
+	if !usesWorldBank() {
+		return nil
+	}
+	latest, err := fetchWorldBankLatest()
+	if err != nil {
+		log.Printf("World Bank unavailable, falling back to other sources: %v", err)
+		return nil
+	}
+	return latest
+}
+
+// mergeCountry applies the precedence rules to a built row. row holds the
+// restcountries and er-api values on entry; the winning provider of each
+// merged field is recorded in row.FieldSources.
+func mergeCountry(row *Country, country RestCountry, rates map[string]float64, wb *worldBankLatest, rng *rand.Rand) {
+	// World Bank values are keyed by alpha-2 code; the flag URL only
+	// stands in when restcountries lists none
+	iso2 := strings.ToUpper(country.Alpha2Code)
+	if iso2 == "" {
+		iso2 = strings.ToUpper(flagCode(row.FlagURL))
+	}
+	sources := map[string]string{}
+
+	for _, provider := range fieldSources["population"] {
+		if provider == sourceRestCountries && country.Population > 0 {
+			row.Population = country.Population
+		} else if provider == sourceWorldBank && wb != nil && wb.Population[iso2] > 0 {
+			row.Population = wb.Population[iso2]
+		} else {
+			continue
+		}
+		sources["population"] = provider
+		break
+	}
+	row.PopulationTier = populationTierFor(row.Population)
+
+	if row.ExchangeRate != nil {
+		sources["exchange_rate"] = sourceExchangeRates
+	}
+
+	for _, provider := range fieldSources["estimated_gdp"] {
+		var gdp float64
+		switch {
+		case provider == sourceWorldBank && wb != nil && wb.GDP[iso2] > 0:
+			gdp = wb.GDP[iso2]
+		case provider == sourceEstimate && row.CurrencyCode == nil:
+			// No currency means no rate to estimate with
+			gdp = 0
+		case provider == sourceEstimate && row.ExchangeRate != nil:
+			gdp = estimateGDP(row.Population, rates[*row.CurrencyCode], row.GDPMultiplier, rng)
+		default:
+			continue
+		}
+		row.EstimatedGDP = &gdp
+		sources["estimated_gdp"] = provider
+		break
+	}
+	if _, ok := sources["estimated_gdp"]; !ok {
+		row.EstimatedGDP = nil
+	}
+	row.GDPTier = gdpTierFor(row.EstimatedGDP, row.Population)
+
+	row.FieldSources = sources
+}
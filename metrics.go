@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// openMetricsContentType is the exposition format served by /metrics
+const openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// lastRefresh holds the most recent refresh summary for per-refresh metrics
+var (
+	lastRefreshMu sync.Mutex
+	lastRefresh   *refreshSummary
+)
+
+// recordRefresh remembers a finished refresh for /metrics
+func recordRefresh(summary *refreshSummary) {
+	lastRefreshMu.Lock()
+	lastRefresh = summary
+	lastRefreshMu.Unlock()
+}
+
+// regionCount is one row of the countries-per-region aggregate
+type regionCount struct {
+	Region *string
+	Count  int64
+}
+
+// getMetrics exports business-level gauges about the stored data in
+// OpenMetrics text format
+func getMetrics(c *fiber.Ctx) error {
+	var regions []regionCount
+	if err := db.Model(&Country{}).Select("region, COUNT(*) AS count").
+		Group("region").Order("region").Scan(&regions).Error; err != nil {
+		return err
+	}
+
+	var currencies int64
+	if err := db.Model(&Country{}).Where("currency_code IS NOT NULL").
+		Distinct("currency_code").Count(&currencies).Error; err != nil {
+		return err
+	}
+
+	var gdpSum float64
+	if err := db.Model(&Country{}).Select("COALESCE(SUM(estimated_gdp), 0)").Scan(&gdpSum).Error; err != nil {
+		return err
+	}
+
+	var b strings.Builder
+
+	b.WriteString("# TYPE countries gauge\n# HELP countries Stored countries by region.\n")
+	for _, r := range regions {
+		region := "unknown"
+		if r.Region != nil {
+			region = *r.Region
+		}
+		fmt.Fprintf(&b, "countries{region=\"%s\"} %d\n", escapeLabel(region), r.Count)
+	}
+
+	b.WriteString("# TYPE currencies_tracked gauge\n# HELP currencies_tracked Distinct currency codes across stored countries.\n")
+	fmt.Fprintf(&b, "currencies_tracked %d\n", currencies)
+
+	b.WriteString("# TYPE estimated_gdp_usd gauge\n# HELP estimated_gdp_usd Sum of estimated GDP across stored countries.\n")
+	fmt.Fprintf(&b, "estimated_gdp_usd %g\n", gdpSum)
+
+	lastRefreshMu.Lock()
+	summary := lastRefresh
+	lastRefreshMu.Unlock()
+	if summary != nil {
+		b.WriteString("# TYPE refresh_anomalies gauge\n# HELP refresh_anomalies Exchange rate and population anomalies detected by the last refresh.\n")
+		fmt.Fprintf(&b, "refresh_anomalies %d\n", len(summary.Anomalies))
+		b.WriteString("# TYPE refresh_errors gauge\n# HELP refresh_errors Countries that failed to save in the last refresh.\n")
+		fmt.Fprintf(&b, "refresh_errors %d\n", len(summary.Errors))
+		b.WriteString("# TYPE refresh_last_finished_seconds gauge\n# HELP refresh_last_finished_seconds Unix time the last refresh finished.\n")
+		fmt.Fprintf(&b, "refresh_last_finished_seconds %d\n", summary.FinishedAt.Unix())
+	}
+
+	writeProxyMetrics(&b)
+	writeCacheMetrics(&b)
+	writeDegradedMetrics(&b)
+	writeRetentionMetrics(&b)
+
+	b.WriteString("# EOF\n")
+
+	c.Set(fiber.HeaderContentType, openMetricsContentType)
+	return c.SendString(b.String())
+}
+
+// escapeLabel escapes a label value per the OpenMetrics text format
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
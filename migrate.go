@@ -0,0 +1,222 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	sqlmysql "github.com/go-sql-driver/mysql"
+	"github.com/golang-migrate/migrate/v4"
+	migratemysql "github.com/golang-migrate/migrate/v4/database/mysql"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// migrationFiles are the versioned schema migrations, applied in order and
+// recorded in the schema_migrations table. Add a change as the next
+// NNNNNN_name.up.sql, with a matching .down.sql when it can be undone.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLogger prints golang-migrate's progress through the log package
+type migrationLogger struct{}
+
+func (migrationLogger) Printf(format string, v ...interface{}) {
+	log.Printf("migrate: "+strings.TrimSuffix(format, "\n"), v...)
+}
+
+func (migrationLogger) Verbose() bool { return false }
+
+// newMigrator opens the migrations against the database. It uses its own
+// connection because migration files hold several statements, which needs
+// multiStatements; the service's connection keeps it off. The caller
+// closes the migrator.
+func newMigrator(dsn string) (*migrate.Migrate, error) {
+	cfg, err := sqlmysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("parsing DSN: %w", err)
+	}
+	cfg.MultiStatements = true
+	conn, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		return nil, err
+	}
+	driver, err := migratemysql.WithInstance(conn, &migratemysql.Config{})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	source, err := iofs.New(migrationFiles, "migrations")
+	if err != nil {
+		driver.Close()
+		return nil, err
+	}
+	m, err := migrate.NewWithInstance("iofs", source, cfg.DBName, driver)
+	if err != nil {
+		driver.Close()
+		return nil, err
+	}
+	m.Log = migrationLogger{}
+	return m, nil
+}
+
+// latestMigration is the highest version among the embedded migrations
+func latestMigration() uint {
+	var latest uint
+	entries, _ := fs.ReadDir(migrationFiles, "migrations")
+	for _, entry := range entries {
+		prefix, _, _ := strings.Cut(entry.Name(), "_")
+		if v, err := strconv.ParseUint(prefix, 10, 64); err == nil && uint(v) > latest {
+			latest = uint(v)
+		}
+	}
+	return latest
+}
+
+// migrationVersion reports the applied version; 0 means none
+func migrationVersion(m *migrate.Migrate) (uint, bool, error) {
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// migrateOnStart brings the schema up to date before serving, unless
+// MIGRATE_ON_START=false. Then migrations are left to `./app migrate up`,
+// e.g. in a release step, and the server refuses to start on a schema
+// older than this build or one left dirty by a failed migration. A newer
+// schema is allowed, so the previous build keeps serving during a rolling
+// deploy.
+func migrateOnStart(dsn string) error {
+	m, err := newMigrator(dsn)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if getEnv("MIGRATE_ON_START", "true") != "false" {
+		if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return err
+		}
+		return nil
+	}
+
+	version, dirty, err := migrationVersion(m)
+	if err != nil {
+		return err
+	}
+	latest := latestMigration()
+	switch {
+	case dirty:
+		return fmt.Errorf("migration %d failed part way; fix the schema, then run ./app migrate force <version>", version)
+	case version < latest:
+		return fmt.Errorf("schema is at migration %d, this build needs %d; run ./app migrate up", version, latest)
+	case version > latest:
+		log.Printf("Schema is at migration %d, newer than this build's %d", version, latest)
+	}
+	return nil
+}
+
+// runMigrateCommand handles `./app migrate up|down [n]|goto <version>|
+// force <version>|status` and returns the process exit code
+func runMigrateCommand(args []string) int {
+	usage := func() int {
+		return usageError("migrate", "usage: migrate up | down [n] | goto <version> | force <version> | status")
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+	switch args[0] {
+	case "up", "down", "goto", "force", "status":
+	default:
+		return usage()
+	}
+	fail := func(context string, err error) int {
+		if jsonOutput {
+			return printResult("migrate", exitFailed, nil, fmt.Errorf("%s: %w", context, err))
+		}
+		fmt.Fprintf(os.Stderr, "%s: %v\n", context, err)
+		return exitFailed
+	}
+
+	m, err := newMigrator(databaseDSN())
+	if err != nil {
+		return fail("Failed to open migrations", err)
+	}
+	defer m.Close()
+
+	number := func() (int, bool) {
+		if len(args) != 2 {
+			return 0, false
+		}
+		n, err := strconv.Atoi(args[1])
+		return n, err == nil && n >= 0
+	}
+
+	switch args[0] {
+	case "up":
+		err = m.Up()
+	case "down":
+		// One step unless told otherwise; `down` never means all
+		steps := 1
+		if len(args) == 2 {
+			n, ok := number()
+			if !ok || n == 0 {
+				return usage()
+			}
+			steps = n
+		}
+		err = m.Steps(-steps)
+	case "goto":
+		n, ok := number()
+		if !ok || n == 0 {
+			return usage()
+		}
+		err = m.Migrate(uint(n))
+	case "force":
+		// Records a version without running anything, after a failed
+		// migration has been repaired by hand
+		n, ok := number()
+		if !ok {
+			return usage()
+		}
+		err = m.Force(n)
+	case "status":
+	default:
+		return usage()
+	}
+	if errors.Is(err, migrate.ErrNoChange) {
+		err = nil
+	}
+	if err != nil {
+		return fail("Migration failed", err)
+	}
+
+	version, dirty, err := migrationVersion(m)
+	if err != nil {
+		return fail("Failed to read the schema version", err)
+	}
+	if jsonOutput {
+		return printResult("migrate", exitOK, migrationState{Version: version, Latest: latestMigration(), Dirty: dirty}, nil)
+	}
+	state := ""
+	if dirty {
+		state = " (dirty)"
+	}
+	fmt.Printf("Schema at migration %d of %d%s\n", version, latestMigration(), state)
+	return exitOK
+}
+
+// migrationState is the --json result of the migrate command
+type migrationState struct {
+	Version uint `json:"version"`
+	Latest  uint `json:"latest"`
+	Dirty   bool `json:"dirty"`
+}
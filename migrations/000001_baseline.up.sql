@@ -0,0 +1,19 @@
+-- Baseline: the countries table as the first AutoMigrate startup created
+-- it. IF NOT EXISTS lets databases AutoMigrate created adopt it; the
+-- migrations after it add the columns and tables later builds created, and
+-- skip those a database already has.
+
+CREATE TABLE IF NOT EXISTS `countries` (
+  `id` bigint unsigned AUTO_INCREMENT,
+  `name` varchar(255) NOT NULL,
+  `capital` varchar(255),
+  `region` varchar(100),
+  `population` bigint NOT NULL,
+  `currency_code` varchar(10),
+  `exchange_rate` double,
+  `estimated_gdp` double,
+  `flag_url` varchar(500),
+  `last_refreshed_at` datetime(3) NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `idx_countries_name` (`name`)
+);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS `country_tombstones`;
+
+ALTER TABLE `countries`
+  DROP COLUMN `updated_at`,
+  DROP COLUMN `created_at`;
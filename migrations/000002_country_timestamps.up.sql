@@ -0,0 +1,22 @@
+-- Creation and update times of countries, and tombstones of deleted ones,
+-- for GET /countries/changes. Existing rows have no creation time.
+
+-- AutoMigrate added the columns before versioned migrations, so each
+-- ALTER only runs where they are missing.
+
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.columns
+    WHERE table_schema = DATABASE() AND table_name = 'countries' AND column_name = 'created_at') = 0,
+  'ALTER TABLE `countries` ADD COLUMN `created_at` datetime(3) NULL, ADD COLUMN `updated_at` datetime(3) NULL, ADD INDEX `idx_countries_created_at` (`created_at`), ADD INDEX `idx_countries_updated_at` (`updated_at`)',
+  'DO 0');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
+
+CREATE TABLE IF NOT EXISTS `country_tombstones` (
+  `id` bigint unsigned AUTO_INCREMENT,
+  `name` varchar(255) NOT NULL,
+  `deleted_at` datetime(3) NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `idx_country_tombstones_name` (`name`),
+  INDEX `idx_country_tombstones_deleted_at` (`deleted_at`)
+);
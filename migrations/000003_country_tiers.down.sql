@@ -0,0 +1,3 @@
+ALTER TABLE `countries`
+  DROP COLUMN `gdp_tier`,
+  DROP COLUMN `population_tier`;
@@ -0,0 +1,13 @@
+-- Population and GDP per capita tiers, for the tier filters. Filled by the
+-- next refresh.
+
+-- AutoMigrate added the columns before versioned migrations, so each
+-- ALTER only runs where they are missing.
+
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.columns
+    WHERE table_schema = DATABASE() AND table_name = 'countries' AND column_name = 'population_tier') = 0,
+  'ALTER TABLE `countries` ADD COLUMN `population_tier` varchar(20), ADD COLUMN `gdp_tier` varchar(20), ADD INDEX `idx_countries_population_tier` (`population_tier`), ADD INDEX `idx_countries_gdp_tier` (`gdp_tier`)',
+  'DO 0');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
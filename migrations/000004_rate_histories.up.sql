@@ -0,0 +1,10 @@
+-- Every exchange rate each refresh stored, for rate history and charts.
+
+CREATE TABLE IF NOT EXISTS `rate_histories` (
+  `id` bigint unsigned AUTO_INCREMENT,
+  `currency_code` varchar(10) NOT NULL,
+  `rate` double NOT NULL,
+  `recorded_at` datetime(3) NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `idx_rate_history_code_time` (`currency_code`,`recorded_at`)
+);
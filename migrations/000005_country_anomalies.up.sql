@@ -0,0 +1,15 @@
+-- Suspicious changes refreshes detected, served by GET /anomalies.
+
+CREATE TABLE IF NOT EXISTS `country_anomalies` (
+  `id` bigint unsigned AUTO_INCREMENT,
+  `country` varchar(255) NOT NULL,
+  `kind` varchar(20) NOT NULL,
+  `old_value` double,
+  `new_value` double,
+  `change_pct` double,
+  `detected_at` datetime(3) NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `idx_country_anomalies_country` (`country`),
+  INDEX `idx_country_anomalies_kind` (`kind`),
+  INDEX `idx_country_anomalies_detected_at` (`detected_at`)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE `countries`
+  DROP COLUMN `subregion`;
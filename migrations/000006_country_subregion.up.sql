@@ -0,0 +1,12 @@
+-- Subregions from restcountries. Filled by the next refresh.
+
+-- AutoMigrate added the column before versioned migrations, so the ALTER
+-- only runs where it is missing.
+
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.columns
+    WHERE table_schema = DATABASE() AND table_name = 'countries' AND column_name = 'subregion') = 0,
+  'ALTER TABLE `countries` ADD COLUMN `subregion` varchar(100)',
+  'DO 0');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
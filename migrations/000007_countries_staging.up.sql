@@ -0,0 +1,27 @@
+-- Full refreshes are staged here, validated, and then published to
+-- countries in one transaction. Later migrations keep its columns in step
+-- with countries.
+
+CREATE TABLE IF NOT EXISTS `countries_staging` (
+  `id` bigint unsigned AUTO_INCREMENT,
+  `name` varchar(255) NOT NULL,
+  `capital` varchar(255),
+  `region` varchar(100),
+  `subregion` varchar(100),
+  `population` bigint NOT NULL,
+  `currency_code` varchar(10),
+  `exchange_rate` double,
+  `estimated_gdp` double,
+  `flag_url` varchar(500),
+  `population_tier` varchar(20),
+  `gdp_tier` varchar(20),
+  `last_refreshed_at` datetime(3) NULL,
+  `created_at` datetime(3) NULL,
+  `updated_at` datetime(3) NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `idx_countries_staging_name` (`name`),
+  INDEX `idx_countries_staging_population_tier` (`population_tier`),
+  INDEX `idx_countries_staging_gdp_tier` (`gdp_tier`),
+  INDEX `idx_countries_staging_created_at` (`created_at`),
+  INDEX `idx_countries_staging_updated_at` (`updated_at`)
+);
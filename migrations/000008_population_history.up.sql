@@ -0,0 +1,12 @@
+-- World Bank population by country and year, imported through
+-- POST /admin/population-history/import.
+
+CREATE TABLE IF NOT EXISTS `population_history` (
+  `id` bigint unsigned AUTO_INCREMENT,
+  `country_code` varchar(2) NOT NULL,
+  `year` bigint NOT NULL,
+  `population` bigint NOT NULL,
+  `source` varchar(50) NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `idx_population_history_code_year` (`country_code`,`year`)
+);
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS `country_borders`;
+
+ALTER TABLE `countries_staging`
+  DROP COLUMN `alpha3_code`;
+
+ALTER TABLE `countries`
+  DROP COLUMN `alpha3_code`;
@@ -0,0 +1,29 @@
+-- ISO 3166 alpha-3 codes and the land borders between them, for
+-- GET /countries/:name/related. Filled by the next full refresh.
+
+-- AutoMigrate added the column before versioned migrations, so the ALTER
+-- only runs where it is missing.
+
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.columns
+    WHERE table_schema = DATABASE() AND table_name = 'countries' AND column_name = 'alpha3_code') = 0,
+  'ALTER TABLE `countries` ADD COLUMN `alpha3_code` varchar(3), ADD INDEX `idx_countries_alpha3_code` (`alpha3_code`)',
+  'DO 0');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
+
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.columns
+    WHERE table_schema = DATABASE() AND table_name = 'countries_staging' AND column_name = 'alpha3_code') = 0,
+  'ALTER TABLE `countries_staging` ADD COLUMN `alpha3_code` varchar(3), ADD INDEX `idx_countries_staging_alpha3_code` (`alpha3_code`)',
+  'DO 0');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
+
+CREATE TABLE IF NOT EXISTS `country_borders` (
+  `id` bigint unsigned AUTO_INCREMENT,
+  `country_code` varchar(3) NOT NULL,
+  `neighbor_code` varchar(3) NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `idx_country_border` (`country_code`,`neighbor_code`)
+);
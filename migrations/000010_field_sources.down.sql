@@ -0,0 +1,5 @@
+ALTER TABLE `countries_staging`
+  DROP COLUMN `field_sources`;
+
+ALTER TABLE `countries`
+  DROP COLUMN `field_sources`;
@@ -0,0 +1,21 @@
+-- Which provider supplied each merged field, as a JSON object. Filled by
+-- the next full refresh.
+
+-- AutoMigrate added the column before versioned migrations, so the ALTER
+-- only runs where it is missing.
+
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.columns
+    WHERE table_schema = DATABASE() AND table_name = 'countries' AND column_name = 'field_sources') = 0,
+  'ALTER TABLE `countries` ADD COLUMN `field_sources` text',
+  'DO 0');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
+
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.columns
+    WHERE table_schema = DATABASE() AND table_name = 'countries_staging' AND column_name = 'field_sources') = 0,
+  'ALTER TABLE `countries_staging` ADD COLUMN `field_sources` text',
+  'DO 0');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
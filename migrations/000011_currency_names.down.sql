@@ -0,0 +1,7 @@
+ALTER TABLE `countries_staging`
+  DROP COLUMN `currency_symbol`,
+  DROP COLUMN `currency_name`;
+
+ALTER TABLE `countries`
+  DROP COLUMN `currency_symbol`,
+  DROP COLUMN `currency_name`;
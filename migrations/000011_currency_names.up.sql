@@ -0,0 +1,21 @@
+-- The name and symbol of each country's primary currency. Filled by the
+-- next full refresh.
+
+-- AutoMigrate added the columns before versioned migrations, so each
+-- ALTER only runs where they are missing.
+
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.columns
+    WHERE table_schema = DATABASE() AND table_name = 'countries' AND column_name = 'currency_name') = 0,
+  'ALTER TABLE `countries` ADD COLUMN `currency_name` varchar(100), ADD COLUMN `currency_symbol` varchar(20)',
+  'DO 0');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
+
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.columns
+    WHERE table_schema = DATABASE() AND table_name = 'countries_staging' AND column_name = 'currency_name') = 0,
+  'ALTER TABLE `countries_staging` ADD COLUMN `currency_name` varchar(100), ADD COLUMN `currency_symbol` varchar(20)',
+  'DO 0');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
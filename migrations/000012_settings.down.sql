@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `setting_changes`;
+DROP TABLE IF EXISTS `settings`;
@@ -0,0 +1,21 @@
+-- Runtime settings changed through /admin/settings, and the audit trail
+-- of every change.
+
+CREATE TABLE IF NOT EXISTS `settings` (
+  `key` varchar(64),
+  `value` text NOT NULL,
+  `updated_at` datetime(3) NULL,
+  PRIMARY KEY (`key`)
+);
+
+CREATE TABLE IF NOT EXISTS `setting_changes` (
+  `id` bigint unsigned AUTO_INCREMENT,
+  `key` varchar(64) NOT NULL,
+  `old_value` text NOT NULL,
+  `new_value` text NOT NULL,
+  `changed_by` varchar(255),
+  `changed_at` datetime(3) NULL,
+  PRIMARY KEY (`id`),
+  INDEX `idx_setting_changes_key` (`key`),
+  INDEX `idx_setting_changes_changed_at` (`changed_at`)
+);
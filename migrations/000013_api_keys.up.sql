@@ -0,0 +1,12 @@
+-- Hashed API keys managed with ./app apikey.
+
+CREATE TABLE IF NOT EXISTS `api_keys` (
+  `id` bigint unsigned AUTO_INCREMENT,
+  `name` varchar(100) NOT NULL,
+  `key_hash` varchar(64) NOT NULL,
+  `created_at` datetime(3) NULL,
+  `revoked_at` datetime(3) NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `idx_api_keys_name` (`name`),
+  UNIQUE INDEX `idx_api_keys_key_hash` (`key_hash`)
+);
@@ -0,0 +1,16 @@
+-- Narrowing copies the tables, and fails if any value is longer than the
+-- old limit rather than truncating it.
+
+ALTER TABLE `country_tombstones`
+  MODIFY COLUMN `name` varchar(255) NOT NULL;
+
+ALTER TABLE `country_anomalies`
+  MODIFY COLUMN `country` varchar(255) NOT NULL;
+
+ALTER TABLE `countries_staging`
+  MODIFY COLUMN `name` varchar(255) NOT NULL,
+  MODIFY COLUMN `flag_url` varchar(500);
+
+ALTER TABLE `countries`
+  MODIFY COLUMN `name` varchar(255) NOT NULL,
+  MODIFY COLUMN `flag_url` varchar(500);
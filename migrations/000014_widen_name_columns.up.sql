@@ -0,0 +1,24 @@
+-- Country names grow from 255 to 512 characters and flag URLs from 500 to
+-- 2048. In utf8mb4 tables the varchars keep the same length prefix, so
+-- MySQL grows them in place without locking and reads and refreshes keep
+-- running. Where it cannot, the statement fails instead of copying the
+-- table under a lock; widen the columns in a maintenance window, then
+-- force this version.
+
+ALTER TABLE `countries`
+  MODIFY COLUMN `name` varchar(512) NOT NULL,
+  MODIFY COLUMN `flag_url` varchar(2048),
+  ALGORITHM=INPLACE, LOCK=NONE;
+
+ALTER TABLE `countries_staging`
+  MODIFY COLUMN `name` varchar(512) NOT NULL,
+  MODIFY COLUMN `flag_url` varchar(2048),
+  ALGORITHM=INPLACE, LOCK=NONE;
+
+ALTER TABLE `country_anomalies`
+  MODIFY COLUMN `country` varchar(512) NOT NULL,
+  ALGORITHM=INPLACE, LOCK=NONE;
+
+ALTER TABLE `country_tombstones`
+  MODIFY COLUMN `name` varchar(512) NOT NULL,
+  ALGORITHM=INPLACE, LOCK=NONE;
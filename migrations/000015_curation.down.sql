@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `country_aliases`;
+DROP TABLE IF EXISTS `country_overrides`;
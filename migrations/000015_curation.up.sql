@@ -0,0 +1,22 @@
+-- Operator-curated corrections, exported and imported through
+-- /admin/curation so they can be versioned and promoted across
+-- environments.
+
+CREATE TABLE IF NOT EXISTS `country_overrides` (
+  `country` varchar(512),
+  `field` varchar(32),
+  `value` text NOT NULL,
+  `note` varchar(255),
+  `updated_by` varchar(255),
+  `updated_at` datetime(3) NULL,
+  PRIMARY KEY (`country`, `field`)
+);
+
+CREATE TABLE IF NOT EXISTS `country_aliases` (
+  `alias` varchar(255),
+  `code` varchar(3) NOT NULL,
+  `note` varchar(255),
+  `updated_by` varchar(255),
+  `updated_at` datetime(3) NULL,
+  PRIMARY KEY (`alias`)
+);
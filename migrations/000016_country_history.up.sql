@@ -0,0 +1,16 @@
+-- Every create, update and delete of a country, from refreshes and the
+-- API, served by GET /countries/:name/history.
+
+CREATE TABLE IF NOT EXISTS `country_history` (
+  `id` bigint unsigned AUTO_INCREMENT,
+  `country` varchar(512) NOT NULL,
+  `action` varchar(10) NOT NULL,
+  `source` varchar(20) NOT NULL,
+  `actor` varchar(255),
+  `old_values` text,
+  `new_values` text,
+  `changed_at` datetime(3) NULL,
+  PRIMARY KEY (`id`),
+  INDEX `idx_country_history_country` (`country`),
+  INDEX `idx_country_history_changed_at` (`changed_at`)
+);
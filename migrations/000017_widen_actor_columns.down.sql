@@ -0,0 +1,14 @@
+-- Fails if any value, encrypted ones in particular, is longer than 255
+-- characters; run ./app encrypt-columns without keys to decrypt first.
+
+ALTER TABLE `setting_changes`
+  MODIFY COLUMN `changed_by` varchar(255);
+
+ALTER TABLE `country_history`
+  MODIFY COLUMN `actor` varchar(255);
+
+ALTER TABLE `country_overrides`
+  MODIFY COLUMN `updated_by` varchar(255);
+
+ALTER TABLE `country_aliases`
+  MODIFY COLUMN `updated_by` varchar(255);
@@ -0,0 +1,14 @@
+-- Actor columns can hold encrypted values (COLUMN_ENCRYPTION_KEYS), which
+-- are longer than the plaintext.
+
+ALTER TABLE `setting_changes`
+  MODIFY COLUMN `changed_by` varchar(512);
+
+ALTER TABLE `country_history`
+  MODIFY COLUMN `actor` varchar(512);
+
+ALTER TABLE `country_overrides`
+  MODIFY COLUMN `updated_by` varchar(512);
+
+ALTER TABLE `country_aliases`
+  MODIFY COLUMN `updated_by` varchar(512);
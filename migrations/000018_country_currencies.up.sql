@@ -0,0 +1,22 @@
+-- Every currency of a country, not only the primary one kept on
+-- countries. Existing primary currencies are copied in so the lists are
+-- filled before the next full refresh.
+
+CREATE TABLE IF NOT EXISTS `country_currencies` (
+  `id` bigint unsigned AUTO_INCREMENT,
+  `country_id` bigint unsigned NOT NULL,
+  `code` varchar(10) NOT NULL,
+  `name` varchar(100),
+  `symbol` varchar(20),
+  `exchange_rate` double,
+  `position` bigint NOT NULL,
+  `is_primary` boolean NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `idx_country_currency` (`country_id`,`code`),
+  INDEX `idx_country_currencies_code` (`code`)
+);
+
+INSERT INTO `country_currencies` (`country_id`, `code`, `name`, `symbol`, `exchange_rate`, `position`, `is_primary`)
+SELECT `id`, `currency_code`, `currency_name`, `currency_symbol`, `exchange_rate`, 0, true
+FROM `countries`
+WHERE `currency_code` IS NOT NULL;
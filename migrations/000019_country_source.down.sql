@@ -0,0 +1,10 @@
+-- Manual countries are kept, and the next full refresh overwrites any that
+-- restcountries also lists.
+
+ALTER TABLE `countries_staging`
+  DROP INDEX `idx_countries_staging_source`,
+  DROP COLUMN `source`;
+
+ALTER TABLE `countries`
+  DROP INDEX `idx_countries_source`,
+  DROP COLUMN `source`;
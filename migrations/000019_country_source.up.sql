@@ -0,0 +1,10 @@
+-- Countries created through POST /countries are marked manual so full
+-- refreshes leave them alone. Every existing row came from restcountries.
+
+ALTER TABLE `countries`
+  ADD COLUMN `source` varchar(20) NOT NULL DEFAULT 'restcountries',
+  ADD INDEX `idx_countries_source` (`source`);
+
+ALTER TABLE `countries_staging`
+  ADD COLUMN `source` varchar(20) NOT NULL DEFAULT 'restcountries',
+  ADD INDEX `idx_countries_staging_source` (`source`);
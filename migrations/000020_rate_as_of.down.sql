@@ -0,0 +1,8 @@
+ALTER TABLE `country_currencies`
+  DROP COLUMN `rate_as_of`;
+
+ALTER TABLE `countries_staging`
+  DROP COLUMN `rate_as_of`;
+
+ALTER TABLE `countries`
+  DROP COLUMN `rate_as_of`;
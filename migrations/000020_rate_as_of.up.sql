@@ -0,0 +1,11 @@
+-- When the rate provider last updated each stored rate, which can be hours
+-- older than the refresh that fetched it. Filled by the next refresh.
+
+ALTER TABLE `countries`
+  ADD COLUMN `rate_as_of` datetime(3) NULL;
+
+ALTER TABLE `countries_staging`
+  ADD COLUMN `rate_as_of` datetime(3) NULL;
+
+ALTER TABLE `country_currencies`
+  ADD COLUMN `rate_as_of` datetime(3) NULL;
@@ -0,0 +1,5 @@
+ALTER TABLE `countries_staging`
+  DROP COLUMN `alpha2_code`;
+
+ALTER TABLE `countries`
+  DROP COLUMN `alpha2_code`;
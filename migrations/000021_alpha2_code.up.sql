@@ -0,0 +1,15 @@
+-- ISO 3166 alpha-2 codes from restcountries, so countries can be looked up
+-- by code. Existing rows take the code from their flagcdn flag URL until
+-- the next refresh stores the upstream value.
+
+ALTER TABLE `countries`
+  ADD COLUMN `alpha2_code` varchar(2) NULL,
+  ADD INDEX `idx_countries_alpha2_code` (`alpha2_code`);
+
+ALTER TABLE `countries_staging`
+  ADD COLUMN `alpha2_code` varchar(2) NULL,
+  ADD INDEX `idx_countries_staging_alpha2_code` (`alpha2_code`);
+
+UPDATE `countries`
+  SET `alpha2_code` = UPPER(SUBSTRING(`flag_url`, 21, 2))
+  WHERE `flag_url` REGEXP '^https://flagcdn\\.com/[a-z]{2}\\.svg$';
@@ -0,0 +1,18 @@
+-- The latest flag check of each country, served by GET /admin/broken-flags.
+-- Repaired rows also keep the fix in place across full refreshes.
+
+CREATE TABLE IF NOT EXISTS `flag_checks` (
+  `id` bigint unsigned AUTO_INCREMENT,
+  `country` varchar(512) NOT NULL,
+  `flag_url` varchar(2048) NOT NULL,
+  `status` varchar(10) NOT NULL,
+  `http_status` bigint,
+  `content_type` varchar(255),
+  `error` varchar(512),
+  `repaired_url` varchar(2048),
+  `checked_at` datetime(3) NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `idx_flag_checks_country` (`country`),
+  INDEX `idx_flag_checks_status` (`status`),
+  INDEX `idx_flag_checks_checked_at` (`checked_at`)
+);
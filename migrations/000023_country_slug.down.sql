@@ -0,0 +1,5 @@
+ALTER TABLE `countries_staging`
+  DROP COLUMN `slug`;
+
+ALTER TABLE `countries`
+  DROP COLUMN `slug`;
@@ -0,0 +1,10 @@
+-- URL-safe slugs of country names, accepted wherever a name is. The server
+-- fills existing rows on startup; refreshes keep them current.
+
+ALTER TABLE `countries`
+  ADD COLUMN `slug` varchar(512) NULL,
+  ADD UNIQUE INDEX `idx_countries_slug` (`slug`);
+
+ALTER TABLE `countries_staging`
+  ADD COLUMN `slug` varchar(512) NULL,
+  ADD INDEX `idx_countries_staging_slug` (`slug`);
@@ -0,0 +1,12 @@
+-- Authenticated requests per caller and UTC day, charted by
+-- GET /admin/usage.png.
+
+CREATE TABLE IF NOT EXISTS `api_usage` (
+  `id` bigint unsigned AUTO_INCREMENT,
+  `actor` varchar(255) NOT NULL,
+  `day` date NOT NULL,
+  `requests` bigint NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `idx_api_usage_actor_day` (`actor`, `day`),
+  INDEX `idx_api_usage_day` (`day`)
+);
@@ -0,0 +1,7 @@
+ALTER TABLE `countries_staging`
+  DROP COLUMN `previous_estimated_gdp`,
+  DROP COLUMN `previous_exchange_rate`;
+
+ALTER TABLE `countries`
+  DROP COLUMN `previous_estimated_gdp`,
+  DROP COLUMN `previous_exchange_rate`;
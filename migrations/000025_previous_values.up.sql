@@ -0,0 +1,10 @@
+-- The exchange rate and estimated GDP each refresh replaced, from which
+-- country responses compute their change since the previous refresh.
+
+ALTER TABLE `countries`
+  ADD COLUMN `previous_exchange_rate` double NULL,
+  ADD COLUMN `previous_estimated_gdp` double NULL;
+
+ALTER TABLE `countries_staging`
+  ADD COLUMN `previous_exchange_rate` double NULL,
+  ADD COLUMN `previous_estimated_gdp` double NULL;
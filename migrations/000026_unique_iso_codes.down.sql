@@ -0,0 +1,15 @@
+-- Codes cleared by the up migration are not restored.
+
+ALTER TABLE `countries_staging`
+  DROP INDEX `idx_countries_staging_slug`,
+  ADD INDEX `idx_countries_staging_slug` (`slug`),
+  DROP INDEX `idx_countries_staging_alpha3_code`,
+  ADD INDEX `idx_countries_staging_alpha3_code` (`alpha3_code`),
+  DROP INDEX `idx_countries_staging_alpha2_code`,
+  ADD INDEX `idx_countries_staging_alpha2_code` (`alpha2_code`);
+
+ALTER TABLE `countries`
+  DROP INDEX `idx_countries_alpha3_code`,
+  ADD INDEX `idx_countries_alpha3_code` (`alpha3_code`),
+  DROP INDEX `idx_countries_alpha2_code`,
+  ADD INDEX `idx_countries_alpha2_code` (`alpha2_code`);
@@ -0,0 +1,39 @@
+-- Alpha-2 and alpha-3 codes identify one country each, like names and
+-- slugs, so the unique keys reject a second country taking a code. Where
+-- rows already share a code the oldest keeps it; the others lose it until
+-- a refresh or an edit sets one again. Staging slugs are unique by
+-- construction and now declared so.
+
+UPDATE `countries` c
+  JOIN `countries` d ON d.`alpha2_code` = c.`alpha2_code` AND d.`id` < c.`id`
+  SET c.`alpha2_code` = NULL;
+
+UPDATE `countries` c
+  JOIN `countries` d ON d.`alpha3_code` = c.`alpha3_code` AND d.`id` < c.`id`
+  SET c.`alpha3_code` = NULL;
+
+ALTER TABLE `countries`
+  DROP INDEX `idx_countries_alpha2_code`,
+  ADD UNIQUE INDEX `idx_countries_alpha2_code` (`alpha2_code`),
+  DROP INDEX `idx_countries_alpha3_code`,
+  ADD UNIQUE INDEX `idx_countries_alpha3_code` (`alpha3_code`);
+
+UPDATE `countries_staging` c
+  JOIN `countries_staging` d ON d.`alpha2_code` = c.`alpha2_code` AND d.`id` < c.`id`
+  SET c.`alpha2_code` = NULL;
+
+UPDATE `countries_staging` c
+  JOIN `countries_staging` d ON d.`alpha3_code` = c.`alpha3_code` AND d.`id` < c.`id`
+  SET c.`alpha3_code` = NULL;
+
+UPDATE `countries_staging` c
+  JOIN `countries_staging` d ON d.`slug` = c.`slug` AND d.`id` < c.`id`
+  SET c.`slug` = NULL;
+
+ALTER TABLE `countries_staging`
+  DROP INDEX `idx_countries_staging_alpha2_code`,
+  ADD UNIQUE INDEX `idx_countries_staging_alpha2_code` (`alpha2_code`),
+  DROP INDEX `idx_countries_staging_alpha3_code`,
+  ADD UNIQUE INDEX `idx_countries_staging_alpha3_code` (`alpha3_code`),
+  DROP INDEX `idx_countries_staging_slug`,
+  ADD UNIQUE INDEX `idx_countries_staging_slug` (`slug`);
@@ -0,0 +1,2 @@
+ALTER TABLE `country_history`
+  DROP COLUMN `country_code`;
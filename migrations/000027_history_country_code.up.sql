@@ -0,0 +1,11 @@
+-- History is keyed by the alpha-3 code as well as the name, so a country
+-- keeps its history, and the changes feed its identity, across renames.
+-- Existing entries take the code of the country that has their name.
+
+ALTER TABLE `country_history`
+  ADD COLUMN `country_code` varchar(3) NULL,
+  ADD INDEX `idx_country_history_country_code` (`country_code`);
+
+UPDATE `country_history` h
+  JOIN `countries` c ON c.`name` = h.`country`
+  SET h.`country_code` = c.`alpha3_code`;
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+	"strconv"
+)
+
+// defaultMinorUnits is the ISO 4217 exponent of most currencies: amounts
+// are kept to the cent
+const defaultMinorUnits = 2
+
+// currencyMinorUnits lists the ISO 4217 currencies whose exponent is not 2
+var currencyMinorUnits = map[string]int{
+	// No minor unit
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0,
+	"KRW": 0, "PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0,
+	"XAF": 0, "XOF": 0, "XPF": 0,
+
+	// Thousandths
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+
+	// Ten-thousandths, for units of account
+	"CLF": 4, "UYW": 4,
+}
+
+// minorUnits is the number of decimals an amount in the currency is kept
+// to; unknown codes get the common two
+func minorUnits(code string) int {
+	if units, ok := currencyMinorUnits[code]; ok {
+		return units
+	}
+	return defaultMinorUnits
+}
+
+// roundMinor rounds an amount to the minor unit of its currency, half away
+// from zero
+func roundMinor(amount float64, code string) float64 {
+	scale := math.Pow10(minorUnits(code))
+	return math.Round(amount*scale) / scale
+}
+
+// formatAmount prints an amount with exactly the decimals of its currency,
+// e.g. 1500 JPY as "1500" and 1.5 BHD as "1.500"
+func formatAmount(amount float64, code string) string {
+	return strconv.FormatFloat(amount, 'f', minorUnits(code), 64)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+)
+
+// Fixture payloads served by the mock upstreams. NOK is deliberately
+// missing from the rates so the "no exchange rate" path is exercised.
+//
+//go:embed fixtures/countries.json fixtures/countries_v3.json fixtures/rates.json
+var fixtures embed.FS
+
+// startMockUpstreams serves the fixture payloads from an in-process HTTP
+// server and points the upstream URLs at it, so refreshes run offline.
+func startMockUpstreams() error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/all", serveFixture("fixtures/countries.json"))
+	mux.HandleFunc("/v3.1/all", serveFixture("fixtures/countries_v3.json"))
+	mux.HandleFunc("/v6/latest/USD", serveFixture("fixtures/rates.json"))
+	mux.HandleFunc("/live", serveHostRates)
+
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Printf("Mock upstream server stopped: %v", err)
+		}
+	}()
+
+	baseURL := "http://" + listener.Addr().String()
+	countriesAPIURL = baseURL + "/" + countriesAPIVersion + "/all"
+	exchangeRatesAPIURL = baseURL + "/v6/latest/USD"
+	exchangerateHostURL = baseURL + "/live"
+
+	log.Printf("Mock upstreams listening on %s", baseURL)
+	return nil
+}
+
+func serveFixture(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := fixtures.ReadFile(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
+
+// serveHostRates serves the rates fixture as exchangerate.host quotes
+func serveHostRates(w http.ResponseWriter, r *http.Request) {
+	data, err := fixtures.ReadFile("fixtures/rates.json")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var payload ExchangeRateResponse
+	if err := json.Unmarshal(data, &payload); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	quotes := make(map[string]float64, len(payload.Rates))
+	for code, rate := range payload.Rates {
+		quotes["USD"+code] = rate
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "source": "USD", "quotes": quotes})
+}
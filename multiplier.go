@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// gdpMultiplierField is the override that tunes one country's GDP
+// estimate. It is not a country column, so edits cannot set or pin it.
+const gdpMultiplierField = "gdp_multiplier"
+
+// gdpMultiplier is the range the GDP estimate draws its multiplier from;
+// Min equal to Max fixes it
+type gdpMultiplier struct {
+	Min float64
+	Max float64
+}
+
+// parseGDPMultiplier reads a gdp_multiplier override: a positive number
+// such as "1500", or a range such as "1200..1800"
+func parseGDPMultiplier(value string) (gdpMultiplier, error) {
+	low, high, isRange := strings.Cut(value, "..")
+	if !isRange {
+		high = low
+	}
+	lo, errLo := strconv.ParseFloat(strings.TrimSpace(low), 64)
+	hi, errHi := strconv.ParseFloat(strings.TrimSpace(high), 64)
+	if errLo != nil || errHi != nil || !(lo > 0) || !(hi >= lo) || math.IsInf(hi, 0) {
+		return gdpMultiplier{}, fmt.Errorf("%s must be a positive number or a min..max range", gdpMultiplierField)
+	}
+	return gdpMultiplier{Min: lo, Max: hi}, nil
+}
+
+// draw picks a multiplier from the range with rng, or the shared source
+// when rng is nil
+func (m gdpMultiplier) draw(rng *rand.Rand) float64 {
+	if rng == nil {
+		return m.Min + rand.Float64()*(m.Max-m.Min)
+	}
+	return m.Min + rng.Float64()*(m.Max-m.Min)
+}
+
+// defaultGDPMultiplier is the configured range used without an override
+func defaultGDPMultiplier() gdpMultiplier {
+	s := currentSettings()
+	return gdpMultiplier{Min: s.GDPMultiplierMin, Max: s.GDPMultiplierMax}
+}
+
+// gdpMultiplierOf returns the multiplier among a country's overrides, or
+// nil. Like other fields, an alpha-3 override listed last wins over the
+// name's.
+func gdpMultiplierOf(overrides []CountryOverride) *gdpMultiplier {
+	var found *gdpMultiplier
+	for _, override := range overrides {
+		if override.Field != gdpMultiplierField {
+			continue
+		}
+		if m, err := parseGDPMultiplier(override.Value); err == nil {
+			found = &m
+		}
+	}
+	return found
+}
+
+// loadGDPMultiplier reads the multiplier override of one country, for
+// edits that re-estimate its GDP outside a refresh
+func loadGDPMultiplier(conn *gorm.DB, country Country) (*gdpMultiplier, error) {
+	keys := []string{strings.ToUpper(country.Name)}
+	if country.Alpha3Code != nil {
+		keys = append(keys, strings.ToUpper(*country.Alpha3Code))
+	}
+	var rows []CountryOverride
+	if err := conn.Where("field = ? AND UPPER(country) IN ?", gdpMultiplierField, keys).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	// Put the name's override first so the code's wins
+	for i := range rows {
+		if strings.EqualFold(rows[i].Country, country.Name) {
+			rows[0], rows[i] = rows[i], rows[0]
+		}
+	}
+	return gdpMultiplierOf(rows), nil
+}
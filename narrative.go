@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// describeCountry renders a short templated paragraph about a country for
+// chatbots and voice assistants. Sentences are skipped when the underlying
+// field is missing rather than filled with placeholders.
+func describeCountry(country Country) string {
+	var sentences []string
+
+	where := ""
+	switch {
+	case country.Subregion != nil && country.Region != nil:
+		where = fmt.Sprintf(" in %s, %s", *country.Subregion, *country.Region)
+	case country.Region != nil:
+		where = fmt.Sprintf(" in %s", *country.Region)
+	}
+	sentences = append(sentences, fmt.Sprintf("%s is a country%s with a population of %s.",
+		country.Name, where, humanizeCount(float64(country.Population))))
+
+	if country.Capital != nil {
+		sentences = append(sentences, fmt.Sprintf("Its capital is %s.", *country.Capital))
+	}
+
+	switch {
+	case country.CurrencyCode != nil && country.ExchangeRate != nil:
+		sentences = append(sentences, fmt.Sprintf("Its currency is %s, trading at %s per US dollar.",
+			*country.CurrencyCode, formatRate(*country.ExchangeRate)))
+	case country.CurrencyCode != nil:
+		sentences = append(sentences, fmt.Sprintf("Its currency is %s, for which no exchange rate is available.",
+			*country.CurrencyCode))
+	default:
+		sentences = append(sentences, "It has no currency on record.")
+	}
+
+	if country.CurrencyPeg != nil {
+		sentences = append(sentences, fmt.Sprintf("The %s is pegged to the %s.",
+			*country.CurrencyCode, country.CurrencyPeg.Target))
+	}
+
+	if country.EstimatedGDP != nil && *country.EstimatedGDP > 0 {
+		sentences = append(sentences, fmt.Sprintf("Its estimated GDP is about %s US dollars.",
+			humanizeCount(*country.EstimatedGDP)))
+	}
+
+	return strings.Join(sentences, " ")
+}
+
+// humanizeCount spells out large numbers as "206.1 million" and smaller ones
+// with thousands separators
+func humanizeCount(n float64) string {
+	units := []struct {
+		size float64
+		name string
+	}{
+		{1e12, "trillion"},
+		{1e9, "billion"},
+		{1e6, "million"},
+	}
+	for _, u := range units {
+		if math.Abs(n) >= u.size {
+			return fmt.Sprintf("%s %s", strings.TrimSuffix(fmt.Sprintf("%.1f", n/u.size), ".0"), u.name)
+		}
+	}
+	return groupThousands(fmt.Sprintf("%.0f", n))
+}
+
+// formatRate shows an exchange rate with up to four decimals
+func formatRate(rate float64) string {
+	s := fmt.Sprintf("%.4f", rate)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	whole, frac, _ := strings.Cut(s, ".")
+	if frac != "" {
+		return groupThousands(whole) + "." + frac
+	}
+	return groupThousands(whole)
+}
+
+// groupThousands inserts commas into a string of digits
+func groupThousands(digits string) string {
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(d)
+	}
+	return sign + b.String()
+}
+
+func getCountrySummary(c *fiber.Ctx) error {
+	country, err := findCountry(c.Params("name"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"name":    country.Name,
+		"summary": describeCountry(*country),
+	})
+}
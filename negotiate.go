@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Response formats of the country read endpoints
+const (
+	formatJSON = "json"
+	formatXML  = "xml"
+)
+
+// negotiateFormat picks the response format of a country read endpoint:
+// ?format=json or xml, else the best match of the Accept header, or JSON
+// when it accepts neither. Handlers and the response cache read the
+// choice from Locals("format").
+func negotiateFormat() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Vary(fiber.HeaderAccept)
+		format := c.Query("format")
+		switch format {
+		case formatJSON, formatXML:
+		case "":
+			format = formatJSON
+			switch c.Accepts(fiber.MIMEApplicationJSON, fiber.MIMEApplicationXML, fiber.MIMETextXML) {
+			case fiber.MIMEApplicationXML, fiber.MIMETextXML:
+				format = formatXML
+			}
+		default:
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "Validation failed",
+				"details": "format must be json or xml",
+			})
+		}
+		c.Locals("format", format)
+		return c.Next()
+	}
+}
+
+// wantsXML reports whether negotiateFormat chose XML
+func wantsXML(c *fiber.Ctx) bool {
+	return c.Locals("format") == formatXML
+}
+
+// xmlFields is a string map that marshals to XML as one element per key,
+// in key order. Keys must be valid element names, as field and parameter
+// names are.
+type xmlFields map[string]string
+
+func (m xmlFields) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := e.EncodeElement(m[key], xml.StartElement{Name: xml.Name{Local: key}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// countryListXML is the XML form of a list envelope of countries. Until
+// and Deleted are set for ?since= deltas, Region for a region's countries.
+// Unlike JSON, null fields are left out; empty lists are empty elements.
+type countryListXML struct {
+	Data    []Country     `xml:"data>country"`
+	Meta    listMeta      `xml:"meta"`
+	Until   *time.Time    `xml:"until,omitempty"`
+	Deleted *tombstoneXML `xml:"deleted,omitempty"`
+	Region  *regionStats  `xml:"region,omitempty"`
+}
+
+// tombstoneXML lists the deletions of a delta
+type tombstoneXML struct {
+	Countries []CountryTombstone `xml:"country"`
+}
+
+// sendXML sends v as an XML document with the given root element
+func sendXML(c *fiber.Ctx, root string, v interface{}) error {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	if err := xml.NewEncoder(&b).EncodeElement(v, xml.StartElement{Name: xml.Name{Local: root}}); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationXMLCharsetUTF8)
+	return c.Send(b.Bytes())
+}
+
+// sendCountry sends one country in the negotiated format
+func sendCountry(c *fiber.Ctx, country *Country) error {
+	if wantsXML(c) {
+		return sendXML(c, "country", country)
+	}
+	return c.JSON(country)
+}
+
+// sendCountryBlob sends a country stored as JSON, as the read model and
+// the standby snapshot hold them, in the negotiated format
+func sendCountryBlob(c *fiber.Ctx, blob []byte) error {
+	if wantsXML(c) {
+		var country Country
+		if err := json.Unmarshal(blob, &country); err != nil {
+			return err
+		}
+		return sendXML(c, "country", &country)
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(blob)
+}
+
+// sendCountryList sends a list envelope of countries in the negotiated
+// format
+func sendCountryList(c *fiber.Ctx, countries []Country, meta listMeta) error {
+	if wantsXML(c) {
+		return sendXML(c, "countries", countryListXML{Data: countries, Meta: meta})
+	}
+	return sendList(c, countries, meta)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notification event kinds
+const (
+	eventRefresh = "refresh"
+	eventAnomaly = "anomaly"
+	eventAlert   = "alert"
+)
+
+// Webhook payloads: the event alone, or with the records a refresh changed
+const (
+	payloadSummary = "summary"
+	payloadDelta   = "delta"
+)
+
+// maxDeltaRecords caps the changed countries a delta payload carries; the
+// rest are paged from its next URL
+const maxDeltaRecords = 100
+
+// notificationEvent is what every channel receives. Text is a ready-made
+// plain-text body; structured channels can use the other fields.
+type notificationEvent struct {
+	Kind      string           `json:"kind"`
+	Subject   string           `json:"subject"`
+	Text      string           `json:"text"`
+	Summary   *refreshSummary  `json:"summary,omitempty"`
+	Anomalies []CountryAnomaly `json:"anomalies,omitempty"`
+	Delta     *refreshDelta    `json:"delta,omitempty"`
+	At        time.Time        `json:"at"`
+}
+
+// refreshDelta is the countries a refresh changed, as full records, so a
+// subscriber can update its copy without fetching them
+type refreshDelta struct {
+	// Total counts every changed country; Changed holds the first
+	// maxDeltaRecords in name order
+	Total   int64     `json:"total"`
+	Changed []Country `json:"changed"`
+	// Next is the GET /countries/refresh/changes page after Changed, empty
+	// when Changed is complete
+	Next string `json:"next,omitempty"`
+}
+
+// Notifier delivers events to one channel. Adding a channel means adding an
+// implementation and registering it in initNotifiers.
+type Notifier interface {
+	Name() string
+	Notify(ev notificationEvent) error
+}
+
+// notifiers are the channels enabled at startup
+var notifiers []Notifier
+
+// initNotifiers enables each channel whose settings are present
+func initNotifiers() {
+	notifiers = nil
+	if os.Getenv("NOTIFY_LOG") == "true" {
+		notifiers = append(notifiers, logNotifier{})
+	}
+	if cfg, ok := loadSMTPConfig(); ok {
+		notifiers = append(notifiers, emailNotifier{cfg: cfg})
+	}
+	if url := os.Getenv("NOTIFY_WEBHOOK_URL"); url != "" {
+		payload := getEnv("NOTIFY_WEBHOOK_PAYLOAD", payloadSummary)
+		if payload != payloadSummary && payload != payloadDelta {
+			log.Printf("Unknown NOTIFY_WEBHOOK_PAYLOAD %q, sending summaries", payload)
+			payload = payloadSummary
+		}
+		notifiers = append(notifiers, webhookNotifier{url: url, payload: payload,
+			baseURL: strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/")})
+	}
+	if url := os.Getenv("SLACK_WEBHOOK_URL"); url != "" {
+		notifiers = append(notifiers, slackNotifier{url: url})
+	}
+	for _, n := range notifiers {
+		log.Printf("Notifications enabled: %s", n.Name())
+	}
+}
+
+// notify fans an event out to every channel; failures are logged so one
+// broken channel does not block the others
+func notify(ev notificationEvent) {
+	if ev.At.IsZero() {
+		ev.At = clock.Now()
+	}
+	for _, n := range notifiers {
+		if err := n.Notify(ev); err != nil {
+			log.Printf("Failed to send %s notification via %s: %v", ev.Kind, n.Name(), err)
+		}
+	}
+}
+
+// notifyRefresh sends the events for a finished refresh: always a refresh
+// digest, plus an anomaly event when anything looked suspicious
+func notifyRefresh(summary *refreshSummary) {
+	notify(notificationEvent{
+		Kind:    eventRefresh,
+		Subject: fmt.Sprintf("Country refresh: %d processed, %d errors", summary.Processed, len(summary.Errors)),
+		Text:    formatDigest(summary),
+		Summary: summary,
+	})
+
+	if len(summary.Anomalies) > 0 {
+		notify(notificationEvent{
+			Kind:      eventAnomaly,
+			Subject:   fmt.Sprintf("Country refresh: %d anomalies detected", len(summary.Anomalies)),
+			Text:      formatAnomalies(summary.Anomalies),
+			Anomalies: summary.Anomalies,
+		})
+	}
+}
+
+// notifyAlert reports an operational failure
+func notifyAlert(subject string, err error) {
+	notify(notificationEvent{
+		Kind:    eventAlert,
+		Subject: subject,
+		Text:    err.Error(),
+	})
+}
+
+// logNotifier writes events to the server log
+type logNotifier struct{}
+
+func (logNotifier) Name() string { return "log" }
+
+func (logNotifier) Notify(ev notificationEvent) error {
+	log.Printf("[%s] %s\n%s", ev.Kind, ev.Subject, ev.Text)
+	return nil
+}
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// postJSON sends a JSON body and treats any non-2xx status as a failure
+func postJSON(url string, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := notifyClient.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// webhookNotifier POSTs the full event as JSON. With the delta payload,
+// refresh events also carry the changed records, linked from baseURL.
+type webhookNotifier struct {
+	url     string
+	payload string
+	baseURL string
+}
+
+func (webhookNotifier) Name() string { return "webhook" }
+
+func (w webhookNotifier) Notify(ev notificationEvent) error {
+	if w.payload == payloadDelta && ev.Kind == eventRefresh && ev.Summary != nil {
+		delta, err := loadRefreshDelta(ev.Summary, w.baseURL)
+		if err != nil {
+			// The counts still tell the subscriber a refresh happened
+			log.Printf("Failed to load the refresh delta for the webhook: %v", err)
+		}
+		ev.Delta = delta
+	}
+	return postJSON(w.url, ev)
+}
+
+// loadRefreshDelta reads the countries a refresh changed. They are found
+// by the time of its history, which every entry shares; MySQL keeps it to
+// the millisecond, so the bounds span the whole second.
+func loadRefreshDelta(summary *refreshSummary, baseURL string) (*refreshDelta, error) {
+	delta := &refreshDelta{Changed: []Country{}}
+	if len(summary.history) == 0 {
+		return delta, nil
+	}
+	from := summary.history[0].ChangedAt.UTC().Truncate(time.Second)
+	to := from.Add(time.Second)
+
+	query := refreshChanges(db, from, to)
+	if err := query.Count(&delta.Total).Error; err != nil {
+		return nil, err
+	}
+	if err := query.Order("name ASC").Limit(maxDeltaRecords).Find(&delta.Changed).Error; err != nil {
+		return nil, err
+	}
+	if err := attachCurrencies(db, delta.Changed); err != nil {
+		return nil, err
+	}
+	if delta.Total > int64(len(delta.Changed)) {
+		params := url.Values{}
+		params.Set("from", from.Format(time.RFC3339))
+		params.Set("to", to.Format(time.RFC3339))
+		params.Set("limit", strconv.Itoa(maxDeltaRecords))
+		params.Set("offset", strconv.Itoa(len(delta.Changed)))
+		delta.Next = baseURL + "/countries/refresh/changes?" + params.Encode()
+	}
+	return delta, nil
+}
+
+// slackNotifier posts to a Slack incoming webhook
+type slackNotifier struct {
+	url string
+}
+
+func (slackNotifier) Name() string { return "slack" }
+
+func (s slackNotifier) Notify(ev notificationEvent) error {
+	return postJSON(s.url, map[string]string{
+		"text": fmt.Sprintf("*%s*\n```%s```", ev.Subject, ev.Text),
+	})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Open Graph image layout, in output pixels
+const (
+	ogWidth       = 1200
+	ogHeight      = 630
+	ogMargin      = 60
+	ogFlagWidth   = 360
+	ogTitleScale  = 6
+	ogTitleMin    = 3
+	ogTitleLines  = 2
+	ogStatScale   = 3
+	ogFooterScale = 2
+)
+
+var ogFlagClient = &http.Client{Timeout: 5 * time.Second}
+
+// flagCode extracts the lowercase ISO 3166-1 alpha-2 code from a flagcdn
+// URL, or "" for other hosts
+func flagCode(flagURL *string) string {
+	if flagURL == nil || !strings.HasPrefix(*flagURL, "https://flagcdn.com/") {
+		return ""
+	}
+	return strings.TrimSuffix(path.Base(*flagURL), ".svg")
+}
+
+// flagPNGURL maps a flagcdn SVG URL to its raster equivalent; other hosts
+// are not supported since SVG cannot be rendered here
+func flagPNGURL(flagURL *string) string {
+	code := flagCode(flagURL)
+	if code == "" {
+		return ""
+	}
+	return fmt.Sprintf("https://flagcdn.com/w640/%s.png", code)
+}
+
+// fetchFlag downloads the country's flag, returning nil if unavailable
+func fetchFlag(flagURL *string) image.Image {
+	url := flagPNGURL(flagURL)
+	if url == "" {
+		return nil
+	}
+	resp, err := ogFlagClient.Get(url)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil
+	}
+	img, err := png.Decode(resp.Body)
+	if err != nil {
+		return nil
+	}
+	return img
+}
+
+// drawScaledText renders text with the bitmap face at an integer scale,
+// with its top-left corner at (x, y)
+func drawScaledText(dst *image.RGBA, x, y, scale int, text string, col color.Color) {
+	face := basicfont.Face7x13
+	width := len(text) * face.Advance
+	height := face.Height
+	small := image.NewRGBA(image.Rect(0, 0, width, height))
+	addLabel(small, fixed.P(0, face.Ascent), text, col)
+
+	rect := image.Rect(x, y, x+width*scale, y+height*scale)
+	xdraw.NearestNeighbor.Scale(dst, rect, small, small.Bounds(), xdraw.Over, nil)
+}
+
+// ogStatLines are the key facts shown under the country name
+func ogStatLines(country Country) []string {
+	var lines []string
+	if country.Capital != nil {
+		lines = append(lines, "Capital: "+*country.Capital)
+	}
+	switch {
+	case country.Subregion != nil:
+		lines = append(lines, "Region: "+*country.Subregion)
+	case country.Region != nil:
+		lines = append(lines, "Region: "+*country.Region)
+	}
+	lines = append(lines, "Population: "+humanizeCount(float64(country.Population)))
+	if country.CurrencyCode != nil {
+		currency := "Currency: " + *country.CurrencyCode
+		if country.ExchangeRate != nil {
+			currency += fmt.Sprintf(" (%s per USD)", formatRate(*country.ExchangeRate))
+		}
+		lines = append(lines, currency)
+	}
+	if country.EstimatedGDP != nil && *country.EstimatedGDP > 0 {
+		lines = append(lines, "Est. GDP: $"+humanizeCount(*country.EstimatedGDP))
+	}
+	return lines
+}
+
+// renderOGImage lays out a 1200x630 social preview card for a country
+func renderOGImage(country Country, flag image.Image) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, ogWidth, ogHeight))
+	theme := currentTheme()
+	draw.Draw(img, img.Bounds(), &image.Uniform{theme.Background}, image.Point{}, draw.Src)
+
+	face := basicfont.Face7x13
+	textWidth := ogWidth - 2*ogMargin
+
+	// Flag in the top-right corner, keeping its aspect ratio
+	if flag != nil {
+		b := flag.Bounds()
+		flagHeight := ogFlagWidth * b.Dy() / b.Dx()
+		rect := image.Rect(ogWidth-ogMargin-ogFlagWidth, ogMargin, ogWidth-ogMargin, ogMargin+flagHeight)
+		xdraw.CatmullRom.Scale(img, rect, flag, b, xdraw.Over, nil)
+		textWidth -= ogFlagWidth + ogMargin/2
+	}
+
+	// Shrink long names until they fit on two lines
+	scale := ogTitleScale
+	title := wrapText(face, country.Name, fixed.I(textWidth/scale))
+	for len(title) > ogTitleLines && scale > ogTitleMin {
+		scale--
+		title = wrapText(face, country.Name, fixed.I(textWidth/scale))
+	}
+
+	ink := theme.Text
+	y := ogMargin
+	for _, line := range title {
+		drawScaledText(img, ogMargin, y, scale, line, ink)
+		y += face.Height * scale
+	}
+
+	// Stats stop above the footer rather than overlap it
+	footerY := ogHeight - ogMargin - face.Height*ogFooterScale
+	lineHeight := face.Height*ogStatScale + 8
+	y += 30
+stats:
+	for _, line := range ogStatLines(country) {
+		for _, part := range wrapText(face, line, fixed.I(textWidth/ogStatScale)) {
+			if y+lineHeight > footerY {
+				break stats
+			}
+			drawScaledText(img, ogMargin, y, ogStatScale, part, ink)
+			y += lineHeight
+		}
+	}
+
+	footer := theme.Muted
+	drawScaledText(img, ogMargin, footerY, ogFooterScale, "Country Currency & Exchange API", footer)
+
+	return img
+}
+
+func getCountryOGImage(c *fiber.Ctx) error {
+	country, err := findCountry(c.Params("name"))
+	if err != nil {
+		return err
+	}
+
+	img := renderOGImage(*country, fetchFlag(country.FlagURL))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderContentType, "image/png")
+	c.Set(fiber.HeaderCacheControl, "public, max-age=3600")
+	return c.Send(buf.Bytes())
+}
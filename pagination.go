@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Page size bounds for /countries
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// cursorPrefix versions the opaque cursor format
+const cursorPrefix = "id:"
+
+// pageRequest describes how a list request is paged. Offset paging uses
+// Limit and Offset; cursor paging walks rows in id order after AfterID,
+// which stays stable while a refresh updates rows in place.
+type pageRequest struct {
+	Limit   int
+	Offset  int
+	Cursor  bool
+	AfterID uint
+}
+
+// encodeCursor makes the opaque cursor for the row after id
+func encodeCursor(id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorPrefix + strconv.FormatUint(uint64(id), 10)))
+}
+
+func decodeCursor(cursor string) (uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(raw), cursorPrefix) {
+		return 0, errors.New("cursor is invalid")
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(string(raw), cursorPrefix), 10, 64)
+	if err != nil {
+		return 0, errors.New("cursor is invalid")
+	}
+	return uint(id), nil
+}
+
+// parsePage reads ?limit=, ?offset= and ?cursor=. Passing cursor (empty for
+// the first page) selects cursor paging; without limit, offset paging
+// returns everything.
+func parsePage(c *fiber.Ctx) (pageRequest, error) {
+	var page pageRequest
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return page, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		page.Limit = limit
+	}
+
+	if c.Context().QueryArgs().Has("cursor") {
+		if c.Query("offset") != "" {
+			return page, errors.New("offset cannot be combined with cursor")
+		}
+		page.Cursor = true
+		if page.Limit == 0 {
+			page.Limit = defaultPageLimit
+		}
+		if cursor := c.Query("cursor"); cursor != "" {
+			id, err := decodeCursor(cursor)
+			if err != nil {
+				return page, err
+			}
+			page.AfterID = id
+		}
+		return page, nil
+	}
+
+	if raw := c.Query("offset"); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return page, errors.New("offset must be a non-negative integer")
+		}
+		if page.Limit == 0 {
+			page.Limit = defaultPageLimit
+		}
+		page.Offset = offset
+	}
+	return page, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+
+	"gorm.io/gorm"
+)
+
+// CurrencyPeg describes a currency that is fixed (or tightly banded) against
+// another. Ratio is the number of units of the pegged currency per one unit
+// of Target.
+type CurrencyPeg struct {
+	Target string  `json:"target" xml:"target"`
+	Ratio  float64 `json:"ratio" xml:"ratio"`
+}
+
+// currencyPegs lists well-known hard pegs and currency boards
+var currencyPegs = map[string]CurrencyPeg{
+	// Euro
+	"XOF": {Target: "EUR", Ratio: 655.957},
+	"XAF": {Target: "EUR", Ratio: 655.957},
+	"XPF": {Target: "EUR", Ratio: 119.331742},
+	"KMF": {Target: "EUR", Ratio: 491.96775},
+	"BAM": {Target: "EUR", Ratio: 1.95583},
+	"BGN": {Target: "EUR", Ratio: 1.95583},
+	"CVE": {Target: "EUR", Ratio: 110.265},
+	"STN": {Target: "EUR", Ratio: 24.5},
+	"DKK": {Target: "EUR", Ratio: 7.46038},
+
+	// US dollar
+	"AED": {Target: "USD", Ratio: 3.6725},
+	"SAR": {Target: "USD", Ratio: 3.75},
+	"QAR": {Target: "USD", Ratio: 3.64},
+	"OMR": {Target: "USD", Ratio: 0.384497},
+	"BHD": {Target: "USD", Ratio: 0.376},
+	"JOD": {Target: "USD", Ratio: 0.709},
+	"HKD": {Target: "USD", Ratio: 7.8},
+	"BSD": {Target: "USD", Ratio: 1},
+	"BBD": {Target: "USD", Ratio: 2},
+	"BZD": {Target: "USD", Ratio: 2},
+	"BMD": {Target: "USD", Ratio: 1},
+	"PAB": {Target: "USD", Ratio: 1},
+	"XCD": {Target: "USD", Ratio: 2.7},
+	"AWG": {Target: "USD", Ratio: 1.79},
+	"ANG": {Target: "USD", Ratio: 1.79},
+	"DJF": {Target: "USD", Ratio: 177.721},
+	"ERN": {Target: "USD", Ratio: 15},
+	"KYD": {Target: "USD", Ratio: 0.833333},
+
+	// Other anchors
+	"LSL": {Target: "ZAR", Ratio: 1},
+	"NAD": {Target: "ZAR", Ratio: 1},
+	"SZL": {Target: "ZAR", Ratio: 1},
+	"BTN": {Target: "INR", Ratio: 1},
+	"NPR": {Target: "INR", Ratio: 1.6},
+	"FKP": {Target: "GBP", Ratio: 1},
+	"GIP": {Target: "GBP", Ratio: 1},
+	"SHP": {Target: "GBP", Ratio: 1},
+	"GGP": {Target: "GBP", Ratio: 1},
+	"JEP": {Target: "GBP", Ratio: 1},
+	"IMP": {Target: "GBP", Ratio: 1},
+	"FOK": {Target: "DKK", Ratio: 1},
+	"BND": {Target: "SGD", Ratio: 1},
+	"MOP": {Target: "HKD", Ratio: 1.03},
+	"TVD": {Target: "AUD", Ratio: 1},
+	"KID": {Target: "AUD", Ratio: 1},
+}
+
+// pegFor returns the peg for a currency code, or nil if it floats
+func pegFor(code string) *CurrencyPeg {
+	peg, ok := currencyPegs[code]
+	if !ok {
+		return nil
+	}
+	return &peg
+}
+
+// pegTolerance is the relative deviation from the implied peg rate still
+// treated as "holding"; banded pegs (HKD, DKK) move a little
+const pegTolerance = 0.02
+
+// rateHoldsPeg reports whether a USD-based rate for a pegged currency is
+// consistent with its peg given the target's rate. Movements in such rates
+// simply follow the anchor and are not anomalies in their own right.
+func rateHoldsPeg(code string, rate float64, rates map[string]float64) bool {
+	peg := pegFor(code)
+	if peg == nil {
+		return false
+	}
+	targetRate, ok := rates[peg.Target]
+	if peg.Target == "USD" {
+		targetRate, ok = 1, true
+	}
+	if !ok || targetRate <= 0 {
+		return false
+	}
+	implied := targetRate * peg.Ratio
+	return math.Abs(rate-implied)/implied <= pegTolerance
+}
+
+// AfterFind attaches peg metadata and the changes since the previous
+// refresh to loaded countries
+func (c *Country) AfterFind(tx *gorm.DB) error {
+	if c.CurrencyCode != nil {
+		c.CurrencyPeg = pegFor(*c.CurrencyCode)
+	}
+	c.ExchangeRateChangePct = changePct(c.PreviousExchangeRate, c.ExchangeRate)
+	c.EstimatedGDPChangePct = changePct(c.PreviousEstimatedGDP, c.EstimatedGDP)
+	return nil
+}
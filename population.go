@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// populationHistorySource labels rows imported from the World Bank
+// SP.POP.TOTL indicator (total population, UN WPP based)
+const populationHistorySource = "worldbank:SP.POP.TOTL"
+
+// worldBankAPIURL is the World Bank API root; override with
+// WORLD_BANK_API_URL
+var worldBankAPIURL = "https://api.worldbank.org/v2"
+
+// PopulationHistory is one yearly population figure for a country. Rows are
+// keyed by ISO 3166-1 alpha-2 code so they survive a country being deleted
+// and re-created by a refresh.
+type PopulationHistory struct {
+	ID          uint   `gorm:"primaryKey" json:"-"`
+	CountryCode string `gorm:"type:varchar(2);uniqueIndex:idx_population_history_code_year;not null" json:"-"`
+	Year        int    `gorm:"uniqueIndex:idx_population_history_code_year;not null" json:"year"`
+	Population  int64  `gorm:"not null" json:"population"`
+	Source      string `gorm:"type:varchar(50);not null" json:"-"`
+}
+
+func (PopulationHistory) TableName() string {
+	return "population_history"
+}
+
+// worldBankPage is the metadata element leading every World Bank response
+type worldBankPage struct {
+	Page  int `json:"page"`
+	Pages int `json:"pages"`
+}
+
+// worldBankObservation is one country-year value of an indicator
+type worldBankObservation struct {
+	Country struct {
+		ID string `json:"id"`
+	} `json:"country"`
+	Date  string   `json:"date"`
+	Value *float64 `json:"value"`
+}
+
+// fetchPopulationHistory downloads every country's yearly population
+func fetchPopulationHistory() ([]worldBankObservation, error) {
+	return fetchWorldBankIndicator("SP.POP.TOTL", "")
+}
+
+// fetchWorldBankIndicator downloads an indicator for every country,
+// following the API's paging. query adds parameters such as mrnev=1.
+func fetchWorldBankIndicator(indicator, query string) ([]worldBankObservation, error) {
+	var all []worldBankObservation
+	for page, pages := 1, 1; page <= pages; page++ {
+		url := fmt.Sprintf("%s/country/all/indicator/%s?format=json&per_page=20000&page=%d%s", worldBankAPIURL, indicator, page, query)
+		ctx, cancel := context.WithTimeout(context.Background(), upstreamTimeout)
+		body, err := fetchPayload(ctx, url)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+
+		var parts []json.RawMessage
+		if err := json.Unmarshal(body, &parts); err != nil || len(parts) < 2 {
+			return nil, fmt.Errorf("unexpected World Bank response: %.200s", body)
+		}
+		var meta worldBankPage
+		if err := json.Unmarshal(parts[0], &meta); err != nil {
+			return nil, err
+		}
+		var observations []worldBankObservation
+		if err := json.Unmarshal(parts[1], &observations); err != nil {
+			return nil, err
+		}
+		all = append(all, observations...)
+		pages = meta.Pages
+	}
+	return all, nil
+}
+
+// importPopulationHistory stores the observations for countries we hold,
+// skipping aggregates (regions, income groups) and missing values. Existing
+// country-years are overwritten, so re-running an import is safe.
+func importPopulationHistory(observations []worldBankObservation) (int, error) {
+	var countries []Country
+	if err := db.Select("flag_url").Find(&countries).Error; err != nil {
+		return 0, err
+	}
+	known := make(map[string]bool, len(countries))
+	for _, country := range countries {
+		if code := flagCode(country.FlagURL); code != "" {
+			known[strings.ToUpper(code)] = true
+		}
+	}
+
+	rows := make([]PopulationHistory, 0, len(observations))
+	for _, obs := range observations {
+		year, err := strconv.Atoi(obs.Date)
+		if err != nil || obs.Value == nil || !known[obs.Country.ID] {
+			continue
+		}
+		rows = append(rows, PopulationHistory{
+			CountryCode: obs.Country.ID,
+			Year:        year,
+			Population:  int64(*obs.Value),
+			Source:      populationHistorySource,
+		})
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "country_code"}, {Name: "year"}},
+			DoUpdates: clause.AssignmentColumns([]string{"population", "source"}),
+		}).CreateInBatches(rows, refreshBatchSize).Error
+	})
+	return len(rows), err
+}
+
+func importPopulationHistoryHandler(c *fiber.Ctx) error {
+	observations, err := fetchPopulationHistory()
+	if err != nil {
+		return c.Status(503).JSON(fiber.Map{
+			"error":   "External data source unavailable",
+			"details": (&upstreamError{source: "World Bank API", err: err}).Error(),
+		})
+	}
+
+	imported, err := importPopulationHistory(observations)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"message":  "Population history imported",
+		"imported": imported,
+		"source":   populationHistorySource,
+	})
+}
+
+// yearBounds reads the optional ?from= and ?to= years
+func yearBounds(c *fiber.Ctx) (from, to *int, err error) {
+	parse := func(param string) (*int, error) {
+		raw := c.Query(param)
+		if raw == "" {
+			return nil, nil
+		}
+		year, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a year", param)
+		}
+		return &year, nil
+	}
+	if from, err = parse("from"); err != nil {
+		return nil, nil, err
+	}
+	if to, err = parse("to"); err != nil {
+		return nil, nil, err
+	}
+	return from, to, nil
+}
+
+func getPopulationHistory(c *fiber.Ctx) error {
+	from, to, err := yearBounds(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	country, err := findCountry(c.Params("name"))
+	if err != nil {
+		return err
+	}
+
+	// Countries without a flagcdn flag have no code to match on
+	history := []PopulationHistory{}
+	if code := flagCode(country.FlagURL); code != "" {
+		query := db.Where("country_code = ?", strings.ToUpper(code))
+		if from != nil {
+			query = query.Where("year >= ?", *from)
+		}
+		if to != nil {
+			query = query.Where("year <= ?", *to)
+		}
+		if err := query.Order("year ASC").Find(&history).Error; err != nil {
+			return err
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"name":    country.Name,
+		"source":  populationHistorySource,
+		"history": history,
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// proxyUpstream is an upstream payload re-served under /proxy/:upstream
+type proxyUpstream struct {
+	fetch    func(ctx context.Context) ([]byte, error)
+	validate func([]byte) error
+	ttl      time.Duration
+
+	// fetchMu collapses concurrent misses into one upstream call
+	fetchMu sync.Mutex
+}
+
+// proxyUpstreams are keyed by their /proxy path segment. TTLs can be
+// overridden with PROXY_COUNTRIES_TTL and PROXY_RATES_TTL.
+var proxyUpstreams = map[string]*proxyUpstream{
+	"countries": {
+		fetch:    func(ctx context.Context) ([]byte, error) { return fetchPayload(ctx, countriesAPIURL) },
+		validate: validateCountriesPayload,
+		ttl:      24 * time.Hour,
+	},
+	"rates": {
+		fetch:    func(ctx context.Context) ([]byte, error) { return rateProvider.Fetch(ctx) },
+		validate: validateRatesPayload,
+		ttl:      time.Hour,
+	},
+}
+
+// proxyCache holds proxied payloads. It is separate from the response cache
+// so data changes do not purge it.
+var proxyCache responseCache
+
+// proxyStats counts proxy hits, misses and upstream calls per upstream for
+// /metrics
+var proxyStats = struct {
+	sync.Mutex
+	requests map[string]map[string]int64
+}{requests: map[string]map[string]int64{}}
+
+func countProxyRequest(upstream, result string) {
+	proxyStats.Lock()
+	defer proxyStats.Unlock()
+	if proxyStats.requests[upstream] == nil {
+		proxyStats.requests[upstream] = map[string]int64{}
+	}
+	proxyStats.requests[upstream][result]++
+}
+
+// initProxy sets up the proxy cache, shared through Redis when
+// CACHE_ENGINE=redis so every instance reuses one upstream fetch
+func initProxy() error {
+	for key, upstream := range map[string]*proxyUpstream{
+		"PROXY_COUNTRIES_TTL": proxyUpstreams["countries"],
+		"PROXY_RATES_TTL":     proxyUpstreams["rates"],
+	} {
+		ttl, err := parseInterval(key)
+		if err != nil {
+			return err
+		}
+		if ttl > 0 {
+			upstream.ttl = ttl
+		}
+	}
+
+	if getEnv("CACHE_ENGINE", "") == "redis" {
+		client, err := redisClient()
+		if err != nil {
+			return err
+		}
+		proxyCache = &redisCache{client: client, prefix: getEnv("REDIS_PROXY_PREFIX", "proxy")}
+		return nil
+	}
+	proxyCache = &memoryCache{entries: map[string]memoryEntry{}}
+	return nil
+}
+
+// getProxied serves an upstream payload byte for byte, fetching it at most
+// once per TTL. Rates come from the configured RATES_PROVIDER in the
+// er-api shape. Other instances can point COUNTRIES_API_URL or
+// EXCHANGE_RATES_API_URL here.
+func getProxied(c *fiber.Ctx) error {
+	name := c.Params("upstream")
+	upstream, ok := proxyUpstreams[name]
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "Unknown upstream")
+	}
+
+	resp, hit, err := upstream.get(name)
+	if err != nil {
+		countProxyRequest(name, "error")
+		return c.Status(503).JSON(fiber.Map{
+			"error":   "External data source unavailable",
+			"details": (&upstreamError{source: name + " upstream", err: err}).Error(),
+		})
+	}
+
+	if hit {
+		countProxyRequest(name, "hit")
+		c.Set("X-Cache", "HIT")
+	} else {
+		countProxyRequest(name, "miss")
+		c.Set("X-Cache", "MISS")
+	}
+	c.Set(fiber.HeaderContentType, resp.ContentType)
+	c.Set(fiber.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", int(upstream.ttl.Seconds())))
+	return c.Send(resp.Body)
+}
+
+// get returns the cached payload, fetching and caching it on a miss.
+// Invalid payloads are never cached.
+func (u *proxyUpstream) get(name string) (*cachedResponse, bool, error) {
+	if resp, ok := proxyCache.get(name); ok {
+		return resp, true, nil
+	}
+
+	u.fetchMu.Lock()
+	defer u.fetchMu.Unlock()
+	if resp, ok := proxyCache.get(name); ok {
+		return resp, true, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), upstreamTimeout)
+	defer cancel()
+	body, err := u.fetch(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := u.validate(body); err != nil {
+		return nil, false, err
+	}
+
+	resp := cachedResponse{ContentType: fiber.MIMEApplicationJSON, Body: body}
+	proxyCache.set(name, resp, u.ttl)
+	return &resp, false, nil
+}
+
+// writeProxyMetrics appends the proxy counters to a /metrics response
+func writeProxyMetrics(b *strings.Builder) {
+	proxyStats.Lock()
+	defer proxyStats.Unlock()
+	if len(proxyStats.requests) == 0 {
+		return
+	}
+
+	b.WriteString("# TYPE proxy_requests counter\n# HELP proxy_requests Requests to /proxy by upstream and result (hit, miss, error).\n")
+	for _, upstream := range []string{"countries", "rates"} {
+		for _, result := range []string{"hit", "miss", "error"} {
+			if n, ok := proxyStats.requests[upstream][result]; ok {
+				fmt.Fprintf(b, "proxy_requests_total{upstream=\"%s\",result=\"%s\"} %d\n", upstream, result, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Rate chart layout, in logical (unscaled) pixels
+const (
+	chartDefaultWidth  = 800
+	chartDefaultHeight = 400
+	chartMinHeight     = 200
+	chartTitleHeight   = 40
+	chartAxisGap       = 8
+	chartMarkerSize    = 3
+)
+
+// chartDefaultWindow and chartMaxWindow bound ?window
+const (
+	chartDefaultWindow = 90 * 24 * time.Hour
+	chartMaxWindow     = 3650 * 24 * time.Hour
+)
+
+var currencyCodePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// parseChartWindow reads a window such as "90d", "12w" or "36h"
+func parseChartWindow(raw string) (time.Duration, error) {
+	if raw == "" {
+		return chartDefaultWindow, nil
+	}
+	invalid := fmt.Errorf("window must be a duration such as 90d, 12w or 36h, up to %dd", int(chartMaxWindow.Hours()/24))
+
+	window, ok := parseSpan(raw)
+	if !ok || window <= 0 || window > chartMaxWindow {
+		return 0, invalid
+	}
+	return window, nil
+}
+
+// parseSpan reads a span in days ("90d"), weeks ("12w") or as a Go duration
+// ("36h")
+func parseSpan(raw string) (time.Duration, bool) {
+	if raw == "" {
+		return 0, false
+	}
+	if n, unit := raw[:len(raw)-1], raw[len(raw)-1]; unit == 'd' || unit == 'w' {
+		count, err := strconv.Atoi(n)
+		if err != nil {
+			return 0, false
+		}
+		span := time.Duration(count) * 24 * time.Hour
+		if unit == 'w' {
+			span *= 7
+		}
+		return span, true
+	}
+	d, err := time.ParseDuration(raw)
+	return d, err == nil
+}
+
+// getRateChart renders the stored history of a currency's USD rate as a
+// PNG line chart
+func getRateChart(c *fiber.Ctx) error {
+	code := strings.ToUpper(c.Params("code"))
+	if !currencyCodePattern.MatchString(code) {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": "code must be a three-letter currency code",
+		})
+	}
+	window, err := parseChartWindow(c.Query("window"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+	opts, _, err := parseImageOptions(c, summaryImageOptions{
+		Width:    chartDefaultWidth,
+		Height:   chartDefaultHeight,
+		Scale:    1,
+		Location: time.UTC,
+	})
+	if err == nil && opts.Height < chartMinHeight {
+		err = fmt.Errorf("height must be at least %d", chartMinHeight)
+	}
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	var history []RateHistory
+	if err := db.WithContext(c.UserContext()).
+		Where("currency_code = ? AND recorded_at >= ?", code, clock.Now().Add(-window)).
+		Order("recorded_at ASC").Find(&history).Error; err != nil {
+		return err
+	}
+	if len(history) == 0 {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Rate history not found",
+		})
+	}
+
+	title := fmt.Sprintf("%s per USD, last %s", code, c.Query("window", "90d"))
+	img := renderRateChart(title, history, opts)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, "image/png")
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.Send(buf.Bytes())
+}
+
+// renderRateChart draws the series with value gridlines at its minimum,
+// midpoint and maximum, dates along the time axis and markers labelling
+// the lowest and highest rate
+func renderRateChart(title string, history []RateHistory, opts summaryImageOptions) image.Image {
+	face := basicfont.Face7x13
+	theme := currentTheme()
+	img := image.NewRGBA(image.Rect(0, 0, opts.Width, opts.Height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{theme.Background}, image.Point{}, draw.Src)
+
+	// Value range, padded so the line does not touch the frame
+	lo, hi := history[0].Rate, history[0].Rate
+	minAt, maxAt := 0, 0
+	for i, point := range history {
+		if point.Rate < lo {
+			lo, minAt = point.Rate, i
+		}
+		if point.Rate > hi {
+			hi, maxAt = point.Rate, i
+		}
+	}
+	pad := (hi - lo) * 0.1
+	if pad == 0 {
+		pad = math.Max(math.Abs(hi)*0.01, 0.0001)
+	}
+	bottom, top := lo-pad, hi+pad
+
+	ticks := []float64{lo, (lo + hi) / 2, hi}
+	if lo == hi {
+		ticks = ticks[:1]
+	}
+	labelWidth := 0
+	for _, tick := range ticks {
+		labelWidth = max(labelWidth, len(formatRate(tick))*face.Advance)
+	}
+
+	plot := image.Rect(imageMargin+labelWidth+chartAxisGap, imageMargin+chartTitleHeight,
+		opts.Width-imageMargin, opts.Height-imageMargin-face.Height-chartAxisGap)
+
+	first, last := history[0].RecordedAt, history[len(history)-1].RecordedAt
+	span := last.Sub(first)
+	valueY := func(v float64) int {
+		return plot.Max.Y - int(float64(plot.Dy())*(v-bottom)/(top-bottom))
+	}
+	pointAt := func(i int) image.Point {
+		x := plot.Min.X + plot.Dx()/2
+		if span > 0 {
+			x = plot.Min.X + int(float64(plot.Dx())*float64(history[i].RecordedAt.Sub(first))/float64(span))
+		}
+		return image.Pt(x, valueY(history[i].Rate))
+	}
+
+	addLabel(img, fixed.P(imageMargin, imageMargin+face.Ascent), title, theme.Text)
+
+	// Gridlines and value labels
+	grid := color.NRGBA{theme.Muted.R, theme.Muted.G, theme.Muted.B, 80}
+	for _, tick := range ticks {
+		y := valueY(tick)
+		for x := plot.Min.X; x < plot.Max.X; x += 4 {
+			blend(img, x, y, grid)
+			blend(img, x+1, y, grid)
+		}
+		label := formatRate(tick)
+		addLabel(img, fixed.P(plot.Min.X-chartAxisGap-len(label)*face.Advance, y+face.Ascent/2), label, theme.Muted)
+	}
+
+	// Axes
+	drawLine(img, plot.Min.X, plot.Min.Y, plot.Min.X, plot.Max.Y, theme.Muted)
+	drawLine(img, plot.Min.X, plot.Max.Y, plot.Max.X, plot.Max.Y, theme.Muted)
+
+	// Dates at both ends of the time axis, and the middle when there is
+	// room; a single observation gets one centred date
+	type axisDate struct {
+		at    time.Time
+		align float64
+	}
+	dateY := plot.Max.Y + chartAxisGap + face.Ascent
+	dates := []axisDate{{first, 0.5}}
+	if span > 0 {
+		dates = []axisDate{{first, 0}, {last, 1}}
+		if plot.Dx() > 30*face.Advance {
+			dates = append(dates, axisDate{first.Add(span / 2), 0.5})
+		}
+	}
+	for _, date := range dates {
+		label := date.at.In(opts.Location).Format("2006-01-02")
+		x := plot.Min.X + int(float64(plot.Dx())*date.align) - int(float64(len(label)*face.Advance)*date.align)
+		addLabel(img, fixed.P(x, dateY), label, theme.Muted)
+	}
+
+	// The series, two pixels thick
+	prev := pointAt(0)
+	for i := 1; i < len(history); i++ {
+		next := pointAt(i)
+		drawLine(img, prev.X, prev.Y, next.X, next.Y, theme.Accent)
+		drawLine(img, prev.X, prev.Y+1, next.X, next.Y+1, theme.Accent)
+		prev = next
+	}
+
+	// Min and max annotations, kept inside the plot
+	annotate := func(i int, prefix string, above bool) {
+		p := pointAt(i)
+		marker := image.Rect(p.X-chartMarkerSize, p.Y-chartMarkerSize, p.X+chartMarkerSize+1, p.Y+chartMarkerSize+1)
+		draw.Draw(img, marker, &image.Uniform{theme.Text}, image.Point{}, draw.Src)
+
+		label := prefix + " " + formatRate(history[i].Rate)
+		width := len(label) * face.Advance
+		x := min(max(p.X-width/2, plot.Min.X+2), plot.Max.X-width-2)
+		y := p.Y + chartMarkerSize + chartAxisGap + face.Ascent
+		if above {
+			y = p.Y - chartMarkerSize - chartAxisGap
+		}
+		y = min(max(y, plot.Min.Y+face.Ascent), plot.Max.Y-face.Descent)
+		addLabel(img, fixed.P(x, y), label, theme.Text)
+	}
+	if minAt != maxAt {
+		annotate(minAt, "min", false)
+	}
+	annotate(maxAt, "max", true)
+
+	return upscaleImage(img, opts.Scale)
+}
+
+// drawLine draws a one-pixel line between two points (Bresenham)
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, col color.Color) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	err := dx + dy
+	for {
+		img.Set(x0, y0, col)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+// blend draws a translucent pixel over the existing one
+func blend(img *image.RGBA, x, y int, col color.Color) {
+	rect := image.Rect(x, y, x+1, y+1)
+	draw.Draw(img, rect, &image.Uniform{col}, image.Point{}, draw.Over)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
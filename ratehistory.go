@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// RateHistory is one exchange rate observation, kept per refresh so rate
+// movements can be reviewed after the countries table is overwritten
+type RateHistory struct {
+	ID           uint      `gorm:"primaryKey" json:"-"`
+	CurrencyCode string    `gorm:"type:varchar(10);index:idx_rate_history_code_time;not null" json:"currency_code"`
+	Rate         float64   `gorm:"not null" json:"rate"`
+	RecordedAt   time.Time `gorm:"index:idx_rate_history_code_time;not null" json:"recorded_at"`
+}
+
+// recordRateHistory stores every fetched USD-based rate at the time the
+// provider updated it. Rates fetched again before the provider's next
+// update are already recorded and skipped.
+func recordRateHistory(tx *gorm.DB, rates map[string]float64, at time.Time) error {
+	if len(rates) == 0 {
+		return nil
+	}
+	// Any one code tells, through the (code, time) index
+	var probe string
+	for code := range rates {
+		probe = code
+		break
+	}
+	var recorded int64
+	if err := tx.Model(&RateHistory{}).Where("currency_code = ? AND recorded_at = ?", probe, at).
+		Count(&recorded).Error; err != nil {
+		return err
+	}
+	if recorded > 0 {
+		return nil
+	}
+	rows := make([]RateHistory, 0, len(rates))
+	for code, rate := range rates {
+		rows = append(rows, RateHistory{CurrencyCode: code, Rate: rate, RecordedAt: at})
+	}
+	return tx.CreateInBatches(rows, refreshBatchSize).Error
+}
+
+// getCurrencyRates lists the stored USD rates of one currency as a time
+// series, oldest first
+func getCurrencyRates(c *fiber.Ctx) error {
+	meta := newListMeta(c, "from", "to", "limit", "offset")
+	meta.Sort = "recorded_at_asc"
+
+	code := strings.ToUpper(c.Params("code"))
+	page, err := parsePage(c)
+	if err == nil && page.Cursor {
+		err = errors.New("cursor paging is not supported; use limit and offset")
+	}
+	if err == nil && !currencyCodePattern.MatchString(code) {
+		err = errors.New("code must be a three-letter currency code")
+	}
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+	if page.Limit == 0 {
+		page.Limit = defaultPageLimit
+	}
+
+	query := db.WithContext(c.UserContext()).Model(&RateHistory{}).Where("currency_code = ?", code)
+	var bounds [2]time.Time
+	for i, bound := range []struct{ param, clause string }{
+		{"from", "recorded_at >= ?"},
+		{"to", "recorded_at <= ?"},
+	} {
+		raw := c.Query(bound.param)
+		if raw == "" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "Validation failed",
+				"details": bound.param + " must be an RFC3339 timestamp",
+			})
+		}
+		bounds[i] = at.UTC()
+		query = query.Where(bound.clause, bounds[i])
+		meta.filter(bound.param, bounds[i].Format(time.RFC3339))
+	}
+	if !bounds[0].IsZero() && !bounds[1].IsZero() && bounds[1].Before(bounds[0]) {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": "to must not be before from",
+		})
+	}
+
+	if err := query.Count(&meta.Total).Error; err != nil {
+		return err
+	}
+	if meta.Total == 0 {
+		// A currency never fetched is a 404; a known one may just have no
+		// rates in the range
+		var known int64
+		if err := db.WithContext(c.UserContext()).Model(&RateHistory{}).
+			Where("currency_code = ?", code).Limit(1).Count(&known).Error; err != nil {
+			return err
+		}
+		if known == 0 {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Rate history not found",
+			})
+		}
+	}
+
+	rates := []RateHistory{}
+	if err := query.Order("recorded_at ASC, id ASC").
+		Limit(page.Limit).Offset(page.Offset).Find(&rates).Error; err != nil {
+		return err
+	}
+	meta.Page = pageMetaFor(page, "")
+	return sendList(c, rates, meta)
+}
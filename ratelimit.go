@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// rateBudget is a number of requests allowed per fixed window. The global
+// budget comes from RATE_LIMIT; route budgets from RATE_LIMIT_ROUTES.
+type rateBudget struct {
+	// name identifies the budget's counters, e.g. "POST /countries/refresh"
+	name    string
+	method  string
+	path    string
+	prefix  bool
+	limit   int
+	window  time.Duration
+	enforce bool
+}
+
+// matches reports whether a request falls under a route budget; an empty
+// path matches every path, and a trailing * matches by prefix
+func (b *rateBudget) matches(method, path string) bool {
+	if b.method != method {
+		return false
+	}
+	switch {
+	case b.path == "":
+		return true
+	case b.prefix:
+		return strings.HasPrefix(path, b.path)
+	}
+	return path == b.path
+}
+
+// specificity orders matching route budgets: exact paths beat prefixes,
+// longer prefixes beat shorter ones, and method-only rules come last
+func (b *rateBudget) specificity() int {
+	switch {
+	case b.path == "":
+		return 0
+	case b.prefix:
+		return 1 + len(b.path)
+	}
+	return 1 << 20
+}
+
+// rateCounter counts hits per key within a window
+type rateCounter interface {
+	hit(key string, window time.Duration, now time.Time) (int, error)
+}
+
+// memoryCounter keeps counters per process, dropping a budget's counters
+// when its window rolls over
+type memoryCounter struct {
+	mu     sync.Mutex
+	starts map[string]time.Time
+	counts map[string]map[string]int
+}
+
+func (m *memoryCounter) hit(key string, window time.Duration, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	budget, _, _ := strings.Cut(key, "|")
+	start := now.Truncate(window)
+	if !start.Equal(m.starts[budget]) {
+		m.starts[budget] = start
+		m.counts[budget] = map[string]int{}
+	}
+	m.counts[budget][key]++
+	return m.counts[budget][key], nil
+}
+
+// redisCounter shares counters across instances. Each window gets its own
+// key, which expires with the window.
+type redisCounter struct {
+	client *redis.Client
+	prefix string
+}
+
+func (r *redisCounter) hit(key string, window time.Duration, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	k := fmt.Sprintf("%s:%s:%d", r.prefix, key, now.Truncate(window).Unix())
+	pipe := r.client.TxPipeline()
+	incr := pipe.Incr(ctx, k)
+	pipe.Expire(ctx, k, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return int(incr.Val()), nil
+}
+
+// rateLimiter applies the route budget matching a request, or else the
+// global budget
+type rateLimiter struct {
+	global  *rateBudget
+	routes  []*rateBudget
+	counter rateCounter
+}
+
+// loadRateLimiter reads RATE_LIMIT (requests per window; unset or 0
+// disables), RATE_LIMIT_WINDOW (Go duration, default 1m),
+// RATE_LIMIT_ENFORCE, RATE_LIMIT_ROUTES and RATE_LIMIT_STORE. Without
+// enforcement the global budget's headers are advisory only; route budgets
+// are always enforced.
+func loadRateLimiter() (*rateLimiter, error) {
+	l := &rateLimiter{}
+
+	if limit := getEnvInt("RATE_LIMIT", 0); limit > 0 {
+		window := time.Minute
+		if raw := os.Getenv("RATE_LIMIT_WINDOW"); raw != "" {
+			d, err := time.ParseDuration(raw)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid RATE_LIMIT_WINDOW %q", raw)
+			}
+			window = d
+		}
+		l.global = &rateBudget{
+			name:    "global",
+			limit:   limit,
+			window:  window,
+			enforce: os.Getenv("RATE_LIMIT_ENFORCE") == "true",
+		}
+	}
+
+	routes, err := parseRouteBudgets(os.Getenv("RATE_LIMIT_ROUTES"))
+	if err != nil {
+		return nil, err
+	}
+	l.routes = routes
+
+	if l.global == nil && len(l.routes) == 0 {
+		return nil, nil
+	}
+
+	switch store := getEnv("RATE_LIMIT_STORE", "memory"); store {
+	case "memory":
+		l.counter = &memoryCounter{starts: map[string]time.Time{}, counts: map[string]map[string]int{}}
+	case "redis":
+		client, err := redisClient()
+		if err != nil {
+			return nil, err
+		}
+		l.counter = &redisCounter{client: client, prefix: getEnv("REDIS_RATE_LIMIT_PREFIX", "ratelimit")}
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_STORE %q (expected memory or redis)", store)
+	}
+	return l, nil
+}
+
+// parseRouteBudgets reads semicolon-separated "METHOD [/path[*]]=N/window"
+// rules, e.g. "POST /countries/refresh=1/1m;GET=600/1m"
+func parseRouteBudgets(raw string) ([]*rateBudget, error) {
+	var budgets []*rateBudget
+	for _, rule := range strings.Split(raw, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		invalid := fmt.Errorf("invalid RATE_LIMIT_ROUTES rule %q (expected METHOD [/path]=N/window)", rule)
+
+		route, budget, ok := strings.Cut(rule, "=")
+		if !ok {
+			return nil, invalid
+		}
+		fields := strings.Fields(route)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, invalid
+		}
+		b := &rateBudget{name: strings.Join(fields, " "), method: strings.ToUpper(fields[0]), enforce: true}
+		if len(fields) == 2 {
+			if !strings.HasPrefix(fields[1], "/") {
+				return nil, invalid
+			}
+			b.path, b.prefix = strings.CutSuffix(fields[1], "*")
+		}
+
+		count, window, ok := strings.Cut(strings.TrimSpace(budget), "/")
+		limit, err := strconv.Atoi(count)
+		if !ok || err != nil || limit <= 0 {
+			return nil, invalid
+		}
+		d, err := time.ParseDuration(window)
+		if err != nil || d <= 0 {
+			return nil, invalid
+		}
+		b.limit, b.window = limit, d
+		budgets = append(budgets, b)
+	}
+	return budgets, nil
+}
+
+// budgetFor picks the most specific route budget for a request, falling
+// back to the global budget; nil means the request is not limited
+func (l *rateLimiter) budgetFor(method, path string) *rateBudget {
+	var best *rateBudget
+	for _, b := range l.routes {
+		if b.matches(method, path) && (best == nil || b.specificity() > best.specificity()) {
+			best = b
+		}
+	}
+	if best == nil {
+		return l.global
+	}
+	return best
+}
+
+// rateLimitKey identifies the client: the principal its API key or bearer
+// token authenticates as, otherwise its address. Credentials that fail
+// validation count against the address, so made-up keys cannot buy a
+// fresh budget.
+func rateLimitKey(c *fiber.Ctx) string {
+	if p, err := authenticateOnce(c); err == nil {
+		return "principal:" + p.Actor
+	}
+	return "ip:" + clientIP(c)
+}
+
+// middleware sets X-RateLimit-Limit, X-RateLimit-Remaining and
+// X-RateLimit-Reset (Unix seconds) for the budget a request falls under,
+// and answers 429 with Retry-After once an enforced budget is spent. If the
+// counter store fails the request is let through. Windows use wall time,
+// not the pinnable clock, so they still roll over under FIXED_TIME.
+func (l *rateLimiter) middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		b := l.budgetFor(c.Method(), c.Path())
+		if b == nil {
+			return c.Next()
+		}
+
+		now := time.Now()
+		count, err := l.counter.hit(b.name+"|"+rateLimitKey(c), b.window, now)
+		if err != nil {
+			log.Printf("Rate limit counter failed for %s: %v", b.name, err)
+			return c.Next()
+		}
+		remaining := b.limit - count
+		reset := now.Truncate(b.window).Add(b.window)
+
+		c.Set("X-RateLimit-Limit", strconv.Itoa(b.limit))
+		c.Set("X-RateLimit-Remaining", strconv.Itoa(max(remaining, 0)))
+		c.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+
+		if b.enforce && remaining < 0 {
+			retry := int(reset.Sub(now).Seconds()) + 1
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retry))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error":   "Rate limit exceeded",
+				"details": fmt.Sprintf("%s allows %d requests per %s; retry in %ds", b.name, b.limit, b.window, retry),
+			})
+		}
+		return c.Next()
+	}
+}
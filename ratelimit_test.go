@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryCounterWindows(t *testing.T) {
+	start := time.Date(2025, 10, 22, 18, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		key  string
+		at   time.Duration // after start
+		want int
+	}{
+		{"first hit", "global|ip:1.2.3.4", 0, 1},
+		{"same window", "global|ip:1.2.3.4", 30 * time.Second, 2},
+		{"other client", "global|ip:5.6.7.8", 40 * time.Second, 1},
+		{"other budget", "GET|ip:1.2.3.4", 45 * time.Second, 1},
+		{"end of window", "global|ip:1.2.3.4", time.Minute - time.Nanosecond, 3},
+		{"next window", "global|ip:1.2.3.4", time.Minute, 1},
+		{"next window, other client", "global|ip:5.6.7.8", 90 * time.Second, 1},
+		{"later window", "global|ip:1.2.3.4", 10 * time.Minute, 1},
+	}
+
+	counter := &memoryCounter{starts: map[string]time.Time{}, counts: map[string]map[string]int{}}
+	for _, tt := range tests {
+		got, err := counter.hit(tt.key, time.Minute, start.Add(tt.at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: hit(%s) = %d, want %d", tt.name, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestParseRouteBudgets(t *testing.T) {
+	budgets, err := parseRouteBudgets("POST /countries/refresh=1/1m; GET /countries/*=300/1m;get=600/30s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []rateBudget{
+		{name: "POST /countries/refresh", method: "POST", path: "/countries/refresh", limit: 1, window: time.Minute, enforce: true},
+		{name: "GET /countries/*", method: "GET", path: "/countries/", prefix: true, limit: 300, window: time.Minute, enforce: true},
+		{name: "get", method: "GET", limit: 600, window: 30 * time.Second, enforce: true},
+	}
+	if len(budgets) != len(want) {
+		t.Fatalf("parsed %d budgets, want %d", len(budgets), len(want))
+	}
+	for i, b := range budgets {
+		if *b != want[i] {
+			t.Errorf("budget %d = %+v, want %+v", i, *b, want[i])
+		}
+	}
+
+	for _, raw := range []string{
+		"POST /countries/refresh",
+		"POST countries=1/1m",
+		"POST /a /b=1/1m",
+		"=1/1m",
+		"GET=0/1m",
+		"GET=ten/1m",
+		"GET=10",
+		"GET=10/0s",
+		"GET=10/soon",
+	} {
+		if _, err := parseRouteBudgets(raw); err == nil {
+			t.Errorf("parseRouteBudgets(%q) accepted an invalid rule", raw)
+		}
+	}
+}
+
+func TestBudgetFor(t *testing.T) {
+	global := &rateBudget{name: "global"}
+	routes, err := parseRouteBudgets("GET=600/1m;GET /countries/*=300/1m;GET /countries/image*=10/1m;GET /countries/image=5/1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &rateLimiter{global: global, routes: routes}
+
+	tests := []struct {
+		method, path string
+		want         string
+	}{
+		{"GET", "/countries/image", "GET /countries/image"},
+		{"GET", "/countries/image/check", "GET /countries/image*"},
+		{"GET", "/countries/Nigeria", "GET /countries/*"},
+		{"GET", "/status", "GET"},
+		{"POST", "/countries/refresh", "global"},
+	}
+	for _, tt := range tests {
+		if got := l.budgetFor(tt.method, tt.path); got.name != tt.want {
+			t.Errorf("budgetFor(%s %s) = %s, want %s", tt.method, tt.path, got.name, tt.want)
+		}
+	}
+
+	if got := (&rateLimiter{routes: routes}).budgetFor("POST", "/countries"); got != nil {
+		t.Errorf("budgetFor without a global budget = %s, want nil", got.name)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RateProvider is a source of USD exchange rates. Fetch returns the rates in
+// the open.er-api shape ({"rates": {"NGN": 1600.2, ...}}) whatever the
+// provider's own format, so archives, replays and the proxy stay
+// provider-agnostic.
+type RateProvider interface {
+	Name() string
+	Fetch(ctx context.Context) ([]byte, error)
+}
+
+// Names accepted by RATES_PROVIDER
+const (
+	rateProviderERAPI   = "er-api"
+	rateProviderHost    = "exchangerate.host"
+	rateProviderFixture = "file"
+)
+
+// exchangerateHostURL is the exchangerate.host live endpoint; override with
+// EXCHANGERATE_HOST_URL
+var exchangerateHostURL = "https://api.exchangerate.host/live"
+
+// rateProvider is the provider refreshes use; see loadRateProvider
+var rateProvider RateProvider = erAPIRates{}
+
+// erAPIRates reads open.er-api, or any service with the same response, from
+// EXCHANGE_RATES_API_URL
+type erAPIRates struct{}
+
+func (erAPIRates) Name() string { return rateProviderERAPI }
+
+func (erAPIRates) Fetch(ctx context.Context) ([]byte, error) {
+	return fetchPayload(ctx, exchangeRatesAPIURL)
+}
+
+// hostRates reads exchangerate.host, which quotes pairs such as "USDNGN".
+// The access key is read on every fetch so a rotated key applies at once.
+type hostRates struct{}
+
+func (hostRates) Name() string { return rateProviderHost }
+
+func (hostRates) Fetch(ctx context.Context) ([]byte, error) {
+	url := exchangerateHostURL + "?source=USD"
+	if accessKey := credential("EXCHANGERATE_HOST_ACCESS_KEY"); accessKey != "" {
+		url += "&access_key=" + accessKey
+	}
+	body, err := fetchPayload(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Success   bool               `json:"success"`
+		Source    string             `json:"source"`
+		Timestamp int64              `json:"timestamp"`
+		Quotes    map[string]float64 `json:"quotes"`
+		Error     *struct {
+			Code int    `json:"code"`
+			Info string `json:"info"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, &schemaError{problems: []string{"expected a JSON object"}, sample: truncate(body)}
+	}
+	if !resp.Success {
+		if resp.Error != nil {
+			return nil, fmt.Errorf("exchangerate.host error %d: %s", resp.Error.Code, resp.Error.Info)
+		}
+		return nil, fmt.Errorf("exchangerate.host request failed: %s", truncate(body))
+	}
+
+	rates := map[string]float64{"USD": 1}
+	for pair, rate := range resp.Quotes {
+		if code := strings.TrimPrefix(pair, "USD"); len(code) == 3 && len(pair) == 6 {
+			rates[code] = rate
+		}
+	}
+	converted := ExchangeRateResponse{Rates: rates}
+	if resp.Timestamp > 0 {
+		converted.TimeLastUpdateUnix = resp.Timestamp
+		converted.TimeLastUpdateUTC = time.Unix(resp.Timestamp, 0).UTC().Format(time.RFC1123Z)
+	}
+	return json.Marshal(converted)
+}
+
+// fileRates reads an er-api shaped payload from RATES_FILE, or the bundled
+// fixture when it is unset. Useful offline or while every live source is
+// rate-limiting us.
+type fileRates struct {
+	path string
+}
+
+func (fileRates) Name() string { return rateProviderFixture }
+
+func (p fileRates) Fetch(ctx context.Context) ([]byte, error) {
+	if p.path == "" {
+		return fixtures.ReadFile("fixtures/rates.json")
+	}
+	return os.ReadFile(p.path)
+}
+
+// ratesAsOf is when the provider last updated the rates of an er-api shaped
+// payload, from time_last_update_utc or time_last_update_unix. A payload
+// with neither, or a time after fetched, is taken as current at fetched.
+func ratesAsOf(body []byte, fetched time.Time) time.Time {
+	var resp ExchangeRateResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fetched
+	}
+	asOf, err := time.Parse(time.RFC1123Z, resp.TimeLastUpdateUTC)
+	if err != nil && resp.TimeLastUpdateUnix > 0 {
+		asOf, err = time.Unix(resp.TimeLastUpdateUnix, 0), nil
+	}
+	if err != nil || asOf.After(fetched) {
+		return fetched
+	}
+	return asOf.UTC()
+}
+
+// newRateProvider builds the provider named by RATES_PROVIDER
+func newRateProvider() (RateProvider, error) {
+	switch name := getEnv("RATES_PROVIDER", rateProviderERAPI); name {
+	case rateProviderERAPI:
+		return erAPIRates{}, nil
+	case rateProviderHost:
+		return hostRates{}, nil
+	case rateProviderFixture:
+		return fileRates{path: os.Getenv("RATES_FILE")}, nil
+	default:
+		known := []string{rateProviderERAPI, rateProviderHost, rateProviderFixture}
+		sort.Strings(known)
+		return nil, fmt.Errorf("unknown RATES_PROVIDER %q (expected one of %s)", name, strings.Join(known, ", "))
+	}
+}
+
+// loadRateProvider selects the provider refreshes use
+func loadRateProvider() error {
+	provider, err := newRateProvider()
+	if err != nil {
+		return err
+	}
+	rateProvider = provider
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// readModel is a denormalized copy of the countries table holding one
+// pre-encoded JSON blob per country, so single-country reads can be served
+// without querying MySQL. It is rebuilt from the database after each change.
+type readModel interface {
+	// rebuild replaces the whole model with the given countries
+	rebuild(countries []Country) error
+	// get returns the stored blob for a country name, case-insensitively
+	get(name string) ([]byte, bool, error)
+}
+
+// countryReads is nil unless READ_MODEL selects an engine
+var countryReads readModel
+
+// initReadModel configures the read model from READ_MODEL (memory or redis)
+func initReadModel() error {
+	switch engine := os.Getenv("READ_MODEL"); engine {
+	case "":
+		return nil
+	case "memory":
+		countryReads = &memoryReadModel{}
+	case "redis":
+		client, err := redisClient()
+		if err != nil {
+			return err
+		}
+		countryReads = &redisReadModel{client: client, key: getEnv("REDIS_READ_MODEL_KEY", "countries:by_name")}
+	default:
+		return fmt.Errorf("unknown READ_MODEL %q", engine)
+	}
+	return rebuildReadModel()
+}
+
+// sharedRedis is the client used by every Redis-backed feature
+var sharedRedis *redis.Client
+
+// redisClient connects to REDIS_URL on first use
+func redisClient() (*redis.Client, error) {
+	if sharedRedis != nil {
+		return sharedRedis, nil
+	}
+	opts, err := redis.ParseURL(getEnv("REDIS_URL", "redis://localhost:6379/0"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		return nil, fmt.Errorf("redis unavailable: %w", err)
+	}
+	sharedRedis = client
+	return client, nil
+}
+
+// rebuildReadModel reloads every country from the database into the read
+// model; it is a no-op when no engine is configured
+func rebuildReadModel() error {
+	if countryReads == nil {
+		return nil
+	}
+	var countries []Country
+	if err := db.Find(&countries).Error; err != nil {
+		return err
+	}
+	if err := attachCurrencies(db, countries); err != nil {
+		return err
+	}
+	return countryReads.rebuild(countries)
+}
+
+func readModelKey(name string) string {
+	return strings.ToLower(name)
+}
+
+// encodeCountries marshals each country into its response blob
+func encodeCountries(countries []Country) (map[string][]byte, error) {
+	blobs := make(map[string][]byte, len(countries))
+	for _, country := range countries {
+		blob, err := json.Marshal(country)
+		if err != nil {
+			return nil, err
+		}
+		blobs[readModelKey(country.Name)] = blob
+	}
+	return blobs, nil
+}
+
+// memoryReadModel keeps the blobs in process memory; suited to a single
+// instance
+type memoryReadModel struct {
+	mu    sync.RWMutex
+	blobs map[string][]byte
+}
+
+func (m *memoryReadModel) rebuild(countries []Country) error {
+	blobs, err := encodeCountries(countries)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.blobs = blobs
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *memoryReadModel) get(name string) ([]byte, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	blob, ok := m.blobs[readModelKey(name)]
+	return blob, ok, nil
+}
+
+// redisReadModel stores the blobs in a single Redis hash shared by all
+// instances. Rebuilds write a staging hash and rename it over the live one,
+// so readers never see a half-built model.
+type redisReadModel struct {
+	client *redis.Client
+	key    string
+}
+
+func (r *redisReadModel) rebuild(countries []Country) error {
+	blobs, err := encodeCountries(countries)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if len(blobs) == 0 {
+		return r.client.Del(ctx, r.key).Err()
+	}
+
+	fields := make(map[string]interface{}, len(blobs))
+	for name, blob := range blobs {
+		fields[name] = blob
+	}
+
+	staging := r.key + ":staging"
+	pipe := r.client.TxPipeline()
+	pipe.Del(ctx, staging)
+	pipe.HSet(ctx, staging, fields)
+	pipe.Rename(ctx, staging, r.key)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (r *redisReadModel) get(name string) ([]byte, bool, error) {
+	blob, err := r.client.HGet(context.Background(), r.key, readModelKey(name)).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return blob, true, nil
+}
+
+// refreshReadModel rebuilds the read model after a data change, logging
+// rather than failing the write that triggered it
+func refreshReadModel() {
+	if err := rebuildReadModel(); err != nil {
+		log.Printf("Failed to rebuild read model: %v", err)
+	}
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+)
+
+// upstreamTimeout bounds fetching the upstream payloads of one refresh
+const upstreamTimeout = 30 * time.Second
+
+// refreshMu serializes refreshes so scheduled and manual runs never
+// interleave their writes
+var refreshMu sync.Mutex
+
+// upstreamError is returned when an external data source cannot be fetched
+type upstreamError struct {
+	source string
+	err    error
+}
+
+func (e *upstreamError) Error() string {
+	return fmt.Sprintf("Could not fetch data from %s: %v", e.source, e.err)
+}
+
+func (e *upstreamError) Unwrap() error {
+	return e.err
+}
+
+// upstreamErrors collects the failures of sources fetched together
+type upstreamErrors []*upstreamError
+
+func (e upstreamErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e upstreamErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// estimateGDP applies a random multiplier to a population at a USD rate,
+// drawn from the country's gdp_multiplier override when it has one and the
+// configured range otherwise. rng is as for gdpMultiplier.draw.
+func estimateGDP(population int64, rate float64, override *gdpMultiplier, rng *rand.Rand) float64 {
+	m := defaultGDPMultiplier()
+	if override != nil {
+		m = *override
+	}
+	return float64(population) * m.draw(rng) / rate
+}
+
+// runFullRefresh fetches country facts and exchange rates and publishes
+// them through the staging table, recording now as last_refreshed_at
+func runFullRefresh(now time.Time) (*refreshSummary, error) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+	return trackRefresh(refreshFull, now, "", func() (*refreshSummary, error) {
+		return fullRefresh(now)
+	})
+}
+
+// fullRefresh is runFullRefresh for callers already holding refreshMu
+func fullRefresh(now time.Time) (summary *refreshSummary, err error) {
+	traceCtx, span := tracer.Start(context.Background(), "refresh.full")
+	defer func() {
+		recordSpanError(span, err)
+		span.End()
+	}()
+
+	// Fetch the sources at once; rates share one deadline, while each step
+	// of the restcountries fallback chain has its own
+	ctx, cancel := context.WithTimeout(traceCtx, upstreamTimeout)
+	defer cancel()
+
+	var countriesBody, ratesBody []byte
+	var countriesSource string
+	var countries []RestCountry
+	var rates map[string]float64
+	var wb *worldBankLatest
+	var countriesErr, ratesErr error
+	var g errgroup.Group
+	g.Go(func() error {
+		wb = fetchWorldBankForRefresh()
+		return nil
+	})
+	g.Go(func() error {
+		countriesErr = traceStep(traceCtx, "refresh.fetch_countries", func(ctx context.Context) error {
+			var err error
+			countriesBody, countries, countriesSource, err = fetchCountries(ctx)
+			return err
+		})
+		return countriesErr
+	})
+	g.Go(func() error {
+		ratesErr = traceStep(ctx, "refresh.fetch_rates", func(ctx context.Context) error {
+			var err error
+			if ratesBody, err = rateProvider.Fetch(ctx); err == nil {
+				rates, err = parseExchangeRates(ratesBody)
+			}
+			return err
+		})
+		return ratesErr
+	})
+
+	// A failing source does not cancel the other, so each reports its own error
+	if g.Wait() != nil {
+		var errs upstreamErrors
+		if countriesErr != nil {
+			errs = append(errs, &upstreamError{source: "restcountries API", err: countriesErr})
+		}
+		if ratesErr != nil {
+			errs = append(errs, &upstreamError{source: "exchange rates API", err: ratesErr})
+		}
+		return nil, errs
+	}
+
+	archiveID := archiveRefresh(map[string][]byte{
+		archiveCountries: countriesBody,
+		archiveRates:     ratesBody,
+	})
+
+	summary, err = publishFullRefresh(traceCtx, countries, rates, ratesAsOf(ratesBody, now), wb, now, clock.Now().UnixNano())
+	if summary != nil {
+		summary.ArchiveID = archiveID
+		summary.CountriesSource = countriesSource
+		recordCountriesSource(countriesSource)
+		if countriesSource == countriesFromBundled {
+			go notifyAlert("Refresh used the bundled country snapshot",
+				errors.New("every live restcountries source failed; country facts are from the snapshot shipped with the binary and may be stale"))
+		}
+	}
+	return summary, err
+}
+
+// replayFullRefresh runs a full refresh from an archived pair of payloads
+// instead of the live upstreams. The GDP multipliers are seeded from the
+// archive ID, so replaying an archive at the same now reproduces the same
+// rows.
+func replayFullRefresh(archiveID string, now time.Time) (*refreshSummary, error) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+	return trackRefresh(refreshFull, now, archiveID, func() (*refreshSummary, error) {
+		return replayRefresh(archiveID, now)
+	})
+}
+
+// replayRefresh is replayFullRefresh for callers already holding refreshMu
+func replayRefresh(archiveID string, now time.Time) (summary *refreshSummary, err error) {
+	ctx, span := tracer.Start(context.Background(), "refresh.replay")
+	defer func() {
+		recordSpanError(span, err)
+		span.End()
+	}()
+
+	countriesBody, err := readArchivedPayload(archiveID, archiveCountries)
+	if err != nil {
+		return nil, err
+	}
+	countries, err := parseCountries(countriesBody)
+	if err != nil {
+		return nil, err
+	}
+
+	ratesBody, err := readArchivedPayload(archiveID, archiveRates)
+	if err != nil {
+		return nil, err
+	}
+	rates, err := parseExchangeRates(ratesBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// World Bank values are not archived, so replays use the fallback
+	// providers of each field
+	summary, err = publishFullRefresh(ctx, countries, rates, ratesAsOf(ratesBody, now), nil, now, archiveSeed(archiveID))
+	if summary != nil {
+		summary.ArchiveID = archiveID
+		summary.CountriesSource = countriesFromArchive
+		recordCountriesSource(countriesFromArchive)
+	}
+	return summary, err
+}
+
+// archiveSeed derives a stable random seed from an archive ID
+func archiveSeed(archiveID string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(archiveID))
+	return int64(h.Sum64())
+}
+
+// archiveRefresh stores the raw payloads when ARCHIVE_DIR is set and
+// returns the archive ID, or "" when archival is off or failed
+func archiveRefresh(payloads map[string][]byte) string {
+	if archiveDir == "" {
+		return ""
+	}
+	id, err := archivePayloads(payloads)
+	if err != nil {
+		log.Printf("Failed to archive upstream payloads: %v", err)
+		return ""
+	}
+	return id
+}
+
+// publishFullRefresh stages, validates and publishes parsed upstream data,
+// seeding the GDP multipliers with seed. ratesAsOf is when the provider
+// last updated rates. Callers hold refreshMu.
+func publishFullRefresh(ctx context.Context, countries []RestCountry, rates map[string]float64, ratesAsOf time.Time, wb *worldBankLatest, now time.Time, seed int64) (*refreshSummary, error) {
+	rng := rand.New(rand.NewSource(seed))
+
+	summary := &refreshSummary{StartedAt: clock.Now(), Processed: len(countries)}
+
+	overrides, err := loadOverrides(db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	manual, err := manualCountryNames(db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	manualCodes, err := manualCountryCodes(db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	repairs, err := flagRepairs(db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]Country, 0, len(countries))
+	for _, country := range countries {
+		// Manual countries are owned by whoever created them
+		if manual[strings.ToLower(country.Name)] {
+			summary.Skipped++
+			continue
+		}
+		row := buildCountry(country, rates, wb, now, rng)
+		applyFlagRepair(&row, repairs)
+		applyOverrides(&row, overrides, rates, rng)
+		stampRates(&row, ratesAsOf)
+		// Oversized values are reported instead of truncated by MySQL
+		if err := checkColumnSizes(row); err != nil {
+			summary.addError(row.Name, err)
+			continue
+		}
+		// Codes are unique, and a manual country keeps its own
+		if err := checkManualCodes(row, manualCodes); err != nil {
+			summary.addError(row.Name, err)
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if err := assignSlugs(db.WithContext(ctx), rows); err != nil {
+		return nil, err
+	}
+
+	// Stage, validate and diff the snapshot before touching live data
+	err = traceStep(ctx, "refresh.stage", func(ctx context.Context) error {
+		staged, err := stageCountries(ctx, rows)
+		if err != nil {
+			return err
+		}
+		if err := validateStaging(ctx, staged); err != nil {
+			return err
+		}
+		return diffStaging(ctx, summary, rates)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Publish atomically
+	err = traceStep(ctx, "refresh.publish", func(ctx context.Context) error {
+		return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := renameCountries(tx, summary.renames); err != nil {
+				return err
+			}
+			if err := publishStaging(tx); err != nil {
+				return err
+			}
+			if err := replaceCurrencies(tx, rows); err != nil {
+				return err
+			}
+			if err := replaceBorders(tx, countries); err != nil {
+				return err
+			}
+			if err := recordRateHistory(tx, rates, ratesAsOf); err != nil {
+				return err
+			}
+			if err := recordHistory(tx, summary.history, now); err != nil {
+				return err
+			}
+			return recordAnomalies(tx, summary.Anomalies)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	finishRefresh(summary)
+	return summary, nil
+}
+
+// runRatesRefresh fetches only exchange rates and reprices the countries
+// already stored in a single UPDATE, leaving country facts untouched
+func runRatesRefresh(now time.Time) (*refreshSummary, error) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+	return trackRefresh(refreshRatesOnly, now, "", func() (*refreshSummary, error) {
+		return ratesRefresh(now)
+	})
+}
+
+// ratesRefresh is runRatesRefresh for callers already holding refreshMu
+func ratesRefresh(now time.Time) (summary *refreshSummary, err error) {
+	traceCtx, span := tracer.Start(context.Background(), "refresh.rates")
+	defer func() {
+		recordSpanError(span, err)
+		span.End()
+	}()
+
+	var ratesBody []byte
+	var rates map[string]float64
+	err = traceStep(traceCtx, "refresh.fetch_rates", func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, upstreamTimeout)
+		defer cancel()
+		var err error
+		if ratesBody, err = rateProvider.Fetch(ctx); err == nil {
+			rates, err = parseExchangeRates(ratesBody)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, &upstreamError{source: "exchange rates API", err: err}
+	}
+	archiveID := archiveRefresh(map[string][]byte{archiveRates: ratesBody})
+	asOf := ratesAsOf(ratesBody, now)
+
+	// Only the columns needed to report movers, anomalies and the history
+	repriced := []string{"id", "name", "alpha3_code", "currency_code", "exchange_rate", "estimated_gdp", "gdp_tier", "rate_as_of"}
+	var before []Country
+	if err := db.WithContext(traceCtx).Select(repriced).
+		Where("currency_code IS NOT NULL").Find(&before).Error; err != nil {
+		return nil, err
+	}
+
+	summary = &refreshSummary{StartedAt: clock.Now(), Processed: len(before), ArchiveID: archiveID}
+
+	overrides, err := loadOverrides(db.WithContext(traceCtx))
+	if err != nil {
+		return nil, err
+	}
+	multipliers := map[uint]gdpMultiplier{}
+	for _, country := range before {
+		matched := overrides[strings.ToUpper(country.Name)]
+		if country.Alpha3Code != nil {
+			matched = append(matched, overrides[strings.ToUpper(*country.Alpha3Code)]...)
+		}
+		if m := gdpMultiplierOf(matched); m != nil {
+			multipliers[country.ID] = *m
+		}
+	}
+
+	codes := make([]string, 0, len(rates))
+	for _, country := range before {
+		if _, ok := rates[*country.CurrencyCode]; ok {
+			codes = append(codes, *country.CurrencyCode)
+		}
+	}
+
+	for _, old := range before {
+		rate, ok := rates[*old.CurrencyCode]
+		if !ok {
+			continue
+		}
+		updated := Country{Name: old.Name, CurrencyCode: old.CurrencyCode, ExchangeRate: &rate}
+		summary.trackMover(old, updated)
+		summary.checkAnomaly(old, updated, rates)
+	}
+
+	err = db.WithContext(traceCtx).Transaction(func(tx *gorm.DB) error {
+		if len(codes) > 0 {
+			query, args := repriceSQL(codes, rates, multipliers, asOf, now)
+			result := tx.Exec(query, args...)
+			if result.Error != nil {
+				return result.Error
+			}
+			summary.Updated = int(result.RowsAffected)
+
+			// The new GDP is drawn by MySQL, so read the rows back
+			var after []Country
+			if err := tx.Select(repriced).Where("currency_code IN ?", codes).Find(&after).Error; err != nil {
+				return err
+			}
+			byID := make(map[uint]Country, len(before))
+			for _, old := range before {
+				byID[old.ID] = old
+			}
+			for i := range after {
+				old := byID[after[i].ID]
+				if entry := historyEntry(&old, &after[i], historySourceRates); entry != nil {
+					summary.history = append(summary.history, *entry)
+				}
+			}
+		}
+		// Secondary currencies are repriced too; the GDP only uses the primary
+		if err := repriceCurrencies(tx, rates, asOf); err != nil {
+			return err
+		}
+		if err := recordRateHistory(tx, rates, asOf); err != nil {
+			return err
+		}
+		if err := recordHistory(tx, summary.history, now); err != nil {
+			return err
+		}
+		return recordAnomalies(tx, summary.Anomalies)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	finishRefresh(summary)
+	return summary, nil
+}
+
+// repriceSQL builds the bulk UPDATE for a rates-only refresh and its
+// arguments. MySQL applies SET assignments left to right, so estimated_gdp
+// sees the new rate and gdp_tier sees the new GDP; the bounds mirror
+// estimateGDP and gdpTierFor. GDP taken from the World Bank is kept.
+func repriceSQL(codes []string, rates map[string]float64, multipliers map[uint]gdpMultiplier, asOf, now time.Time) (string, []interface{}) {
+	var cases strings.Builder
+	var args []interface{}
+	seen := make(map[string]bool, len(codes))
+	unique := make([]string, 0, len(codes))
+	for _, code := range codes {
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+		unique = append(unique, code)
+		cases.WriteString(" WHEN ? THEN ?")
+		args = append(args, code, rates[code])
+	}
+
+	// Countries with a gdp_multiplier override draw from their own range
+	ids := make([]uint, 0, len(multipliers))
+	for id := range multipliers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	fallback := defaultGDPMultiplier()
+	bound := func(value func(gdpMultiplier) float64) string {
+		if len(ids) == 0 {
+			args = append(args, value(fallback))
+			return "?"
+		}
+		var expr strings.Builder
+		expr.WriteString("CASE id")
+		for _, id := range ids {
+			expr.WriteString(" WHEN ? THEN ?")
+			args = append(args, id, value(multipliers[id]))
+		}
+		expr.WriteString(" ELSE ? END")
+		args = append(args, value(fallback))
+		return expr.String()
+	}
+	low := bound(func(m gdpMultiplier) float64 { return m.Min })
+	span := bound(func(m gdpMultiplier) float64 { return m.Max - m.Min })
+
+	args = append(args,
+		gdpPerCapitaLowMax, gdpTierLow,
+		gdpPerCapitaMidMax, gdpTierMid,
+		gdpTierHigh,
+		asOf, now, unique)
+
+	// MySQL assigns left to right, so the previous values are kept first
+	return `UPDATE countries SET
+	previous_exchange_rate = exchange_rate,
+	previous_estimated_gdp = estimated_gdp,
+	exchange_rate = CASE currency_code` + cases.String() + ` END,
+	estimated_gdp = CASE
+		WHEN field_sources LIKE '%"estimated_gdp":"worldbank"%' THEN estimated_gdp
+		ELSE population * (` + low + ` + RAND() * ` + span + `) / exchange_rate
+	END,
+	gdp_tier = CASE
+		WHEN estimated_gdp <= 0 OR population <= 0 THEN NULL
+		WHEN estimated_gdp / population < ? THEN ?
+		WHEN estimated_gdp / population < ? THEN ?
+		ELSE ?
+	END,
+	rate_as_of = ?,
+	last_refreshed_at = ?
+WHERE currency_code IN ?`, args
+}
+
+// stampRates records when the provider last updated the rates of a built
+// row and of its currencies; those without a rate get none
+func stampRates(row *Country, asOf time.Time) {
+	row.RateAsOf = nil
+	if row.ExchangeRate != nil {
+		row.RateAsOf = &asOf
+	}
+	for i := range row.Currencies {
+		row.Currencies[i].RateAsOf = nil
+		if row.Currencies[i].ExchangeRate != nil {
+			row.Currencies[i].RateAsOf = &asOf
+		}
+	}
+}
+
+// finishRefresh fans out a completed refresh to derived data and
+// notifications
+func finishRefresh(summary *refreshSummary) {
+	// Regenerate the summary image in the background
+	summary.imageEnqueued = notifyDataChanged()
+
+	summary.FinishedAt = clock.Now()
+	recordRefresh(summary)
+	go notifyRefresh(summary)
+}
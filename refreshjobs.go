@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxRefreshJobs bounds how many finished jobs are kept for lookup
+const maxRefreshJobs = 100
+
+// Refresh job states
+const (
+	jobPending = "pending"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// Refresh job kinds
+const (
+	refreshFull      = "full"
+	refreshRatesOnly = "rates-only"
+)
+
+// ErrJobNotFound is returned for unknown or expired refresh job IDs
+var ErrJobNotFound = errors.New("refresh job not found")
+
+// refreshJob tracks one refresh: an asynchronous POST /countries/refresh,
+// or a blocking, scheduled or rates-only refresh, registered as it starts
+type refreshJob struct {
+	ID              string     `json:"id"`
+	Kind            string     `json:"kind"`
+	State           string     `json:"state"`
+	CreatedAt       time.Time  `json:"created_at"`
+	StartedAt       *time.Time `json:"started_at"`
+	FinishedAt      *time.Time `json:"finished_at"`
+	LastRefreshedAt time.Time  `json:"last_refreshed_at"`
+	FromArchive     *string    `json:"from_archive,omitempty"`
+	ArchiveID       *string    `json:"archive_id"`
+	CountriesSource *string    `json:"countries_source"`
+	Processed       int        `json:"total_processed"`
+	Inserted        int        `json:"total_inserted"`
+	Updated         int        `json:"total_updated"`
+	Errors          []string   `json:"errors"`
+	Error           *string    `json:"error"`
+
+	// done is closed once the job is done or failed
+	done chan struct{}
+}
+
+// refreshJobStore keeps jobs in memory, oldest evicted first. Jobs do not
+// survive a restart.
+type refreshJobStore struct {
+	mu    sync.Mutex
+	jobs  map[string]*refreshJob
+	order []string
+}
+
+var refreshJobs = &refreshJobStore{jobs: map[string]*refreshJob{}}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Failed to generate job ID: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// create registers a pending job
+func (s *refreshJobStore) create(kind string, now time.Time, fromArchive string) *refreshJob {
+	job := &refreshJob{
+		ID:              newJobID(),
+		Kind:            kind,
+		State:           jobPending,
+		CreatedAt:       clock.Now(),
+		LastRefreshedAt: now,
+		FromArchive:     nilIfEmpty(&fromArchive),
+		Errors:          []string{},
+		done:            make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = job
+	s.order = append(s.order, job.ID)
+	for len(s.order) > maxRefreshJobs {
+		delete(s.jobs, s.order[0])
+		s.order = s.order[1:]
+	}
+	return job
+}
+
+// get returns a copy of a job so callers never race the runner
+func (s *refreshJobStore) get(id string) (refreshJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return refreshJob{}, ErrJobNotFound
+	}
+	return *job, nil
+}
+
+// latest returns the newest job still pending or running, or else the
+// newest finished one; ok is false when no job has been created
+func (s *refreshJobStore) latest() (job *refreshJob, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.order) - 1; i >= 0; i-- {
+		candidate := s.jobs[s.order[i]]
+		if candidate.State == jobPending || candidate.State == jobRunning {
+			return candidate, true
+		}
+		if job == nil {
+			job = candidate
+		}
+	}
+	return job, job != nil
+}
+
+// lookup returns the live job for id, for callers that wait on it
+func (s *refreshJobStore) lookup(id string) (*refreshJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// update applies fn to a job under the store lock
+func (s *refreshJobStore) update(job *refreshJob, fn func(*refreshJob)) {
+	s.mu.Lock()
+	fn(job)
+	s.mu.Unlock()
+}
+
+// runRefreshJob performs a full refresh (or archive replay) for job. It
+// stays pending while another refresh holds refreshMu. release frees the
+// refresh concurrency slot the request took, once the work is done.
+func runRefreshJob(job *refreshJob, release func()) {
+	defer release()
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	_, err := refreshJobs.run(job, func() (*refreshSummary, error) {
+		if job.FromArchive != nil {
+			return replayRefresh(*job.FromArchive, job.LastRefreshedAt)
+		}
+		return fullRefresh(job.LastRefreshedAt)
+	})
+	if err != nil {
+		log.Printf("Refresh job %s failed: %v", job.ID, err)
+	}
+}
+
+// trackRefresh registers a refresh that starts right away, for callers
+// already holding refreshMu, so GET /countries/refresh/wait sees every
+// refresh and not just the asynchronous ones
+func trackRefresh(kind string, now time.Time, fromArchive string, refresh func() (*refreshSummary, error)) (*refreshSummary, error) {
+	return refreshJobs.run(refreshJobs.create(kind, now, fromArchive), refresh)
+}
+
+// run marks job running, performs refresh and records its outcome
+func (s *refreshJobStore) run(job *refreshJob, refresh func() (*refreshSummary, error)) (*refreshSummary, error) {
+	started := clock.Now()
+	s.update(job, func(j *refreshJob) {
+		j.State = jobRunning
+		j.StartedAt = &started
+	})
+
+	summary, err := refresh()
+
+	finished := clock.Now()
+	s.update(job, func(j *refreshJob) {
+		j.FinishedAt = &finished
+		if err != nil {
+			msg := err.Error()
+			j.State = jobFailed
+			j.Error = &msg
+			return
+		}
+		j.State = jobDone
+		j.ArchiveID = nilIfEmpty(&summary.ArchiveID)
+		j.CountriesSource = nilIfEmpty(&summary.CountriesSource)
+		j.Processed = summary.Processed
+		j.Inserted = summary.Inserted
+		j.Updated = summary.Updated
+		if summary.Errors != nil {
+			j.Errors = summary.Errors
+		}
+	})
+	close(job.done)
+	return summary, err
+}
+
+func getRefreshJob(c *fiber.Ctx) error {
+	job, err := refreshJobs.get(c.Params("id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(job)
+}
+
+// Bounds of the timeout accepted by GET /countries/refresh/wait
+const (
+	defaultRefreshWait = 30 * time.Second
+	maxRefreshWait     = 2 * time.Minute
+)
+
+// waitRefreshJob long-polls a refresh job: it blocks until the newest
+// pending or running job (or ?job_id) finishes, then returns it like
+// GET /countries/refresh/jobs/:id. When the timeout passes first it returns
+// 202 with the job as it stands, so callers can simply poll again.
+func waitRefreshJob(c *fiber.Ctx) error {
+	timeout := defaultRefreshWait
+	if raw := c.Query("timeout"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 || d > maxRefreshWait {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "Validation failed",
+				"details": fmt.Sprintf("timeout must be a positive duration of at most %s, e.g. 60s", maxRefreshWait),
+			})
+		}
+		timeout = d
+	}
+
+	var job *refreshJob
+	if id := c.Query("job_id"); id != "" {
+		found, err := refreshJobs.lookup(id)
+		if err != nil {
+			return err
+		}
+		job = found
+	} else {
+		found, ok := refreshJobs.latest()
+		if !ok {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error":   "Job not found",
+				"details": "no refresh has been started since the server started",
+			})
+		}
+		job = found
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-job.done:
+	case <-timer.C:
+		current, err := refreshJobs.get(job.ID)
+		if err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusAccepted).JSON(current)
+	}
+
+	finished, err := refreshJobs.get(job.ID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(finished)
+}
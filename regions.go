@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// regionStats aggregates the stored countries of one region
+type regionStats struct {
+	Name string `json:"name" xml:"name"`
+	// Label is the localized name, set when ?lang= asks for one
+	Label      *string `json:"label,omitempty" xml:"label,omitempty"`
+	Countries  int64   `json:"countries" xml:"countries"`
+	Population int64   `json:"population" xml:"population"`
+	// EstimatedGDP sums the countries that have an estimate
+	EstimatedGDP float64 `json:"estimated_gdp" xml:"estimated_gdp"`
+	// AverageExchangeRate averages the primary currency rates (units per
+	// USD) of the countries that have one; null when none do
+	AverageExchangeRate *float64 `json:"average_exchange_rate" xml:"average_exchange_rate"`
+}
+
+// loadRegionStats aggregates every region, or only the named one when name
+// is set. Countries without a region are left out.
+func loadRegionStats(name string) ([]regionStats, error) {
+	stats := []regionStats{}
+	query := db.Model(&Country{}).
+		Select(`region AS name, COUNT(*) AS countries, COALESCE(SUM(population), 0) AS population,
+	COALESCE(SUM(estimated_gdp), 0) AS estimated_gdp, AVG(exchange_rate) AS average_exchange_rate`).
+		Where("region IS NOT NULL").
+		Group("region").
+		Order("region ASC")
+	if name != "" {
+		query = query.Where("region = ?", name)
+	}
+	if err := query.Scan(&stats).Error; err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// getRegions lists every region with its aggregates
+func getRegions(c *fiber.Ctx) error {
+	lang, err := parseLanguage(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	meta := newListMeta(c, "lang")
+	meta.Sort = "name_asc"
+	stats, err := loadRegionStats("")
+	if err != nil {
+		return err
+	}
+	if lang != "" {
+		for i := range stats {
+			stats[i].Label = localizedLabel(lang, &stats[i].Name)
+		}
+	}
+	meta.Total = int64(len(stats))
+	return sendList(c, stats, meta)
+}
+
+// getRegionCountries lists the countries of one region, by name, with the
+// region's aggregates
+func getRegionCountries(c *fiber.Ctx) error {
+	lang, err := parseLanguage(c)
+	var page pageRequest
+	if err == nil {
+		page, err = parsePage(c)
+	}
+	if err == nil && page.Cursor {
+		err = errors.New("cursor paging is not supported; use limit and offset")
+	}
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	// The column's collation compares region names case-insensitively
+	stats, err := loadRegionStats(c.Params("name"))
+	if err != nil {
+		return err
+	}
+	if len(stats) == 0 {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Region not found",
+		})
+	}
+	region := stats[0]
+
+	meta := newListMeta(c, "limit", "offset", "lang", "format")
+	meta.Sort = "name_asc"
+	meta.filter("region", region.Name)
+	meta.Total = region.Countries
+
+	countries := []Country{}
+	query := db.Where("region = ?", region.Name).Order("name ASC")
+	if page.Limit > 0 {
+		query = query.Limit(page.Limit).Offset(page.Offset)
+	}
+	if err := query.Find(&countries).Error; err != nil {
+		return err
+	}
+	if err := attachCurrencies(db, countries); err != nil {
+		return err
+	}
+	localizeCountries(lang, countries)
+	if lang != "" {
+		region.Label = localizedLabel(lang, &region.Name)
+	}
+
+	meta.Page = pageMetaFor(page, "")
+	if wantsXML(c) {
+		return sendXML(c, "countries", countryListXML{Data: countries, Meta: meta, Region: &region})
+	}
+	return c.JSON(struct {
+		listResponse
+		Region regionStats `json:"region"`
+	}{listResponse{Data: countries, Meta: meta}, region})
+}
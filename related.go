@@ -0,0 +1,179 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Relationship kinds between countries
+const (
+	relatedByBorder   = "border"
+	relatedByCurrency = "currency"
+	relatedByRegion   = "region"
+)
+
+var relationshipKinds = []string{relatedByBorder, relatedByCurrency, relatedByRegion}
+
+// CountryBorder is one land border as reported upstream, between ISO 3166-1
+// alpha-3 codes. The set is replaced on every full refresh.
+type CountryBorder struct {
+	ID           uint   `gorm:"primaryKey" json:"-"`
+	CountryCode  string `gorm:"type:varchar(3);uniqueIndex:idx_country_border;not null" json:"country_code"`
+	NeighborCode string `gorm:"type:varchar(3);uniqueIndex:idx_country_border;not null" json:"neighbor_code"`
+}
+
+// replaceBorders swaps in the borders from a full upstream snapshot
+func replaceBorders(tx *gorm.DB, countries []RestCountry) error {
+	seen := map[[2]string]bool{}
+	var rows []CountryBorder
+	for _, country := range countries {
+		if country.Alpha3Code == "" {
+			continue
+		}
+		for _, neighbor := range country.Borders {
+			key := [2]string{country.Alpha3Code, neighbor}
+			if neighbor == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			rows = append(rows, CountryBorder{CountryCode: country.Alpha3Code, NeighborCode: neighbor})
+		}
+	}
+
+	if err := tx.Exec("DELETE FROM country_borders").Error; err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(rows, refreshBatchSize).Error
+}
+
+// relatedCountry is a connected country and every way it is connected
+type relatedCountry struct {
+	Name          string   `json:"name"`
+	Region        *string  `json:"region"`
+	CurrencyCode  *string  `json:"currency_code"`
+	FlagURL       *string  `json:"flag_url"`
+	Relationships []string `json:"relationships"`
+}
+
+// parseRelationshipKinds reads ?by=, a comma-separated subset of the kinds;
+// empty means all of them
+func parseRelationshipKinds(raw string) ([]string, bool) {
+	if raw == "" {
+		return relationshipKinds, true
+	}
+	var kinds []string
+	for _, kind := range strings.Split(raw, ",") {
+		kind = strings.TrimSpace(kind)
+		valid := false
+		for _, known := range relationshipKinds {
+			valid = valid || kind == known
+		}
+		if !valid {
+			return nil, false
+		}
+		for _, existing := range kinds {
+			valid = valid && existing != kind
+		}
+		if valid {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds, true
+}
+
+// relatedCountries finds the countries connected to country by each kind
+func relatedCountries(country *Country, kinds []string) ([]relatedCountry, error) {
+	byName := map[string]*relatedCountry{}
+	add := func(matches []Country, kind string) {
+		for _, match := range matches {
+			if match.ID == country.ID {
+				continue
+			}
+			rel, ok := byName[match.Name]
+			if !ok {
+				rel = &relatedCountry{
+					Name:         match.Name,
+					Region:       match.Region,
+					CurrencyCode: match.CurrencyCode,
+					FlagURL:      match.FlagURL,
+				}
+				byName[match.Name] = rel
+			}
+			rel.Relationships = append(rel.Relationships, kind)
+		}
+	}
+
+	for _, kind := range kinds {
+		var matches []Country
+		var query *gorm.DB
+		switch kind {
+		case relatedByBorder:
+			if country.Alpha3Code == nil {
+				continue
+			}
+			// Borders are symmetric upstream, but accept either direction
+			query = db.Where("alpha3_code IN (?) OR alpha3_code IN (?)",
+				db.Model(&CountryBorder{}).Select("neighbor_code").Where("country_code = ?", *country.Alpha3Code),
+				db.Model(&CountryBorder{}).Select("country_code").Where("neighbor_code = ?", *country.Alpha3Code))
+		case relatedByCurrency:
+			if country.CurrencyCode == nil {
+				continue
+			}
+			query = db.Where("currency_code = ?", *country.CurrencyCode)
+		case relatedByRegion:
+			if country.Region == nil {
+				continue
+			}
+			query = db.Where("region = ?", *country.Region)
+		}
+		if err := query.Find(&matches).Error; err != nil {
+			return nil, err
+		}
+		add(matches, kind)
+	}
+
+	// Most connected first, then by name
+	related := make([]relatedCountry, 0, len(byName))
+	for _, rel := range byName {
+		related = append(related, *rel)
+	}
+	sort.Slice(related, func(i, j int) bool {
+		if len(related[i].Relationships) != len(related[j].Relationships) {
+			return len(related[i].Relationships) > len(related[j].Relationships)
+		}
+		return related[i].Name < related[j].Name
+	})
+	return related, nil
+}
+
+func getRelatedCountries(c *fiber.Ctx) error {
+	kinds, ok := parseRelationshipKinds(c.Query("by"))
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": "by must be one or more of " + strings.Join(relationshipKinds, ", "),
+		})
+	}
+
+	country, err := findCountry(c.Params("name"))
+	if err != nil {
+		return err
+	}
+
+	related, err := relatedCountries(country, kinds)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"name":    country.Name,
+		"by":      kinds,
+		"related": related,
+	})
+}
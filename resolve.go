@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxResolveQueries caps the values accepted by one resolve request
+const maxResolveQueries = 1000
+
+// How a resolve query matched
+const (
+	resolvedByAlpha2 = "alpha2"
+	resolvedByAlpha3 = "alpha3"
+	resolvedByName   = "name"
+	resolvedByAlias  = "alias"
+)
+
+// countryAliases maps common informal and former names, already folded
+// with foldName, to ISO 3166-1 alpha-3 codes. Upstream names are often the
+// formal ones ("Korea (Republic of)"), which messy data rarely uses.
+// Curated aliases in country_aliases take precedence over these.
+var countryAliases = map[string]string{
+	"uk": "GBR", "great britain": "GBR", "britain": "GBR", "united kingdom": "GBR",
+	"usa": "USA", "united states": "USA", "america": "USA",
+	"holland": "NLD", "the netherlands": "NLD", "netherlands": "NLD",
+	"ivory coast": "CIV",
+	"south korea": "KOR", "korea": "KOR", "republic of korea": "KOR",
+	"north korea": "PRK", "dprk": "PRK",
+	"russia": "RUS", "iran": "IRN", "syria": "SYR", "laos": "LAO",
+	"vietnam": "VNM", "bolivia": "BOL", "venezuela": "VEN", "tanzania": "TZA",
+	"moldova": "MDA", "czechia": "CZE", "czech republic": "CZE",
+	"macedonia": "MKD", "north macedonia": "MKD",
+	"burma": "MMR", "swaziland": "SWZ", "eswatini": "SWZ",
+	"cape verde": "CPV", "cabo verde": "CPV", "east timor": "TLS", "timor leste": "TLS",
+	"drc": "COD", "dr congo": "COD", "congo kinshasa": "COD", "democratic republic of the congo": "COD",
+	"congo brazzaville": "COG", "republic of the congo": "COG",
+	"taiwan": "TWN", "palestine": "PSE", "vatican": "VAT", "holy see": "VAT",
+	"brunei": "BRN", "micronesia": "FSM", "uae": "ARE", "emirates": "ARE",
+	"turkey": "TUR", "turkiye": "TUR",
+}
+
+// resolveResult is one input value and the country it resolved to, if any
+type resolveResult struct {
+	Query   string   `json:"query"`
+	MatchBy *string  `json:"match_by"`
+	Country *Country `json:"country"`
+	// Suggestion is the closest name by search score for unresolved values;
+	// it is never applied automatically
+	Suggestion *string `json:"suggestion,omitempty"`
+	Error      *string `json:"error,omitempty"`
+}
+
+// countryResolver indexes the stored countries by every key a query can use
+type countryResolver struct {
+	countries []Country
+	byAlpha2  map[string]*Country
+	byAlpha3  map[string]*Country
+	byName    map[string]*Country
+}
+
+func newCountryResolver(countries []Country) *countryResolver {
+	r := &countryResolver{
+		countries: countries,
+		byAlpha2:  map[string]*Country{},
+		byAlpha3:  map[string]*Country{},
+		byName:    map[string]*Country{},
+	}
+	for i := range countries {
+		country := &countries[i]
+		if country.Alpha2Code != nil {
+			r.byAlpha2[strings.ToUpper(*country.Alpha2Code)] = country
+		} else if code := flagCode(country.FlagURL); code != "" {
+			r.byAlpha2[strings.ToUpper(code)] = country
+		}
+		if country.Alpha3Code != nil {
+			r.byAlpha3[strings.ToUpper(*country.Alpha3Code)] = country
+		}
+		r.byName[foldName(country.Name)] = country
+	}
+	return r
+}
+
+// resolve tries codes first, since a two or three letter value is far more
+// likely a code than a name, then the name, then the aliases
+func (r *countryResolver) resolve(query string) resolveResult {
+	res := resolveResult{Query: query}
+	matched := func(by string, country *Country) resolveResult {
+		res.MatchBy, res.Country = &by, country
+		return res
+	}
+
+	trimmed := strings.TrimSpace(query)
+	if trimmed == "" {
+		msg := "query is empty"
+		res.Error = &msg
+		return res
+	}
+
+	// Dotted abbreviations such as "U.S.A." are codes too
+	upper := strings.ToUpper(strings.ReplaceAll(trimmed, ".", ""))
+	if country, ok := r.byAlpha2[upper]; ok && len(upper) == 2 {
+		return matched(resolvedByAlpha2, country)
+	}
+	if country, ok := r.byAlpha3[upper]; ok && len(upper) == 3 {
+		return matched(resolvedByAlpha3, country)
+	}
+
+	folded := foldName(trimmed)
+	if country, ok := r.byName[folded]; ok {
+		return matched(resolvedByName, country)
+	}
+	for _, key := range []string{folded, strings.ReplaceAll(folded, " ", "")} {
+		if code, ok := aliasCode(key); ok {
+			if country, ok := r.byAlpha3[code]; ok {
+				return matched(resolvedByAlias, country)
+			}
+		}
+	}
+
+	best := 0
+	for i := range r.countries {
+		if score := matchScore(foldName(r.countries[i].Name), folded); score > best {
+			best, res.Suggestion = score, &r.countries[i].Name
+		}
+	}
+	return res
+}
+
+// resolveCountries maps a list of messy country values (names, ISO 3166-1
+// alpha-2 or alpha-3 codes, common aliases) to stored countries, in order
+func resolveCountries(c *fiber.Ctx) error {
+	var queries []string
+	if err := c.BodyParser(&queries); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": "body must be a JSON array of strings",
+		})
+	}
+	if len(queries) == 0 || len(queries) > maxResolveQueries {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": fmt.Sprintf("batch must contain between 1 and %d values", maxResolveQueries),
+		})
+	}
+
+	var countries []Country
+	if err := db.Find(&countries).Error; err != nil {
+		return err
+	}
+	resolver := newCountryResolver(countries)
+
+	results := make([]resolveResult, len(queries))
+	unresolved := 0
+	for i, query := range queries {
+		results[i] = resolver.resolve(query)
+		if results[i].Country == nil {
+			unresolved++
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"total":      len(results),
+		"resolved":   len(results) - unresolved,
+		"unresolved": unresolved,
+		"results":    results,
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// restcountries API versions accepted by COUNTRIES_API_VERSION
+const (
+	countriesAPIv2  = "v2"
+	countriesAPIv31 = "v3.1"
+)
+
+// countriesAPIURLs are the default endpoints per version, limited to the
+// fields we read
+var countriesAPIURLs = map[string]string{
+	countriesAPIv2:  "https://restcountries.com/v2/all?fields=name,alpha2Code,alpha3Code,borders,capital,region,subregion,population,flag,currencies",
+	countriesAPIv31: "https://restcountries.com/v3.1/all?fields=name,cca2,cca3,borders,capital,region,subregion,population,flags,currencies",
+}
+
+// countriesAPIVersion selects the default restcountries endpoint. Parsing
+// detects the shape of each payload, so archives from either version replay.
+var countriesAPIVersion = countriesAPIv2
+
+// checkCountriesAPIVersion reports an unknown COUNTRIES_API_VERSION
+func checkCountriesAPIVersion() error {
+	if _, ok := countriesAPIURLs[countriesAPIVersion]; !ok {
+		return fmt.Errorf("unknown COUNTRIES_API_VERSION %q (expected %s or %s)", countriesAPIVersion, countriesAPIv2, countriesAPIv31)
+	}
+	return nil
+}
+
+// restCountryV3 is a restcountries v3.1 record
+type restCountryV3 struct {
+	Name struct {
+		Common   string `json:"common"`
+		Official string `json:"official"`
+	} `json:"name"`
+	CCA2       string   `json:"cca2"`
+	CCA3       string   `json:"cca3"`
+	Borders    []string `json:"borders"`
+	Capital    []string `json:"capital"`
+	Region     string   `json:"region"`
+	Subregion  string   `json:"subregion"`
+	Population int64    `json:"population"`
+	Flags      struct {
+		SVG string `json:"svg"`
+		PNG string `json:"png"`
+	} `json:"flags"`
+	Currencies map[string]struct {
+		Name   string `json:"name"`
+		Symbol string `json:"symbol"`
+	} `json:"currencies"`
+}
+
+// restCountryV3Rules mirrors restCountryV3
+var restCountryV3Rules = []fieldRule{
+	{name: "name", kind: "object", required: true},
+	{name: "population", kind: "number", required: true},
+	{name: "cca2", kind: "string"},
+	{name: "cca3", kind: "string"},
+	{name: "borders", kind: "array"},
+	{name: "region", kind: "string"},
+	{name: "subregion", kind: "string"},
+	{name: "capital", kind: "array"},
+	{name: "flags", kind: "object"},
+	{name: "currencies", kind: "object"},
+}
+
+// isCountriesV3 reports whether a restcountries payload uses the v3.1
+// shape, where name is an object rather than a string
+func isCountriesV3(records []json.RawMessage) bool {
+	if len(records) == 0 {
+		return false
+	}
+	var first struct {
+		Name json.RawMessage `json:"name"`
+	}
+	return json.Unmarshal(records[0], &first) == nil && jsonKind(first.Name) == "object"
+}
+
+// toRestCountry maps a v3.1 record onto the v2 shape the refresh reads.
+// v3.1 currencies are a map, so they are ordered by code; the first is
+// used as the country's currency.
+func (c restCountryV3) toRestCountry() RestCountry {
+	country := RestCountry{
+		Name:       c.Name.Common,
+		Alpha2Code: c.CCA2,
+		Alpha3Code: c.CCA3,
+		Borders:    c.Borders,
+		Region:     c.Region,
+		Subregion:  c.Subregion,
+		Population: c.Population,
+		Flag:       c.Flags.SVG,
+	}
+	if len(c.Capital) > 0 {
+		country.Capital = c.Capital[0]
+	}
+
+	codes := make([]string, 0, len(c.Currencies))
+	for code := range c.Currencies {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		currency := c.Currencies[code]
+		country.Currencies = append(country.Currencies, map[string]string{
+			"code":   code,
+			"name":   currency.Name,
+			"symbol": currency.Symbol,
+		})
+	}
+	return country
+}
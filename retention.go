@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRetentionInterval is how often retention is enforced unless
+// RETENTION_INTERVAL says otherwise
+const defaultRetentionInterval = time.Hour
+
+// retentionBatchSize bounds the rows one DELETE removes, so pruning a large
+// backlog does not hold long locks
+const retentionBatchSize = 5000
+
+// retentionTable is a history table pruned by age
+type retentionTable struct {
+	model  interface{}
+	column string
+}
+
+// retentionTables are the targets of RETENTION kept for a span of time.
+// "archives" is kept by count instead.
+var retentionTables = map[string]retentionTable{
+	"rate_history":    {&RateHistory{}, "recorded_at"},
+	"country_history": {&CountryHistory{}, "changed_at"},
+	"anomalies":       {&CountryAnomaly{}, "detected_at"},
+	"tombstones":      {&CountryTombstone{}, "deleted_at"},
+	"setting_changes": {&SettingChange{}, "changed_at"},
+	"api_usage":       {&APIUsage{}, "day"},
+}
+
+// retentionPolicy is how much of one target to keep: rows younger than
+// MaxAge, or the newest Keep archives
+type retentionPolicy struct {
+	MaxAge time.Duration
+	Keep   int
+}
+
+var (
+	retentionPolicies = map[string]retentionPolicy{}
+
+	// retentionStats counts the rows and archives removed per target since
+	// startup, for /metrics
+	retentionStats = struct {
+		sync.Mutex
+		purged  map[string]int64
+		lastRun time.Time
+	}{purged: map[string]int64{}}
+)
+
+// loadRetentionPolicies reads RETENTION, comma-separated target=value pairs
+// such as "rate_history=90d,archives=10". Tables take a span in days,
+// weeks or a Go duration; archives take a count. Targets not listed are
+// kept forever.
+func loadRetentionPolicies() error {
+	policies := map[string]retentionPolicy{}
+	for _, pair := range strings.Split(os.Getenv("RETENTION"), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		target, raw, ok := strings.Cut(pair, "=")
+		target, raw = strings.TrimSpace(target), strings.TrimSpace(raw)
+		if !ok {
+			return fmt.Errorf("invalid RETENTION entry %q (expected target=value)", pair)
+		}
+		if target == "archives" {
+			keep, err := strconv.Atoi(raw)
+			if err != nil || keep < 1 {
+				return fmt.Errorf("invalid RETENTION entry %q: archives takes a count of at least 1", pair)
+			}
+			policies[target] = retentionPolicy{Keep: keep}
+			continue
+		}
+		if _, known := retentionTables[target]; !known {
+			return fmt.Errorf("invalid RETENTION entry %q: target must be one of %s", pair, strings.Join(retentionTargets(), ", "))
+		}
+		age, ok := parseSpan(raw)
+		if !ok || age <= 0 {
+			return fmt.Errorf("invalid RETENTION entry %q: expected a span such as 90d, 12w or 36h", pair)
+		}
+		policies[target] = retentionPolicy{MaxAge: age}
+	}
+	retentionPolicies = policies
+	return nil
+}
+
+// retentionTargets lists every target RETENTION accepts
+func retentionTargets() []string {
+	targets := []string{"archives"}
+	for target := range retentionTables {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// pruneHistory enforces every retention policy once and returns how many
+// rows or archives each target lost. A failing target does not stop the
+// others; the first error is returned.
+func pruneHistory(now time.Time) (map[string]int64, error) {
+	purged := map[string]int64{}
+	var firstErr error
+	for target, policy := range retentionPolicies {
+		var n int64
+		var err error
+		if target == "archives" {
+			n, err = pruneArchives(policy.Keep)
+		} else {
+			n, err = pruneTable(retentionTables[target], now.Add(-policy.MaxAge))
+		}
+		purged[target] = n
+		if err != nil {
+			log.Printf("Retention of %s failed: %v", target, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", target, err)
+			}
+		}
+	}
+
+	retentionStats.Lock()
+	for target, n := range purged {
+		retentionStats.purged[target] += n
+	}
+	retentionStats.lastRun = now
+	retentionStats.Unlock()
+	return purged, firstErr
+}
+
+// pruneTable deletes the rows older than cutoff in batches
+func pruneTable(table retentionTable, cutoff time.Time) (int64, error) {
+	var total int64
+	for {
+		result := db.Where(table.column+" < ?", cutoff).Limit(retentionBatchSize).Delete(table.model)
+		total += result.RowsAffected
+		if result.Error != nil {
+			return total, result.Error
+		}
+		if result.RowsAffected < retentionBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// pruneArchives removes all but the newest keep archives
+func pruneArchives(keep int) (int64, error) {
+	archives, err := listArchives()
+	if err != nil || len(archives) <= keep {
+		return 0, err
+	}
+	var removed int64
+	for _, archive := range archives[keep:] {
+		if err := os.RemoveAll(filepath.Join(archiveDir, archive.ID)); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// runRetentionEvery enforces retention at startup and then once per
+// interval
+func runRetentionEvery(interval time.Duration) {
+	log.Printf("Scheduled retention every %s", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		started := clock.Now()
+		run := scheduledRun{Interval: interval.String(), StartedAt: &started, NextRunAt: started.Add(interval)}
+		purged, err := pruneHistory(started)
+		finished := clock.Now()
+		run.FinishedAt = &finished
+		run.Status = scheduledOK
+		for _, n := range purged {
+			run.Updated += int(n)
+		}
+		run.Processed = len(purged)
+		if err != nil {
+			msg := err.Error()
+			run.Status, run.Error = scheduledFailed, &msg
+		} else if run.Updated > 0 {
+			log.Printf("Retention purged %d rows and archives", run.Updated)
+		}
+		setScheduledRun("retention", run)
+		<-ticker.C
+	}
+}
+
+// writeRetentionMetrics adds the purge counters to /metrics once retention
+// has run
+func writeRetentionMetrics(b *strings.Builder) {
+	retentionStats.Lock()
+	defer retentionStats.Unlock()
+	if retentionStats.lastRun.IsZero() {
+		return
+	}
+
+	b.WriteString("# TYPE retention_purged counter\n# HELP retention_purged Rows (archives for target=\"archives\") removed by retention since startup.\n")
+	targets := make([]string, 0, len(retentionStats.purged))
+	for target := range retentionStats.purged {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		fmt.Fprintf(b, "retention_purged_total{target=\"%s\"} %d\n", target, retentionStats.purged[target])
+	}
+	b.WriteString("# TYPE retention_last_run_seconds gauge\n# HELP retention_last_run_seconds Unix time retention last ran.\n")
+	fmt.Fprintf(b, "retention_last_run_seconds %d\n", retentionStats.lastRun.Unix())
+}
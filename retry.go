@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// retryPolicy controls how upstream fetches are retried after transient
+// failures: network errors, 429 and 5xx responses
+type retryPolicy struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Jitter is the fraction (0-1) of each delay that is randomized
+	Jitter float64
+}
+
+// upstreamRetry is the policy fetchPayload applies; loadRetryPolicy
+// overrides it from the environment
+var upstreamRetry = retryPolicy{
+	Attempts:   3,
+	Backoff:    500 * time.Millisecond,
+	MaxBackoff: 5 * time.Second,
+	Jitter:     0.5,
+}
+
+// statusError is a non-200 upstream response
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("API returned status %d", e.code)
+}
+
+// loadRetryPolicy reads UPSTREAM_RETRY_ATTEMPTS (total tries, 1 disables
+// retries), UPSTREAM_RETRY_BACKOFF and UPSTREAM_RETRY_MAX_BACKOFF (Go
+// durations) and UPSTREAM_RETRY_JITTER
+func loadRetryPolicy() error {
+	policy := upstreamRetry
+	if raw := os.Getenv("UPSTREAM_RETRY_ATTEMPTS"); raw != "" {
+		policy.Attempts = getEnvInt("UPSTREAM_RETRY_ATTEMPTS", 0)
+		if policy.Attempts < 1 {
+			return fmt.Errorf("invalid UPSTREAM_RETRY_ATTEMPTS %q", raw)
+		}
+	}
+	for key, target := range map[string]*time.Duration{
+		"UPSTREAM_RETRY_BACKOFF":     &policy.Backoff,
+		"UPSTREAM_RETRY_MAX_BACKOFF": &policy.MaxBackoff,
+	} {
+		d, err := parseInterval(key)
+		if err != nil {
+			return err
+		}
+		if d > 0 {
+			*target = d
+		}
+	}
+	if raw := os.Getenv("UPSTREAM_RETRY_JITTER"); raw != "" {
+		jitter, err := strconv.ParseFloat(raw, 64)
+		if err != nil || jitter < 0 || jitter > 1 {
+			return fmt.Errorf("invalid UPSTREAM_RETRY_JITTER %q", raw)
+		}
+		policy.Jitter = jitter
+	}
+	upstreamRetry = policy
+	return nil
+}
+
+// retryable reports whether err is worth another attempt
+func retryable(err error) bool {
+	if errors.Is(err, errCircuitOpen) {
+		return false
+	}
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.code == http.StatusTooManyRequests || status.code >= 500
+	}
+	// Payload and schema problems will not fix themselves
+	var schema *schemaError
+	return !errors.As(err, &schema)
+}
+
+// delay is the wait before retry number n (1-based): exponential, capped,
+// with part of it randomized so clients do not retry in lockstep
+func (p retryPolicy) delay(n int) time.Duration {
+	d := p.Backoff << (n - 1)
+	if d <= 0 || d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	jitter := time.Duration(float64(d) * p.Jitter * rand.Float64())
+	return d - jitter
+}
+
+// do calls fn until it succeeds, fails permanently, runs out of attempts
+// or ctx expires, returning the last error
+func (p retryPolicy) do(ctx context.Context, url string, fn func() ([]byte, error)) ([]byte, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		var body []byte
+		if body, err = fn(); err == nil {
+			return body, nil
+		}
+		if attempt >= p.Attempts || !retryable(err) || ctx.Err() != nil {
+			return nil, err
+		}
+
+		wait := p.delay(attempt)
+		// Log host and path only; query strings can carry access keys
+		log.Printf("Fetching %s failed (attempt %d of %d), retrying in %s: %v", breakerKey(url), attempt, p.Attempts, wait, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Outcomes of a scheduled run
+const (
+	scheduledOK      = "ok"
+	scheduledFailed  = "failed"
+	scheduledSkipped = "skipped"
+)
+
+// refreshSchedule is how often each kind of data is refreshed in the
+// background; a zero interval disables that schedule
+type refreshSchedule struct {
+	Countries time.Duration
+	Rates     time.Duration
+	// Images re-renders the dashboard image variants
+	Images time.Duration
+	// ImageCheck compares the cached summary image with the data
+	ImageCheck time.Duration
+	// Retention prunes history per RETENTION; it only runs when a policy
+	// is set
+	Retention time.Duration
+	// Flags checks the stored flag URLs and repairs broken ones
+	Flags time.Duration
+}
+
+// scheduledRun is the outcome of the latest run of one schedule, reported
+// by /status
+type scheduledRun struct {
+	Interval   string     `json:"interval"`
+	Status     string     `json:"status,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Processed  int        `json:"processed"`
+	Updated    int        `json:"updated"`
+	Error      *string    `json:"error,omitempty"`
+	NextRunAt  time.Time  `json:"next_run_at"`
+}
+
+var (
+	scheduledRunsMu sync.Mutex
+	scheduledRuns   = map[string]scheduledRun{}
+)
+
+// scheduledRunsSnapshot copies the per-kind outcomes for /status
+func scheduledRunsSnapshot() map[string]scheduledRun {
+	scheduledRunsMu.Lock()
+	defer scheduledRunsMu.Unlock()
+	runs := make(map[string]scheduledRun, len(scheduledRuns))
+	for kind, run := range scheduledRuns {
+		runs[kind] = run
+	}
+	return runs
+}
+
+func setScheduledRun(kind string, run scheduledRun) {
+	scheduledRunsMu.Lock()
+	scheduledRuns[kind] = run
+	scheduledRunsMu.Unlock()
+}
+
+// loadRefreshSchedule reads COUNTRIES_REFRESH_INTERVAL (or its alias
+// REFRESH_INTERVAL), RATES_REFRESH_INTERVAL, IMAGE_VARIANTS_INTERVAL,
+// SUMMARY_IMAGE_CHECK_INTERVAL and FLAG_CHECK_INTERVAL as Go durations
+// (e.g. 168h, 1h). Only the image check runs by default; 0 disables it.
+func loadRefreshSchedule() (refreshSchedule, error) {
+	var sched refreshSchedule
+	var err error
+	key := "COUNTRIES_REFRESH_INTERVAL"
+	if os.Getenv(key) == "" {
+		key = "REFRESH_INTERVAL"
+	}
+	if sched.Countries, err = parseInterval(key); err != nil {
+		return sched, err
+	}
+	if sched.Rates, err = parseInterval("RATES_REFRESH_INTERVAL"); err != nil {
+		return sched, err
+	}
+	if sched.Images, err = parseInterval("IMAGE_VARIANTS_INTERVAL"); err != nil {
+		return sched, err
+	}
+	sched.ImageCheck = defaultSummaryImageCheckInterval
+	if os.Getenv("SUMMARY_IMAGE_CHECK_INTERVAL") != "" {
+		if sched.ImageCheck, err = parseInterval("SUMMARY_IMAGE_CHECK_INTERVAL"); err != nil {
+			return sched, err
+		}
+	}
+	if sched.Flags, err = parseInterval("FLAG_CHECK_INTERVAL"); err != nil {
+		return sched, err
+	}
+	sched.Retention = defaultRetentionInterval
+	if os.Getenv("RETENTION_INTERVAL") != "" {
+		if sched.Retention, err = parseInterval("RETENTION_INTERVAL"); err != nil {
+			return sched, err
+		}
+	}
+	return sched, nil
+}
+
+func parseInterval(key string) (time.Duration, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q", key, raw)
+	}
+	return d, nil
+}
+
+// start launches a ticker per enabled schedule. A full refresh also updates
+// rates, so the two cadences can overlap safely; a tick that finds any
+// refresh already running is skipped rather than queued.
+func (s refreshSchedule) start() {
+	if s.Countries > 0 {
+		go runEvery(s.Countries, refreshFull, fullRefresh)
+	}
+	if s.Rates > 0 {
+		go runEvery(s.Rates, refreshRatesOnly, ratesRefresh)
+	}
+	if s.Images > 0 {
+		go runImageVariantsEvery(s.Images)
+	}
+	if s.ImageCheck > 0 {
+		go runSummaryImageCheckEvery(s.ImageCheck)
+	}
+	if s.Flags > 0 {
+		go runFlagCheckEvery(s.Flags)
+	}
+	if s.Retention > 0 && len(retentionPolicies) > 0 {
+		go runRetentionEvery(s.Retention)
+	}
+}
+
+// runEvery calls refresh, which expects refreshMu held, once per interval
+func runEvery(interval time.Duration, kind string, refresh func(time.Time) (*refreshSummary, error)) {
+	log.Printf("Scheduled %s refresh every %s", kind, interval)
+	setScheduledRun(kind, scheduledRun{Interval: interval.String(), NextRunAt: clock.Now().Add(interval)})
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		setScheduledRun(kind, runScheduled(interval, kind, refresh))
+	}
+}
+
+// runScheduled performs one tick and describes its outcome
+func runScheduled(interval time.Duration, kind string, refresh func(time.Time) (*refreshSummary, error)) scheduledRun {
+	started := clock.Now()
+	run := scheduledRun{Interval: interval.String(), StartedAt: &started, NextRunAt: started.Add(interval)}
+
+	if !refreshMu.TryLock() {
+		log.Printf("Scheduled %s refresh skipped: a refresh is already running", kind)
+		run.Status = scheduledSkipped
+		return run
+	}
+	summary, err := trackRefresh(kind, started, "", func() (*refreshSummary, error) {
+		return refresh(started)
+	})
+	refreshMu.Unlock()
+
+	finished := clock.Now()
+	run.FinishedAt = &finished
+	if err != nil {
+		log.Printf("Scheduled %s refresh failed: %v", kind, err)
+		notifyAlert(fmt.Sprintf("Scheduled %s refresh failed", kind), err)
+		msg := err.Error()
+		run.Status = scheduledFailed
+		run.Error = &msg
+		return run
+	}
+	log.Printf("Scheduled %s refresh updated %d of %d countries", kind, summary.Inserted+summary.Updated, summary.Processed)
+	run.Status = scheduledOK
+	run.Processed = summary.Processed
+	run.Updated = summary.Inserted + summary.Updated
+	return run
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"gorm.io/gorm/schema"
+)
+
+// varcharType matches the declared size of a varchar column
+var varcharType = regexp.MustCompile(`(?i)^varchar\((\d+)\)$`)
+
+// varcharSize returns the declared size of a varchar field, or 0
+func varcharSize(field *schema.Field) int {
+	m := varcharType.FindStringSubmatch(string(field.DataType))
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+// columnSize is the varchar limit of one Country field
+type columnSize struct {
+	column string
+	size   int
+}
+
+// countryColumnSizes maps Country string fields to their column limits
+var countryColumnSizes = func() map[string]columnSize {
+	parsed, err := schema.Parse(&Country{}, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		panic(err)
+	}
+	sizes := map[string]columnSize{}
+	for _, field := range parsed.Fields {
+		if size := varcharSize(field); size > 0 {
+			sizes[field.Name] = columnSize{column: field.DBName, size: size}
+		}
+	}
+	return sizes
+}()
+
+// checkColumnSizes reports fields of a built row that would not fit their
+// columns. Rows are checked before staging so an oversized upstream value
+// is reported by name rather than truncated or rejected by MySQL.
+func checkColumnSizes(row Country) error {
+	v := reflect.ValueOf(row)
+	var problems []string
+	for name, limit := range countryColumnSizes {
+		field := v.FieldByName(name)
+		var value string
+		switch field.Kind() {
+		case reflect.String:
+			value = field.String()
+		case reflect.Pointer:
+			if field.IsNil() || field.Elem().Kind() != reflect.String {
+				continue
+			}
+			value = field.Elem().String()
+		default:
+			continue
+		}
+		if n := utf8.RuneCountInString(value); n > limit.size {
+			problems = append(problems, fmt.Sprintf("%s is %d characters (limit %d)", limit.column, n, limit.size))
+		}
+	}
+	problems = append(problems, checkCurrencySizes(row)...)
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("value too long: %s", strings.Join(problems, "; "))
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Search result limits
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 50
+)
+
+// Match scores, best first. Fuzzy matches score below every substring match
+// and lose a point per edit.
+const (
+	scoreExact      = 100
+	scorePrefix     = 80
+	scoreWordPrefix = 60
+	scoreSubstring  = 40
+	scoreFuzzy      = 20
+)
+
+// accentFolds maps accented Latin letters to their unaccented base so
+// "Cote d'Ivoire" finds "Côte d'Ivoire"
+var accentFolds = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae", 'ç': "c", 'ć': "c", 'č': "c", 'ď': "d", 'đ': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'į': "i", 'ı': "i",
+	'ł': "l", 'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o", 'œ': "oe",
+	'ř': "r", 'ś': "s", 'š': "s", 'ş': "s", 'ș': "s", 'ß': "ss", 'ť': "t", 'ţ': "t", 'ț': "t",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// foldName lowercases s, strips accents and apostrophes and turns other
+// punctuation into single spaces, so names compare on their letters alone
+func foldName(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(s) {
+		if fold, ok := accentFolds[r]; ok {
+			b.WriteString(fold)
+			space = false
+			continue
+		}
+		if r == '\'' || r == '’' {
+			continue
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			space = false
+			continue
+		}
+		if !space && b.Len() > 0 {
+			b.WriteByte(' ')
+			space = true
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// matchKind names the match quality of a score
+func matchKind(score int) string {
+	switch {
+	case score >= scoreExact:
+		return "exact"
+	case score >= scorePrefix:
+		return "prefix"
+	case score >= scoreWordPrefix:
+		return "word_prefix"
+	case score >= scoreSubstring:
+		return "substring"
+	}
+	return "fuzzy"
+}
+
+// matchScore ranks how well a folded name matches a folded query; 0 means
+// no match. Typos are tolerated at roughly one edit per four letters.
+func matchScore(name, query string) int {
+	switch {
+	case name == query:
+		return scoreExact
+	case strings.HasPrefix(name, query):
+		return scorePrefix
+	case strings.Contains(" "+name, " "+query):
+		return scoreWordPrefix
+	case strings.Contains(name, query):
+		return scoreSubstring
+	}
+
+	allowed := len([]rune(query)) / 4
+	if allowed == 0 {
+		return 0
+	}
+	best := editDistance(name, query)
+	for _, word := range strings.Fields(name) {
+		best = min(best, editDistance(word, query))
+	}
+	// Compare against the name's leading text too, for typos mid-autocomplete
+	if prefix := []rune(name); len(prefix) > len([]rune(query)) {
+		best = min(best, editDistance(string(prefix[:len([]rune(query))]), query))
+	}
+	if best > allowed {
+		return 0
+	}
+	return scoreFuzzy - best
+}
+
+// searchResult is one ranked match
+type searchResult struct {
+	Score int `json:"score"`
+	// Match is the kind of match the score stands for
+	Match   string  `json:"match"`
+	Country Country `json:"country"`
+}
+
+func searchCountries(c *fiber.Ctx) error {
+	query := foldName(c.Query("q"))
+	if query == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": "q is required",
+		})
+	}
+
+	limit := defaultSearchLimit
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "Validation failed",
+				"details": "limit must be between 1 and " + strconv.Itoa(maxSearchLimit),
+			})
+		}
+		limit = n
+	}
+
+	lang, err := parseLanguage(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	var countries []Country
+	if err := db.Find(&countries).Error; err != nil {
+		return err
+	}
+
+	results := []searchResult{}
+	for _, country := range countries {
+		if score := matchScore(foldName(country.Name), query); score > 0 {
+			results = append(results, searchResult{Score: score, Match: matchKind(score), Country: country})
+		}
+	}
+	// Equally good matches put the most populous country first, so typing
+	// "gu" offers Guatemala before Guam
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Country.Population != b.Country.Population {
+			return a.Country.Population > b.Country.Population
+		}
+		return a.Country.Name < b.Country.Name
+	})
+	meta := newListMeta(c, "q", "limit", "lang")
+	meta.filter("q", c.Query("q"))
+	meta.Sort = "score_desc"
+	meta.Total = int64(len(results))
+	meta.Page = &pageMeta{Limit: limit}
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	matched := make([]Country, len(results))
+	for i := range results {
+		matched[i] = results[i].Country
+	}
+	if err := attachCurrencies(db, matched); err != nil {
+		return err
+	}
+	for i := range results {
+		results[i].Country = matched[i]
+		localizeCountry(lang, &results[i].Country)
+	}
+
+	return sendList(c, results, meta)
+}
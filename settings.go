@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Setting is one runtime-tunable setting, stored as its JSON value
+type Setting struct {
+	Key       string    `gorm:"type:varchar(64);primaryKey" json:"key"`
+	Value     string    `gorm:"type:text;not null" json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SettingChange is the audit trail of PUT /admin/settings
+type SettingChange struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Key       string    `gorm:"type:varchar(64);index;not null" json:"key"`
+	OldValue  string    `gorm:"type:text;not null" json:"old_value"`
+	NewValue  string    `gorm:"type:text;not null" json:"new_value"`
+	ChangedBy string    `gorm:"type:varchar(512);serializer:encrypted" json:"changed_by"`
+	ChangedAt time.Time `gorm:"index" json:"changed_at"`
+}
+
+// maxSettingChanges caps the audit entries returned by GET /admin/settings
+const maxSettingChanges = 20
+
+// runtimeSettings are the settings operators can change without a redeploy
+type runtimeSettings struct {
+	// RefreshCooldown is the minimum time between manual refreshes of one
+	// kind, as a Go duration; "0s" disables it
+	RefreshCooldown  string  `json:"refresh_cooldown"`
+	GDPMultiplierMin float64 `json:"gdp_multiplier_min"`
+	GDPMultiplierMax float64 `json:"gdp_multiplier_max"`
+	ImageTheme       string  `json:"image_theme"`
+
+	cooldown time.Duration
+}
+
+// imageTheme is the palette of the generated images
+type imageTheme struct {
+	Background color.RGBA
+	Text       color.RGBA
+	Muted      color.RGBA
+	Accent     color.RGBA
+}
+
+// imageThemes are the palettes image_theme accepts
+var imageThemes = map[string]imageTheme{
+	"light": {
+		Background: color.RGBA{240, 240, 250, 255},
+		Text:       color.RGBA{20, 20, 40, 255},
+		Muted:      color.RGBA{110, 110, 130, 255},
+		Accent:     color.RGBA{37, 99, 235, 255},
+	},
+	"dark": {
+		Background: color.RGBA{24, 26, 38, 255},
+		Text:       color.RGBA{232, 234, 246, 255},
+		Muted:      color.RGBA{150, 152, 170, 255},
+		Accent:     color.RGBA{96, 165, 250, 255},
+	},
+}
+
+var (
+	settingsMu sync.RWMutex
+	settings   = runtimeSettings{
+		RefreshCooldown:  "0s",
+		GDPMultiplierMin: 1000,
+		GDPMultiplierMax: 2000,
+		ImageTheme:       "light",
+	}
+
+	// lastManualRefresh is when each manual refresh kind last started
+	lastManualRefresh = map[string]time.Time{}
+)
+
+// currentSettings returns a copy of the settings in effect
+func currentSettings() runtimeSettings {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return settings
+}
+
+// currentTheme is the palette of the configured image theme
+func currentTheme() imageTheme {
+	return imageThemes[currentSettings().ImageTheme]
+}
+
+// apply validates and sets one setting from its JSON value
+func (s *runtimeSettings) apply(key string, raw json.RawMessage) error {
+	switch key {
+	case "refresh_cooldown":
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return fmt.Errorf("%s must be a duration string such as \"5m\"", key)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return fmt.Errorf("%s must be a non-negative duration such as \"5m\"", key)
+		}
+		s.RefreshCooldown, s.cooldown = d.String(), d
+	case "gdp_multiplier_min", "gdp_multiplier_max":
+		var value float64
+		if err := json.Unmarshal(raw, &value); err != nil || value <= 0 {
+			return fmt.Errorf("%s must be a positive number", key)
+		}
+		if key == "gdp_multiplier_min" {
+			s.GDPMultiplierMin = value
+		} else {
+			s.GDPMultiplierMax = value
+		}
+	case "image_theme":
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return fmt.Errorf("%s must be light or dark", key)
+		}
+		if _, ok := imageThemes[value]; !ok {
+			return fmt.Errorf("%s must be light or dark", key)
+		}
+		s.ImageTheme = value
+	default:
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	return nil
+}
+
+// validate checks rules that span settings
+func (s runtimeSettings) validate() error {
+	if s.GDPMultiplierMin > s.GDPMultiplierMax {
+		return fmt.Errorf("gdp_multiplier_min (%g) cannot exceed gdp_multiplier_max (%g)", s.GDPMultiplierMin, s.GDPMultiplierMax)
+	}
+	return nil
+}
+
+// values encodes every setting as the JSON stored in the settings table
+func (s runtimeSettings) values() map[string]string {
+	encoded, _ := json.Marshal(s)
+	var fields map[string]json.RawMessage
+	json.Unmarshal(encoded, &fields)
+	values := make(map[string]string, len(fields))
+	for key, raw := range fields {
+		values[key] = string(raw)
+	}
+	return values
+}
+
+// loadSettings applies the stored settings over the defaults. Invalid rows
+// are logged and skipped so a bad value cannot stop startup.
+func loadSettings() error {
+	var rows []Setting
+	if err := db.Find(&rows).Error; err != nil {
+		return err
+	}
+
+	loaded := currentSettings()
+	for _, row := range rows {
+		next := loaded
+		if err := next.apply(row.Key, json.RawMessage(row.Value)); err != nil {
+			log.Printf("Ignoring stored setting %s: %v", row.Key, err)
+			continue
+		}
+		loaded = next
+	}
+	if err := loaded.validate(); err != nil {
+		log.Printf("Ignoring stored GDP multiplier range: %v", err)
+		defaults := currentSettings()
+		loaded.GDPMultiplierMin, loaded.GDPMultiplierMax = defaults.GDPMultiplierMin, defaults.GDPMultiplierMax
+	}
+
+	settingsMu.Lock()
+	settings = loaded
+	settingsMu.Unlock()
+	return nil
+}
+
+// claimManualRefresh starts the cooldown of a manual refresh kind, or
+// returns how long the caller must wait. Wall time is used so FIXED_TIME
+// does not freeze the cooldown.
+func claimManualRefresh(kind string) time.Duration {
+	cooldown := currentSettings().cooldown
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+
+	now := time.Now()
+	if last, ok := lastManualRefresh[kind]; ok && cooldown > 0 {
+		if wait := last.Add(cooldown).Sub(now); wait > 0 {
+			return wait
+		}
+	}
+	lastManualRefresh[kind] = now
+	return 0
+}
+
+// refreshCooldownError answers a manual refresh made during the cooldown
+func refreshCooldownError(c *fiber.Ctx, wait time.Duration) error {
+	seconds := int(wait.Seconds() + 0.999)
+	c.Set(fiber.HeaderRetryAfter, fmt.Sprint(seconds))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":   "Refresh cooldown",
+		"details": fmt.Sprintf("a refresh ran recently; retry in %ds (refresh_cooldown is %s)", seconds, currentSettings().RefreshCooldown),
+	})
+}
+
+func getSettings(c *fiber.Ctx) error {
+	changes := []SettingChange{}
+	if err := db.Order("changed_at DESC, id DESC").Limit(maxSettingChanges).Find(&changes).Error; err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{
+		"settings":       currentSettings(),
+		"recent_changes": changes,
+	})
+}
+
+// putSettings updates any subset of the settings. The whole request is
+// validated first and written with its audit rows in one transaction.
+func putSettings(c *fiber.Ctx) error {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(c.Body(), &body); err != nil || len(body) == 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": "body must be a JSON object with at least one setting",
+		})
+	}
+
+	current := currentSettings()
+	next := current
+	var problems []string
+	keys := make([]string, 0, len(body))
+	for key := range body {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := next.apply(key, body[key]); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) == 0 {
+		if err := next.validate(); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": strings.Join(problems, "; "),
+		})
+	}
+
+	oldValues, newValues := current.values(), next.values()
+	now := clock.Now()
+	changed := []string{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, key := range keys {
+			if oldValues[key] == newValues[key] {
+				continue
+			}
+			changed = append(changed, key)
+			if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).
+				Create(&Setting{Key: key, Value: newValues[key], UpdatedAt: now}).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&SettingChange{
+				Key:       key,
+				OldValue:  oldValues[key],
+				NewValue:  newValues[key],
+				ChangedBy: requestActor(c),
+				ChangedAt: now,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	settingsMu.Lock()
+	settings = next
+	settingsMu.Unlock()
+
+	// Redraw the cached summary image in the new palette
+	if next.ImageTheme != current.ImageTheme {
+		images.enqueue()
+	}
+
+	return c.JSON(fiber.Map{
+		"settings": next,
+		"changed":  changed,
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRuntimeSettingsApply(t *testing.T) {
+	tests := []struct {
+		key     string
+		raw     string
+		wantErr bool
+		check   func(runtimeSettings) bool
+	}{
+		{key: "refresh_cooldown", raw: `"5m"`, check: func(s runtimeSettings) bool {
+			return s.RefreshCooldown == "5m0s" && s.cooldown == 5*time.Minute
+		}},
+		{key: "refresh_cooldown", raw: `"0s"`, check: func(s runtimeSettings) bool { return s.cooldown == 0 }},
+		{key: "refresh_cooldown", raw: `"-1m"`, wantErr: true},
+		{key: "refresh_cooldown", raw: `"soon"`, wantErr: true},
+		{key: "refresh_cooldown", raw: `300`, wantErr: true},
+		{key: "gdp_multiplier_min", raw: `500`, check: func(s runtimeSettings) bool { return s.GDPMultiplierMin == 500 }},
+		{key: "gdp_multiplier_max", raw: `2500.5`, check: func(s runtimeSettings) bool { return s.GDPMultiplierMax == 2500.5 }},
+		{key: "gdp_multiplier_min", raw: `0`, wantErr: true},
+		{key: "gdp_multiplier_max", raw: `"2000"`, wantErr: true},
+		{key: "image_theme", raw: `"dark"`, check: func(s runtimeSettings) bool { return s.ImageTheme == "dark" }},
+		{key: "image_theme", raw: `"sepia"`, wantErr: true},
+		{key: "unknown", raw: `1`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.raw, func(t *testing.T) {
+			s := runtimeSettings{RefreshCooldown: "0s", GDPMultiplierMin: 1000, GDPMultiplierMax: 2000, ImageTheme: "light"}
+			err := s.apply(tt.key, json.RawMessage(tt.raw))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("apply(%s, %s) = %v, want error: %v", tt.key, tt.raw, err, tt.wantErr)
+			}
+			if tt.check != nil && !tt.check(s) {
+				t.Errorf("apply(%s, %s) left %+v", tt.key, tt.raw, s)
+			}
+		})
+	}
+}
+
+func TestRuntimeSettingsValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max float64
+		wantErr  bool
+	}{
+		{"ordered", 1000, 2000, false},
+		{"equal", 1500, 1500, false},
+		{"inverted", 2500, 2000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runtimeSettings{GDPMultiplierMin: tt.min, GDPMultiplierMax: tt.max}.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate(%g, %g) = %v, want error: %v", tt.min, tt.max, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// slugify turns a name into a URL-safe slug: accents folded, apostrophes
+// dropped and everything else between letters and digits collapsed into
+// single hyphens. "Congo (Democratic Republic of the)" becomes
+// "congo-democratic-republic-of-the".
+func slugify(s string) string {
+	words := []string{}
+	for _, word := range strings.Fields(foldName(s)) {
+		word = strings.Map(func(r rune) rune {
+			if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+				return r
+			}
+			return -1
+		}, word)
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, "-")
+}
+
+// isSlug reports whether s could be a slug, ignoring case
+func isSlug(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range strings.ToLower(s) {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// assignSlugs sets the slug of each row. Slugs are unique: one already
+// held by another country gets a numeric suffix ("-2", "-3", ...), while a
+// country keeps the slug of its own name across refreshes.
+func assignSlugs(conn *gorm.DB, rows []Country) error {
+	var live []Country
+	if err := conn.Select("name", "slug").Where("slug IS NOT NULL").Find(&live).Error; err != nil {
+		return err
+	}
+	// taken maps each slug to the lower-cased name holding it
+	taken := make(map[string]string, len(live)+len(rows))
+	for _, country := range live {
+		taken[*country.Slug] = strings.ToLower(country.Name)
+	}
+
+	for i := range rows {
+		name := strings.ToLower(rows[i].Name)
+		base := slugify(rows[i].Name)
+		if base == "" {
+			base = "country"
+		}
+		slug := base
+		for n := 2; taken[slug] != "" && taken[slug] != name; n++ {
+			slug = base + "-" + strconv.Itoa(n)
+		}
+		taken[slug] = name
+		rows[i].Slug = &slug
+	}
+	return nil
+}
+
+// backfillSlugs gives countries stored before slugs existed their slug.
+// It leaves updated_at alone, as the data itself does not change.
+func backfillSlugs() error {
+	var missing []Country
+	if err := db.Select("id", "name").Where("slug IS NULL").Order("name ASC").Find(&missing).Error; err != nil {
+		return err
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := assignSlugs(tx, missing); err != nil {
+			return err
+		}
+		for _, country := range missing {
+			if err := tx.Model(&Country{ID: country.ID}).UpdateColumn("slug", *country.Slug).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// StagedCountry is a refresh result waiting to be published. Refreshes load
+// the whole upstream snapshot here, validate and diff it against the live
+// table, and only then merge it into countries in one transaction.
+type StagedCountry Country
+
+func (StagedCountry) TableName() string {
+	return "countries_staging"
+}
+
+// refreshBatchSize is the rows per multi-row INSERT when a refresh writes
+// staging or history rows; a full snapshot (~250 countries) fits in one
+const refreshBatchSize = 500
+
+// maxZeroPopulationShare is the fraction of staged countries allowed to
+// report no population before the snapshot is rejected as bogus
+const maxZeroPopulationShare = 0.5
+
+// errStagingInvalid is returned when a staged snapshot fails validation
+var errStagingInvalid = errors.New("staged refresh failed validation")
+
+// buildCountry converts an upstream record into the row we store, merging
+// in World Bank values (nil when unused) per the field precedence rules
+func buildCountry(country RestCountry, rates map[string]float64, wb *worldBankLatest, now time.Time, rng *rand.Rand) Country {
+	var currencyCode, currencyName, currencySymbol *string
+	var exchangeRate *float64
+
+	// Handle currency
+	if len(country.Currencies) > 0 && country.Currencies[0]["code"] != "" {
+		code := country.Currencies[0]["code"]
+		currencyCode = &code
+		name, symbol := country.Currencies[0]["name"], country.Currencies[0]["symbol"]
+		currencyName, currencySymbol = nilIfEmpty(&name), nilIfEmpty(&symbol)
+
+		// Get exchange rate
+		if rate, exists := rates[code]; exists {
+			exchangeRate = &rate
+		}
+	}
+
+	// Records without alpha2Code fall back to the flagcdn code in the flag URL
+	alpha2 := strings.ToUpper(country.Alpha2Code)
+	if alpha2 == "" {
+		alpha2 = strings.ToUpper(flagCode(&country.Flag))
+	}
+	alpha3 := country.Alpha3Code
+	capital := country.Capital
+	region := country.Region
+	subregion := country.Subregion
+	flagURL := country.Flag
+
+	row := Country{
+		Name:            country.Name,
+		Alpha2Code:      nilIfEmpty(&alpha2),
+		Alpha3Code:      nilIfEmpty(&alpha3),
+		Capital:         nilIfEmpty(&capital),
+		Region:          nilIfEmpty(&region),
+		Subregion:       nilIfEmpty(&subregion),
+		Population:      country.Population,
+		CurrencyCode:    currencyCode,
+		CurrencyName:    currencyName,
+		CurrencySymbol:  currencySymbol,
+		ExchangeRate:    exchangeRate,
+		FlagURL:         nilIfEmpty(&flagURL),
+		Source:          sourceRestCountries,
+		LastRefreshedAt: now,
+	}
+	row.Currencies = buildCurrencies(country, rates)
+	// Population, estimated GDP and their tiers
+	mergeCountry(&row, country, rates, wb, rng)
+	return row
+}
+
+// stageCountries replaces the staging table with the given snapshot.
+// Duplicate names (case-insensitive) keep the last record, as the old
+// row-by-row upsert did.
+func stageCountries(ctx context.Context, countries []Country) (int, error) {
+	order := []string{}
+	byName := map[string]StagedCountry{}
+	for _, country := range countries {
+		key := strings.ToLower(country.Name)
+		if _, seen := byName[key]; !seen {
+			order = append(order, key)
+		}
+		byName[key] = StagedCountry(country)
+	}
+
+	rows := make([]StagedCountry, 0, len(order))
+	for _, key := range order {
+		rows = append(rows, byName[key])
+	}
+
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM countries_staging").Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(&rows, refreshBatchSize).Error
+	})
+	return len(rows), err
+}
+
+// validateStaging checks the staged snapshot before it may be published
+func validateStaging(ctx context.Context, expected int) error {
+	conn := db.WithContext(ctx)
+	var total, zeroPopulation int64
+	if err := conn.Model(&StagedCountry{}).Count(&total).Error; err != nil {
+		return err
+	}
+	if int(total) != expected {
+		return fmt.Errorf("%w: staged %d of %d countries", errStagingInvalid, total, expected)
+	}
+	if total == 0 {
+		return fmt.Errorf("%w: no countries staged", errStagingInvalid)
+	}
+
+	if err := conn.Model(&StagedCountry{}).Where("population <= 0").Count(&zeroPopulation).Error; err != nil {
+		return err
+	}
+	if float64(zeroPopulation)/float64(total) > maxZeroPopulationShare {
+		return fmt.Errorf("%w: %d of %d countries have no population", errStagingInvalid, zeroPopulation, total)
+	}
+	return nil
+}
+
+// diffStaging compares the staged snapshot with the live table, counting
+// inserts and updates and recording movers, anomalies and the change
+// history on the summary
+func diffStaging(ctx context.Context, summary *refreshSummary, rates map[string]float64) error {
+	conn := db.WithContext(ctx)
+	var staged []StagedCountry
+	if err := conn.Find(&staged).Error; err != nil {
+		return err
+	}
+	var live []Country
+	if err := conn.Find(&live).Error; err != nil {
+		return err
+	}
+
+	byName := make(map[string]Country, len(live))
+	byCode := make(map[string]Country, len(live))
+	for _, country := range live {
+		byName[strings.ToLower(country.Name)] = country
+		if country.Alpha3Code != nil {
+			byCode[*country.Alpha3Code] = country
+		}
+	}
+	stagedNames := make(map[string]bool, len(staged))
+	for _, s := range staged {
+		stagedNames[strings.ToLower(s.Name)] = true
+	}
+
+	for _, s := range staged {
+		updated := Country(s)
+		old, exists := byName[strings.ToLower(updated.Name)]
+		renamed := false
+		// A new name for a live country's code, whose old name upstream no
+		// longer lists, is a rename of that country
+		if !exists && updated.Alpha3Code != nil {
+			if holder, ok := byCode[*updated.Alpha3Code]; ok && !stagedNames[strings.ToLower(holder.Name)] {
+				old, exists, renamed = holder, true, true
+				if summary.renames == nil {
+					summary.renames = map[string]string{}
+				}
+				summary.renames[holder.Name] = updated.Name
+			}
+		}
+		if !exists {
+			summary.Inserted++
+			summary.history = append(summary.history, *historyEntry(nil, &updated, historySourceRefresh))
+			continue
+		}
+		summary.Updated++
+		if entry := historyEntry(&old, &updated, historySourceRefresh); entry != nil {
+			// The live name keeps its casing on publish
+			if !renamed {
+				entry.Country = old.Name
+			}
+			summary.history = append(summary.history, *entry)
+		}
+		summary.trackMover(old, updated)
+		summary.checkAnomaly(old, updated, rates)
+		summary.checkPopulation(old, updated)
+	}
+	return nil
+}
+
+// stagedColumns are copied from staging into the live table on publish
+var stagedColumns = []string{
+	"slug", "alpha2_code", "alpha3_code", "capital", "region", "subregion", "population", "currency_code",
+	"currency_name", "currency_symbol",
+	"exchange_rate", "estimated_gdp", "flag_url", "population_tier",
+	"gdp_tier", "field_sources", "last_refreshed_at", "rate_as_of",
+	"previous_exchange_rate", "previous_estimated_gdp",
+}
+
+// renameCountries gives live countries the names upstream lists their
+// codes under (see diffStaging), so publishStaging matches them by name
+func renameCountries(tx *gorm.DB, renames map[string]string) error {
+	for from, to := range renames {
+		if err := tx.Model(&Country{}).Where("name = ?", from).Update("name", to).Error; err != nil {
+			return storeError(err)
+		}
+	}
+	return nil
+}
+
+// checkManualCodes rejects a row whose alpha-2 or alpha-3 code a manual
+// country holds
+func checkManualCodes(row Country, manualCodes map[string]string) error {
+	for _, code := range []*string{row.Alpha2Code, row.Alpha3Code} {
+		if code == nil {
+			continue
+		}
+		if owner, ok := manualCodes[strings.ToUpper(*code)]; ok && !strings.EqualFold(owner, row.Name) {
+			return fmt.Errorf("code %s belongs to the manual country %s", *code, owner)
+		}
+	}
+	return nil
+}
+
+// publishStaging merges the staged snapshot into countries: matching names
+// are overwritten and new names inserted. Countries missing upstream are
+// left in place, and lose any alpha-2 or alpha-3 code the snapshot gives
+// another country.
+func publishStaging(tx *gorm.DB) error {
+	now := clock.Now()
+
+	// The unique keys allow one holder per code
+	for _, column := range []string{"alpha2_code", "alpha3_code"} {
+		release := fmt.Sprintf(`UPDATE countries c
+JOIN countries_staging s ON c.%[1]s = s.%[1]s AND LOWER(c.name) <> LOWER(s.name)
+SET c.%[1]s = NULL`, column)
+		if err := tx.Exec(release).Error; err != nil {
+			return err
+		}
+	}
+
+	// Stage the values being replaced, for the change fields
+	if err := tx.Exec(`UPDATE countries_staging s
+JOIN countries c ON LOWER(c.name) = LOWER(s.name)
+SET s.previous_exchange_rate = c.exchange_rate, s.previous_estimated_gdp = c.estimated_gdp`).Error; err != nil {
+		return err
+	}
+
+	sets := make([]string, len(stagedColumns))
+	for i, col := range stagedColumns {
+		sets[i] = fmt.Sprintf("c.%s = s.%s", col, col)
+	}
+	update := `UPDATE countries c
+JOIN countries_staging s ON LOWER(c.name) = LOWER(s.name)
+SET ` + strings.Join(sets, ", ") + `, c.updated_at = ?`
+	if err := tx.Exec(update, now).Error; err != nil {
+		return err
+	}
+
+	cols := strings.Join(stagedColumns, ", ")
+	insert := `INSERT INTO countries (name, ` + cols + `, created_at, updated_at)
+SELECT s.name, s.` + strings.Join(stagedColumns, ", s.") + `, ?, ?
+FROM countries_staging s
+WHERE NOT EXISTS (SELECT 1 FROM countries c WHERE LOWER(c.name) = LOWER(s.name))`
+	return tx.Exec(insert, now, now).Error
+}
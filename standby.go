@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	bolt "go.etcd.io/bbolt"
+	"gorm.io/gorm"
+)
+
+// Bolt buckets of a standby snapshot. countries holds the response blob of
+// each country under its read model key; meta holds the snapshot's
+// metadata as one JSON document under "snapshot".
+var (
+	standbyCountriesBucket = []byte("countries")
+	standbyMetaBucket      = []byte("meta")
+)
+
+// standbyFormat versions the snapshot layout
+const standbyFormat = 1
+
+// defaultStandbyRetry is how often a standby instance retries MySQL unless
+// STANDBY_RETRY_INTERVAL says otherwise
+const defaultStandbyRetry = 5 * time.Second
+
+var (
+	// standbyURL is where snapshots are published and fetched from: a file
+	// path or an http(s) URL accepting PUT and GET. Empty disables them.
+	standbyURL string
+	// standbyPath is the local copy served while MySQL is unreachable
+	standbyPath  string
+	standbyRetry = defaultStandbyRetry
+)
+
+// standbyMeta describes a snapshot
+type standbyMeta struct {
+	Format          int       `json:"format"`
+	CreatedAt       time.Time `json:"created_at"`
+	Countries       int       `json:"countries"`
+	LastRefreshedAt time.Time `json:"last_refreshed_at"`
+}
+
+// standbyStatus reports snapshot publishing for GET /status
+type standbyStatus struct {
+	Pending         bool       `json:"pending"`
+	LastPublishedAt *time.Time `json:"last_published_at"`
+	LastError       *string    `json:"last_error"`
+}
+
+// standbyWorker publishes snapshots in the background. Triggers coalesce
+// like the summary image's: changes while a run is queued cause one run.
+type standbyWorker struct {
+	trigger chan struct{}
+
+	mu     sync.Mutex
+	status standbyStatus
+}
+
+var standby = &standbyWorker{trigger: make(chan struct{}, 1)}
+
+// loadStandbySettings reads STANDBY_SNAPSHOT_URL, STANDBY_SNAPSHOT_PATH and
+// STANDBY_RETRY_INTERVAL
+func loadStandbySettings() error {
+	raw := os.Getenv("STANDBY_SNAPSHOT_URL")
+	if raw != "" {
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "file") {
+			return fmt.Errorf("invalid STANDBY_SNAPSHOT_URL %q (expected a path or an http, https or file URL)", raw)
+		}
+	}
+	retry := defaultStandbyRetry
+	if os.Getenv("STANDBY_RETRY_INTERVAL") != "" {
+		d, err := parseInterval("STANDBY_RETRY_INTERVAL")
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid STANDBY_RETRY_INTERVAL %q", os.Getenv("STANDBY_RETRY_INTERVAL"))
+		}
+		retry = d
+	}
+	standbyURL, standbyPath, standbyRetry = raw, getEnv("STANDBY_SNAPSHOT_PATH", "cache/standby.db"), retry
+	return nil
+}
+
+// standbyFilePath returns the file a snapshot URL points to, or "" for an
+// http(s) URL
+func standbyFilePath(raw string) string {
+	u, err := url.Parse(raw)
+	switch {
+	case err != nil || u.Scheme == "":
+		return raw
+	case u.Scheme == "file":
+		return u.Path
+	}
+	return ""
+}
+
+// enqueue schedules a snapshot when publishing is configured
+func (w *standbyWorker) enqueue() {
+	if standbyURL == "" {
+		return
+	}
+	select {
+	case w.trigger <- struct{}{}:
+		w.mu.Lock()
+		w.status.Pending = true
+		w.mu.Unlock()
+	default:
+	}
+}
+
+// run publishes snapshots until the process exits
+func (w *standbyWorker) run() {
+	for range w.trigger {
+		w.mu.Lock()
+		w.status.Pending = false
+		w.mu.Unlock()
+
+		err := publishStandbySnapshot()
+
+		w.mu.Lock()
+		if err != nil {
+			msg := err.Error()
+			w.status.LastError = &msg
+			log.Printf("Failed to publish standby snapshot: %v", err)
+		} else {
+			now := clock.Now()
+			w.status.LastPublishedAt = &now
+			w.status.LastError = nil
+		}
+		w.mu.Unlock()
+	}
+}
+
+// snapshot reports publishing for /status; nil when it is off
+func (w *standbyWorker) snapshot() *standbyStatus {
+	if standbyURL == "" {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	status := w.status
+	return &status
+}
+
+// publishStandbySnapshot writes every country to a new Bolt file and
+// publishes it to STANDBY_SNAPSHOT_URL
+func publishStandbySnapshot() error {
+	var countries []Country
+	if err := db.Order("name ASC").Find(&countries).Error; err != nil {
+		return err
+	}
+	if err := attachCurrencies(db, countries); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp("", "standby-*.db")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := writeStandbySnapshot(tmp.Name(), countries); err != nil {
+		return err
+	}
+	return uploadStandbySnapshot(tmp.Name())
+}
+
+// writeStandbySnapshot stores the countries and their metadata in a Bolt
+// file at path
+func writeStandbySnapshot(path string, countries []Country) error {
+	blobs, err := encodeCountries(countries)
+	if err != nil {
+		return err
+	}
+	meta := standbyMeta{Format: standbyFormat, CreatedAt: clock.Now(), Countries: len(countries)}
+	for _, country := range countries {
+		if country.LastRefreshedAt.After(meta.LastRefreshedAt) {
+			meta.LastRefreshedAt = country.LastRefreshedAt
+		}
+	}
+	encodedMeta, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	file, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	err = file.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket(standbyCountriesBucket)
+		if err != nil {
+			return err
+		}
+		for key, blob := range blobs {
+			if err := bucket.Put([]byte(key), blob); err != nil {
+				return err
+			}
+		}
+		metaBucket, err := tx.CreateBucket(standbyMetaBucket)
+		if err != nil {
+			return err
+		}
+		return metaBucket.Put([]byte("snapshot"), encodedMeta)
+	})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// uploadStandbySnapshot copies a written snapshot to its destination,
+// replacing a local file atomically or PUTting it over HTTP
+func uploadStandbySnapshot(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if dest := standbyFilePath(standbyURL); dest != "" {
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dest+".tmp", data, 0o644); err != nil {
+			return err
+		}
+		return os.Rename(dest+".tmp", dest)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, standbyURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("snapshot upload returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// fetchStandbySnapshot downloads the published snapshot to standbyPath. A
+// failed download keeps the local copy from an earlier start, if any.
+func fetchStandbySnapshot() error {
+	if err := os.MkdirAll(filepath.Dir(standbyPath), 0o755); err != nil {
+		return err
+	}
+	var data []byte
+	var err error
+	if src := standbyFilePath(standbyURL); src != "" {
+		data, err = os.ReadFile(src)
+	} else {
+		data, err = downloadStandbySnapshot()
+	}
+	if err != nil {
+		if _, statErr := os.Stat(standbyPath); statErr == nil {
+			log.Printf("Failed to fetch standby snapshot, using the local copy: %v", err)
+			return nil
+		}
+		return err
+	}
+	if err := os.WriteFile(standbyPath+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(standbyPath+".tmp", standbyPath)
+}
+
+func downloadStandbySnapshot() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, standbyURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("snapshot download returned HTTP %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// standbyReads serves countries from the opened snapshot, which reload
+// swaps for a newer one
+type standbyReads struct {
+	mu   sync.RWMutex
+	file *bolt.DB
+	meta standbyMeta
+}
+
+// openStandbySnapshot opens the local snapshot read-only
+func openStandbySnapshot() (*standbyReads, error) {
+	reads := &standbyReads{}
+	if err := reads.reload(); err != nil {
+		return nil, err
+	}
+	return reads, nil
+}
+
+// reload opens the local snapshot again, after a newer one was fetched, and
+// closes the previous file once its readers are done
+func (s *standbyReads) reload() error {
+	file, err := bolt.Open(standbyPath, 0o444, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	var meta standbyMeta
+	err = file.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(standbyMetaBucket)
+		if bucket == nil || tx.Bucket(standbyCountriesBucket) == nil {
+			return fmt.Errorf("%s is not a standby snapshot", standbyPath)
+		}
+		return json.Unmarshal(bucket.Get([]byte("snapshot")), &meta)
+	})
+	if err == nil && meta.Format != standbyFormat {
+		err = fmt.Errorf("standby snapshot format %d is not supported", meta.Format)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	s.mu.Lock()
+	previous := s.file
+	s.file, s.meta = file, meta
+	s.mu.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+func (s *standbyReads) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file != nil {
+		s.file.Close()
+	}
+}
+
+func (s *standbyReads) snapshotMeta() standbyMeta {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.meta
+}
+
+// get returns the blob of one country by case-insensitive name or, as
+// findCountry does, by slug or alpha-2 or alpha-3 code
+func (s *standbyReads) get(identifier string) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var blob []byte
+	s.file.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(standbyCountriesBucket)
+		if value := bucket.Get([]byte(readModelKey(identifier))); value != nil {
+			blob = append([]byte{}, value...)
+			return nil
+		}
+		if !isSlug(identifier) {
+			return nil
+		}
+		slug, code := strings.ToLower(identifier), strings.ToUpper(identifier)
+		var byCode []byte
+		bucket.ForEach(func(_, v []byte) error {
+			var keys struct {
+				Slug       *string `json:"slug"`
+				Alpha2Code *string `json:"alpha2_code"`
+				Alpha3Code *string `json:"alpha3_code"`
+			}
+			if blob != nil || json.Unmarshal(v, &keys) != nil {
+				return nil
+			}
+			if keys.Slug != nil && *keys.Slug == slug {
+				blob = append([]byte{}, v...)
+			} else if byCode == nil && ((keys.Alpha2Code != nil && *keys.Alpha2Code == code) || (keys.Alpha3Code != nil && *keys.Alpha3Code == code)) {
+				byCode = append([]byte{}, v...)
+			}
+			return nil
+		})
+		if blob == nil {
+			blob = byCode
+		}
+		return nil
+	})
+	return blob, blob != nil
+}
+
+// all returns every blob, sorted by name
+func (s *standbyReads) all() []json.RawMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys, blobs := []string{}, map[string]json.RawMessage{}
+	s.file.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(standbyCountriesBucket).ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			blobs[string(k)] = append(json.RawMessage{}, v...)
+			return nil
+		})
+	})
+	sort.Strings(keys)
+	out := make([]json.RawMessage, len(keys))
+	for i, key := range keys {
+		out[i] = blobs[key]
+	}
+	return out
+}
+
+// snapshotCountryFields are the fields of a blob the list filters read
+type snapshotCountryFields struct {
+	Region       *string `json:"region"`
+	CurrencyCode *string `json:"currency_code"`
+	Currencies   []struct {
+		Code string `json:"code"`
+	} `json:"currencies"`
+}
+
+// snapshotApp serves the snapshot's reads: GET /status, /countries and
+// /countries/:name. mode names the deployment in /status; unavailable
+// answers every other route.
+func snapshotApp(reads *standbyReads, mode string, unavailable fiber.Handler) *fiber.App {
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Use(requestLogging())
+	app.Use(cors.New())
+
+	app.Get("/status", func(c *fiber.Ctx) error {
+		meta := reads.snapshotMeta()
+		return c.JSON(fiber.Map{
+			"mode":              mode,
+			"degraded":          mode == "standby",
+			"standby":           meta,
+			"total_countries":   meta.Countries,
+			"last_refreshed_at": meta.LastRefreshedAt,
+		})
+	})
+	app.Get("/countries", negotiateFormat(), reads.listCountries)
+	app.Get("/countries/:name", negotiateFormat(), reads.getCountry)
+	app.Use(unavailable)
+	return app
+}
+
+// stamp marks a response as served from the snapshot, with the time it was
+// taken
+func (s *standbyReads) stamp(c *fiber.Ctx) {
+	c.Set("X-Standby-Snapshot", s.snapshotMeta().CreatedAt.Format(time.RFC3339))
+}
+
+// listCountries serves GET /countries from the snapshot: countries sorted
+// by name, filtered by region and currency and paged by limit and offset
+func (s *standbyReads) listCountries(c *fiber.Ctx) error {
+	page, err := parsePage(c)
+	if err == nil && page.Cursor {
+		err = fmt.Errorf("cursor paging is not supported here; use limit and offset")
+	}
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+	meta := newListMeta(c, "region", "currency", "limit", "offset", "format")
+	meta.Sort = "name_asc"
+	region, currency := c.Query("region"), c.Query("currency")
+	if region != "" {
+		meta.filter("region", region)
+	}
+	if currency != "" {
+		meta.filter("currency", currency)
+	}
+
+	countries := []json.RawMessage{}
+	for _, blob := range s.all() {
+		var fields snapshotCountryFields
+		if err := json.Unmarshal(blob, &fields); err != nil {
+			return err
+		}
+		if region != "" && (fields.Region == nil || !strings.EqualFold(*fields.Region, region)) {
+			continue
+		}
+		if currency != "" && !usesCurrency(fields, currency) {
+			continue
+		}
+		countries = append(countries, blob)
+	}
+	meta.Total = int64(len(countries))
+	if page.Limit > 0 {
+		start := min(page.Offset, len(countries))
+		countries = countries[start:min(start+page.Limit, len(countries))]
+	}
+	meta.Page = pageMetaFor(page, "")
+	s.stamp(c)
+	if wantsXML(c) {
+		decoded := make([]Country, len(countries))
+		for i, blob := range countries {
+			if err := json.Unmarshal(blob, &decoded[i]); err != nil {
+				return err
+			}
+		}
+		return sendCountryList(c, decoded, meta)
+	}
+	return sendList(c, countries, meta)
+}
+
+// getCountry serves GET /countries/:name from the snapshot
+func (s *standbyReads) getCountry(c *fiber.Ctx) error {
+	blob, ok := s.get(c.Params("name"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Country not found",
+		})
+	}
+	s.stamp(c)
+	return sendCountryBlob(c, blob)
+}
+
+// usesCurrency reports whether a snapshot country uses the currency, as the
+// ?currency= filter of /countries matches any of a country's currencies
+func usesCurrency(fields snapshotCountryFields, code string) bool {
+	if fields.CurrencyCode != nil && strings.EqualFold(*fields.CurrencyCode, code) {
+		return true
+	}
+	for _, currency := range fields.Currencies {
+		if strings.EqualFold(currency.Code, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveStandby serves reads from the published snapshot while MySQL is
+// unreachable at startup, and returns the connection once MySQL answers.
+// The standby server is shut down first so the full one can take the port.
+func serveStandby(dsn string, connectErr error) (*gorm.DB, error) {
+	if err := fetchStandbySnapshot(); err != nil {
+		return nil, fmt.Errorf("%w; standby snapshot unavailable: %v", connectErr, err)
+	}
+	reads, err := openStandbySnapshot()
+	if err != nil {
+		return nil, fmt.Errorf("%w; standby snapshot unavailable: %v", connectErr, err)
+	}
+	defer reads.close()
+	log.Printf("Database unreachable (%v); serving standby snapshot from %s", connectErr, reads.snapshotMeta().CreatedAt.Format(time.RFC3339))
+
+	app := snapshotApp(reads, "standby", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderRetryAfter, fmt.Sprint(max(int(standbyRetry.Seconds()), 1)))
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error":   "Service unavailable",
+			"details": "the database is unreachable; only GET /status, /countries and /countries/:name are served, from the standby snapshot",
+		})
+	})
+
+	port := getEnv("PORT", "3000")
+	go func() {
+		if err := app.Listen(":" + port); err != nil {
+			log.Printf("Standby server stopped: %v", err)
+		}
+	}()
+
+	for {
+		time.Sleep(standbyRetry)
+		conn, err := openDB(dsn)
+		if err != nil {
+			continue
+		}
+		log.Println("Database reachable; leaving standby")
+		if err := app.Shutdown(); err != nil {
+			log.Printf("Failed to stop standby server: %v", err)
+		}
+		return conn, nil
+	}
+}
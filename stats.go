@@ -0,0 +1,194 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// statsTopCount is how many countries GET /countries/stats ranks by GDP,
+// as the summary image does
+const statsTopCount = 5
+
+// distribution summarizes a set of values; the fields are null when the set
+// is empty
+type distribution struct {
+	Total   float64  `json:"total"`
+	Average *float64 `json:"average"`
+	Median  *float64 `json:"median"`
+	Min     *float64 `json:"min"`
+	P25     *float64 `json:"p25"`
+	P75     *float64 `json:"p75"`
+	Max     *float64 `json:"max"`
+}
+
+// rankedCountry is a country in the GDP ranking of GET /countries/stats
+type rankedCountry struct {
+	Name         string  `json:"name"`
+	EstimatedGDP float64 `json:"estimated_gdp"`
+}
+
+// countryStats is the response of GET /countries/stats: the data behind
+// the summary image, and more, as JSON
+type countryStats struct {
+	TotalCountries int64        `json:"total_countries"`
+	Population     distribution `json:"population"`
+	GDP            struct {
+		distribution
+		// Countries counts those with a usable estimate, which the
+		// distribution covers
+		Countries int64            `json:"countries"`
+		ByTier    map[string]int64 `json:"by_tier"`
+		Top       []rankedCountry  `json:"top"`
+	} `json:"gdp"`
+	// Regions counts countries per region; those without one are under
+	// "none"
+	Regions             map[string]int64 `json:"regions"`
+	MissingExchangeRate struct {
+		Count     int64    `json:"count"`
+		Countries []string `json:"countries"`
+	} `json:"missing_exchange_rate"`
+	Staleness struct {
+		LastRefreshedAt   *time.Time `json:"last_refreshed_at"`
+		OldestRefreshedAt *time.Time `json:"oldest_refreshed_at"`
+		// AgeSeconds is how long ago the newest refresh was;
+		// OldestAgeSeconds how long ago the stalest country was refreshed
+		AgeSeconds       *int64 `json:"age_seconds"`
+		OldestAgeSeconds *int64 `json:"oldest_age_seconds"`
+		// RatesAsOf is when the provider last updated the stored rates,
+		// which refreshes fetching daily rates can far outlive
+		RatesAsOf       *time.Time `json:"rates_as_of"`
+		RatesAgeSeconds *int64     `json:"rates_age_seconds"`
+	} `json:"staleness"`
+}
+
+// distributionOf summarizes values, sorting them in place
+func distributionOf(values []float64) distribution {
+	var d distribution
+	if len(values) == 0 {
+		return d
+	}
+	sort.Float64s(values)
+	for _, v := range values {
+		d.Total += v
+	}
+	average := d.Total / float64(len(values))
+	d.Average = &average
+	d.Min, d.Max = &values[0], &values[len(values)-1]
+	p25, median, p75 := percentile(values, 0.25), percentile(values, 0.5), percentile(values, 0.75)
+	d.P25, d.Median, d.P75 = &p25, &median, &p75
+	return d
+}
+
+// percentile interpolates linearly between the closest ranks of sorted
+// values
+func percentile(sorted []float64, p float64) float64 {
+	rank := p * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower+1 >= len(sorted) {
+		return sorted[lower]
+	}
+	return sorted[lower] + (rank-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
+
+// loadCountryStats aggregates every stored country; times are rendered in
+// loc
+func loadCountryStats(loc *time.Location) (*countryStats, error) {
+	var countries []Country
+	if err := db.Select("name", "region", "population", "exchange_rate", "estimated_gdp", "gdp_tier", "last_refreshed_at", "rate_as_of").
+		Order("name ASC").Find(&countries).Error; err != nil {
+		return nil, err
+	}
+
+	stats := &countryStats{
+		TotalCountries: int64(len(countries)),
+		Regions:        map[string]int64{},
+	}
+	stats.GDP.ByTier = map[string]int64{"none": 0}
+	for _, tier := range gdpTiers {
+		stats.GDP.ByTier[tier] = 0
+	}
+	stats.GDP.Top = []rankedCountry{}
+	stats.MissingExchangeRate.Countries = []string{}
+
+	populations := make([]float64, 0, len(countries))
+	gdps := make([]float64, 0, len(countries))
+	var newest, oldest, ratesAsOf time.Time
+	for _, country := range countries {
+		if country.RateAsOf != nil && country.RateAsOf.After(ratesAsOf) {
+			ratesAsOf = *country.RateAsOf
+		}
+		populations = append(populations, float64(country.Population))
+		// Countries without a currency are stored with a GDP of 0, which
+		// is no estimate
+		if country.EstimatedGDP != nil && *country.EstimatedGDP > 0 {
+			gdps = append(gdps, *country.EstimatedGDP)
+			stats.GDP.Top = append(stats.GDP.Top, rankedCountry{Name: country.Name, EstimatedGDP: *country.EstimatedGDP})
+		}
+		if country.GDPTier != nil {
+			stats.GDP.ByTier[*country.GDPTier]++
+		} else {
+			stats.GDP.ByTier["none"]++
+		}
+
+		region := "none"
+		if country.Region != nil {
+			region = *country.Region
+		}
+		stats.Regions[region]++
+
+		if country.ExchangeRate == nil {
+			stats.MissingExchangeRate.Countries = append(stats.MissingExchangeRate.Countries, country.Name)
+		}
+
+		if newest.IsZero() || country.LastRefreshedAt.After(newest) {
+			newest = country.LastRefreshedAt
+		}
+		if oldest.IsZero() || country.LastRefreshedAt.Before(oldest) {
+			oldest = country.LastRefreshedAt
+		}
+	}
+
+	stats.Population = distributionOf(populations)
+	stats.GDP.distribution = distributionOf(gdps)
+	stats.GDP.Countries = int64(len(gdps))
+	sort.SliceStable(stats.GDP.Top, func(i, j int) bool {
+		return stats.GDP.Top[i].EstimatedGDP > stats.GDP.Top[j].EstimatedGDP
+	})
+	if len(stats.GDP.Top) > statsTopCount {
+		stats.GDP.Top = stats.GDP.Top[:statsTopCount]
+	}
+	stats.MissingExchangeRate.Count = int64(len(stats.MissingExchangeRate.Countries))
+
+	if len(countries) > 0 {
+		now := clock.Now()
+		newest, oldest = newest.In(loc), oldest.In(loc)
+		age, oldestAge := int64(now.Sub(newest).Seconds()), int64(now.Sub(oldest).Seconds())
+		stats.Staleness.LastRefreshedAt, stats.Staleness.OldestRefreshedAt = &newest, &oldest
+		stats.Staleness.AgeSeconds, stats.Staleness.OldestAgeSeconds = &age, &oldestAge
+	}
+	if !ratesAsOf.IsZero() {
+		ratesAsOf = ratesAsOf.In(loc)
+		ratesAge := int64(clock.Now().Sub(ratesAsOf).Seconds())
+		stats.Staleness.RatesAsOf, stats.Staleness.RatesAgeSeconds = &ratesAsOf, &ratesAge
+	}
+	return stats, nil
+}
+
+// getCountryStats returns global aggregates of the stored countries
+func getCountryStats(c *fiber.Ctx) error {
+	loc, err := parseTimezone(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	stats, err := loadCountryStats(loc)
+	if err != nil {
+		return err
+	}
+	return c.JSON(stats)
+}
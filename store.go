@@ -0,0 +1,427 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+// Domain errors returned by the store functions. Handlers return them as
+// is and customErrorHandler maps them to HTTP responses.
+var (
+	ErrCountryNotFound = errors.New("country not found")
+	ErrDuplicateName   = errors.New("a country with this name already exists")
+	ErrDuplicateAlpha2 = errors.New("a country with this alpha-2 code already exists")
+	ErrDuplicateAlpha3 = errors.New("a country with this alpha-3 code already exists")
+	ErrDuplicateSlug   = errors.New("a country with this slug already exists")
+	ErrStaleVersion    = errors.New("country was modified concurrently; retry the request")
+	ErrNothingToPin    = errors.New("field has no value to pin")
+)
+
+// mysqlDuplicateEntry is the MySQL error number for unique key violations
+const mysqlDuplicateEntry = 1062
+
+// duplicateKeyErrors maps the unique keys of countries, and of its staging
+// copy, to the domain error for a violation of each
+var duplicateKeyErrors = map[string]error{
+	"idx_countries_name":                ErrDuplicateName,
+	"idx_countries_staging_name":        ErrDuplicateName,
+	"idx_countries_slug":                ErrDuplicateSlug,
+	"idx_countries_staging_slug":        ErrDuplicateSlug,
+	"idx_countries_alpha2_code":         ErrDuplicateAlpha2,
+	"idx_countries_staging_alpha2_code": ErrDuplicateAlpha2,
+	"idx_countries_alpha3_code":         ErrDuplicateAlpha3,
+	"idx_countries_staging_alpha3_code": ErrDuplicateAlpha3,
+}
+
+// storeError translates driver and ORM errors into domain errors. A unique
+// key violation maps by the key MySQL names; one on any other key is
+// returned as is.
+func storeError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrCountryNotFound
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+		if domainErr, ok := duplicateKeyErrors[duplicateKey(mysqlErr.Message)]; ok {
+			return domainErr
+		}
+	}
+	return err
+}
+
+// duplicateKey extracts the index name from a MySQL duplicate entry
+// message, "Duplicate entry 'x' for key 'countries.idx_countries_name'";
+// MySQL 8 prefixes the table, older servers do not
+func duplicateKey(message string) string {
+	i := strings.LastIndex(message, " for key '")
+	if i < 0 {
+		return ""
+	}
+	key := strings.TrimSuffix(message[i+len(" for key '"):], "'")
+	if _, index, ok := strings.Cut(key, "."); ok {
+		return index
+	}
+	return key
+}
+
+// findCountryByName loads a country by case-insensitive name
+func findCountryByName(name string) (*Country, error) {
+	var country Country
+	if err := db.Where("LOWER(name) = LOWER(?)", name).First(&country).Error; err != nil {
+		return nil, storeError(err)
+	}
+	return &country, nil
+}
+
+// isoCodeColumn is the column an identifier matches as an ISO 3166 code:
+// alpha2_code for two letters, alpha3_code for three, else ""
+func isoCodeColumn(identifier string) string {
+	switch code := strings.ToUpper(identifier); {
+	case isAlpha2(code):
+		return "alpha2_code"
+	case isAlpha3(code):
+		return "alpha3_code"
+	}
+	return ""
+}
+
+// findCountry loads a country by case-insensitive name or, failing that,
+// by its slug or its alpha-2 or alpha-3 code: "Nigeria", "nigeria", "NG"
+// and "nga" all match. Names win so a country whose name looks like a slug
+// or code stays reachable.
+func findCountry(identifier string) (*Country, error) {
+	country, err := findCountryByName(identifier)
+	if !errors.Is(err, ErrCountryNotFound) || !isSlug(identifier) {
+		return country, err
+	}
+	var found []Country
+	if err := db.Where("slug = ?", strings.ToLower(identifier)).Limit(1).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	if column := isoCodeColumn(identifier); len(found) == 0 && column != "" {
+		if err := db.Where(column+" = ?", strings.ToUpper(identifier)).Limit(1).Find(&found).Error; err != nil {
+			return nil, err
+		}
+	}
+	if len(found) == 0 {
+		return nil, ErrCountryNotFound
+	}
+	return &found[0], nil
+}
+
+// createCountry stores a new country with its currencies and records its
+// history entry, attributed to actor. Names are unique case-insensitively;
+// so are alpha-2 and alpha-3 codes, which lookups, pins and borders key
+// countries by.
+func createCountry(row Country, actor string) (*Country, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var taken int64
+		if err := tx.Model(&Country{}).Where("LOWER(name) = LOWER(?)", row.Name).Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken > 0 {
+			return ErrDuplicateName
+		}
+		rows := []Country{row}
+		if err := assignSlugs(tx, rows); err != nil {
+			return err
+		}
+		row.Slug = rows[0].Slug
+
+		// The unique keys reject a taken code, also against a concurrent
+		// insert
+		if err := tx.Create(&row).Error; err != nil {
+			return storeError(err)
+		}
+		entry := historyEntry(nil, &row, historySourceAPI)
+		entry.Actor = actor
+		if err := recordHistory(tx, []CountryHistory{*entry}, clock.Now()); err != nil {
+			return err
+		}
+		return replaceCurrencies(tx, []Country{row})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := []Country{row}
+	if err := attachCurrencies(db, loaded); err != nil {
+		return nil, err
+	}
+	return &loaded[0], nil
+}
+
+// manualCountryNames returns the lower-cased names of the countries created
+// through POST /countries
+func manualCountryNames(conn *gorm.DB) (map[string]bool, error) {
+	var names []string
+	if err := conn.Model(&Country{}).Where("source = ?", sourceManual).Pluck("name", &names).Error; err != nil {
+		return nil, err
+	}
+	manual := make(map[string]bool, len(names))
+	for _, name := range names {
+		manual[strings.ToLower(name)] = true
+	}
+	return manual, nil
+}
+
+// manualCountryCodes maps the alpha-2 and alpha-3 codes of the countries
+// created through POST /countries to their names
+func manualCountryCodes(conn *gorm.DB) (map[string]string, error) {
+	var countries []Country
+	if err := conn.Select("name", "alpha2_code", "alpha3_code").
+		Where("source = ?", sourceManual).Find(&countries).Error; err != nil {
+		return nil, err
+	}
+	codes := map[string]string{}
+	for _, country := range countries {
+		for _, code := range []*string{country.Alpha2Code, country.Alpha3Code} {
+			if code != nil {
+				codes[strings.ToUpper(*code)] = country.Name
+			}
+		}
+	}
+	return codes, nil
+}
+
+// deleteCountryByName removes a country and records its tombstone and
+// history entry, attributed to actor. The delete is conditional on the row
+// being unchanged since it was read, so a refresh landing in between yields
+// ErrStaleVersion instead of a lost update.
+func deleteCountryByName(name, actor string) (*Country, error) {
+	country, err := findCountry(name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("updated_at = ?", country.UpdatedAt).Delete(country)
+		if result.Error != nil {
+			return storeError(result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrStaleVersion
+		}
+		entry := historyEntry(country, nil, historySourceAPI)
+		entry.Actor = actor
+		if err := recordHistory(tx, []CountryHistory{*entry}, clock.Now()); err != nil {
+			return err
+		}
+		if err := tx.Where("country_id = ?", country.ID).Delete(&CountryCurrency{}).Error; err != nil {
+			return err
+		}
+		return recordTombstone(tx, country.Name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return country, nil
+}
+
+// deleteCountriesInRegion removes every country of a region, matched
+// case-insensitively, or every country when region is empty. Each gets its
+// tombstone and history entry, attributed to actor, in one transaction. It
+// returns the names removed.
+func deleteCountriesInRegion(region, actor string) ([]string, error) {
+	names := []string{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Order("name ASC")
+		if region != "" {
+			query = query.Where("LOWER(region) = LOWER(?)", region)
+		}
+		var countries []Country
+		if err := query.Find(&countries).Error; err != nil {
+			return err
+		}
+		if len(countries) == 0 {
+			return nil
+		}
+
+		now := clock.Now()
+		ids := make([]uint, len(countries))
+		entries := make([]CountryHistory, len(countries))
+		tombstones := make([]CountryTombstone, len(countries))
+		for i := range countries {
+			ids[i] = countries[i].ID
+			names = append(names, countries[i].Name)
+			entry := historyEntry(&countries[i], nil, historySourceAPI)
+			entry.Actor = actor
+			entries[i] = *entry
+			tombstones[i] = CountryTombstone{Name: countries[i].Name, DeletedAt: now}
+		}
+		if err := tx.Where("id IN ?", ids).Delete(&Country{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("country_id IN ?", ids).Delete(&CountryCurrency{}).Error; err != nil {
+			return err
+		}
+		if err := recordHistory(tx, entries, now); err != nil {
+			return err
+		}
+		return tx.CreateInBatches(tombstones, refreshBatchSize).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// updateCountryByName applies a validated edit to a country, pins and
+// unpins its fields, and records a history entry attributed to actor. rates
+// price a new primary currency. Like a delete, the update is conditional on
+// the row being unchanged since it was read.
+func updateCountryByName(name string, patch countryPatch, rates map[string]float64, actor string) (*Country, error) {
+	country, err := findCountry(name)
+	if err != nil {
+		return nil, err
+	}
+	loaded := []Country{*country}
+	if err := attachCurrencies(db, loaded); err != nil {
+		return nil, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		_, err := applyCountryPatch(tx, loaded[0], patch, rates, actor, clock.Now())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Read back what was stored, with the new updated_at
+	country, err = findCountryByName(loaded[0].Name)
+	if err != nil {
+		return nil, err
+	}
+	loaded = []Country{*country}
+	if err := attachCurrencies(db, loaded); err != nil {
+		return nil, err
+	}
+	return &loaded[0], nil
+}
+
+// applyCountryPatch edits one country, loaded with its currencies, within
+// tx: it stores the edit conditionally on updated_at, records the history
+// entry and replaces the pins. It reports whether any field changed.
+func applyCountryPatch(tx *gorm.DB, old Country, patch countryPatch, rates map[string]float64, actor string, now time.Time) (bool, error) {
+	updated := old
+	updated.Currencies = append([]CountryCurrency{}, old.Currencies...)
+	updated.FieldSources = map[string]string{}
+	for field, source := range old.FieldSources {
+		updated.FieldSources[field] = source
+	}
+	// New populations and currencies re-estimate the GDP
+	_, repopulated := patch.Values["population"]
+	_, repriced := patch.Values["currency_code"]
+	if repopulated || repriced {
+		m, err := loadGDPMultiplier(tx, old)
+		if err != nil {
+			return false, err
+		}
+		updated.GDPMultiplier = m
+	}
+	for _, field := range patch.fields() {
+		value := patch.Values[field]
+		switch field {
+		case "capital":
+			updated.Capital = nilIfEmpty(&value)
+		case "region":
+			updated.Region = nilIfEmpty(&value)
+		case "subregion":
+			updated.Subregion = nilIfEmpty(&value)
+		case "flag_url":
+			updated.FlagURL = nilIfEmpty(&value)
+		case "population":
+			population, _ := strconv.ParseInt(value, 10, 64)
+			setPopulation(&updated, population, nil)
+		case "currency_code":
+			setPrimaryCurrency(&updated, value, rates, nil)
+			if updated.ExchangeRate != nil && updated.RateAsOf == nil {
+				asOf, err := latestRateAsOf(tx)
+				if err != nil {
+					return false, err
+				}
+				updated.RateAsOf, updated.Currencies[0].RateAsOf = asOf, asOf
+			}
+		}
+		updated.FieldSources[field] = sourceManual
+	}
+	// An edit that moves the rate or GDP rolls the previous values forward,
+	// as a refresh does, so the change fields measure the edit
+	if !sameFloat(old.ExchangeRate, updated.ExchangeRate) || !sameFloat(old.EstimatedGDP, updated.EstimatedGDP) {
+		updated.PreviousExchangeRate, updated.PreviousEstimatedGDP = old.ExchangeRate, old.EstimatedGDP
+	}
+
+	// Pins are keyed by the alpha-3 code when there is one, which wins over
+	// a pin by name and survives a rename
+	key := updated.Name
+	if updated.Alpha3Code != nil {
+		key = *updated.Alpha3Code
+	}
+	keys := []string{updated.Name, key}
+	var pins []CountryOverride
+	for _, field := range patch.Pin {
+		value, ok := overrideValue(updated, field)
+		if !ok {
+			return false, fmt.Errorf("%w: %s of %s", ErrNothingToPin, field, updated.Name)
+		}
+		pins = append(pins, CountryOverride{Country: key, Field: field, Value: value, UpdatedBy: actor, UpdatedAt: now})
+		updated.FieldSources[field] = sourceOverride
+	}
+	for _, field := range patch.Unpin {
+		if updated.FieldSources[field] == sourceOverride {
+			updated.FieldSources[field] = sourceManual
+		}
+	}
+
+	result := tx.Model(&Country{ID: old.ID}).Where("updated_at = ?", old.UpdatedAt).
+		Select("capital", "region", "subregion", "flag_url", "population", "population_tier",
+			"currency_code", "currency_name", "currency_symbol", "exchange_rate",
+			"rate_as_of", "estimated_gdp", "previous_exchange_rate", "previous_estimated_gdp",
+			"gdp_tier", "field_sources", "updated_at").
+		Updates(&updated)
+	if result.Error != nil {
+		return false, storeError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return false, ErrStaleVersion
+	}
+	entry := historyEntry(&old, &updated, historySourceAPI)
+	if entry != nil {
+		entry.Actor = actor
+		if err := recordHistory(tx, []CountryHistory{*entry}, now); err != nil {
+			return false, err
+		}
+	}
+	if _, ok := patch.Values["currency_code"]; ok {
+		if err := replaceCurrencies(tx, []Country{updated}); err != nil {
+			return false, err
+		}
+	}
+
+	if unpin := append(append([]string{}, patch.Pin...), patch.Unpin...); len(unpin) > 0 {
+		if err := tx.Where("country IN ? AND field IN ?", keys, unpin).Delete(&CountryOverride{}).Error; err != nil {
+			return false, err
+		}
+	}
+	if len(pins) > 0 {
+		if err := tx.Create(&pins).Error; err != nil {
+			return false, err
+		}
+	}
+	return entry != nil, nil
+}
+
+// sameFloat reports whether two optional values are both null or equal
+func sameFloat(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
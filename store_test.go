@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestStoreError(t *testing.T) {
+	duplicate := func(key string) error {
+		return fmt.Errorf("insert: %w", &mysql.MySQLError{
+			Number:  mysqlDuplicateEntry,
+			Message: fmt.Sprintf("Duplicate entry 'atlantis' for key '%s'", key),
+		})
+	}
+	other := errors.New("connection reset")
+	unknownKey := duplicate("api_keys.idx_api_keys_name")
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"not found", gorm.ErrRecordNotFound, ErrCountryNotFound},
+		{"name, MySQL 8", duplicate("countries.idx_countries_name"), ErrDuplicateName},
+		{"name, MySQL 5.7", duplicate("idx_countries_name"), ErrDuplicateName},
+		{"staging name", duplicate("countries_staging.idx_countries_staging_name"), ErrDuplicateName},
+		{"slug", duplicate("countries.idx_countries_slug"), ErrDuplicateSlug},
+		{"staging slug", duplicate("countries_staging.idx_countries_staging_slug"), ErrDuplicateSlug},
+		{"alpha-2", duplicate("countries.idx_countries_alpha2_code"), ErrDuplicateAlpha2},
+		{"alpha-3", duplicate("idx_countries_alpha3_code"), ErrDuplicateAlpha3},
+		{"staging alpha-2", duplicate("countries_staging.idx_countries_staging_alpha2_code"), ErrDuplicateAlpha2},
+		{"other key", unknownKey, unknownKey},
+		{"other error", other, other},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := storeError(tt.err); got != tt.want {
+				t.Errorf("storeError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
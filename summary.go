@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// maxMovers caps how many exchange rate movers a summary keeps
+const maxMovers = 5
+
+// refreshSummary records the outcome of a refresh for notifications
+type refreshSummary struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Processed  int       `json:"processed"`
+	Inserted   int       `json:"inserted"`
+	Updated    int       `json:"updated"`
+	// Skipped counts upstream countries left alone because a manual
+	// country has their name
+	Skipped   int              `json:"skipped,omitempty"`
+	Errors    []string         `json:"errors"`
+	Movers    []rateMover      `json:"movers"`
+	Anomalies []CountryAnomaly `json:"anomalies"`
+	// ArchiveID names the archived upstream payloads, if any
+	ArchiveID string `json:"archive_id,omitempty"`
+	// CountriesSource is the step of the restcountries fallback chain that
+	// served a full refresh, or "archive" for a replay
+	CountriesSource string `json:"countries_source,omitempty"`
+
+	// imageEnqueued reports whether this refresh queued an image rebuild
+	imageEnqueued bool
+	// history is the per-country changes the refresh publishes
+	history []CountryHistory
+	// renames maps live names to the names upstream now lists their
+	// alpha-3 codes under
+	renames map[string]string
+}
+
+// rateMover is a country whose exchange rate changed during a refresh
+type rateMover struct {
+	Country      string  `json:"country"`
+	CurrencyCode string  `json:"currency_code"`
+	OldRate      float64 `json:"old_rate"`
+	NewRate      float64 `json:"new_rate"`
+	ChangePct    float64 `json:"change_pct"`
+}
+
+func (s *refreshSummary) addError(name string, err error) {
+	s.Errors = append(s.Errors, fmt.Sprintf("%s: %v", name, err))
+}
+
+// trackMover keeps the largest exchange rate changes, by absolute percentage
+func (s *refreshSummary) trackMover(old, updated Country) {
+	if old.ExchangeRate == nil || updated.ExchangeRate == nil || updated.CurrencyCode == nil {
+		return
+	}
+	if *old.ExchangeRate == 0 || *old.ExchangeRate == *updated.ExchangeRate {
+		return
+	}
+
+	s.Movers = append(s.Movers, rateMover{
+		Country:      updated.Name,
+		CurrencyCode: *updated.CurrencyCode,
+		OldRate:      *old.ExchangeRate,
+		NewRate:      *updated.ExchangeRate,
+		ChangePct:    *changePct(old.ExchangeRate, updated.ExchangeRate),
+	})
+
+	sort.Slice(s.Movers, func(i, j int) bool {
+		return math.Abs(s.Movers[i].ChangePct) > math.Abs(s.Movers[j].ChangePct)
+	})
+	if len(s.Movers) > maxMovers {
+		s.Movers = s.Movers[:maxMovers]
+	}
+}
+
+// changePct is the change from previous to current in percent of the
+// previous magnitude, so a rise is positive even from a negative value, or
+// nil when either is missing or previous is zero
+func changePct(previous, current *float64) *float64 {
+	if previous == nil || current == nil || *previous == 0 {
+		return nil
+	}
+	pct := (*current - *previous) / math.Abs(*previous) * 100
+	return &pct
+}
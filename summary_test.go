@@ -0,0 +1,37 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestChangePct(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name              string
+		previous, current *float64
+		want              *float64
+	}{
+		{"rise", f(100), f(110), f(10)},
+		{"fall", f(200), f(150), f(-25)},
+		{"unchanged", f(1550.5), f(1550.5), f(0)},
+		{"from negative", f(-50), f(-25), f(50)},
+		{"no previous", nil, f(10), nil},
+		{"no current", f(10), nil, nil},
+		{"previous zero", f(0), f(10), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := changePct(tt.previous, tt.current)
+			switch {
+			case tt.want == nil && got != nil:
+				t.Errorf("changePct = %g, want nil", *got)
+			case tt.want != nil && got == nil:
+				t.Errorf("changePct = nil, want %g", *tt.want)
+			case tt.want != nil && math.Abs(*got-*tt.want) > 1e-9:
+				t.Errorf("changePct = %g, want %g", *got, *tt.want)
+			}
+		})
+	}
+}
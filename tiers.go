@@ -0,0 +1,71 @@
+package main
+
+import "fmt"
+
+// Population tiers, by upper bound (exclusive)
+const (
+	populationTierMicro  = "micro"
+	populationTierSmall  = "small"
+	populationTierMedium = "medium"
+	populationTierLarge  = "large"
+)
+
+// GDP per capita tiers, loosely following the World Bank income thresholds
+// in USD
+const (
+	gdpTierLow  = "low"
+	gdpTierMid  = "mid"
+	gdpTierHigh = "high"
+
+	gdpPerCapitaLowMax = 1145
+	gdpPerCapitaMidMax = 14005
+)
+
+var (
+	populationTiers = []string{populationTierMicro, populationTierSmall, populationTierMedium, populationTierLarge}
+	gdpTiers        = []string{gdpTierLow, gdpTierMid, gdpTierHigh}
+)
+
+// populationTierFor buckets a population: under 1M is micro, under 10M
+// small, under 50M medium, anything larger is large
+func populationTierFor(population int64) string {
+	switch {
+	case population < 1_000_000:
+		return populationTierMicro
+	case population < 10_000_000:
+		return populationTierSmall
+	case population < 50_000_000:
+		return populationTierMedium
+	default:
+		return populationTierLarge
+	}
+}
+
+// gdpTierFor buckets estimated GDP per capita; it returns nil when there is
+// no usable GDP estimate (countries without a currency are stored as 0) or
+// no population to divide by
+func gdpTierFor(gdp *float64, population int64) *string {
+	if gdp == nil || *gdp <= 0 || population <= 0 {
+		return nil
+	}
+	perCapita := *gdp / float64(population)
+
+	tier := gdpTierHigh
+	switch {
+	case perCapita < gdpPerCapitaLowMax:
+		tier = gdpTierLow
+	case perCapita < gdpPerCapitaMidMax:
+		tier = gdpTierMid
+	}
+	return &tier
+}
+
+// validateTier checks a ?..._tier= filter value against the known tiers
+func validateTier(param, value string, tiers []string) error {
+	for _, tier := range tiers {
+		if value == tier {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s must be one of %v", param, tiers)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Bounds of ?limit= on GET /countries/top; the default matches the summary
+// image
+const (
+	defaultTopLimit = 5
+	maxTopLimit     = 100
+)
+
+// topMetrics maps ?by= of GET /countries/top to the column it ranks, with
+// the condition a country must meet to be ranked
+var topMetrics = map[string]struct {
+	column string
+	ranked string
+}{
+	// Countries without a currency are stored with a GDP of 0, which is no
+	// estimate
+	"gdp":           {"estimated_gdp", "estimated_gdp > 0"},
+	"population":    {"population", "population IS NOT NULL"},
+	"exchange_rate": {"exchange_rate", "exchange_rate IS NOT NULL"},
+}
+
+// loadTopCountries ranks the countries that have a value for metric, ties
+// broken by name, and returns the first limit with the number ranked
+func loadTopCountries(metric string, desc bool, limit int) ([]Country, int64, error) {
+	m := topMetrics[metric]
+	direction := "ASC"
+	if desc {
+		direction = "DESC"
+	}
+
+	var total int64
+	if err := db.Model(&Country{}).Where(m.ranked).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	countries := []Country{}
+	if err := db.Where(m.ranked).
+		Order(m.column + " " + direction).Order("name ASC").
+		Limit(limit).Find(&countries).Error; err != nil {
+		return nil, 0, err
+	}
+	return countries, total, nil
+}
+
+// getTopCountries ranks countries by ?by=gdp|population|exchange_rate in
+// ?order=desc|asc, returning the first ?limit=
+func getTopCountries(c *fiber.Ctx) error {
+	lang, err := parseLanguage(c)
+	validation := func(details string) error {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": details,
+		})
+	}
+	if err != nil {
+		return validation(err.Error())
+	}
+
+	by := c.Query("by", "gdp")
+	if _, ok := topMetrics[by]; !ok {
+		return validation("by must be one of gdp, population, exchange_rate")
+	}
+	order := c.Query("order", "desc")
+	if order != "desc" && order != "asc" {
+		return validation("order must be asc or desc")
+	}
+	limit := defaultTopLimit
+	if raw := c.Query("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxTopLimit {
+			return validation(fmt.Sprintf("limit must be between 1 and %d", maxTopLimit))
+		}
+	}
+
+	countries, total, err := loadTopCountries(by, order == "desc", limit)
+	if err != nil {
+		return err
+	}
+	if err := attachCurrencies(db, countries); err != nil {
+		return err
+	}
+	localizeCountries(lang, countries)
+
+	meta := newListMeta(c, "by", "order", "limit", "lang", "format")
+	meta.Sort = by + "_" + order
+	meta.Total = total
+	meta.Page = pageMetaFor(pageRequest{Limit: limit}, "")
+	return sendCountryList(c, countries, meta)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// tracer creates every span of the service. Until initTracing installs a
+// provider it is a no-op, so instrumentation costs nothing when tracing is
+// off.
+var tracer = otel.Tracer("github.com/iamatila/hng_backend_task2")
+
+// initTracing exports spans over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT
+// or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set. The exporter and sampler
+// read the other standard OTEL_* variables themselves. The returned
+// function flushes buffered spans.
+func initTracing() (func(), error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func() {}, nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(getEnv("OTEL_SERVICE_NAME", "country-api")),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+	log.Println("Exporting traces over OTLP")
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			log.Printf("Failed to flush traces: %v", err)
+		}
+	}, nil
+}
+
+// traceStep runs fn in a child span of ctx named name, marking the span
+// failed when fn returns an error
+func traceStep(ctx context.Context, name string, fn func(context.Context) error) error {
+	ctx, span := tracer.Start(ctx, name)
+	defer span.End()
+	err := fn(ctx)
+	recordSpanError(span, err)
+	return err
+}
+
+func recordSpanError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// traceRequests starts a server span per request, continuing the caller's
+// trace from traceparent. Handlers reach the span through c.UserContext().
+// The span is named after the matched route, e.g. "GET /countries/:name".
+func traceRequests() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		carrier := propagation.MapCarrier{}
+		c.Request().Header.VisitAll(func(key, value []byte) {
+			carrier[string(key)] = string(value)
+		})
+		ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), carrier)
+		ctx, span := tracer.Start(ctx, c.Method(), trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+
+		route := c.Route().Path
+		span.SetName(c.Method() + " " + route)
+		status := c.Response().StatusCode()
+		if err != nil {
+			// The error handler has not written the response yet
+			var fe *fiber.Error
+			status = fiber.StatusInternalServerError
+			if errors.As(err, &fe) {
+				status = fe.Code
+			}
+		}
+		span.SetAttributes(
+			semconv.HTTPRequestMethodKey.String(c.Method()),
+			semconv.HTTPRoute(route),
+			semconv.URLPath(c.Path()),
+			semconv.HTTPResponseStatusCode(status),
+		)
+		if status >= 500 {
+			span.SetStatus(codes.Error, fmt.Sprintf("HTTP %d", status))
+		}
+		return err
+	}
+}
+
+// tracingTransport records a client span per outbound request and
+// propagates the trace to the upstream
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+func (t tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := tracer.Start(req.Context(), "HTTP "+req.Method+" "+req.URL.Host,
+		trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	// The query is left out, as it may carry access keys
+	span.SetAttributes(
+		semconv.HTTPRequestMethodKey.String(req.Method),
+		semconv.URLFull(req.URL.Scheme+"://"+req.URL.Host+req.URL.Path),
+		semconv.ServerAddress(req.URL.Hostname()),
+	)
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	return resp, nil
+}
+
+// upstreamClient fetches every upstream payload
+var upstreamClient = &http.Client{Transport: tracingTransport{base: http.DefaultTransport}}
+
+// gormSpanKey holds the span of a statement between its callbacks
+const gormSpanKey = "otel:span"
+
+// registerGormTracing records a span per GORM statement. Statements run
+// with db.WithContext(ctx) nest under the span in ctx; others start their
+// own trace.
+func registerGormTracing(conn *gorm.DB) error {
+	before := func(op string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			ctx := tx.Statement.Context
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			_, span := tracer.Start(ctx, "gorm."+op, trace.WithSpanKind(trace.SpanKindClient))
+			tx.InstanceSet(gormSpanKey, span)
+		}
+	}
+	after := func(tx *gorm.DB) {
+		value, ok := tx.InstanceGet(gormSpanKey)
+		if !ok {
+			return
+		}
+		span := value.(trace.Span)
+		defer span.End()
+
+		// Only the SQL text, never the bound values
+		span.SetAttributes(
+			semconv.DBSystemMySQL,
+			semconv.DBQueryText(tx.Statement.SQL.String()),
+			semconv.DBCollectionName(tx.Statement.Table),
+			attribute.Int64("db.rows_affected", tx.Statement.RowsAffected),
+		)
+		if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			recordSpanError(span, tx.Error)
+		}
+	}
+
+	cb := conn.Callback()
+	for _, step := range []struct {
+		op            string
+		before, after func(string, func(*gorm.DB)) error
+	}{
+		{"create", cb.Create().Before("gorm:create").Register, cb.Create().After("gorm:create").Register},
+		{"query", cb.Query().Before("gorm:query").Register, cb.Query().After("gorm:query").Register},
+		{"update", cb.Update().Before("gorm:update").Register, cb.Update().After("gorm:update").Register},
+		{"delete", cb.Delete().Before("gorm:delete").Register, cb.Delete().After("gorm:delete").Register},
+		{"row", cb.Row().Before("gorm:row").Register, cb.Row().After("gorm:row").Register},
+		{"raw", cb.Raw().Before("gorm:raw").Register, cb.Raw().After("gorm:raw").Register},
+	} {
+		if err := step.before("otel:before_"+step.op, before(step.op)); err != nil {
+			return err
+		}
+		if err := step.after("otel:after_"+step.op, after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Reasons a country has no GDP estimate
+const (
+	unratedNoCurrency = "no_currency"
+	unratedNoRate     = "no_rate"
+)
+
+// unratedCountry is a country missing rate coverage and why
+type unratedCountry struct {
+	Name         string  `json:"name"`
+	Region       *string `json:"region"`
+	CurrencyCode *string `json:"currency_code"`
+	Reason       string  `json:"reason"`
+}
+
+// latestRateCodes returns the currency codes in the most recent rate
+// snapshot and when it was taken; both are nil when no history exists yet
+func latestRateCodes() (codes map[string]bool, at *time.Time, err error) {
+	var latest *time.Time
+	if err := db.Model(&RateHistory{}).Select("MAX(recorded_at)").Scan(&latest).Error; err != nil {
+		return nil, nil, err
+	}
+	if latest == nil {
+		return nil, nil, nil
+	}
+
+	var list []string
+	if err := db.Model(&RateHistory{}).Where("recorded_at = ?", *latest).
+		Pluck("currency_code", &list).Error; err != nil {
+		return nil, nil, err
+	}
+
+	codes = make(map[string]bool, len(list))
+	for _, code := range list {
+		codes[code] = true
+	}
+	return codes, latest, nil
+}
+
+func getUnratedCountries(c *fiber.Ctx) error {
+	codes, ratesAt, err := latestRateCodes()
+	if err != nil {
+		return err
+	}
+
+	var countries []Country
+	if err := db.Select("name", "region", "currency_code", "exchange_rate").
+		Order("name ASC").Find(&countries).Error; err != nil {
+		return err
+	}
+
+	unrated := []unratedCountry{}
+	byReason := map[string]int{unratedNoCurrency: 0, unratedNoRate: 0}
+	for _, country := range countries {
+		reason := ""
+		switch {
+		case country.CurrencyCode == nil:
+			reason = unratedNoCurrency
+		case codes != nil && !codes[*country.CurrencyCode]:
+			reason = unratedNoRate
+		// Before any rate history is recorded, fall back to the stored rate
+		case codes == nil && country.ExchangeRate == nil:
+			reason = unratedNoRate
+		default:
+			continue
+		}
+		byReason[reason]++
+		unrated = append(unrated, unratedCountry{
+			Name:         country.Name,
+			Region:       country.Region,
+			CurrencyCode: country.CurrencyCode,
+			Reason:       reason,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"rates_as_of":     ratesAt,
+		"total_countries": len(countries),
+		"total_rated":     len(countries) - len(unrated),
+		"total_unrated":   len(unrated),
+		"by_reason":       byReason,
+		"countries":       unrated,
+	})
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxSchemaProblems caps how many problems a schema error lists
+const maxSchemaProblems = 5
+
+// maxSchemaSample caps the size of the offending payload excerpt
+const maxSchemaSample = 500
+
+// schemaError reports an upstream payload that no longer has the expected
+// shape, e.g. a renamed or retyped field. Decoding such a payload into our
+// structs would silently produce zero values.
+type schemaError struct {
+	problems []string
+	sample   string
+}
+
+func (e *schemaError) Error() string {
+	return "upstream schema changed: " + strings.Join(e.problems, "; ")
+}
+
+// Sample returns an excerpt of the first offending record
+func (e *schemaError) Sample() string {
+	return e.sample
+}
+
+// jsonKind names the JSON type of a raw value
+func jsonKind(raw json.RawMessage) string {
+	s := strings.TrimSpace(string(raw))
+	if s == "" {
+		return "missing"
+	}
+	switch s[0] {
+	case '"':
+		return "string"
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case 't', 'f':
+		return "boolean"
+	case 'n':
+		return "null"
+	default:
+		return "number"
+	}
+}
+
+// fieldRule is an expected field in an upstream record
+type fieldRule struct {
+	name     string
+	kind     string
+	required bool
+}
+
+// restCountryRules mirrors RestCountry
+var restCountryRules = []fieldRule{
+	{name: "name", kind: "string", required: true},
+	{name: "population", kind: "number", required: true},
+	{name: "alpha2Code", kind: "string"},
+	{name: "alpha3Code", kind: "string"},
+	{name: "borders", kind: "array"},
+	{name: "region", kind: "string"},
+	{name: "subregion", kind: "string"},
+	{name: "capital", kind: "string"},
+	{name: "flag", kind: "string"},
+	{name: "currencies", kind: "array"},
+}
+
+// schemaCollector accumulates problems and the first offending sample
+type schemaCollector struct {
+	err schemaError
+}
+
+func (s *schemaCollector) add(sample json.RawMessage, format string, args ...interface{}) {
+	if len(s.err.problems) < maxSchemaProblems {
+		s.err.problems = append(s.err.problems, fmt.Sprintf(format, args...))
+	}
+	if s.err.sample == "" {
+		s.err.sample = truncate(sample)
+	}
+}
+
+func (s *schemaCollector) result() error {
+	if len(s.err.problems) == 0 {
+		return nil
+	}
+	return &s.err
+}
+
+// validateCountriesPayload checks a restcountries response, v2 or v3.1,
+// against the fields we read before it is decoded
+func validateCountriesPayload(body []byte) error {
+	var records []json.RawMessage
+	if err := json.Unmarshal(body, &records); err != nil {
+		return &schemaError{problems: []string{"expected a JSON array of countries"}, sample: truncate(body)}
+	}
+	if len(records) == 0 {
+		return &schemaError{problems: []string{"no countries returned"}, sample: truncate(body)}
+	}
+
+	rules := restCountryRules
+	if isCountriesV3(records) {
+		rules = restCountryV3Rules
+	}
+
+	var s schemaCollector
+	for i, record := range records {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(record, &fields); err != nil {
+			s.add(record, "country %d: expected an object", i)
+			continue
+		}
+		for _, rule := range rules {
+			raw, ok := fields[rule.name]
+			if !ok {
+				if rule.required {
+					s.add(record, "country %d: missing %q", i, rule.name)
+				}
+				continue
+			}
+			// Optional fields may be null
+			if kind := jsonKind(raw); kind != rule.kind && !(kind == "null" && !rule.required) {
+				s.add(record, "country %d: %q is %s, expected %s", i, rule.name, kind, rule.kind)
+			}
+		}
+	}
+	return s.result()
+}
+
+// validateRatesPayload checks an exchange rate response for a non-empty
+// rates object of numbers
+func validateRatesPayload(body []byte) error {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return &schemaError{problems: []string{"expected a JSON object"}, sample: truncate(body)}
+	}
+
+	raw, ok := payload["rates"]
+	if !ok || jsonKind(raw) != "object" {
+		return &schemaError{problems: []string{`missing "rates" object`}, sample: truncate(body)}
+	}
+
+	var rates map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &rates); err != nil || len(rates) == 0 {
+		return &schemaError{problems: []string{`"rates" is empty`}, sample: truncate(body)}
+	}
+
+	codes := make([]string, 0, len(rates))
+	for code := range rates {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	var s schemaCollector
+	for _, code := range codes {
+		if kind := jsonKind(rates[code]); kind != "number" {
+			s.add(raw, "rate %q is %s, expected number", code, kind)
+		}
+	}
+	return s.result()
+}
+
+func truncate(body []byte) string {
+	if len(body) > maxSchemaSample {
+		return string(body[:maxSchemaSample]) + "..."
+	}
+	return string(body)
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// usageDays is the span of days /admin/usage.png charts, today included
+const usageDays = 30
+
+// usageFlushInterval is how often counted requests are written to api_usage
+const usageFlushInterval = time.Minute
+
+// usageChartSeries bounds the callers charted on their own; the rest are
+// summed as "other"
+const usageChartSeries = 7
+
+// usageColors tell the charted callers apart, busiest first. "other" is
+// drawn in the theme's muted colour.
+var usageColors = []color.RGBA{
+	{37, 99, 235, 255},
+	{234, 88, 12, 255},
+	{22, 163, 74, 255},
+	{219, 39, 119, 255},
+	{202, 138, 4, 255},
+	{124, 58, 237, 255},
+	{8, 145, 178, 255},
+}
+
+// APIUsage counts the authenticated requests of one caller, an API key or
+// token subject, on one UTC day
+type APIUsage struct {
+	ID       uint      `gorm:"primaryKey" json:"-"`
+	Actor    string    `gorm:"type:varchar(255);uniqueIndex:idx_api_usage_actor_day;not null" json:"actor"`
+	Day      time.Time `gorm:"type:date;uniqueIndex:idx_api_usage_actor_day;index;not null" json:"day"`
+	Requests int64     `gorm:"not null" json:"requests"`
+}
+
+func (APIUsage) TableName() string {
+	return "api_usage"
+}
+
+type usageKey struct {
+	actor string
+	day   time.Time
+}
+
+// usageCounts holds requests counted since the last flush, so serving a
+// request never waits on a write
+var usageCounts = struct {
+	sync.Mutex
+	pending map[usageKey]int64
+}{pending: map[usageKey]int64{}}
+
+// countUsage counts each request that authenticated against its caller,
+// once the handler has run. Requests without credentials, or whose
+// credentials were rejected, are not counted.
+func countUsage() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+		if actor, ok := c.Locals("actor").(string); ok && actor != "" {
+			key := usageKey{actor: actor, day: clock.Now().UTC().Truncate(24 * time.Hour)}
+			usageCounts.Lock()
+			usageCounts.pending[key]++
+			usageCounts.Unlock()
+		}
+		return err
+	}
+}
+
+// flushUsage adds the pending counts to api_usage. On failure they are
+// kept for the next flush.
+func flushUsage() error {
+	usageCounts.Lock()
+	pending := usageCounts.pending
+	usageCounts.pending = map[usageKey]int64{}
+	usageCounts.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	rows := make([]APIUsage, 0, len(pending))
+	for key, n := range pending {
+		rows = append(rows, APIUsage{Actor: key.actor, Day: key.day, Requests: n})
+	}
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "actor"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"requests": gorm.Expr("requests + VALUES(requests)")}),
+	}).Create(&rows).Error
+	if err != nil {
+		usageCounts.Lock()
+		for key, n := range pending {
+			usageCounts.pending[key] += n
+		}
+		usageCounts.Unlock()
+	}
+	return err
+}
+
+// flushUsageEvery writes the counted requests once per interval
+func flushUsageEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := flushUsage(); err != nil {
+			log.Printf("API usage flush failed: %v", err)
+		}
+	}
+}
+
+// usageSeries is one charted caller's requests per day, oldest first
+type usageSeries struct {
+	Actor string
+	Total int64
+	Daily []int64
+}
+
+// usageByCaller spreads usage rows over the days from first, busiest
+// caller first. Callers beyond usageChartSeries are summed as "other".
+func usageByCaller(rows []APIUsage, first time.Time) []usageSeries {
+	byActor := map[string]*usageSeries{}
+	for _, row := range rows {
+		day := int(row.Day.UTC().Sub(first).Hours() / 24)
+		if day < 0 || day >= usageDays {
+			continue
+		}
+		series := byActor[row.Actor]
+		if series == nil {
+			series = &usageSeries{Actor: row.Actor, Daily: make([]int64, usageDays)}
+			byActor[row.Actor] = series
+		}
+		series.Daily[day] += row.Requests
+		series.Total += row.Requests
+	}
+
+	all := make([]usageSeries, 0, len(byActor))
+	for _, series := range byActor {
+		all = append(all, *series)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Total != all[j].Total {
+			return all[i].Total > all[j].Total
+		}
+		return all[i].Actor < all[j].Actor
+	})
+	if len(all) <= usageChartSeries {
+		return all
+	}
+
+	other := usageSeries{Actor: "other", Daily: make([]int64, usageDays)}
+	for _, series := range all[usageChartSeries:] {
+		for day, n := range series.Daily {
+			other.Daily[day] += n
+		}
+		other.Total += series.Total
+	}
+	return append(all[:usageChartSeries], other)
+}
+
+// getUsageChart renders the authenticated requests per day and caller over
+// the last usageDays UTC days as a PNG stacked bar chart
+func getUsageChart(c *fiber.Ctx) error {
+	opts, _, err := parseImageOptions(c, summaryImageOptions{
+		Width:    chartDefaultWidth,
+		Height:   chartDefaultHeight,
+		Scale:    1,
+		Location: time.UTC,
+	})
+	if err == nil && opts.Height < chartMinHeight {
+		err = fmt.Errorf("height must be at least %d", chartMinHeight)
+	}
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	// Include the requests counted since the last flush
+	if err := flushUsage(); err != nil {
+		log.Printf("API usage flush failed: %v", err)
+	}
+
+	first := clock.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-usageDays)
+	var rows []APIUsage
+	if err := db.WithContext(c.UserContext()).Where("day >= ?", first).Find(&rows).Error; err != nil {
+		return err
+	}
+
+	title := fmt.Sprintf("API requests per day, last %d days (UTC)", usageDays)
+	img := renderUsageChart(title, first, usageByCaller(rows, first), opts)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, "image/png")
+	c.Set(fiber.HeaderCacheControl, "private, max-age=60")
+	return c.Send(buf.Bytes())
+}
+
+// renderUsageChart draws one bar per day, stacked by caller, with a legend
+// of caller totals on the right
+func renderUsageChart(title string, first time.Time, series []usageSeries, opts summaryImageOptions) image.Image {
+	face := basicfont.Face7x13
+	theme := currentTheme()
+	img := image.NewRGBA(image.Rect(0, 0, opts.Width, opts.Height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{theme.Background}, image.Point{}, draw.Src)
+	addLabel(img, fixed.P(imageMargin, imageMargin+face.Ascent), title, theme.Text)
+
+	colorOf := func(i int) color.RGBA {
+		if i < len(usageColors) {
+			return usageColors[i]
+		}
+		return theme.Muted
+	}
+
+	var peak int64
+	for day := 0; day < usageDays; day++ {
+		var total int64
+		for _, s := range series {
+			total += s.Daily[day]
+		}
+		peak = max(peak, total)
+	}
+
+	ticks := []int64{0}
+	if peak > 0 {
+		ticks = append(ticks, peak/2, peak)
+	}
+	labelWidth := 0
+	for _, tick := range ticks {
+		labelWidth = max(labelWidth, len(strconv.FormatInt(tick, 10))*face.Advance)
+	}
+
+	// The legend takes the right of the image, sized to its longest entry
+	legend := make([]string, len(series))
+	legendWidth := 0
+	for i, s := range series {
+		legend[i] = fmt.Sprintf("%s %d", s.Actor, s.Total)
+		legendWidth = max(legendWidth, len(legend[i])*face.Advance+face.Height+chartAxisGap)
+	}
+	legendWidth = min(legendWidth, opts.Width/3)
+	fits := max((legendWidth-face.Height-chartAxisGap)/face.Advance, 1)
+	for i, entry := range legend {
+		if len(entry) > fits {
+			legend[i] = entry[:fits-1] + "~"
+		}
+	}
+
+	plot := image.Rect(imageMargin+labelWidth+chartAxisGap, imageMargin+chartTitleHeight,
+		opts.Width-imageMargin-legendWidth-chartAxisGap, opts.Height-imageMargin-face.Height-chartAxisGap)
+
+	valueY := func(v int64) int {
+		if peak == 0 {
+			return plot.Max.Y
+		}
+		return plot.Max.Y - int(float64(plot.Dy())*float64(v)/float64(peak))
+	}
+
+	// Gridlines and value labels
+	grid := color.NRGBA{theme.Muted.R, theme.Muted.G, theme.Muted.B, 80}
+	for _, tick := range ticks {
+		y := valueY(tick)
+		for x := plot.Min.X; x < plot.Max.X; x += 4 {
+			blend(img, x, y, grid)
+			blend(img, x+1, y, grid)
+		}
+		label := strconv.FormatInt(tick, 10)
+		addLabel(img, fixed.P(plot.Min.X-chartAxisGap-len(label)*face.Advance, y+face.Ascent/2), label, theme.Muted)
+	}
+
+	// Bars, stacked from the busiest caller at the bottom
+	slot := float64(plot.Dx()) / usageDays
+	barWidth := max(int(slot*0.75), 1)
+	for day := 0; day < usageDays; day++ {
+		x := plot.Min.X + int(slot*float64(day)+(slot-float64(barWidth))/2)
+		var stacked int64
+		for i, s := range series {
+			if s.Daily[day] == 0 {
+				continue
+			}
+			bar := image.Rect(x, valueY(stacked+s.Daily[day]), x+barWidth, valueY(stacked))
+			draw.Draw(img, bar, &image.Uniform{colorOf(i)}, image.Point{}, draw.Src)
+			stacked += s.Daily[day]
+		}
+	}
+
+	// Axes
+	drawLine(img, plot.Min.X, plot.Min.Y, plot.Min.X, plot.Max.Y, theme.Muted)
+	drawLine(img, plot.Min.X, plot.Max.Y, plot.Max.X, plot.Max.Y, theme.Muted)
+
+	// Dates under the first, middle and last bars
+	dateY := plot.Max.Y + chartAxisGap + face.Ascent
+	for _, day := range []int{0, usageDays / 2, usageDays - 1} {
+		label := first.AddDate(0, 0, day).Format("01-02")
+		x := plot.Min.X + int(slot*(float64(day)+0.5)) - len(label)*face.Advance/2
+		addLabel(img, fixed.P(x, dateY), label, theme.Muted)
+	}
+
+	if len(series) == 0 {
+		label := "No authenticated requests"
+		x := plot.Min.X + (plot.Dx()-len(label)*face.Advance)/2
+		addLabel(img, fixed.P(x, plot.Min.Y+plot.Dy()/2), label, theme.Muted)
+	}
+
+	// Legend: a swatch and "caller total" per series
+	legendX := plot.Max.X + chartAxisGap
+	for i, entry := range legend {
+		y := plot.Min.Y + i*(face.Height+4)
+		swatch := image.Rect(legendX, y, legendX+face.Height-2, y+face.Height-2)
+		draw.Draw(img, swatch, &image.Uniform{colorOf(i)}, image.Point{}, draw.Src)
+		addLabel(img, fixed.P(legendX+face.Height+2, y+face.Ascent-1), entry, theme.Text)
+	}
+
+	return upscaleImage(img, opts.Scale)
+}